@@ -0,0 +1,46 @@
+// Command devcerts creates an ephemeral CA and a certificate it signed for
+// trying out TLS and mTLS locally, e.g.
+//
+//	go run ./cmd/devcerts -dir certs -rotate 1m
+//
+// writes certs/ca.pem, certs/cert.pem and certs/key.pem, which every service
+// can share:
+//
+//	-tls server,service2 -tls-cert certs/cert.pem -tls-key certs/key.pem -tls-ca certs/ca.pem -tls-client-auth
+//
+// With -rotate it keeps running and issues a new certificate every interval,
+// which the services pick up without a restart.
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"go-service-tracing/internal/tlsconfig"
+)
+
+func main() {
+	dir := flag.String("dir", "certs", "directory to write ca.pem, cert.pem and key.pem to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "comma-separated names and IP addresses of the certificate")
+	validity := flag.Duration("validity", 24*time.Hour, "how long the certificate is valid")
+	rotate := flag.Duration("rotate", 0, "issue a new certificate every interval, 0 to issue one and exit")
+	flag.Parse()
+
+	ca, err := tlsconfig.NewCA("go-service-tracing dev CA", 365*24*time.Hour)
+	if err != nil {
+		log.Fatalf("create CA error: %v", err)
+	}
+	names := strings.Split(*hosts, ",")
+	for {
+		if err := ca.WriteFiles(*dir, "go-service-tracing", names, *validity); err != nil {
+			log.Fatalf("write certificate error: %v", err)
+		}
+		log.Printf("wrote %s/ca.pem, cert.pem and key.pem", *dir)
+		if *rotate <= 0 {
+			return
+		}
+		time.Sleep(*rotate)
+	}
+}
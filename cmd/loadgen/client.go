@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/kvhttp"
+	"go-service-tracing/internal/tlsconfig"
+
+	"google.golang.org/grpc"
+)
+
+type kvClient interface {
+	Put(ctx context.Context, key, value string) error
+	Get(ctx context.Context, key string) error
+	Close() error
+}
+
+type httpClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newHTTPClient(baseURL string, certs *tlsconfig.Reloader, t *tracing) (*httpClient, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 1024
+	transport.TLSClientConfig = certs.ClientConfig("target", u.Host)
+	return &httpClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Transport: t.httpTransport(transport)},
+	}, nil
+}
+
+func (c *httpClient) Put(ctx context.Context, key, value string) error {
+	body, err := json.Marshal(kvhttp.PutBody{Value: value})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", c.keyURL(key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req)
+}
+
+func (c *httpClient) Get(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.keyURL(key), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req)
+}
+
+func (c *httpClient) keyURL(key string) string {
+	return c.baseURL + "/v1/kv/" + url.PathEscape(key)
+}
+
+func (c *httpClient) do(req *http.Request) error {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *httpClient) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// grpcClient calls the Storage service, which every gRPC example serves.
+type grpcClient struct {
+	conn    *grpc.ClientConn
+	storage storagev1.StorageClient
+}
+
+func newGRPCClient(target string, certs *tlsconfig.Reloader, t *tracing) (*grpcClient, error) {
+	opts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(certs, "target", target)),
+	}, t.grpcDialOptions()...)
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcClient{conn: conn, storage: storagev1.NewStorageClient(conn)}, nil
+}
+
+func (c *grpcClient) Put(ctx context.Context, key, value string) error {
+	_, err := c.storage.Put(ctx, &storagev1.PutRequest{Key: key, Value: value})
+	return err
+}
+
+func (c *grpcClient) Get(ctx context.Context, key string) error {
+	_, err := c.storage.Get(ctx, &storagev1.GetRequest{Key: key})
+	return err
+}
+
+func (c *grpcClient) Close() error {
+	return c.conn.Close()
+}
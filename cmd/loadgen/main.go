@@ -0,0 +1,177 @@
+// Command loadgen drives the KV example services over the gin HTTP API or the
+// gRPC Storage service and reports throughput and latency.
+//
+//	go run ./cmd/loadgen -mode grpc -target localhost:8082 -concurrency 16 -duration 30s
+//	go run ./cmd/loadgen -mode http -target http://localhost:8082 -rate 200 -trace otel
+//
+// -tls target sends the requests over TLS, to an https -target in http mode,
+// and -tls collector the spans of -trace.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-service-tracing/internal/tlsconfig"
+)
+
+func main() {
+	mode := flag.String("mode", "http", "http (gin API) or grpc (Storage service)")
+	target := flag.String("target", "http://localhost:8082", "base URL for http mode, host:port for grpc mode")
+	concurrency := flag.Int("concurrency", 8, "number of concurrent workers")
+	rate := flag.Float64("rate", 0, "total requests per second, 0 for as fast as possible")
+	duration := flag.Duration("duration", 10*time.Second, "how long to run")
+	keys := flag.Int("keys", 1000, "number of distinct keys")
+	dist := flag.String("dist", "uniform", "key distribution: uniform or zipf")
+	zipfS := flag.Float64("zipf-s", 1.1, "zipf skew, must be > 1")
+	readRatio := flag.Float64("read-ratio", 0.9, "fraction of requests that are reads")
+	valueSize := flag.Int("value-size", 16, "size of written values in bytes")
+	traceMode := flag.String("trace", "none", "start a root span per request: none, otel or zipkin")
+	tlsUse := flag.String("tls", "", "connections that use TLS, any of target,collector")
+	tlsCert := flag.String("tls-cert", "", "certificate file presented to servers that ask for one")
+	tlsKey := flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "CA file to verify servers against, the system roots if empty")
+	flag.Parse()
+
+	switch {
+	case *concurrency < 1:
+		usage("-concurrency must be at least 1")
+	case *rate < 0 || *rate > 1e9:
+		usage("-rate must be between 0 and 1e9")
+	case *keys < 1:
+		usage("-keys must be at least 1")
+	case *dist != "uniform" && *dist != "zipf":
+		usage("unknown key distribution %q", *dist)
+	case *dist == "zipf" && *zipfS <= 1:
+		usage("-zipf-s must be > 1")
+	}
+
+	certs, err := tlsconfig.Load(tlsconfig.Files{
+		Cert: *tlsCert,
+		Key:  *tlsKey,
+		CA:   *tlsCA,
+		Use:  tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid -tls: %v", err)
+	}
+	tracing, err := newTracing(*traceMode, certs)
+	if err != nil {
+		log.Fatalf("init tracing error: %v", err)
+	}
+	defer tracing.shutdown()
+
+	var client kvClient
+	switch *mode {
+	case "http":
+		client, err = newHTTPClient(*target, certs, tracing)
+		if err != nil {
+			log.Fatalf("invalid -target: %v", err)
+		}
+	case "grpc":
+		client, err = newGRPCClient(*target, certs, tracing)
+		if err != nil {
+			log.Fatalf("did not connect: %v", err)
+		}
+	default:
+		log.Fatalf("unknown mode %q", *mode)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	// with -rate the requests are sent on a schedule, every interval after
+	// start, and the workers take the next slot of it in turn
+	var interval time.Duration
+	if *rate > 0 {
+		interval = time.Duration(float64(time.Second) / *rate)
+	}
+	var slots atomic.Int64
+
+	value := make([]byte, *valueSize)
+	for i := range value {
+		value[i] = 'a' + byte(i%26)
+	}
+
+	results := make([]*recorder, *concurrency)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		rec := newRecorder()
+		results[i] = rec
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			next := keyChooser(r, *dist, *keys, *zipfS)
+			for {
+				began := time.Now()
+				if interval > 0 {
+					// the latency counts from when the request was due, so
+					// that the time it waited for a busy worker is not
+					// hidden (coordinated omission)
+					began = start.Add(time.Duration(slots.Add(1)-1) * interval)
+					if wait := time.Until(began); wait > 0 {
+						timer := time.NewTimer(wait)
+						select {
+						case <-timer.C:
+						case <-ctx.Done():
+							timer.Stop()
+							return
+						}
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				key := fmt.Sprintf("key-%d", next())
+				op := opPut
+				if r.Float64() < *readRatio {
+					op = opGet
+				}
+				err := tracing.do(ctx, op, key, func(ctx context.Context) error {
+					if op == opGet {
+						return client.Get(ctx, key)
+					}
+					return client.Put(ctx, key, string(value))
+				})
+				if ctx.Err() != nil {
+					// the run ended mid-request; do not count it
+					return
+				}
+				rec.record(op, time.Since(began), err)
+			}
+		}(time.Now().UnixNano() + int64(i))
+	}
+	wg.Wait()
+
+	report(os.Stdout, merge(results), time.Since(start))
+}
+
+func keyChooser(r *rand.Rand, dist string, keys int, s float64) func() uint64 {
+	if dist == "zipf" && keys > 1 {
+		zipf := rand.NewZipf(r, s, 1, uint64(keys-1))
+		return zipf.Uint64
+	}
+	return func() uint64 {
+		return uint64(r.Intn(keys))
+	}
+}
+
+// usage reports an invalid flag with the usage of the command and exits.
+func usage(format string, args ...any) {
+	fmt.Fprintf(flag.CommandLine.Output(), format+"\n", args...)
+	flag.Usage()
+	os.Exit(2)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	opPut = "put"
+	opGet = "get"
+)
+
+// recorder is owned by a single worker; results are merged after the run.
+type recorder struct {
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	lastErr   map[string]error
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		latencies: map[string][]time.Duration{},
+		errors:    map[string]int{},
+		lastErr:   map[string]error{},
+	}
+}
+
+func (r *recorder) record(op string, d time.Duration, err error) {
+	if err != nil {
+		r.errors[op]++
+		r.lastErr[op] = err
+		return
+	}
+	r.latencies[op] = append(r.latencies[op], d)
+}
+
+func merge(recs []*recorder) *recorder {
+	out := newRecorder()
+	for _, r := range recs {
+		for op, l := range r.latencies {
+			out.latencies[op] = append(out.latencies[op], l...)
+		}
+		for op, n := range r.errors {
+			out.errors[op] += n
+		}
+		for op, err := range r.lastErr {
+			out.lastErr[op] = err
+		}
+	}
+	return out
+}
+
+func report(w io.Writer, r *recorder, elapsed time.Duration) {
+	fmt.Fprintf(w, "elapsed %s\n\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "%-4s %9s %7s %10s %10s %10s %10s %10s %10s\n",
+		"op", "ok", "errors", "req/s", "mean", "p50", "p90", "p99", "max")
+	var all []time.Duration
+	for _, op := range []string{opPut, opGet} {
+		l := r.latencies[op]
+		all = append(all, l...)
+		printRow(w, op, l, r.errors[op], elapsed)
+	}
+	printRow(w, "all", all, r.errors[opPut]+r.errors[opGet], elapsed)
+
+	for _, op := range []string{opPut, opGet} {
+		if err := r.lastErr[op]; err != nil {
+			fmt.Fprintf(w, "\nlast %s error: %v\n", op, err)
+		}
+	}
+
+	fmt.Fprintf(w, "\nlatency histogram (all ops)\n")
+	histogram(w, all)
+}
+
+func printRow(w io.Writer, op string, l []time.Duration, errs int, elapsed time.Duration) {
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	var sum time.Duration
+	for _, d := range l {
+		sum += d
+	}
+	var mean time.Duration
+	if len(l) > 0 {
+		mean = sum / time.Duration(len(l))
+	}
+	fmt.Fprintf(w, "%-4s %9d %7d %10.1f %10s %10s %10s %10s %10s\n",
+		op, len(l), errs, float64(len(l))/elapsed.Seconds(),
+		round(mean), round(percentile(l, 0.50)), round(percentile(l, 0.90)),
+		round(percentile(l, 0.99)), round(percentile(l, 1)))
+}
+
+// percentile expects l to be sorted.
+func percentile(l []time.Duration, p float64) time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	i := int(float64(len(l))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(l) {
+		i = len(l) - 1
+	}
+	return l[i]
+}
+
+// histogram prints counts in power-of-two buckets starting at 64µs.
+func histogram(w io.Writer, l []time.Duration) {
+	if len(l) == 0 {
+		fmt.Fprintln(w, "  no successful requests")
+		return
+	}
+	bound := 64 * time.Microsecond
+	var bounds []time.Duration
+	for bound < l[len(l)-1] {
+		bounds = append(bounds, bound)
+		bound *= 2
+	}
+	bounds = append(bounds, bound)
+
+	counts := make([]int, len(bounds))
+	i := 0
+	for _, d := range l {
+		for d > bounds[i] {
+			i++
+		}
+		counts[i]++
+	}
+	peak := 0
+	for _, c := range counts {
+		if c > peak {
+			peak = c
+		}
+	}
+	for i, c := range counts {
+		bar := strings.Repeat("#", c*40/peak)
+		fmt.Fprintf(w, "  <= %-9s %9d %s\n", round(bounds[i]), c, bar)
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-service-tracing/internal/tlsconfig"
+
+	"github.com/openzipkin/zipkin-go"
+	zipkingrpc "github.com/openzipkin/zipkin-go/middleware/grpc"
+	zipkinhttp "github.com/openzipkin/zipkin-go/middleware/http"
+	httpreporter "github.com/openzipkin/zipkin-go/reporter/http"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"google.golang.org/grpc"
+)
+
+// tracing optionally makes every generated request the root of its own trace,
+// so slow requests in the report can be looked up in Jaeger or Zipkin.
+type tracing struct {
+	mode           string
+	tracerProvider *sdktrace.TracerProvider
+	zipkinTracer   *zipkin.Tracer
+	shutdown       func()
+}
+
+func newTracing(mode string, certs *tlsconfig.Reloader) (*tracing, error) {
+	t := &tracing{mode: mode, shutdown: func() {}}
+	switch mode {
+	case "none":
+	case "otel":
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		res, err := resource.New(ctx,
+			resource.WithAttributes(
+				semconv.ServiceName("loadgen"),
+				semconv.ServiceVersion("1.0.0"),
+			),
+		)
+		if err != nil {
+			return nil, err
+		}
+		traceExporter, err := otlptracegrpc.New(ctx,
+			otlptracegrpc.WithEndpoint("127.0.0.1:4317"),
+			otlptracegrpc.WithTLSCredentials(tlsconfig.ClientCredentials(certs, "collector", "127.0.0.1:4317")),
+		)
+		if err != nil {
+			return nil, err
+		}
+		t.tracerProvider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(traceExporter),
+			sdktrace.WithResource(res),
+		)
+		otel.SetTracerProvider(t.tracerProvider)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{},
+			propagation.Baggage{},
+		))
+		t.shutdown = func() {
+			if err := t.tracerProvider.Shutdown(context.Background()); err != nil {
+				fmt.Printf("tracer shutdown error: %v\n", err)
+			}
+		}
+	case "zipkin":
+		reporter := httpreporter.NewReporter(tlsconfig.URL(certs, "collector", "localhost:9411")+"/api/v2/spans",
+			httpreporter.Timeout(time.Second*5),
+			httpreporter.Client(&http.Client{Transport: tlsconfig.Transport(certs, "collector", "localhost:9411")}),
+		)
+		endpoint, err := zipkin.NewEndpoint("loadgen", "")
+		if err != nil {
+			return nil, err
+		}
+		t.zipkinTracer, err = zipkin.NewTracer(reporter, zipkin.WithLocalEndpoint(endpoint))
+		if err != nil {
+			return nil, err
+		}
+		t.shutdown = func() {
+			reporter.Close()
+		}
+	default:
+		return nil, fmt.Errorf("unknown trace mode %q", mode)
+	}
+	return t, nil
+}
+
+func (t *tracing) httpTransport(rt http.RoundTripper) http.RoundTripper {
+	switch t.mode {
+	case "otel":
+		return otelhttp.NewTransport(rt)
+	case "zipkin":
+		transport, err := zipkinhttp.NewTransport(t.zipkinTracer, zipkinhttp.RoundTripper(rt))
+		if err != nil {
+			// only fails on a nil tracer, which newTracing rules out
+			panic(err)
+		}
+		return transport
+	default:
+		return rt
+	}
+}
+
+func (t *tracing) grpcDialOptions() []grpc.DialOption {
+	switch t.mode {
+	case "otel":
+		return []grpc.DialOption{grpc.WithStatsHandler(otelgrpc.NewClientHandler())}
+	case "zipkin":
+		return []grpc.DialOption{grpc.WithStatsHandler(zipkingrpc.NewClientHandler(t.zipkinTracer))}
+	default:
+		return nil
+	}
+}
+
+// do runs fn inside a root span named after the operation.
+func (t *tracing) do(ctx context.Context, op, key string, fn func(ctx context.Context) error) error {
+	name := "loadgen." + op
+	switch t.mode {
+	case "otel":
+		ctx, span := otel.Tracer("loadgen").Start(ctx, name)
+		defer span.End()
+		span.SetAttributes(attribute.String("kv.key", key))
+		err := fn(ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	case "zipkin":
+		span, ctx := t.zipkinTracer.StartSpanFromContext(ctx, name)
+		defer span.Finish()
+		span.Tag("kv.key", key)
+		err := fn(ctx)
+		if err != nil {
+			zipkin.TagError.Set(span, err.Error())
+		}
+		return err
+	default:
+		return fn(ctx)
+	}
+}
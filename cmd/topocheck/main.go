@@ -0,0 +1,137 @@
+// Command topocheck drives the example services with the scenarios of a
+// topology spec and checks the spans they report.
+//
+// It replaces the OpenTelemetry collector and Zipkin while running, so stop
+// those containers first and start Redis and the services under test:
+//
+//	go run ./cmd/topocheck -spec jaeger/grpcexample/topology.yaml
+//
+// go test ./internal/topology runs the specs of the examples the same way,
+// with Redis and the services started by the test.
+//
+// With -tls server it serves OTLP and Zipkin over TLS, for services started
+// with -tls collector, and with -tls target it sends the requests over TLS,
+// http URLs of the spec as https, for services started with -tls server.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"go-service-tracing/internal/tlsconfig"
+	"go-service-tracing/internal/topology"
+
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	spec := flag.String("spec", "", "topology spec file")
+	otlpAddr := flag.String("otlp", ":4317", "OTLP gRPC listen address, empty to disable")
+	zipkinAddr := flag.String("zipkin", ":9411", "Zipkin HTTP listen address, empty to disable")
+	settle := flag.Duration("settle", 6*time.Second, "how long no span must arrive before matching")
+	ready := flag.Duration("ready", 30*time.Second, "how long to wait for the services to accept connections")
+	tlsUse := flag.String("tls", "", "connections that use TLS, any of server,target")
+	tlsCert := flag.String("tls-cert", "", "certificate file, served and presented to services that ask for one")
+	tlsKey := flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA := flag.String("tls-ca", "", "CA file to verify the services against, the system roots if empty")
+	tlsClientAuth := flag.Bool("tls-client-auth", false, "require services to present a certificate signed by -tls-ca")
+	flag.Parse()
+	if *settle <= 0 {
+		log.Fatalf("-settle must be positive, got %s", *settle)
+	}
+	certs, err := tlsconfig.Load(tlsconfig.Files{
+		Cert:       *tlsCert,
+		Key:        *tlsKey,
+		CA:         *tlsCA,
+		ClientAuth: *tlsClientAuth,
+		Use:        tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid -tls: %v", err)
+	}
+
+	scenarios, err := topology.Load(*spec)
+	if err != nil {
+		log.Fatalf("load spec error: %v", err)
+	}
+
+	collector := topology.NewCollector()
+	if *otlpAddr != "" {
+		lis, err := net.Listen("tcp", *otlpAddr)
+		if err != nil {
+			log.Fatalf("failed to listen: %v", err)
+		}
+		s := grpc.NewServer(grpc.Creds(tlsconfig.ServerCredentials(certs)))
+		collectorpb.RegisterTraceServiceServer(s, collector)
+		go s.Serve(lis)
+		defer s.Stop()
+	}
+	if *zipkinAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/api/v2/spans", collector)
+		lis, err := net.Listen("tcp", *zipkinAddr)
+		if err != nil {
+			log.Fatalf("failed to listen: %v", err)
+		}
+		srv := &http.Server{Handler: mux, TLSConfig: certs.ServerConfig()}
+		if srv.TLSConfig != nil {
+			go srv.ServeTLS(lis, "", "")
+		} else {
+			go srv.Serve(lis)
+		}
+	}
+
+	// the services block on connecting to the collector at startup, so they
+	// may only come up after the listeners above
+	for _, sc := range scenarios {
+		addr := sc.Request.Addr()
+		if err := waitReady(addr, *ready); err != nil {
+			log.Fatalf("%s is not ready: %v", addr, err)
+		}
+	}
+
+	failed := 0
+	for _, sc := range scenarios {
+		ctx, cancel := context.WithTimeout(context.Background(), *settle+30*time.Second)
+		result, err := topology.Run(ctx, collector, sc, *settle, certs)
+		cancel()
+		switch {
+		case err != nil:
+			failed++
+			fmt.Printf("FAIL %s: request error: %v\n", sc.Name, err)
+		case result.OK():
+			fmt.Printf("PASS %s (trace %s)\n", sc.Name, result.TraceID)
+		default:
+			failed++
+			fmt.Printf("FAIL %s: matched %d/%d spans in trace %s\n", sc.Name, result.Matched, result.Expected, result.TraceID)
+			for _, m := range result.Mismatches {
+				fmt.Printf("    %s\n", m)
+			}
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func waitReady(addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
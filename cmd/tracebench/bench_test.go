@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+)
+
+// BenchmarkTracing measures the requests of the command under every setup,
+// for comparison with benchstat:
+//
+//	go test ./cmd/tracebench -run '^$' -bench . -count 10 > new.txt
+func BenchmarkTracing(b *testing.B) {
+	si, err := startStandIns()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer si.close()
+
+	for _, name := range setupNames {
+		b.Run(name, func(b *testing.B) {
+			st, err := newSetup(name, si)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer st.close()
+			ops, stop, err := newOperations(st)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer stop()
+
+			for _, o := range ops {
+				b.Run(o.name, func(b *testing.B) {
+					p99, err := measure(b, o.do)
+					if err != nil {
+						b.Fatal(err)
+					}
+					b.ReportMetric(float64(p99.Nanoseconds()), "p99-ns")
+				})
+			}
+		})
+	}
+}
+
+// TestOperations checks that every setup serves the requests, so the
+// benchmarks do not measure errors.
+func TestOperations(t *testing.T) {
+	si, err := startStandIns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer si.close()
+
+	for _, name := range setupNames {
+		t.Run(name, func(t *testing.T) {
+			st, err := newSetup(name, si)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.close()
+			ops, stop, err := newOperations(st)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stop()
+
+			for _, o := range ops {
+				if err := o.do(); err != nil {
+					t.Errorf("%s: %v", o.name, err)
+				}
+			}
+		})
+	}
+}
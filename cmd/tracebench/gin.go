@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+// newGinEngine builds the service2 gin routes with the middleware the
+// examples use for st, backed by a map instead of Redis.
+func newGinEngine(st *setup) *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	switch {
+	case st.tracerProvider != nil:
+		r.Use(otelgin.Middleware("tracebench", otelgin.WithTracerProvider(st.tracerProvider)))
+	case st.zipkinTracer != nil:
+		r.Use(zipkinMiddleware(st.zipkinTracer))
+	}
+
+	var store sync.Map
+	r.POST("/kv/put", func(c *gin.Context) {
+		key := c.PostForm("key")
+		value := c.PostForm("value")
+		if key == "" || value == "" {
+			c.JSON(400, gin.H{"message": "key or value is empty"})
+			return
+		}
+		_, end := st.startSpan(c.Request.Context(), "redis.set", key)
+		defer end()
+
+		store.Store(key, value)
+		c.JSON(200, gin.H{"message": "success"})
+	})
+	r.GET("/kv/get", func(c *gin.Context) {
+		key := c.Query("key")
+		if key == "" {
+			c.JSON(400, gin.H{"message": "key is empty"})
+			return
+		}
+		_, end := st.startSpan(c.Request.Context(), "redis.get", key)
+		defer end()
+
+		value, ok := store.Load(key)
+		if !ok {
+			c.JSON(500, gin.H{"message": "redis: nil"})
+			return
+		}
+		c.JSON(200, gin.H{"value": value})
+	})
+	return r
+}
+
+// zipkinMiddleware is the middleware of the zipkin gin examples.
+func zipkinMiddleware(tracer *zipkin.Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		spanContext := tracer.Extract(b3.ExtractHTTP(c.Request))
+		request := fmt.Sprintf("%s %s", c.Request.Method, c.Request.URL.Path)
+		span, ctx := tracer.StartSpanFromContext(c.Request.Context(), request, zipkin.Parent(spanContext))
+		defer span.Finish()
+		c.Request = c.Request.WithContext(ctx)
+
+		span.Tag("http.method", c.Request.Method)
+		span.Tag("http.url", c.Request.URL.Path)
+		c.Next()
+		span.Tag("http.status_code", strconv.Itoa(c.Writer.Status()))
+		span.Tag("http.response_size", strconv.Itoa(c.Writer.Size()))
+	}
+}
+
+func ginPut(h http.Handler, key string) error {
+	form := url.Values{"key": {key}, "value": {"value"}}.Encode()
+	req := httptest.NewRequest("POST", "/kv/put", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 200 {
+		return fmt.Errorf("put status %d", w.Code)
+	}
+	return nil
+}
+
+func ginGet(h http.Handler, key string) error {
+	req := httptest.NewRequest("GET", "/kv/get?key="+url.QueryEscape(key), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 200 {
+		return fmt.Errorf("get status %d", w.Code)
+	}
+	return nil
+}
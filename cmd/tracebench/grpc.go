@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	storagev1 "go-service-tracing/api/storage/v1"
+
+	zipkingrpc "github.com/openzipkin/zipkin-go/middleware/grpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// storageServer is service2's Storage service backed by a map instead of
+// Redis.
+type storageServer struct {
+	storagev1.UnimplementedStorageServer
+	st    *setup
+	store sync.Map
+}
+
+func (s *storageServer) Put(ctx context.Context, req *storagev1.PutRequest) (*storagev1.PutResponse, error) {
+	_, end := s.st.startSpan(ctx, "redis.set", req.Key)
+	defer end()
+
+	s.store.Store(req.Key, req.Value)
+	return &storagev1.PutResponse{}, nil
+}
+
+func (s *storageServer) Get(ctx context.Context, req *storagev1.GetRequest) (*storagev1.GetResponse, error) {
+	_, end := s.st.startSpan(ctx, "redis.get", req.Key)
+	defer end()
+
+	value, ok := s.store.Load(req.Key)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "redis: nil")
+	}
+	return &storagev1.GetResponse{Value: value.(string)}, nil
+}
+
+// startGRPC serves the Storage service over bufconn with st's
+// instrumentation on both the server and the returned client.
+func startGRPC(st *setup) (storagev1.StorageClient, func(), error) {
+	propagators := propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	)
+	var serverOpts []grpc.ServerOption
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	switch {
+	case st.tracerProvider != nil:
+		serverOpts = append(serverOpts, grpc.StatsHandler(otelgrpc.NewServerHandler(
+			otelgrpc.WithTracerProvider(st.tracerProvider),
+			otelgrpc.WithPropagators(propagators),
+		)))
+		dialOpts = append(dialOpts, grpc.WithStatsHandler(otelgrpc.NewClientHandler(
+			otelgrpc.WithTracerProvider(st.tracerProvider),
+			otelgrpc.WithPropagators(propagators),
+		)))
+	case st.zipkinTracer != nil:
+		serverOpts = append(serverOpts, grpc.StatsHandler(zipkingrpc.NewServerHandler(st.zipkinTracer)))
+		dialOpts = append(dialOpts, grpc.WithStatsHandler(zipkingrpc.NewClientHandler(st.zipkinTracer)))
+	}
+
+	buf := bufconn.Listen(1 << 20)
+	lis := loopbackListener{buf}
+	s := grpc.NewServer(serverOpts...)
+	storagev1.RegisterStorageServer(s, &storageServer{st: st})
+	go s.Serve(lis)
+
+	dialOpts = append(dialOpts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		conn, err := buf.DialContext(ctx)
+		return loopbackConn{conn}, err
+	}))
+	conn, err := grpc.NewClient("passthrough:///bufconn", dialOpts...)
+	if err != nil {
+		s.Stop()
+		return nil, nil, err
+	}
+	return storagev1.NewStorageClient(conn), func() {
+		conn.Close()
+		s.Stop()
+	}, nil
+}
+
+var loopbackAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+// loopbackConn reports a loopback address instead of bufconn's placeholder.
+// The zipkin handlers build endpoints from the peer address and would
+// otherwise try to resolve "bufconn" on every RPC.
+type loopbackConn struct {
+	net.Conn
+}
+
+func (loopbackConn) LocalAddr() net.Addr  { return loopbackAddr }
+func (loopbackConn) RemoteAddr() net.Addr { return loopbackAddr }
+
+type loopbackListener struct {
+	*bufconn.Listener
+}
+
+func (l loopbackListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return loopbackConn{conn}, nil
+}
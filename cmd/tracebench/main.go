@@ -0,0 +1,198 @@
+// Command tracebench measures what tracing costs per request. It runs the
+// example KV handlers in-process, gin through httptest and gRPC over bufconn,
+// under each tracing setup and prints the results side by side:
+//
+//	disabled    no tracing middleware at all
+//	noop        OpenTelemetry instrumentation with a no-op TracerProvider
+//	otel-batch  OpenTelemetry SDK with the batching OTLP exporter, as initTracer() does
+//	otel-sync   OpenTelemetry SDK exporting every span synchronously
+//	zipkin      zipkin-go with the HTTP reporter, as createTracer() does
+//
+// Exporters talk to local stand-ins that discard what they receive.
+//
+//	go run ./cmd/tracebench -benchtime 2s -json results.json
+//	go run ./cmd/tracebench -report before.json,after.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	storagev1 "go-service-tracing/api/storage/v1"
+)
+
+type result struct {
+	Benchmark   string        `json:"benchmark"`
+	Setup       string        `json:"setup"`
+	N           int           `json:"n"`
+	NsPerOp     int64         `json:"ns_per_op"`
+	BytesPerOp  int64         `json:"bytes_per_op"`
+	AllocsPerOp int64         `json:"allocs_per_op"`
+	P99         time.Duration `json:"p99_ns"`
+	Err         string        `json:"error,omitempty"`
+}
+
+func main() {
+	testing.Init()
+	benchtime := flag.String("benchtime", "1s", "run time per benchmark, or a count such as 10000x")
+	setups := flag.String("setups", strings.Join(setupNames, ","), "comma separated setups to run")
+	jsonOut := flag.String("json", "", "also write results to this file")
+	reportFiles := flag.String("report", "", "render previously saved results instead of running, comma separated")
+	flag.Parse()
+
+	if *reportFiles != "" {
+		var results []result
+		for _, path := range strings.Split(*reportFiles, ",") {
+			rs, err := load(path)
+			if err != nil {
+				log.Fatalf("load results error: %v", err)
+			}
+			if len(strings.Split(*reportFiles, ",")) > 1 {
+				for i := range rs {
+					rs[i].Setup = strings.TrimSuffix(path, ".json") + ":" + rs[i].Setup
+				}
+			}
+			results = append(results, rs...)
+		}
+		render(os.Stdout, results)
+		return
+	}
+
+	if err := flag.Set("test.benchtime", *benchtime); err != nil {
+		log.Fatalf("invalid benchtime: %v", err)
+	}
+
+	si, err := startStandIns()
+	if err != nil {
+		log.Fatalf("start stand-ins error: %v", err)
+	}
+	defer si.close()
+
+	var results []result
+	for _, name := range strings.Split(*setups, ",") {
+		st, err := newSetup(name, si)
+		if err != nil {
+			log.Fatalf("setup %s error: %v", name, err)
+		}
+		log.Printf("running %s", name)
+		results = append(results, runSetup(st)...)
+		st.close()
+	}
+
+	render(os.Stdout, results)
+	if *jsonOut != "" {
+		data, _ := json.MarshalIndent(results, "", "  ")
+		if err := os.WriteFile(*jsonOut, data, 0o644); err != nil {
+			log.Fatalf("write results error: %v", err)
+		}
+	}
+}
+
+func runSetup(st *setup) []result {
+	ops, stop, err := newOperations(st)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer stop()
+
+	results := make([]result, 0, len(ops))
+	for _, o := range ops {
+		results = append(results, bench(o.name, st.name, o.do))
+	}
+	return results
+}
+
+// operation is one request the benchmarks repeat.
+type operation struct {
+	name string
+	do   func() error
+}
+
+// newOperations starts the gin and gRPC handlers of st, seeds their stores
+// and returns the requests to measure and a func that stops the handlers.
+func newOperations(st *setup) ([]operation, func(), error) {
+	engine := newGinEngine(st)
+	client, stop, err := startGRPC(st)
+	if err != nil {
+		return nil, nil, fmt.Errorf("start grpc error: %w", err)
+	}
+
+	ctx := context.Background()
+	const key = "bench"
+	if err := ginPut(engine, key); err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("seed gin store: %w", err)
+	}
+	if _, err := client.Put(ctx, &storagev1.PutRequest{Key: key, Value: "value"}); err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("seed grpc store: %w", err)
+	}
+
+	return []operation{
+		{"gin/put", func() error { return ginPut(engine, key) }},
+		{"gin/get", func() error { return ginGet(engine, key) }},
+		{"grpc/put", func() error {
+			_, err := client.Put(ctx, &storagev1.PutRequest{Key: key, Value: "value"})
+			return err
+		}},
+		{"grpc/get", func() error {
+			_, err := client.Get(ctx, &storagev1.GetRequest{Key: key})
+			return err
+		}},
+	}, stop, nil
+}
+
+func bench(name, setup string, op func() error) result {
+	res := result{Benchmark: name, Setup: setup}
+	br := testing.Benchmark(func(b *testing.B) {
+		p99, err := measure(b, op)
+		if err != nil {
+			res.Err = err.Error()
+			return
+		}
+		res.P99 = p99
+	})
+	res.N = br.N
+	res.NsPerOp = br.NsPerOp()
+	res.BytesPerOp = br.AllocedBytesPerOp()
+	res.AllocsPerOp = br.AllocsPerOp()
+	return res
+}
+
+// measure runs op b.N times, reporting allocations, and returns the 99th
+// percentile of its latency, or the first error.
+func measure(b *testing.B, op func() error) (time.Duration, error) {
+	lat := make([]time.Duration, b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		if err := op(); err != nil {
+			return 0, err
+		}
+		lat[i] = time.Since(start)
+	}
+	b.StopTimer()
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	return lat[(len(lat)*99)/100], nil
+}
+
+func load(path string) ([]result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var results []result
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return results, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// render prints one block per benchmark with a column per setup. Latency
+// cells also show the change relative to the first column.
+func render(w io.Writer, results []result) {
+	var benchmarks, setups []string
+	cells := map[string]map[string]result{}
+	for _, r := range results {
+		if cells[r.Benchmark] == nil {
+			cells[r.Benchmark] = map[string]result{}
+			benchmarks = append(benchmarks, r.Benchmark)
+		}
+		if !containsString(setups, r.Setup) {
+			setups = append(setups, r.Setup)
+		}
+		cells[r.Benchmark][r.Setup] = r
+	}
+
+	const width = 22
+	for _, b := range benchmarks {
+		fmt.Fprintf(w, "%-12s", b)
+		for _, s := range setups {
+			fmt.Fprintf(w, "%*s", width, s)
+		}
+		fmt.Fprintln(w)
+
+		base := cells[b][setups[0]]
+		rows := []struct {
+			metric string
+			cell   func(r result) string
+		}{
+			{"ns/op", func(r result) string {
+				return latency(time.Duration(r.NsPerOp), time.Duration(base.NsPerOp))
+			}},
+			{"p99", func(r result) string {
+				return latency(r.P99, base.P99)
+			}},
+			{"B/op", func(r result) string { return fmt.Sprint(r.BytesPerOp) }},
+			{"allocs/op", func(r result) string { return fmt.Sprint(r.AllocsPerOp) }},
+		}
+		for _, row := range rows {
+			fmt.Fprintf(w, "  %-10s", row.metric)
+			for _, s := range setups {
+				r, ok := cells[b][s]
+				cell := "-"
+				switch {
+				case !ok:
+				case r.Err != "":
+					cell = "error"
+				default:
+					cell = row.cell(r)
+				}
+				fmt.Fprintf(w, "%*s", width, cell)
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w)
+	}
+
+	for _, r := range results {
+		if r.Err != "" {
+			fmt.Fprintf(w, "%s/%s failed: %s\n", r.Benchmark, r.Setup, r.Err)
+		}
+	}
+	fmt.Fprintln(w, strings.Repeat("-", 12+width*len(setups)))
+}
+
+func latency(d, base time.Duration) string {
+	s := d.Round(100 * time.Nanosecond).String()
+	if base > 0 && d != base {
+		s += fmt.Sprintf(" (%+.0f%%)", (float64(d)/float64(base)-1)*100)
+	}
+	return s
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
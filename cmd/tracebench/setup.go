@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	httpreporter "github.com/openzipkin/zipkin-go/reporter/http"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+)
+
+// setup is one tracing configuration under test. Exactly one of
+// tracerProvider and zipkinTracer is set when tracing is enabled.
+type setup struct {
+	name           string
+	tracerProvider trace.TracerProvider
+	zipkinTracer   *zipkin.Tracer
+	close          func()
+}
+
+var setupNames = []string{"disabled", "noop", "otel-batch", "otel-sync", "zipkin"}
+
+// standIns receive exported spans locally and throw them away, so exporter
+// cost is measured without a collector or Zipkin server. They run in the
+// benchmark process, so their decoding shows up in the allocation counts of
+// the exporting setups.
+type standIns struct {
+	otlpAddr  string
+	zipkinURL string
+	close     func()
+}
+
+type discardTraceService struct {
+	collectorpb.UnimplementedTraceServiceServer
+}
+
+func (discardTraceService) Export(context.Context, *collectorpb.ExportTraceServiceRequest) (*collectorpb.ExportTraceServiceResponse, error) {
+	return &collectorpb.ExportTraceServiceResponse{}, nil
+}
+
+func startStandIns() (*standIns, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := grpc.NewServer()
+	collectorpb.RegisterTraceServiceServer(s, discardTraceService{})
+	go s.Serve(lis)
+
+	zipkinServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	return &standIns{
+		otlpAddr:  lis.Addr().String(),
+		zipkinURL: zipkinServer.URL + "/api/v2/spans",
+		close: func() {
+			zipkinServer.Close()
+			s.Stop()
+		},
+	}, nil
+}
+
+func newSetup(name string, si *standIns) (*setup, error) {
+	st := &setup{name: name, close: func() {}}
+	switch name {
+	case "disabled":
+	case "noop":
+		st.tracerProvider = noop.NewTracerProvider()
+	case "otel-batch", "otel-sync":
+		ctx := context.Background()
+		res, err := resource.New(ctx,
+			resource.WithAttributes(
+				semconv.ServiceName("tracebench"),
+				semconv.ServiceVersion("1.0.0"),
+			),
+		)
+		if err != nil {
+			return nil, err
+		}
+		traceExporter, err := otlptracegrpc.New(ctx,
+			otlptracegrpc.WithEndpoint(si.otlpAddr),
+			otlptracegrpc.WithInsecure(),
+		)
+		if err != nil {
+			return nil, err
+		}
+		processor := sdktrace.WithBatcher(traceExporter)
+		if name == "otel-sync" {
+			processor = sdktrace.WithSyncer(traceExporter)
+		}
+		tracerProvider := sdktrace.NewTracerProvider(
+			processor,
+			sdktrace.WithResource(res),
+		)
+		st.tracerProvider = tracerProvider
+		st.close = func() {
+			tracerProvider.Shutdown(context.Background())
+		}
+	case "zipkin":
+		// a large backlog keeps the reporter from silently dropping spans,
+		// which would make zipkin look cheaper than it is
+		reporter := httpreporter.NewReporter(si.zipkinURL,
+			httpreporter.Timeout(time.Second*5),
+			httpreporter.MaxBacklog(1<<20),
+		)
+		endpoint, err := zipkin.NewEndpoint("tracebench", "localhost:0")
+		if err != nil {
+			return nil, err
+		}
+		sampler, err := zipkin.NewCountingSampler(1)
+		if err != nil {
+			return nil, err
+		}
+		st.zipkinTracer, err = zipkin.NewTracer(reporter,
+			zipkin.WithLocalEndpoint(endpoint),
+			zipkin.WithSampler(sampler),
+		)
+		if err != nil {
+			return nil, err
+		}
+		st.close = func() {
+			reporter.Close()
+		}
+	default:
+		return nil, fmt.Errorf("unknown setup %q", name)
+	}
+	return st, nil
+}
+
+// startSpan mirrors the manual "redis.set"/"redis.get" spans the example
+// handlers create around their Redis call.
+func (st *setup) startSpan(ctx context.Context, name, key string) (context.Context, func()) {
+	switch {
+	case st.tracerProvider != nil:
+		ctx, span := st.tracerProvider.Tracer("tracebench").Start(ctx, name)
+		return ctx, func() { span.End() }
+	case st.zipkinTracer != nil:
+		span, ctx := st.zipkinTracer.StartSpanFromContext(ctx, name)
+		span.Tag("redis.key", key)
+		return ctx, span.Finish
+	default:
+		return ctx, func() {}
+	}
+}
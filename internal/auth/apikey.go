@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+// APIKeyHeader carries a static API key.
+const APIKeyHeader = "X-Api-Key"
+
+type apiKeys struct {
+	// keys maps the sha256 of a key to its principal, which keeps the
+	// comparison constant-time whatever the length of the keys
+	keys map[[sha256.Size]byte]string
+}
+
+// APIKeys authenticates the requests whose X-Api-Key header is one of keys,
+// as the principal it maps to.
+func APIKeys(keys map[string]string) Authenticator {
+	a := apiKeys{keys: map[[sha256.Size]byte]string{}}
+	for key, principal := range keys {
+		a.keys[sha256.Sum256([]byte(key))] = principal
+	}
+	return a
+}
+
+func (a apiKeys) Authenticate(r Request) (Principal, error) {
+	key := r.Header(APIKeyHeader)
+	if key == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	sum := sha256.Sum256([]byte(key))
+	found := ""
+	for k, principal := range a.keys {
+		if subtle.ConstantTimeCompare(k[:], sum[:]) == 1 {
+			found = principal
+		}
+	}
+	if found == "" {
+		return Principal{}, invalid("unknown API key")
+	}
+	return Principal{ID: found, Method: "api_key"}, nil
+}
+
+func (a apiKeys) Challenge() string {
+	return "ApiKey"
+}
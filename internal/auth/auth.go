@@ -0,0 +1,249 @@
+// Package auth authenticates the callers of the KV API and records who they
+// are on the spans.
+//
+// service1 authenticates every request with the Authenticator its -auth
+// config file describes: static API keys, HMAC-signed requests or JWTs
+// verified against a local JWKS file. The principal travels on to service2 as
+// the enduser.id member of the W3C baggage, which both record as enduser.id
+// on their server spans. service2 accepts that baggage only from peers that
+// presented a client certificate, i.e. over mTLS.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/baggage"
+	"gopkg.in/yaml.v3"
+)
+
+// Attribute names of the authenticated principal; enduser.id is the one of
+// the semantic conventions.
+const (
+	AttrEnduserID = "enduser.id"
+	AttrMethod    = "auth.method"
+)
+
+// BaggageKey is the baggage member that carries the principal downstream.
+const BaggageKey = "enduser.id"
+
+// ErrUnauthenticated is the error of every failed authentication.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// ErrNoCredentials means the request carries none of the credentials the
+// Authenticator understands.
+var ErrNoCredentials = fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrUnauthenticated}, args...)...)
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	ID string
+	// Method is how it was authenticated: api_key, hmac or jwt.
+	Method string
+	// Tenant is the tenant the caller acts for, empty for none.
+	Tenant string
+}
+
+// Request is what an Authenticator sees of an HTTP request or a gRPC call.
+type Request struct {
+	// Method is the HTTP method, POST for gRPC calls.
+	Method string
+	// Path is the path and query of an HTTP request, the full method of a
+	// gRPC call, e.g. /storage.v1.Storage/Get.
+	Path string
+	// Header returns a header, or the metadata of a gRPC call.
+	Header func(name string) string
+	// Body returns the body of an HTTP request, the deterministic protobuf
+	// encoding of the request of a unary gRPC call and nothing for streams.
+	Body func() ([]byte, error)
+}
+
+// Authenticator finds out who sent a request.
+type Authenticator interface {
+	// Authenticate fails with ErrNoCredentials if the request carries none
+	// of its credentials, and with another ErrUnauthenticated if they are
+	// wrong.
+	Authenticate(r Request) (Principal, error)
+	// Challenge is the WWW-Authenticate value of its scheme.
+	Challenge() string
+}
+
+type chain []Authenticator
+
+// Chain asks each of auths in turn; the first one whose credentials the
+// request carries decides.
+func Chain(auths ...Authenticator) Authenticator {
+	if len(auths) == 1 {
+		return auths[0]
+	}
+	return chain(auths)
+}
+
+func (c chain) Authenticate(r Request) (Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if !errors.Is(err, ErrNoCredentials) {
+			return p, err
+		}
+	}
+	return Principal{}, ErrNoCredentials
+}
+
+func (c chain) Challenge() string {
+	challenges := make([]string, len(c))
+	for i, a := range c {
+		challenges[i] = a.Challenge()
+	}
+	return strings.Join(challenges, ", ")
+}
+
+type tenants struct {
+	Authenticator
+	tenants map[string]string
+}
+
+// Tenants lets the principals that a authenticates act for the tenant that
+// they map to in m, unless a found one of their own.
+func Tenants(a Authenticator, m map[string]string) Authenticator {
+	return tenants{Authenticator: a, tenants: m}
+}
+
+func (t tenants) Authenticate(r Request) (Principal, error) {
+	p, err := t.Authenticator.Authenticate(r)
+	if err == nil && p.Tenant == "" {
+		p.Tenant = t.tenants[p.ID]
+	}
+	return p, err
+}
+
+// Config is the -auth file. Each section that is set adds an Authenticator.
+type Config struct {
+	APIKeys []struct {
+		Key       string `yaml:"key"`
+		Principal string `yaml:"principal"`
+	} `yaml:"api_keys,omitempty"`
+	HMAC *struct {
+		// MaxSkew is how far the timestamp of a request may be off, 5m if
+		// empty.
+		MaxSkew string `yaml:"max_skew,omitempty"`
+		Keys    []struct {
+			ID     string `yaml:"id"`
+			Secret string `yaml:"secret"`
+		} `yaml:"keys"`
+	} `yaml:"hmac,omitempty"`
+	JWT *struct {
+		// JWKS is a file, relative to the config file.
+		JWKS     string `yaml:"jwks"`
+		Issuer   string `yaml:"issuer,omitempty"`
+		Audience string `yaml:"audience,omitempty"`
+		// Claim holds the principal, sub if empty.
+		Claim string `yaml:"claim,omitempty"`
+		// TenantClaim holds the tenant of the principal, which takes
+		// precedence over Tenants.
+		TenantClaim string `yaml:"tenant_claim,omitempty"`
+	} `yaml:"jwt,omitempty"`
+	// Tenants maps a principal to the tenant it acts for. The others act
+	// for none.
+	Tenants map[string]string `yaml:"tenants,omitempty"`
+}
+
+// Load reads the config file, or returns nil if file is empty, which leaves
+// the API open.
+func Load(file string) (Authenticator, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+
+	var auths []Authenticator
+	if len(c.APIKeys) > 0 {
+		keys := map[string]string{}
+		for _, k := range c.APIKeys {
+			if k.Key == "" || k.Principal == "" {
+				return nil, errors.New("api_keys: key and principal are required")
+			}
+			keys[k.Key] = k.Principal
+		}
+		auths = append(auths, APIKeys(keys))
+	}
+	if c.HMAC != nil {
+		maxSkew := 5 * time.Minute
+		if c.HMAC.MaxSkew != "" {
+			if maxSkew, err = time.ParseDuration(c.HMAC.MaxSkew); err != nil {
+				return nil, fmt.Errorf("hmac: max_skew: %w", err)
+			}
+		}
+		secrets := map[string][]byte{}
+		for _, k := range c.HMAC.Keys {
+			if k.ID == "" || k.Secret == "" {
+				return nil, errors.New("hmac: id and secret are required")
+			}
+			secrets[k.ID] = []byte(k.Secret)
+		}
+		auths = append(auths, HMAC(secrets, maxSkew))
+	}
+	if c.JWT != nil {
+		jwks := c.JWT.JWKS
+		if !filepath.IsAbs(jwks) {
+			jwks = filepath.Join(filepath.Dir(file), jwks)
+		}
+		keys, err := LoadJWKS(jwks)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: %w", err)
+		}
+		auths = append(auths, JWT(keys, c.JWT.Issuer, c.JWT.Audience, c.JWT.Claim, c.JWT.TenantClaim))
+	}
+	if len(auths) == 0 {
+		return nil, fmt.Errorf("%s: no api_keys, hmac or jwt", file)
+	}
+	if len(c.Tenants) > 0 {
+		return Tenants(Chain(auths...), c.Tenants), nil
+	}
+	return Chain(auths...), nil
+}
+
+type principalKey struct{}
+
+// NewContext returns a context that carries p, with p.ID added to the baggage
+// for the calls made within it.
+func NewContext(ctx context.Context, p Principal) context.Context {
+	ctx = context.WithValue(ctx, principalKey{}, p)
+	if m, err := baggage.NewMemberRaw(BaggageKey, p.ID); err == nil {
+		if b, err := baggage.FromContext(ctx).SetMember(m); err == nil {
+			ctx = baggage.ContextWithBaggage(ctx, b)
+		}
+	}
+	return ctx
+}
+
+// FromContext returns the principal that ctx carries.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// fromBaggage returns the principal of the baggage in ctx, which the
+// OpenTelemetry propagator extracted, or else of a baggage header.
+func fromBaggage(ctx context.Context, header string) (string, bool) {
+	b := baggage.FromContext(ctx)
+	if b.Len() == 0 && header != "" {
+		b, _ = baggage.Parse(header)
+	}
+	id := b.Member(BaggageKey).Value()
+	return id, id != ""
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func apiKey(key string) Request {
+	return Request{Header: func(name string) string {
+		if name == APIKeyHeader {
+			return key
+		}
+		return ""
+	}}
+}
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "auth.yaml")
+	config := `
+api_keys:
+  - key: k1
+    principal: alice
+  - key: k2
+    principal: bob
+hmac:
+  keys:
+    - id: batch-job
+      secret: s3cr3t
+tenants:
+  alice: acme
+`
+	if err := os.WriteFile(file, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Challenge(); got != "ApiKey, HMAC-SHA256" {
+		t.Errorf("Challenge = %q", got)
+	}
+	for key, want := range map[string]Principal{
+		"k1": {ID: "alice", Method: "api_key", Tenant: "acme"},
+		"k2": {ID: "bob", Method: "api_key"},
+	} {
+		if p, err := a.Authenticate(apiKey(key)); err != nil || p != want {
+			t.Errorf("%s: %+v, %v, want %+v", key, p, err, want)
+		}
+	}
+
+	if a, err := Load(""); a != nil || err != nil {
+		t.Errorf("Load without file = %v, %v, want nil", a, err)
+	}
+	if err := os.WriteFile(file, []byte("tenants:\n  alice: acme\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil {
+		t.Error("loaded a config without authenticators")
+	}
+}
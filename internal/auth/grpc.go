@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/baggage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// authenticated tells which methods need authentication: all but the health
+// checks, which the orchestrator makes without credentials.
+func authenticated(fullMethod string) bool {
+	return !strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/")
+}
+
+// grpcRequest is what an Authenticator sees of a call, with the request
+// message of a unary call and none of a stream.
+func grpcRequest(ctx context.Context, fullMethod string, req any) Request {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return Request{
+		Method: "POST",
+		Path:   fullMethod,
+		Header: func(name string) string {
+			if v := md.Get(name); len(v) > 0 {
+				return v[0]
+			}
+			return ""
+		},
+		Body: func() ([]byte, error) {
+			m, ok := req.(proto.Message)
+			if !ok {
+				return nil, nil
+			}
+			return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+		},
+	}
+}
+
+func authenticate(ctx context.Context, a Authenticator, t Tracing, r Request) (context.Context, error) {
+	p, err := a.Authenticate(r)
+	if err != nil {
+		t.Rejected(ctx, err)
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	}
+	t.Authenticated(ctx, p)
+	return NewContext(ctx, p), nil
+}
+
+// UnaryServerInterceptor authenticates every call with a, failing with
+// Unauthenticated. A nil a lets every call through.
+func UnaryServerInterceptor(a Authenticator, t Tracing) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if a == nil || !authenticated(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := authenticate(ctx, a, t, grpcRequest(ctx, info.FullMethod, req))
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authenticates every stream with a when it opens,
+// before any message is read; signed streams sign an empty body and a nonce.
+func StreamServerInterceptor(a Authenticator, t Tracing) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if a == nil || !authenticated(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		ctx, err := authenticate(ss.Context(), a, t, grpcRequest(ss.Context(), info.FullMethod, nil))
+		if err != nil {
+			return err
+		}
+		return handler(srv, serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// IdentityUnaryServerInterceptor records the principal that the caller passed
+// on in its baggage, for the services behind service1. Only callers that
+// presented a client certificate are trusted with it.
+func IdentityUnaryServerInterceptor(t Tracing) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(identify(ctx, t), req)
+	}
+}
+
+// IdentityStreamServerInterceptor is IdentityUnaryServerInterceptor for
+// streams.
+func IdentityStreamServerInterceptor(t Tracing) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, serverStream{ServerStream: ss, ctx: identify(ss.Context(), t)})
+	}
+}
+
+func identify(ctx context.Context, t Tracing) context.Context {
+	if !CertifiedPeer(ctx) {
+		return ctx
+	}
+	header := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		header = strings.Join(md.Get("baggage"), ",")
+	}
+	id, ok := fromBaggage(ctx, header)
+	if !ok {
+		return ctx
+	}
+	p := Principal{ID: id}
+	t.Authenticated(ctx, p)
+	return NewContext(ctx, p)
+}
+
+// CertifiedPeer reports whether the caller of the gRPC call of ctx presented
+// a client certificate, which tlsconfig verifies against its CA before the
+// call. Only such callers are trusted with the baggage that service1 sets.
+func CertifiedPeer(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	return ok && len(info.State.PeerCertificates) > 0
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// UnaryClientInterceptor passes the baggage of ctx on in the baggage
+// metadata. otelgrpc does so by itself; the Zipkin services need it.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor is UnaryClientInterceptor for streams.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoing(ctx), desc, cc, method, opts...)
+	}
+}
+
+func outgoing(ctx context.Context) context.Context {
+	if b := baggage.FromContext(ctx); b.Len() > 0 {
+		return metadata.AppendToOutgoingContext(ctx, "baggage", b.String())
+	}
+	return ctx
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s stream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	intercept := StreamServerInterceptor(HMAC(map[string][]byte{"batch-job": secret}, time.Minute), OTel)
+	info := &grpc.StreamServerInfo{FullMethod: "/storage.v1.Storage/Watch"}
+	open := func(nonce string) (string, error) {
+		s := Signed{Method: "POST", Path: info.FullMethod, Tenant: "acme", Timestamp: strconv.FormatInt(time.Now().Unix(), 10), Nonce: nonce}
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			KeyIDHeader, "batch-job",
+			TimestampHeader, s.Timestamp,
+			NonceHeader, nonce,
+			TenantHeader, "acme",
+			SignatureHeader, Sign(secret, s),
+		))
+		id := ""
+		err := intercept(nil, stream{ctx: ctx}, info, func(_ any, ss grpc.ServerStream) error {
+			p, _ := FromContext(ss.Context())
+			id = p.ID
+			return nil
+		})
+		return id, err
+	}
+
+	if _, err := open(""); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("stream without nonce: %v, want Unauthenticated", err)
+	}
+	if id, err := open("n1"); err != nil || id != "batch-job" {
+		t.Errorf("stream = %q, %v, want batch-job", id, err)
+	}
+	if _, err := open("n1"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("replayed stream: %v, want Unauthenticated", err)
+	}
+}
+
+func TestIdentityUnaryServerInterceptor(t *testing.T) {
+	intercept := IdentityUnaryServerInterceptor(OTel)
+	call := func(p *peer.Peer) string {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("baggage", BaggageKey+"=alice"))
+		if p != nil {
+			ctx = peer.NewContext(ctx, p)
+		}
+		id := ""
+		intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/storage.v1.Storage/Get"}, func(ctx context.Context, _ any) (any, error) {
+			p, _ := FromContext(ctx)
+			id = p.ID
+			return nil, nil
+		})
+		return id
+	}
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+
+	if id := call(nil); id != "" {
+		t.Errorf("call without peer passed on %q", id)
+	}
+	if id := call(&peer.Peer{Addr: addr}); id != "" {
+		t.Errorf("plaintext caller passed on %q", id)
+	}
+	if id := call(&peer.Peer{Addr: addr, AuthInfo: credentials.TLSInfo{}}); id != "" {
+		t.Errorf("caller without certificate passed on %q", id)
+	}
+	certified := credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}}
+	if id := call(&peer.Peer{Addr: addr, AuthInfo: certified}); id != "alice" {
+		t.Errorf("caller with certificate passed on %q, want alice", id)
+	}
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of an HMAC-signed request.
+const (
+	KeyIDHeader     = "X-Auth-Key-Id"
+	TimestampHeader = "X-Auth-Timestamp"
+	NonceHeader     = "X-Auth-Nonce"
+	SignatureHeader = "X-Auth-Signature"
+)
+
+// TenantHeader is the tenant.Header of a request, which the signature covers
+// so that a request cannot be replayed against another tenant.
+const TenantHeader = "X-Tenant-Id"
+
+// maxNonce is the longest nonce, which bounds the memory of the ones seen.
+const maxNonce = 64
+
+type hmacAuth struct {
+	secrets map[string][]byte
+	maxSkew time.Duration
+
+	mu sync.Mutex
+	// nonces maps the key ID and nonce of the requests seen to when they
+	// would be too old to be accepted again
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+// HMAC authenticates requests signed with one of secrets, by key ID, as the
+// key ID. The timestamp of a request, in Unix seconds, may be off by maxSkew
+// at most, and every request carries a nonce, which is accepted once within
+// that time, so that a captured request cannot be replayed. The nonces seen
+// are kept in memory, not shared between replicas.
+func HMAC(secrets map[string][]byte, maxSkew time.Duration) Authenticator {
+	return &hmacAuth{secrets: secrets, maxSkew: maxSkew, nonces: map[string]time.Time{}, lastSweep: time.Now()}
+}
+
+// Signed is what the signature of a request covers.
+type Signed struct {
+	Method string
+	Path   string
+	// Tenant is the X-Tenant-Id header, empty without one.
+	Tenant    string
+	Timestamp string
+	// Nonce is the X-Auth-Nonce header.
+	Nonce string
+	Body  []byte
+}
+
+// Sign returns the X-Auth-Signature of a request: the hex HMAC-SHA256 with
+// secret of
+//
+//	method + "\n" + path + "\n" + tenant + "\n" + timestamp + "\n" + nonce + "\n" + hex(sha256(body))
+func Sign(secret []byte, s Signed) string {
+	sum := sha256.Sum256(s.Body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(s.Method + "\n" + s.Path + "\n" + s.Tenant + "\n" + s.Timestamp + "\n" + s.Nonce + "\n" + hex.EncodeToString(sum[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *hmacAuth) Authenticate(r Request) (Principal, error) {
+	id := r.Header(KeyIDHeader)
+	if id == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	secret, ok := a.secrets[id]
+	if !ok {
+		return Principal{}, invalid("unknown key ID %q", id)
+	}
+	timestamp := r.Header(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Principal{}, invalid("bad timestamp %q", timestamp)
+	}
+	if skew := time.Since(time.Unix(unix, 0)).Abs(); skew > a.maxSkew {
+		return Principal{}, invalid("timestamp is %v off", skew.Truncate(time.Second))
+	}
+	nonce := r.Header(NonceHeader)
+	if nonce == "" {
+		return Principal{}, invalid("no %s", NonceHeader)
+	}
+	if len(nonce) > maxNonce {
+		return Principal{}, invalid("nonce longer than %d bytes", maxNonce)
+	}
+	body, err := r.Body()
+	if err != nil {
+		return Principal{}, invalid("read body: %w", err)
+	}
+	want := Sign(secret, Signed{
+		Method:    r.Method,
+		Path:      r.Path,
+		Tenant:    r.Header(TenantHeader),
+		Timestamp: timestamp,
+		Nonce:     nonce,
+		Body:      body,
+	})
+	if !hmac.Equal([]byte(r.Header(SignatureHeader)), []byte(want)) {
+		return Principal{}, invalid("bad signature")
+	}
+	// only signed nonces are recorded, so that others cannot use them up
+	if a.replayed(id, nonce, time.Unix(unix, 0)) {
+		return Principal{}, invalid("nonce already used")
+	}
+	return Principal{ID: id, Method: "hmac"}, nil
+}
+
+// replayed records the nonce of key id on a request of timestamp, and
+// reports whether a request within the skew used it before.
+func (a *hmacAuth) replayed(id, nonce string, timestamp time.Time) bool {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.Sub(a.lastSweep) >= a.maxSkew {
+		a.lastSweep = now
+		for k, expires := range a.nonces {
+			if now.After(expires) {
+				delete(a.nonces, k)
+			}
+		}
+	}
+	k := id + "\n" + nonce
+	if expires, ok := a.nonces[k]; ok && !now.After(expires) {
+		return true
+	}
+	a.nonces[k] = timestamp.Add(a.maxSkew)
+	return false
+}
+
+func (a *hmacAuth) Challenge() string {
+	return "HMAC-SHA256"
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var secret = []byte("s3cr3t")
+
+// signed is a request of key batch-job signed at t, whose headers a test
+// may change after signing.
+func signed(t time.Time, s Signed) (Request, map[string]string) {
+	s.Timestamp = strconv.FormatInt(t.Unix(), 10)
+	headers := map[string]string{
+		KeyIDHeader:     "batch-job",
+		TimestampHeader: s.Timestamp,
+		NonceHeader:     s.Nonce,
+		TenantHeader:    s.Tenant,
+		SignatureHeader: Sign(secret, s),
+	}
+	return Request{
+		Method: s.Method,
+		Path:   s.Path,
+		Header: func(name string) string { return headers[name] },
+		Body:   func() ([]byte, error) { return s.Body, nil },
+	}, headers
+}
+
+func TestHMAC(t *testing.T) {
+	a := HMAC(map[string][]byte{"batch-job": secret}, time.Minute)
+	put := Signed{Method: "PUT", Path: "/v1/kv/k", Tenant: "acme", Nonce: "n1", Body: []byte(`{"value":"v"}`)}
+
+	r, _ := signed(time.Now(), put)
+	p, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != (Principal{ID: "batch-job", Method: "hmac"}) {
+		t.Errorf("principal = %+v", p)
+	}
+	if _, err := a.Authenticate(r); err == nil {
+		t.Error("accepted a replayed request")
+	}
+
+	for name, tamper := range map[string]func(headers map[string]string){
+		"other tenant": func(h map[string]string) { h[TenantHeader] = "globex" },
+		"no tenant":    func(h map[string]string) { h[TenantHeader] = "" },
+		"other nonce":  func(h map[string]string) { h[NonceHeader] = "n2" },
+		"no nonce":     func(h map[string]string) { h[NonceHeader] = "" },
+		"unknown key":  func(h map[string]string) { h[KeyIDHeader] = "other" },
+	} {
+		r, headers := signed(time.Now(), put)
+		tamper(headers)
+		if _, err := a.Authenticate(r); !errors.Is(err, ErrUnauthenticated) || errors.Is(err, ErrNoCredentials) {
+			t.Errorf("%s: err = %v, want ErrUnauthenticated", name, err)
+		}
+	}
+
+	r, _ = signed(time.Now().Add(-2*time.Minute), put)
+	if _, err := a.Authenticate(r); err == nil {
+		t.Error("accepted a timestamp beyond the skew")
+	}
+	if _, err := a.Authenticate(Request{Header: func(string) string { return "" }}); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("request without credentials: err = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestHMACNonce(t *testing.T) {
+	a := HMAC(map[string][]byte{"batch-job": secret}, time.Minute)
+	watch := Signed{Method: "POST", Path: "/storage.v1.Storage/Watch"}
+
+	r, _ := signed(time.Now(), watch)
+	if _, err := a.Authenticate(r); err == nil {
+		t.Fatal("accepted a request without nonce")
+	}
+
+	watch.Nonce = "n1"
+	r, _ = signed(time.Now(), watch)
+	if _, err := a.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(r); err == nil {
+		t.Fatal("accepted a replayed stream")
+	}
+	// the same nonce signed anew is still a replay
+	r, _ = signed(time.Now().Add(time.Second), watch)
+	if _, err := a.Authenticate(r); err == nil {
+		t.Fatal("accepted a nonce twice")
+	}
+	watch.Nonce = "n2"
+	r, _ = signed(time.Now(), watch)
+	if _, err := a.Authenticate(r); err != nil {
+		t.Fatalf("fresh nonce: %v", err)
+	}
+
+	// an unsigned nonce is not recorded, or anyone could use it up
+	watch.Nonce = "n3"
+	r, headers := signed(time.Now(), watch)
+	headers[SignatureHeader] = "forged"
+	if _, err := a.Authenticate(r); err == nil {
+		t.Fatal("accepted a forged signature")
+	}
+	r, _ = signed(time.Now(), watch)
+	if _, err := a.Authenticate(r); err != nil {
+		t.Fatalf("nonce after a forged request: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"go-service-tracing/internal/readiness"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+// MaxBodyBytes is the largest body HTTPRequest reads, which is far beyond any
+// value of the KV API.
+const MaxBodyBytes = 1 << 20
+
+// HTTPRequest is what an Authenticator sees of req, the request that w
+// answers. Reading the body leaves it in place for the handler, and fails
+// with an *http.MaxBytesError beyond MaxBodyBytes.
+func HTTPRequest(w http.ResponseWriter, req *http.Request) Request {
+	return Request{
+		Method: req.Method,
+		Path:   req.URL.RequestURI(),
+		Header: req.Header.Get,
+		Body: func() ([]byte, error) {
+			if req.Body == nil {
+				return nil, nil
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, MaxBodyBytes))
+			req.Body.Close()
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			return body, err
+		},
+	}
+}
+
+// Gin authenticates every request but the probes with a, answering 401 to
+// those that fail and 413 to those whose body is beyond MaxBodyBytes. A nil
+// a lets every request through.
+func Gin(a Authenticator, t Tracing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a == nil || readiness.IsProbe(c.Request) {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		p, err := a.Authenticate(HTTPRequest(c.Writer, c.Request))
+		if err != nil {
+			t.Rejected(ctx, err)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"message": err.Error()})
+				return
+			}
+			c.Header("WWW-Authenticate", a.Challenge())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		}
+		t.Authenticated(ctx, p)
+		c.Request = c.Request.WithContext(NewContext(ctx, p))
+		c.Next()
+	}
+}
+
+// Identity records the principal that the caller passed on in its baggage,
+// for the services behind service1. Only callers that presented a client
+// certificate are trusted with it.
+func Identity(t Tracing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if id, ok := fromBaggage(ctx, c.GetHeader("baggage")); ok && Certified(c.Request) {
+			p := Principal{ID: id}
+			t.Authenticated(ctx, p)
+			c.Request = c.Request.WithContext(NewContext(ctx, p))
+		}
+		c.Next()
+	}
+}
+
+// Certified is CertifiedPeer for the caller of an HTTP request.
+func Certified(req *http.Request) bool {
+	return req.TLS != nil && len(req.TLS.PeerCertificates) > 0
+}
+
+// SetHeader passes the baggage of the context of req on in its baggage
+// header. otelhttp does so by itself; the Zipkin services need it.
+func SetHeader(req *http.Request) {
+	if b := baggage.FromContext(req.Context()); b.Len() > 0 {
+		req.Header.Set("baggage", b.String())
+	}
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestGin(t *testing.T) {
+	a := Chain(APIKeys(map[string]string{"k1": "alice"}), HMAC(map[string][]byte{"batch-job": secret}, time.Minute))
+	r := gin.New()
+	r.Use(Gin(a, OTel))
+	r.PUT("/v1/kv/:key", func(c *gin.Context) {
+		p, _ := FromContext(c.Request.Context())
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, p.ID+" "+string(body)+" "+baggage.FromContext(c.Request.Context()).Member(BaggageKey).Value())
+	})
+	serve := func(body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/v1/kv/k", strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(`{}`, map[string]string{APIKeyHeader: "k1"}); w.Code != http.StatusOK || w.Body.String() != "alice {} alice" {
+		t.Errorf("API key: %d %q", w.Code, w.Body)
+	}
+	w := serve(`{}`, map[string]string{APIKeyHeader: "k2"})
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "ApiKey, HMAC-SHA256" {
+		t.Errorf("unknown API key: %d, WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	// the body the handler reads is the one that was signed
+	body := `{"value":"v"}`
+	s := Signed{Method: http.MethodPut, Path: "/v1/kv/k", Timestamp: strconv.FormatInt(time.Now().Unix(), 10), Nonce: "n1", Body: []byte(body)}
+	signedHeader := map[string]string{KeyIDHeader: "batch-job", TimestampHeader: s.Timestamp, NonceHeader: s.Nonce, SignatureHeader: Sign(secret, s)}
+	if w := serve(body, signedHeader); w.Code != http.StatusOK || w.Body.String() != "batch-job "+body+" batch-job" {
+		t.Errorf("HMAC: %d %q", w.Code, w.Body)
+	}
+
+	huge := strings.Repeat("x", MaxBodyBytes+1)
+	if w := serve(huge, signedHeader); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body beyond MaxBodyBytes: %d, want 413", w.Code)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	r := gin.New()
+	r.Use(Identity(OTel))
+	r.GET("/v1/kv/k", func(c *gin.Context) {
+		p, _ := FromContext(c.Request.Context())
+		c.String(http.StatusOK, p.ID)
+	})
+	serve := func(state *tls.ConnectionState) string {
+		req := httptest.NewRequest(http.MethodGet, "/v1/kv/k", nil)
+		req.Header.Set("baggage", BaggageKey+"=alice")
+		req.TLS = state
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	if id := serve(nil); id != "" {
+		t.Errorf("plaintext caller passed on %q", id)
+	}
+	if id := serve(&tls.ConnectionState{}); id != "" {
+		t.Errorf("caller without certificate passed on %q", id)
+	}
+	if id := serve(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}); id != "alice" {
+		t.Errorf("caller with certificate passed on %q, want alice", id)
+	}
+}
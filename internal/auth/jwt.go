@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// JWKS holds the public keys of a JSON Web Key Set by key ID.
+type JWKS map[string]crypto.PublicKey
+
+// LoadJWKS reads the RSA and EC P-256/P-384 keys of a JWKS file. Keys of
+// other types are skipped.
+func LoadJWKS(file string) (JWKS, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	keys := JWKS{}
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err := errors.Join(err1, err2); err != nil {
+				return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err := errors.Join(err1, err2); err != nil {
+				return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no RSA or EC keys", file)
+	}
+	return keys, nil
+}
+
+type jwtAuth struct {
+	keys        JWKS
+	issuer      string
+	audience    string
+	claim       string
+	tenantClaim string
+}
+
+// JWT authenticates requests with an "Authorization: Bearer" JWT signed with
+// one of keys, as the value of claim, sub if empty, acting for the tenant of
+// tenantClaim unless that is empty. The token must not be expired and must
+// come from issuer for audience, unless they are empty. RS256, RS384, RS512,
+// ES256 and ES384 are accepted.
+func JWT(keys JWKS, issuer, audience, claim, tenantClaim string) Authenticator {
+	if claim == "" {
+		claim = "sub"
+	}
+	return jwtAuth{keys: keys, issuer: issuer, audience: audience, claim: claim, tenantClaim: tenantClaim}
+}
+
+func (a jwtAuth) Authenticate(r Request) (Principal, error) {
+	token, ok := strings.CutPrefix(r.Header("Authorization"), "Bearer ")
+	if !ok {
+		return Principal{}, ErrNoCredentials
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, invalid("malformed JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Principal{}, invalid("JWT header: %v", err)
+	}
+	key, ok := a.keys[header.Kid]
+	if !ok {
+		return Principal{}, invalid("unknown JWT key %q", header.Kid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, invalid("JWT signature: %v", err)
+	}
+	if err := verify(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return Principal{}, invalid("JWT signature: %v", err)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, invalid("JWT claims: %v", err)
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return Principal{}, invalid("JWT has no exp")
+	}
+	if now.After(time.Unix(int64(exp), 0)) {
+		return Principal{}, invalid("JWT expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0)) {
+		return Principal{}, invalid("JWT not valid yet")
+	}
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return Principal{}, invalid("JWT issuer %v", claims["iss"])
+	}
+	if a.audience != "" && !hasAudience(claims["aud"], a.audience) {
+		return Principal{}, invalid("JWT audience %v", claims["aud"])
+	}
+	id, _ := claims[a.claim].(string)
+	if id == "" {
+		return Principal{}, invalid("JWT has no %s", a.claim)
+	}
+	p := Principal{ID: id, Method: "jwt"}
+	if a.tenantClaim != "" {
+		p.Tenant, _ = claims[a.tenantClaim].(string)
+	}
+	return p, nil
+}
+
+func (a jwtAuth) Challenge() string {
+	return "Bearer"
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verify checks sig of signed with key, which must be of the type alg names.
+// Keys are looked up by the kid of the token, so a mismatch of alg and key
+// type is an error rather than a reason to try the other algorithm.
+func verify(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("alg %q for an RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(key, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		// ES256 is P-256 with SHA-256 and ES384 P-384 with SHA-384
+		if curve, ok := curves[alg]; !ok || key.Curve != curve {
+			return fmt.Errorf("alg %q for an EC key of %s", alg, key.Curve.Params().Name)
+		}
+		// r and s, each as long as the curve's order
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("bad ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("ECDSA verification failed")
+		}
+		return nil
+	}
+	return errors.New("unsupported key")
+}
+
+// curves are the curves of the ECDSA algorithms.
+var curves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+}
+
+func hasAudience(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// token signs claims with key as alg, under key ID kid.
+func token(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}[alg[2:]]
+	h := hash.New()
+	h.Write([]byte(signed))
+	var sig []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, hash, h.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func bearer(token string) Request {
+	return Request{Header: func(name string) string {
+		if name == "Authorization" {
+			return "Bearer " + token
+		}
+		return ""
+	}}
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	a := JWT(JWKS{"rsa": &rsaKey.PublicKey, "p256": &p256.PublicKey, "p384": &p384.PublicKey}, "issuer", "kv-api", "", "tenant")
+	claims := func(change func(map[string]any)) map[string]any {
+		c := map[string]any{"sub": "alice", "tenant": "acme", "iss": "issuer", "aud": []string{"other", "kv-api"}, "exp": time.Now().Add(time.Minute).Unix()}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	for _, tc := range []struct {
+		alg, kid string
+		key      crypto.Signer
+	}{
+		{"RS256", "rsa", rsaKey},
+		{"RS512", "rsa", rsaKey},
+		{"ES256", "p256", p256},
+		{"ES384", "p384", p384},
+	} {
+		p, err := a.Authenticate(bearer(token(t, tc.alg, tc.kid, tc.key, claims(nil))))
+		if err != nil {
+			t.Errorf("%s: %v", tc.alg, err)
+		} else if p != (Principal{ID: "alice", Method: "jwt", Tenant: "acme"}) {
+			t.Errorf("%s: principal = %+v", tc.alg, p)
+		}
+	}
+
+	for name, tok := range map[string]string{
+		// a P-384 signature over a SHA-256 digest
+		"ES256 with a P-384 key": token(t, "ES256", "p384", p384, claims(nil)),
+		"ES384 with a P-256 key": token(t, "ES384", "p256", p256, claims(nil)),
+		"RS256 with an EC key":   token(t, "RS256", "p256", rsaKey, claims(nil)),
+		"ES256 with an RSA key":  token(t, "ES256", "rsa", p256, claims(nil)),
+		"other key":              token(t, "ES256", "p256", mustKey(t), claims(nil)),
+		"expired":                token(t, "ES256", "p256", p256, claims(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Second).Unix() })),
+		"without exp":            token(t, "ES256", "p256", p256, claims(func(c map[string]any) { delete(c, "exp") })),
+		"not valid yet":          token(t, "ES256", "p256", p256, claims(func(c map[string]any) { c["nbf"] = time.Now().Add(time.Minute).Unix() })),
+		"other issuer":           token(t, "ES256", "p256", p256, claims(func(c map[string]any) { c["iss"] = "other" })),
+		"other audience":         token(t, "ES256", "p256", p256, claims(func(c map[string]any) { c["aud"] = "other" })),
+		"without sub":            token(t, "ES256", "p256", p256, claims(func(c map[string]any) { delete(c, "sub") })),
+		"malformed":              "a.b",
+	} {
+		if _, err := a.Authenticate(bearer(tok)); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: err = %v, want ErrUnauthenticated", name, err)
+		}
+	}
+}
+
+func mustKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
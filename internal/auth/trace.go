@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/openzipkin/zipkin-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing records the outcome of an authentication on the span in ctx, the
+// server span of the request.
+type Tracing interface {
+	// Authenticated records p as enduser.id, and how it was authenticated
+	// if known.
+	Authenticated(ctx context.Context, p Principal)
+	// Rejected marks the span as failed with err.
+	Rejected(ctx context.Context, err error)
+}
+
+type otelTracing struct{}
+
+// OTel records on OpenTelemetry spans.
+var OTel Tracing = otelTracing{}
+
+func (otelTracing) Authenticated(ctx context.Context, p Principal) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String(AttrEnduserID, p.ID))
+	if p.Method != "" {
+		span.SetAttributes(attribute.String(AttrMethod, p.Method))
+	}
+}
+
+func (otelTracing) Rejected(ctx context.Context, err error) {
+	// the HTTP and gRPC instrumentation leave client errors unset on server
+	// spans; an error status is not lowered by them afterwards
+	trace.SpanFromContext(ctx).SetStatus(codes.Error, err.Error())
+}
+
+type zipkinTracing struct{}
+
+// Zipkin records on Zipkin spans.
+var Zipkin Tracing = zipkinTracing{}
+
+func (zipkinTracing) Authenticated(ctx context.Context, p Principal) {
+	if span := zipkin.SpanFromContext(ctx); span != nil {
+		span.Tag(AttrEnduserID, p.ID)
+		if p.Method != "" {
+			span.Tag(AttrMethod, p.Method)
+		}
+	}
+}
+
+func (zipkinTracing) Rejected(ctx context.Context, err error) {
+	if span := zipkin.SpanFromContext(ctx); span != nil {
+		zipkin.TagError.Set(span, err.Error())
+	}
+}
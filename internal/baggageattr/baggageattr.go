@@ -0,0 +1,103 @@
+// Package baggageattr records selected members of the W3C baggage, e.g.
+// user.tier or experiment.id, as attributes on every span of a process, and
+// keeps the rest of the baggage that callers send out of the services.
+//
+// A Policy lists the members that are allowed and limits how many there may
+// be and how long their values. SpanProcessor and ZipkinHook record the
+// allowed members on the spans; Strip and its interceptors drop everything
+// else where requests enter from outside, at service1.
+package baggageattr
+
+import (
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/baggage"
+)
+
+// Defaults of the limits of a Policy.
+const (
+	DefaultMaxMembers     = 8
+	DefaultMaxValueLength = 128
+)
+
+// Policy decides which baggage members are kept and recorded.
+type Policy struct {
+	// Allow lists the keys of the allowed members. A key ending in ".*"
+	// allows every key with that prefix, e.g. experiment.* allows
+	// experiment.id.
+	Allow []string
+	// MaxMembers caps the allowed members, in the order of their keys; 0
+	// means no limit.
+	MaxMembers int
+	// MaxValueLength drops members with longer values; 0 means no limit.
+	MaxValueLength int
+}
+
+// ParseAllow reads a comma-separated list of keys.
+func ParseAllow(s string) []string {
+	var allow []string
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			allow = append(allow, key)
+		}
+	}
+	return allow
+}
+
+func (p Policy) allowed(key string) bool {
+	for _, a := range p.Allow {
+		if prefix, ok := strings.CutSuffix(a, "*"); ok && strings.HasSuffix(prefix, ".") {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the members of b that p allows.
+func (p Policy) Filter(b baggage.Baggage) baggage.Baggage {
+	var members []baggage.Member
+	for _, m := range b.Members() {
+		if !p.allowed(m.Key()) {
+			continue
+		}
+		if p.MaxValueLength > 0 && len(m.Value()) > p.MaxValueLength {
+			continue
+		}
+		members = append(members, m)
+	}
+	// Members comes in no particular order
+	slices.SortFunc(members, func(a, b baggage.Member) int {
+		return strings.Compare(a.Key(), b.Key())
+	})
+	if p.MaxMembers > 0 && len(members) > p.MaxMembers {
+		members = members[:p.MaxMembers]
+	}
+	filtered, err := baggage.New(members...)
+	if err != nil {
+		return baggage.Baggage{}
+	}
+	return filtered
+}
+
+// merge returns the members of header, the baggage a caller sent, with those
+// of b, which the service set itself, taking precedence.
+func merge(header string, b baggage.Baggage) baggage.Baggage {
+	if header == "" {
+		return b
+	}
+	merged, err := baggage.Parse(header)
+	if err != nil {
+		return b
+	}
+	for _, m := range b.Members() {
+		if next, err := merged.SetMember(m); err == nil {
+			merged = next
+		}
+	}
+	return merged
+}
@@ -0,0 +1,166 @@
+package baggageattr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"go.opentelemetry.io/otel/baggage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// keys returns the members of b as key=value, in the order of their keys.
+func keys(b baggage.Baggage) string {
+	var members []string
+	for _, m := range b.Members() {
+		members = append(members, m.Key()+"="+m.Value())
+	}
+	slices.Sort(members)
+	return strings.Join(members, ",")
+}
+
+func parse(t *testing.T, header string) baggage.Baggage {
+	t.Helper()
+	b, err := baggage.Parse(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		header string
+		want   string
+	}{
+		{"nothing allowed", Policy{}, "user.tier=gold", ""},
+		{"exact", Policy{Allow: []string{"user.tier"}}, "user.tier=gold,user.id=42", "user.tier=gold"},
+		{"wildcard", Policy{Allow: []string{"experiment.*"}}, "experiment.id=7,experiment.arm=b,experiments=x,user.tier=gold",
+			"experiment.arm=b,experiment.id=7"},
+		// only a prefix that ends in a dot is a wildcard
+		{"not a wildcard", Policy{Allow: []string{"experiment*"}}, "experiment.id=7,experiment*=x", "experiment*=x"},
+		{"value too long", Policy{Allow: []string{"a", "b"}, MaxValueLength: 3}, "a=abc,b=abcd", "a=abc"},
+		// the cap keeps the first members in the order of their keys, not
+		// of the header
+		{"most members", Policy{Allow: []string{"x.*"}, MaxMembers: 2}, "x.d=4,x.b=2,x.c=3,x.a=1", "x.a=1,x.b=2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := keys(tc.policy.Filter(parse(t, tc.header))); got != tc.want {
+				t.Errorf("Filter(%q) = %q, want %q", tc.header, got, tc.want)
+			}
+		})
+	}
+}
+
+var stripPolicy = Policy{Allow: []string{"user.tier"}}
+
+func TestStripGin(t *testing.T) {
+	for header, want := range map[string]string{
+		"user.tier=gold,tenant.id=acme": "user.tier=gold",
+		"tenant.id=acme":                "",
+		"":                              "",
+	} {
+		r := gin.New()
+		r.Use(Strip(stripPolicy))
+		var gotHeader, gotBaggage string
+		r.GET("/", func(c *gin.Context) {
+			gotHeader = c.GetHeader("baggage")
+			gotBaggage = keys(baggage.FromContext(c.Request.Context()))
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("baggage", header)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		if gotHeader != want || gotBaggage != want {
+			t.Errorf("baggage %q left header %q and context %q, want %q", header, gotHeader, gotBaggage, want)
+		}
+	}
+}
+
+func TestStripGRPC(t *testing.T) {
+	md := metadata.Pairs("baggage", "user.tier=gold,tenant.id=acme", "baggage", "enduser.id=alice")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	var gotHeader, gotBaggage string
+	_, err := StripUnaryServerInterceptor(stripPolicy)(ctx, nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, _ any) (any, error) {
+			gotHeader = baggageMetadata(ctx)
+			gotBaggage = keys(baggage.FromContext(ctx))
+			return nil, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotHeader != "user.tier=gold" || gotBaggage != "user.tier=gold" {
+		t.Errorf("left metadata %q and context %q, want user.tier=gold", gotHeader, gotBaggage)
+	}
+	// the metadata of the caller is not changed
+	if got := md.Get("baggage"); len(got) != 2 {
+		t.Errorf("caller metadata changed to %q", got)
+	}
+}
+
+// Two requests of the same trace keep their own tags on the spans beneath
+// them, which the reporter gets before their parents.
+func TestZipkinHook(t *testing.T) {
+	hook := NewZipkinHook(Policy{Allow: []string{"user.tier"}})
+	rec := recorder.NewReporter()
+	tracer, err := zipkin.NewTracer(hook.Reporter(rec), zipkin.WithSampler(zipkin.AlwaysSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the caller is another process, which sends each request from a
+	// client span of its own
+	callerTracer, err := zipkin.NewTracer(recorder.NewReporter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller := callerTracer.StartSpan("caller")
+
+	request := func(tier string) (end func()) {
+		client := callerTracer.StartSpan("client", zipkin.Kind(model.Client), zipkin.Parent(caller.Context()))
+		server := tracer.StartSpan("server "+tier, zipkin.Kind(model.Server), zipkin.Parent(client.Context()))
+		ctx := zipkin.NewContext(context.Background(), server)
+		done := hook.begin(ctx, "user.tier="+tier)
+		handler, ctx := tracer.StartSpanFromContext(ctx, "handler "+tier)
+		redis, _ := tracer.StartSpanFromContext(ctx, "redis "+tier)
+		return func() {
+			redis.Finish()
+			handler.Finish()
+			done()
+			server.Finish()
+		}
+	}
+	endGold := request("gold")
+	endSilver := request("silver")
+	endGold()
+	endSilver()
+
+	spans := rec.Flush()
+	if len(spans) != 6 {
+		t.Fatalf("%d spans reported, want 6", len(spans))
+	}
+	for _, s := range spans {
+		_, want, _ := strings.Cut(s.Name, " ")
+		if got := s.Tags["user.tier"]; got != want {
+			t.Errorf("span %s tagged user.tier=%q, want %q", s.Name, got, want)
+		}
+	}
+	if len(hook.spans) != 0 || len(hook.pending) != 0 || len(hook.requests) != 0 {
+		t.Errorf("hook holds %d spans, %d pending, %d requests after the requests", len(hook.spans), len(hook.pending), len(hook.requests))
+	}
+}
@@ -0,0 +1,71 @@
+package baggageattr
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/baggage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func baggageMetadata(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return strings.Join(md.Get("baggage"), ",")
+}
+
+// strip is Strip for the metadata of a call.
+func strip(ctx context.Context, p Policy) context.Context {
+	b := p.Filter(merge(baggageMetadata(ctx), baggage.Baggage{}))
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		md = md.Copy()
+		if b.Len() > 0 {
+			md.Set("baggage", b.String())
+		} else {
+			md.Delete("baggage")
+		}
+		ctx = metadata.NewIncomingContext(ctx, md)
+	}
+	return baggage.ContextWithBaggage(ctx, b)
+}
+
+// StripUnaryServerInterceptor is Strip for unary calls; it goes first in the
+// chain.
+func StripUnaryServerInterceptor(p Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(strip(ctx, p), req)
+	}
+}
+
+// StripStreamServerInterceptor is Strip for streams.
+func StripStreamServerInterceptor(p Policy) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, serverStream{ServerStream: ss, ctx: strip(ss.Context(), p)})
+	}
+}
+
+// UnaryServerInterceptor tags the spans of every call, after the
+// interceptors that add to its baggage.
+func (h *ZipkinHook) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		defer h.begin(ctx, baggageMetadata(ctx))()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func (h *ZipkinHook) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		defer h.begin(ss.Context(), baggageMetadata(ss.Context()))()
+		return handler(srv, ss)
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s serverStream) Context() context.Context {
+	return s.ctx
+}
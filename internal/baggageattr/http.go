@@ -0,0 +1,34 @@
+package baggageattr
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+// Strip keeps only the members of the baggage header of every request that p
+// allows, both in the header and in the baggage of the request context. It
+// goes first, where requests enter from outside: whatever the services add
+// later, such as the tenant, is theirs and kept.
+func Strip(p Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		b := p.Filter(merge(strings.Join(c.Request.Header.Values("baggage"), ","), baggage.Baggage{}))
+		if b.Len() > 0 {
+			c.Request.Header.Set("baggage", b.String())
+		} else {
+			c.Request.Header.Del("baggage")
+		}
+		c.Request = c.Request.WithContext(baggage.ContextWithBaggage(c.Request.Context(), b))
+		c.Next()
+	}
+}
+
+// Gin tags the spans of every request, after the middleware that adds to its
+// baggage.
+func (h *ZipkinHook) Gin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer h.begin(c.Request.Context(), strings.Join(c.Request.Header.Values("baggage"), ","))()
+		c.Next()
+	}
+}
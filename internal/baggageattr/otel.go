@@ -0,0 +1,30 @@
+package baggageattr
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type spanProcessor struct {
+	policy Policy
+}
+
+// SpanProcessor records the members of the baggage of the parent context
+// that p allows on every span as it starts, each as an attribute of the same
+// key.
+func SpanProcessor(p Policy) sdktrace.SpanProcessor {
+	return spanProcessor{policy: p}
+}
+
+func (sp spanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	for _, m := range sp.policy.Filter(baggage.FromContext(parent)).Members() {
+		s.SetAttributes(attribute.String(m.Key(), m.Value()))
+	}
+}
+
+func (spanProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (spanProcessor) Shutdown(context.Context) error   { return nil }
+func (spanProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,169 @@
+package baggageattr
+
+import (
+	"context"
+	"sync"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+// ZipkinHook is SpanProcessor for zipkin-go, which has no hook for the start
+// of a span. Its middleware tags the server span of every request with the
+// members of the baggage that the Policy allows and, until the request ends,
+// its reporter tags every span that the process sends beneath that server
+// span. Requests of the same trace keep their own tags. Spans that end after
+// their request, such as those of a watch that outlives it, are not tagged.
+type ZipkinHook struct {
+	policy Policy
+
+	mu   sync.Mutex
+	next reporter.Reporter
+	// spans maps the server span of every request in progress, and the
+	// spans sent beneath it, to the request
+	spans map[model.ID]*request
+	// pending holds the spans of a trace with a request in progress by the
+	// ID of their parent, until the parent is sent: a span ends before its
+	// parent does
+	pending map[model.ID][]model.SpanModel
+	// requests counts the requests in progress per trace
+	requests map[model.TraceID]int
+}
+
+// request is a request in progress and the IDs of its spans.
+type request struct {
+	tags map[string]string
+	ids  []model.ID
+}
+
+// NewZipkinHook returns a hook that tags the members that p allows.
+func NewZipkinHook(p Policy) *ZipkinHook {
+	return &ZipkinHook{
+		policy:   p,
+		spans:    make(map[model.ID]*request),
+		pending:  make(map[model.ID][]model.SpanModel),
+		requests: make(map[model.TraceID]int),
+	}
+}
+
+// begin tags the server span in ctx with the allowed members of the baggage
+// of ctx and of the header that the caller sent, and the spans beneath it
+// until the returned func is called.
+func (h *ZipkinHook) begin(ctx context.Context, header string) func() {
+	span := zipkin.SpanFromContext(ctx)
+	if span == nil || len(h.policy.Allow) == 0 {
+		return func() {}
+	}
+	b := h.policy.Filter(merge(header, baggage.FromContext(ctx)))
+	r := &request{tags: make(map[string]string, b.Len())}
+	for _, m := range b.Members() {
+		r.tags[m.Key()] = m.Value()
+		span.Tag(m.Key(), m.Value())
+	}
+	sc := span.Context()
+	r.ids = append(r.ids, sc.ID)
+	h.mu.Lock()
+	h.spans[sc.ID] = r
+	h.requests[sc.TraceID]++
+	h.mu.Unlock()
+	return func() {
+		h.mu.Lock()
+		for _, id := range r.ids {
+			delete(h.spans, id)
+		}
+		var orphans []model.SpanModel
+		if h.requests[sc.TraceID]--; h.requests[sc.TraceID] == 0 {
+			delete(h.requests, sc.TraceID)
+			// the spans whose parent belongs to no request go on untagged
+			for parent, spans := range h.pending {
+				if spans[0].TraceID == sc.TraceID {
+					orphans = append(orphans, spans...)
+					delete(h.pending, parent)
+				}
+			}
+		}
+		next := h.next
+		h.mu.Unlock()
+		for _, s := range orphans {
+			next.Send(s)
+		}
+	}
+}
+
+// resolve returns s and the spans that waited for it, tagged with their
+// request, or nothing if the parent of s is not known yet.
+func (h *ZipkinHook) resolve(s model.SpanModel) []model.SpanModel {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.requests[s.TraceID] == 0 {
+		return []model.SpanModel{s}
+	}
+	r, ok := h.spans[s.ID]
+	if !ok && s.ParentID != nil {
+		r, ok = h.spans[*s.ParentID]
+	}
+	if !ok {
+		if s.ParentID == nil {
+			return []model.SpanModel{s}
+		}
+		h.pending[*s.ParentID] = append(h.pending[*s.ParentID], s)
+		return nil
+	}
+
+	var resolved []model.SpanModel
+	for queue := []model.SpanModel{s}; len(queue) > 0; queue = queue[1:] {
+		s := queue[0]
+		if _, ok := h.spans[s.ID]; !ok {
+			h.spans[s.ID] = r
+			r.ids = append(r.ids, s.ID)
+		}
+		resolved = append(resolved, r.tag(s))
+		queue = append(queue, h.pending[s.ID]...)
+		delete(h.pending, s.ID)
+	}
+	return resolved
+}
+
+// tag returns s with the tags of r. The tracer may still hold the map of a
+// span it sent, so the tags go into a new one; those of the span itself win.
+func (r *request) tag(s model.SpanModel) model.SpanModel {
+	if len(r.tags) == 0 {
+		return s
+	}
+	tags := make(map[string]string, len(s.Tags)+len(r.tags))
+	for key, v := range r.tags {
+		tags[key] = v
+	}
+	for key, v := range s.Tags {
+		tags[key] = v
+	}
+	s.Tags = tags
+	return s
+}
+
+type zipkinReporter struct {
+	hook *ZipkinHook
+	next reporter.Reporter
+}
+
+// Reporter sends the spans to next with the tags of their request:
+//
+//	zipkin.NewTracer(h.Reporter(httpreporter.NewReporter(url)), ...)
+func (h *ZipkinHook) Reporter(next reporter.Reporter) reporter.Reporter {
+	h.mu.Lock()
+	h.next = next
+	h.mu.Unlock()
+	return zipkinReporter{hook: h, next: next}
+}
+
+func (z zipkinReporter) Send(s model.SpanModel) {
+	for _, s := range z.hook.resolve(s) {
+		z.next.Send(s)
+	}
+}
+
+func (z zipkinReporter) Close() error {
+	return z.next.Close()
+}
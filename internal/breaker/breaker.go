@@ -0,0 +1,256 @@
+// Package breaker keeps service1 from hammering a service2, or a Redis behind
+// it, that is failing: a circuit breaker stops calls for a while once too
+// many of them fail, and a bulkhead caps how many are in flight at once.
+//
+// Calls the Guard turns away never reach the network. They get a span of
+// their own marked as rejected, state changes of the breaker are recorded as
+// span events, and both are counted as metrics.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State is the state of a Breaker.
+type State int
+
+const (
+	// Closed lets every call through and counts the failures.
+	Closed State = iota
+	// HalfOpen lets a few trial calls through to see whether the service has
+	// recovered.
+	HalfOpen
+	// Open rejects every call until OpenTimeout has passed.
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half_open"
+	case Open:
+		return "open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// ErrRejected is wrapped by the errors of calls that were turned away.
+var ErrRejected = errors.New("call rejected")
+
+var (
+	// ErrOpen rejects calls while the breaker is open, or half open with
+	// all trial calls under way.
+	ErrOpen = fmt.Errorf("%w: circuit breaker open", ErrRejected)
+	// ErrFull rejects calls while the bulkhead has no free slot.
+	ErrFull = fmt.Errorf("%w: bulkhead full", ErrRejected)
+)
+
+// Outcome is what a call that was let through tells the Breaker.
+type Outcome int
+
+const (
+	// Success counts for the service.
+	Success Outcome = iota
+	// Failure counts against the service.
+	Failure
+	// Canceled counts neither way: the call was never made, so it says
+	// nothing about the service. A half-open breaker gets its trial back.
+	Canceled
+)
+
+// Clock tells the time. Tests drive a Breaker with a fake one.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Settings configures a Breaker.
+type Settings struct {
+	// Window is how long failures are counted before the counts start over.
+	Window time.Duration
+	// MinRequests is how many calls a window needs before its failure rate
+	// can open the breaker.
+	MinRequests int
+	// FailureRate opens the breaker when this share of the calls in a window
+	// failed; 0 disables the breaker.
+	FailureRate float64
+	// OpenTimeout is how long the breaker stays open before trying again.
+	OpenTimeout time.Duration
+	// HalfOpenRequests is how many trial calls go through while half open.
+	// The breaker closes once they all succeeded.
+	HalfOpenRequests int
+	// Clock defaults to the system clock.
+	Clock Clock
+	// OnTransition is told about every change of state, with the context of
+	// the call that caused it.
+	OnTransition func(ctx context.Context, from, to State)
+}
+
+// DefaultSettings opens the breaker for 5s when at least half of at least 10
+// calls within 10s failed.
+func DefaultSettings() Settings {
+	return Settings{
+		Window:           10 * time.Second,
+		MinRequests:      10,
+		FailureRate:      0.5,
+		OpenTimeout:      5 * time.Second,
+		HalfOpenRequests: 3,
+	}
+}
+
+// Breaker is a circuit breaker. A nil *Breaker lets every call through.
+type Breaker struct {
+	settings Settings
+
+	mu          sync.Mutex
+	state       State
+	generation  uint64
+	windowStart time.Time
+	openedAt    time.Time
+	requests    int
+	failures    int
+	trials      int
+	successes   int
+}
+
+// NewBreaker returns a closed Breaker, or nil if s.FailureRate is 0.
+func NewBreaker(s Settings) *Breaker {
+	if s.FailureRate <= 0 {
+		return nil
+	}
+	if s.Clock == nil {
+		s.Clock = systemClock{}
+	}
+	s.HalfOpenRequests = max(s.HalfOpenRequests, 1)
+	return &Breaker{settings: s, windowStart: s.Clock.Now()}
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	if b == nil {
+		return Closed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, _ := b.currentLocked()
+	return state
+}
+
+// Allow asks to make a call. It fails with ErrOpen if the call must not be
+// made; otherwise done must be called once with the outcome of the call.
+func (b *Breaker) Allow(ctx context.Context) (done func(ctx context.Context, outcome Outcome), err error) {
+	if b == nil {
+		return func(context.Context, Outcome) {}, nil
+	}
+	b.mu.Lock()
+	state, changed := b.currentLocked()
+	switch {
+	case state == Open:
+		err = ErrOpen
+	case state == HalfOpen && b.trials >= b.settings.HalfOpenRequests:
+		err = ErrOpen
+	case state == HalfOpen:
+		b.trials++
+	}
+	generation := b.generation
+	b.mu.Unlock()
+	if changed {
+		b.notify(ctx, Open, HalfOpen)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, outcome Outcome) {
+		b.done(ctx, generation, outcome)
+	}, nil
+}
+
+func (b *Breaker) done(ctx context.Context, generation uint64, outcome Outcome) {
+	b.mu.Lock()
+	state, _ := b.currentLocked()
+	if generation != b.generation {
+		// the call started in an earlier state, whose counts are gone
+		b.mu.Unlock()
+		return
+	}
+	if outcome == Canceled {
+		if state == HalfOpen {
+			b.trials--
+		}
+		b.mu.Unlock()
+		return
+	}
+
+	failed := outcome == Failure
+	to := state
+	switch state {
+	case Closed:
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.settings.MinRequests &&
+			float64(b.failures) >= b.settings.FailureRate*float64(b.requests) {
+			to = Open
+		}
+	case HalfOpen:
+		if failed {
+			to = Open
+		} else if b.successes++; b.successes >= b.settings.HalfOpenRequests {
+			to = Closed
+		}
+	}
+	if to != state {
+		b.setLocked(to)
+	}
+	b.mu.Unlock()
+	if to != state {
+		b.notify(ctx, state, to)
+	}
+}
+
+// currentLocked starts a new window or moves from open to half open when
+// their time has come, reporting the latter.
+func (b *Breaker) currentLocked() (State, bool) {
+	now := b.settings.Clock.Now()
+	switch b.state {
+	case Closed:
+		if now.Sub(b.windowStart) >= b.settings.Window {
+			b.windowStart = now
+			b.requests, b.failures = 0, 0
+		}
+	case Open:
+		if now.Sub(b.openedAt) >= b.settings.OpenTimeout {
+			b.setLocked(HalfOpen)
+			return HalfOpen, true
+		}
+	}
+	return b.state, false
+}
+
+func (b *Breaker) setLocked(state State) {
+	now := b.settings.Clock.Now()
+	b.state = state
+	b.generation++
+	b.requests, b.failures = 0, 0
+	b.trials, b.successes = 0, 0
+	b.windowStart = now
+	if state == Open {
+		b.openedAt = now
+	}
+}
+
+func (b *Breaker) notify(ctx context.Context, from, to State) {
+	if b.settings.OnTransition != nil {
+		b.settings.OnTransition(ctx, from, to)
+	}
+}
@@ -0,0 +1,218 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+type transition struct {
+	from, to State
+}
+
+// newBreaker returns a breaker on a fake clock that opens for 5s when half of
+// at least 4 calls within 10s failed, and tries 2 calls while half open.
+func newBreaker() (*Breaker, *fakeClock, *[]transition) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	var transitions []transition
+	b := NewBreaker(Settings{
+		Window:           10 * time.Second,
+		MinRequests:      4,
+		FailureRate:      0.5,
+		OpenTimeout:      5 * time.Second,
+		HalfOpenRequests: 2,
+		Clock:            clock,
+		OnTransition: func(_ context.Context, from, to State) {
+			transitions = append(transitions, transition{from, to})
+		},
+	})
+	return b, clock, &transitions
+}
+
+// call makes a call through b with outcome.
+func call(t *testing.T, b *Breaker, outcome Outcome) {
+	t.Helper()
+	done, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	done(context.Background(), outcome)
+}
+
+// open fails calls until b opens.
+func open(t *testing.T, b *Breaker) {
+	t.Helper()
+	for i := 0; i < 4; i++ {
+		call(t, b, Failure)
+	}
+	if b.State() != Open {
+		t.Fatalf("state = %s after 4 failures, want open", b.State())
+	}
+}
+
+func TestNilBreaker(t *testing.T) {
+	b := NewBreaker(Settings{})
+	if b != nil {
+		t.Fatal("NewBreaker without a failure rate is not nil")
+	}
+	call(t, b, Failure)
+	if b.State() != Closed {
+		t.Fatalf("state = %s, want closed", b.State())
+	}
+}
+
+func TestBreakerOpens(t *testing.T) {
+	b, clock, transitions := newBreaker()
+	for i := 0; i < 3; i++ {
+		call(t, b, Failure)
+	}
+	if b.State() != Closed {
+		t.Fatalf("state = %s before MinRequests, want closed", b.State())
+	}
+	call(t, b, Success)
+	if b.State() != Open {
+		t.Fatalf("state = %s after 3 of 4 calls failed, want open", b.State())
+	}
+	if _, err := b.Allow(context.Background()); !errors.Is(err, ErrOpen) || !errors.Is(err, ErrRejected) {
+		t.Fatalf("Allow error = %v while open, want ErrOpen", err)
+	}
+
+	clock.Advance(5*time.Second - time.Millisecond)
+	if b.State() != Open {
+		t.Fatalf("state = %s before OpenTimeout, want open", b.State())
+	}
+	clock.Advance(time.Millisecond)
+	if _, err := b.Allow(context.Background()); err != nil {
+		t.Fatalf("Allow error = %v after OpenTimeout, want a trial", err)
+	}
+	if b.State() != HalfOpen {
+		t.Fatalf("state = %s after OpenTimeout, want half open", b.State())
+	}
+	want := []transition{{Closed, Open}, {Open, HalfOpen}}
+	if len(*transitions) != len(want) || (*transitions)[0] != want[0] || (*transitions)[1] != want[1] {
+		t.Fatalf("transitions = %v, want %v", *transitions, want)
+	}
+}
+
+// Failures of a window that has passed do not count.
+func TestBreakerWindow(t *testing.T) {
+	b, clock, _ := newBreaker()
+	for i := 0; i < 3; i++ {
+		call(t, b, Failure)
+	}
+	clock.Advance(10 * time.Second)
+	call(t, b, Failure)
+	if b.State() != Closed {
+		t.Fatalf("state = %s, want closed as the window started over", b.State())
+	}
+	for i := 0; i < 3; i++ {
+		call(t, b, Success)
+	}
+	if b.State() != Closed {
+		t.Fatalf("state = %s after 1 of 4 calls failed, want closed", b.State())
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	t.Run("closes", func(t *testing.T) {
+		b, clock, transitions := newBreaker()
+		open(t, b)
+		clock.Advance(5 * time.Second)
+		call(t, b, Success)
+		if b.State() != HalfOpen {
+			t.Fatalf("state = %s after 1 of 2 trials, want half open", b.State())
+		}
+		call(t, b, Success)
+		if b.State() != Closed {
+			t.Fatalf("state = %s after 2 successful trials, want closed", b.State())
+		}
+		if last := (*transitions)[len(*transitions)-1]; last != (transition{HalfOpen, Closed}) {
+			t.Fatalf("last transition = %v, want half open to closed", last)
+		}
+	})
+
+	t.Run("reopens", func(t *testing.T) {
+		b, clock, _ := newBreaker()
+		open(t, b)
+		clock.Advance(5 * time.Second)
+		call(t, b, Failure)
+		if b.State() != Open {
+			t.Fatalf("state = %s after a failed trial, want open", b.State())
+		}
+		clock.Advance(5*time.Second - time.Millisecond)
+		if b.State() != Open {
+			t.Fatalf("state = %s, want open for another OpenTimeout", b.State())
+		}
+	})
+
+	t.Run("limits trials", func(t *testing.T) {
+		b, clock, _ := newBreaker()
+		open(t, b)
+		clock.Advance(5 * time.Second)
+		for i := 0; i < 2; i++ {
+			if _, err := b.Allow(context.Background()); err != nil {
+				t.Fatalf("trial %d: %v", i, err)
+			}
+		}
+		if _, err := b.Allow(context.Background()); !errors.Is(err, ErrOpen) {
+			t.Fatalf("Allow error = %v with all trials under way, want ErrOpen", err)
+		}
+	})
+}
+
+// A canceled call counts neither way, and gives a half-open breaker its trial
+// back.
+func TestBreakerCanceled(t *testing.T) {
+	b, clock, _ := newBreaker()
+	for i := 0; i < 3; i++ {
+		call(t, b, Failure)
+	}
+	call(t, b, Canceled)
+	if b.State() != Closed {
+		t.Fatalf("state = %s after a canceled call, want closed", b.State())
+	}
+	call(t, b, Failure)
+	if b.State() != Open {
+		t.Fatalf("state = %s after 4 failures, want open", b.State())
+	}
+
+	clock.Advance(5 * time.Second)
+	for i := 0; i < 5; i++ {
+		call(t, b, Canceled)
+	}
+	if b.State() != HalfOpen {
+		t.Fatalf("state = %s after canceled trials, want half open", b.State())
+	}
+	call(t, b, Success)
+	call(t, b, Success)
+	if b.State() != Closed {
+		t.Fatalf("state = %s after 2 successful trials, want closed", b.State())
+	}
+}
+
+// A call that started before a change of state does not count in the next.
+func TestBreakerStaleCall(t *testing.T) {
+	b, clock, _ := newBreaker()
+	stale, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	open(t, b)
+	clock.Advance(5 * time.Second)
+	if b.State() != HalfOpen {
+		t.Fatalf("state = %s, want half open", b.State())
+	}
+	stale(context.Background(), Failure)
+	if b.State() != HalfOpen {
+		t.Fatalf("state = %s after a stale failure, want half open", b.State())
+	}
+}
@@ -0,0 +1,59 @@
+package breaker
+
+import (
+	"context"
+	"time"
+)
+
+// Bulkhead caps the number of calls in flight. A nil *Bulkhead has no cap.
+type Bulkhead struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// NewBulkhead lets max calls run at once; a call waits up to wait for a free
+// slot. It returns nil if max is 0.
+func NewBulkhead(max int, wait time.Duration) *Bulkhead {
+	if max <= 0 {
+		return nil
+	}
+	return &Bulkhead{slots: make(chan struct{}, max), wait: wait}
+}
+
+// Acquire takes a slot, which release gives back, or fails with ErrFull.
+func (b *Bulkhead) Acquire(ctx context.Context) (release func(), err error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, nil
+	default:
+	}
+	if b.wait <= 0 {
+		return nil, ErrFull
+	}
+
+	timer := time.NewTimer(b.wait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, nil
+	case <-timer.C:
+		return nil, ErrFull
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *Bulkhead) release() {
+	<-b.slots
+}
+
+// InFlight returns the number of calls holding a slot.
+func (b *Bulkhead) InFlight() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.slots)
+}
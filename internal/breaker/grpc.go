@@ -0,0 +1,71 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryClientInterceptor guards unary calls with g. Health checks pass
+// through: they must keep telling whether the service has recovered. Rejected
+// calls fail with UNAVAILABLE.
+func UnaryClientInterceptor(g *Guard) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if strings.HasPrefix(method, "/grpc.health.v1.Health/") {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		err := g.Do(ctx, strings.TrimPrefix(method, "/"), func(ctx context.Context) error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}, grpcFailure)
+		if errors.Is(err, ErrRejected) {
+			return rejection{err}
+		}
+		return err
+	}
+}
+
+// grpcFailure counts the codes that point at the service rather than the
+// request against it.
+func grpcFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown,
+		codes.DataLoss:
+		return true
+	case codes.ResourceExhausted:
+		// a quota of the caller ran out, not the capacity of the service
+		return !quotaFailure(err)
+	}
+	return false
+}
+
+func quotaFailure(err error) bool {
+	for _, d := range status.Convert(err).Details() {
+		if _, ok := d.(*errdetails.QuotaFailure); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rejection carries a rejected call's error as an UNAVAILABLE status while
+// errors.Is still finds ErrRejected.
+type rejection struct {
+	err error
+}
+
+func (r rejection) Error() string {
+	return r.err.Error()
+}
+
+func (r rejection) Unwrap() error {
+	return r.err
+}
+
+func (r rejection) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, r.err.Error())
+}
@@ -0,0 +1,113 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Attribute names of rejected spans, transition events and metrics.
+const (
+	AttrName     = "breaker.name"
+	AttrRejected = "breaker.rejected"
+	AttrReason   = "breaker.reason"
+	AttrFrom     = "breaker.from"
+	AttrTo       = "breaker.to"
+)
+
+// Guard puts a Breaker and a Bulkhead in front of the calls to one service.
+type Guard struct {
+	name     string
+	breaker  *Breaker
+	bulkhead *Bulkhead
+	tracing  Tracing
+
+	transitions metric.Int64Counter
+	rejections  metric.Int64Counter
+}
+
+// NewGuard guards the calls to the service called name with a breaker made
+// from s and bulkhead, either of which may be disabled. Metrics go to mp, the
+// global MeterProvider if nil.
+func NewGuard(name string, s Settings, bulkhead *Bulkhead, t Tracing, mp metric.MeterProvider) *Guard {
+	g := &Guard{name: name, bulkhead: bulkhead, tracing: t}
+
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter("go-service-tracing/internal/breaker")
+	g.transitions, _ = meter.Int64Counter("breaker.transitions",
+		metric.WithDescription("State changes of the circuit breaker"))
+	g.rejections, _ = meter.Int64Counter("breaker.rejections",
+		metric.WithDescription("Calls turned away by the circuit breaker or the bulkhead"))
+	nameAttr := attribute.String(AttrName, name)
+	meter.Int64ObservableGauge("breaker.state",
+		metric.WithDescription("State of the circuit breaker: 0 closed, 1 half open, 2 open"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(g.breaker.State()), metric.WithAttributes(nameAttr))
+			return nil
+		}))
+	meter.Int64ObservableGauge("bulkhead.in_flight",
+		metric.WithDescription("Calls holding a slot of the bulkhead"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(g.bulkhead.InFlight()), metric.WithAttributes(nameAttr))
+			return nil
+		}))
+
+	s.OnTransition = g.transition
+	g.breaker = NewBreaker(s)
+	return g
+}
+
+// Do makes call unless the breaker or the bulkhead turns it away, in which
+// case a span named op records the rejection. failed tells the breaker which
+// errors count against the service.
+func (g *Guard) Do(ctx context.Context, op string, call func(ctx context.Context) error, failed func(err error) bool) error {
+	done, err := g.breaker.Allow(ctx)
+	if err != nil {
+		g.reject(ctx, op, err)
+		return err
+	}
+	release, err := g.bulkhead.Acquire(ctx)
+	if err != nil {
+		// the call was never made, so it says nothing about the service
+		done(ctx, Canceled)
+		if errors.Is(err, ErrRejected) {
+			g.reject(ctx, op, err)
+		}
+		return err
+	}
+	defer release()
+
+	err = call(ctx)
+	if err != nil && failed(err) {
+		done(ctx, Failure)
+	} else {
+		done(ctx, Success)
+	}
+	return err
+}
+
+func (g *Guard) reject(ctx context.Context, op string, err error) {
+	reason := "breaker_open"
+	if errors.Is(err, ErrFull) {
+		reason = "bulkhead_full"
+	}
+	g.rejections.Add(ctx, 1, metric.WithAttributes(
+		attribute.String(AttrName, g.name),
+		attribute.String(AttrReason, reason),
+	))
+	g.tracing.Rejected(ctx, g.name, op, reason, err)
+}
+
+func (g *Guard) transition(ctx context.Context, from, to State) {
+	g.transitions.Add(ctx, 1, metric.WithAttributes(
+		attribute.String(AttrName, g.name),
+		attribute.String(AttrFrom, from.String()),
+		attribute.String(AttrTo, to.String()),
+	))
+	g.tracing.Transition(ctx, g.name, from, to)
+}
@@ -0,0 +1,96 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var errService = errors.New("service failed")
+
+func failing(context.Context) error { return errService }
+
+func always(error) bool { return true }
+
+// Calls the bulkhead turns away neither count for nor against the service,
+// and are recorded as rejected spans and metrics of the MeterProvider given.
+func TestGuardBulkheadFull(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+	reader := sdkmetric.NewManualReader()
+	g := NewGuard("service2", Settings{
+		Window:      time.Minute,
+		MinRequests: 3,
+		FailureRate: 0.6,
+		OpenTimeout: time.Minute,
+	}, NewBulkhead(1, 0), OTel(tracer), sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	ctx := context.Background()
+
+	if err := g.Do(ctx, "get", failing, always); err != errService {
+		t.Fatalf("Do error = %v, want that of the call", err)
+	}
+	// hold the only slot of the bulkhead
+	held, release := make(chan struct{}), make(chan struct{})
+	go g.Do(ctx, "hold", func(context.Context) error {
+		close(held)
+		<-release
+		return nil
+	}, always)
+	<-held
+	if err := g.Do(ctx, "get", failing, always); !errors.Is(err, ErrFull) {
+		t.Fatalf("Do error = %v with the bulkhead full, want ErrFull", err)
+	}
+	close(release)
+	for g.bulkhead.InFlight() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if g.breaker.State() != Closed {
+		t.Fatalf("state = %s, want closed", g.breaker.State())
+	}
+
+	// the hold counted as a success, the rejection not at all, so that 2 of
+	// 3 calls failed rather than 2 of 4
+	if err := g.Do(ctx, "get", failing, always); err != errService {
+		t.Fatalf("Do error = %v, want that of the call", err)
+	}
+	if err := g.Do(ctx, "get", failing, always); !errors.Is(err, ErrOpen) {
+		t.Fatalf("Do error = %v after 2 of 3 calls failed, want ErrOpen", err)
+	}
+
+	rejected := 0
+	for _, s := range exporter.GetSpans() {
+		for _, a := range s.Attributes {
+			if a == attribute.String(AttrReason, "bulkhead_full") {
+				rejected++
+			}
+		}
+	}
+	if rejected != 1 {
+		t.Errorf("got %d spans rejected by the bulkhead, want 1", rejected)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if sum, ok := m.Data.(metricdata.Sum[int64]); ok {
+				for _, dp := range sum.DataPoints {
+					counts[m.Name] += dp.Value
+				}
+			}
+		}
+	}
+	if counts["breaker.rejections"] != 2 || counts["breaker.transitions"] != 1 {
+		t.Errorf("counters = %v, want 2 rejections and 1 transition", counts)
+	}
+}
@@ -0,0 +1,37 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// errServerStatus stands for a 5xx response while the Guard judges a request.
+var errServerStatus = errors.New("server error status")
+
+type transport struct {
+	guard *Guard
+	next  http.RoundTripper
+}
+
+// Transport guards the requests sent through next with g. Transport errors
+// and 5xx responses count as failures.
+func Transport(g *Guard, next http.RoundTripper) http.RoundTripper {
+	return transport{guard: g, next: next}
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	err := t.guard.Do(req.Context(), "HTTP "+req.Method, func(ctx context.Context) error {
+		var err error
+		resp, err = t.next.RoundTrip(req)
+		if err == nil && resp.StatusCode >= 500 {
+			return errServerStatus
+		}
+		return err
+	}, func(error) bool { return true })
+	if err == errServerStatus {
+		return resp, nil
+	}
+	return resp, err
+}
@@ -0,0 +1,79 @@
+package breaker
+
+import (
+	"context"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing records what a Guard does with the tracer of a service.
+type Tracing interface {
+	// Rejected records a call to service that was turned away as a span
+	// named op beneath the span in ctx.
+	Rejected(ctx context.Context, service, op, reason string, err error)
+	// Transition records a change of state of the breaker of service as an
+	// event on the span in ctx.
+	Transition(ctx context.Context, service string, from, to State)
+}
+
+type otelTracing struct {
+	tracer trace.Tracer
+}
+
+// OTel records with the OpenTelemetry tracer.
+func OTel(tracer trace.Tracer) Tracing {
+	return otelTracing{tracer: tracer}
+}
+
+func (t otelTracing) Rejected(ctx context.Context, service, op, reason string, err error) {
+	_, span := t.tracer.Start(ctx, op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String(AttrName, service),
+			attribute.Bool(AttrRejected, true),
+			attribute.String(AttrReason, reason),
+		),
+	)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+}
+
+func (t otelTracing) Transition(ctx context.Context, service string, from, to State) {
+	trace.SpanFromContext(ctx).AddEvent("breaker.transition", trace.WithAttributes(
+		attribute.String(AttrName, service),
+		attribute.String(AttrFrom, from.String()),
+		attribute.String(AttrTo, to.String()),
+	))
+}
+
+type zipkinTracing struct {
+	tracer *zipkin.Tracer
+}
+
+// Zipkin records with tracer. Transitions become annotations such as
+// "breaker service2: closed -> open".
+func Zipkin(tracer *zipkin.Tracer) Tracing {
+	return zipkinTracing{tracer: tracer}
+}
+
+func (t zipkinTracing) Rejected(ctx context.Context, service, op, reason string, err error) {
+	span, _ := t.tracer.StartSpanFromContext(ctx, op,
+		zipkin.Kind(model.Client),
+		zipkin.RemoteEndpoint(&model.Endpoint{ServiceName: service}),
+	)
+	span.Tag(AttrRejected, "true")
+	span.Tag(AttrReason, reason)
+	zipkin.TagError.Set(span, err.Error())
+	span.Finish()
+}
+
+func (t zipkinTracing) Transition(ctx context.Context, service string, from, to State) {
+	if span := zipkin.SpanFromContext(ctx); span != nil {
+		span.Annotate(time.Now(), "breaker "+service+": "+from.String()+" -> "+to.String())
+	}
+}
@@ -0,0 +1,241 @@
+// Package cache is the read-through cache of service1 in front of the gets
+// of service2: an LRU of the values of keys, bounded in entries and age,
+// which collapses concurrent misses of a key into a single load.
+//
+// It only knows of the writes that go through the same service1, which
+// Invalidate the key; those made elsewhere, e.g. by another replica, show
+// once the cached value expires.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/singleflight"
+)
+
+// Results of a lookup.
+const (
+	ResultHit  = "hit"
+	ResultMiss = "miss"
+	// ResultShared is a miss that waited for the load of another request.
+	ResultShared = "shared"
+)
+
+// LoadTimeout bounds the load of a get without a deadline.
+const LoadTimeout = 5 * time.Second
+
+// Value is what a get returns of a key.
+type Value struct {
+	Value   string
+	Version int64
+}
+
+type entry struct {
+	key     string
+	value   Value
+	expires time.Time
+}
+
+// Cache holds the values of up to a number of keys for a while. A nil *Cache
+// loads every value.
+type Cache struct {
+	maxEntries int
+	ttl        time.Duration
+	tracing    Tracing
+	group      singleflight.Group
+
+	mu      sync.Mutex
+	entries *list.List
+	keys    map[string]*list.Element
+	// loads holds the keys being loaded, so that a load that started before
+	// an invalidation of its key does not put back what it removed
+	loads map[string]*load
+
+	lookups metric.Int64Counter
+}
+
+// New returns a cache of up to maxEntries keys, each cached for ttl, or nil
+// if maxEntries is 0. Metrics go to mp, the global MeterProvider if nil.
+func New(maxEntries int, ttl time.Duration, t Tracing, mp metric.MeterProvider) *Cache {
+	if maxEntries <= 0 {
+		return nil
+	}
+	c := &Cache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		tracing:    t,
+		entries:    list.New(),
+		keys:       make(map[string]*list.Element),
+		loads:      make(map[string]*load),
+	}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter("go-service-tracing/internal/cache")
+	c.lookups, _ = meter.Int64Counter("cache.lookups",
+		metric.WithDescription("Lookups of the cache by result: hit, miss or shared"))
+	meter.Int64ObservableGauge("cache.entries",
+		metric.WithDescription("Keys held by the cache"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			o.Observe(int64(c.entries.Len()))
+			return nil
+		}))
+	return c
+}
+
+// Get returns the value of key from the cache or else from load, which runs
+// within the lookup span. Concurrent misses of a key share the load of the
+// first of them, and with it its deadline, or LoadTimeout without one; the
+// load goes on if that get is canceled, as the others still wait for it.
+// Errors are not cached.
+func (c *Cache) Get(ctx context.Context, key string, load func(ctx context.Context) (Value, error)) (Value, error) {
+	if c == nil {
+		return load(ctx)
+	}
+	ctx, end := c.tracing.Lookup(ctx, key)
+	v, ok := c.get(key)
+	if ok {
+		c.record(ctx, ResultHit)
+		end(ResultHit, nil)
+		return v, nil
+	}
+
+	var loaded atomic.Bool
+	ch := c.group.DoChan(key, func() (any, error) {
+		loaded.Store(true)
+		loadCtx, cancel := detach(ctx)
+		defer cancel()
+		epoch := c.begin(key)
+		v, err := load(loadCtx)
+		c.end(key, epoch, v, err == nil)
+		return v, err
+	})
+	var err error
+	select {
+	case res := <-ch:
+		v, err = res.Val.(Value), res.Err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := ResultMiss
+	if !loaded.Load() {
+		result = ResultShared
+	}
+	c.record(ctx, result)
+	end(result, err)
+	return v, err
+}
+
+// detach returns a context of the values of ctx that is not canceled with
+// it, only at its deadline or after LoadTimeout.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(LoadTimeout)
+	}
+	return context.WithDeadline(context.WithoutCancel(ctx), deadline)
+}
+
+// Invalidate removes key, after a write of it.
+func (c *Cache) Invalidate(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if l, ok := c.loads[key]; ok {
+		l.epoch++
+	}
+	if e, ok := c.keys[key]; ok {
+		c.remove(e)
+	}
+	c.mu.Unlock()
+	// the gets from now on load the key anew rather than wait for a load
+	// that may have read it before the write
+	c.group.Forget(key)
+}
+
+// get returns the value of key unless it expired.
+func (c *Cache) get(key string) (Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.keys[key]
+	if !ok {
+		return Value{}, false
+	}
+	en := e.Value.(*entry)
+	if c.ttl > 0 && time.Now().After(en.expires) {
+		c.remove(e)
+		return Value{}, false
+	}
+	c.entries.MoveToFront(e)
+	return en.value, true
+}
+
+// load counts the loads of a key in flight, of which there are more than one
+// when an invalidation let a get start another, and the invalidations of the
+// key since the first of them.
+type load struct {
+	n     int
+	epoch uint64
+}
+
+// begin records a load of key and returns the epoch to end it with.
+func (c *Cache) begin(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.loads[key]
+	if !ok {
+		l = &load{}
+		c.loads[key] = l
+	}
+	l.n++
+	return l.epoch
+}
+
+// end records the end of a load of key that began at epoch, and caches v,
+// if the load succeeded, unless key was invalidated since.
+func (c *Cache) end(key string, epoch uint64, v Value, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.loads[key]
+	if l.n--; l.n == 0 {
+		delete(c.loads, key)
+	}
+	if ok && l.epoch == epoch {
+		c.add(key, v)
+	}
+}
+
+// add caches v as the value of key, evicting the least recently used key if
+// the cache is full. c.mu is held.
+func (c *Cache) add(key string, v Value) {
+	expires := time.Now().Add(c.ttl)
+	if e, ok := c.keys[key]; ok {
+		en := e.Value.(*entry)
+		en.value, en.expires = v, expires
+		c.entries.MoveToFront(e)
+		return
+	}
+	c.keys[key] = c.entries.PushFront(&entry{key: key, value: v, expires: expires})
+	if c.entries.Len() > c.maxEntries {
+		c.remove(c.entries.Back())
+	}
+}
+
+func (c *Cache) remove(e *list.Element) {
+	c.entries.Remove(e)
+	delete(c.keys, e.Value.(*entry).key)
+}
+
+func (c *Cache) record(ctx context.Context, result string) {
+	c.lookups.Add(ctx, 1, metric.WithAttributes(attribute.String(AttrResult, result)))
+}
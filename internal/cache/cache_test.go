@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func newCache(maxEntries int) *Cache {
+	return New(maxEntries, time.Minute, OTel(noop.NewTracerProvider().Tracer("")), metricnoop.NewMeterProvider())
+}
+
+// loader returns a load of value that counts its calls in n.
+func loader(value string, n *int) func(context.Context) (Value, error) {
+	return func(context.Context) (Value, error) {
+		*n++
+		return Value{Value: value, Version: 1}, nil
+	}
+}
+
+func TestGet(t *testing.T) {
+	c := newCache(2)
+	ctx := context.Background()
+	var loads int
+	for _, key := range []string{"a", "a", "b", "c", "a"} {
+		if v, err := c.Get(ctx, key, loader(key, &loads)); err != nil || v.Value != key {
+			t.Fatalf("Get(%s) = %+v, %v", key, v, err)
+		}
+	}
+	// the second get of a is a hit, and c evicts a, which is loaded again
+	if loads != 4 {
+		t.Errorf("loads = %d, want 4", loads)
+	}
+
+	c.Invalidate("a")
+	c.Get(ctx, "a", loader("a", &loads))
+	if loads != 5 {
+		t.Errorf("loads after invalidation = %d, want 5", loads)
+	}
+}
+
+// get runs a get of key whose load waits for release, and returns when the
+// load started and the result of the get.
+func get(c *Cache, key, value string) (started chan struct{}, release chan struct{}, done chan Value) {
+	started, release, done = make(chan struct{}), make(chan struct{}), make(chan Value)
+	go func() {
+		v, _ := c.Get(context.Background(), key, func(context.Context) (Value, error) {
+			close(started)
+			<-release
+			return Value{Value: value}, nil
+		})
+		done <- v
+	}()
+	return started, release, done
+}
+
+func TestInvalidateDuringLoad(t *testing.T) {
+	ctx := context.Background()
+	var loads int
+
+	t.Run("same key", func(t *testing.T) {
+		c := newCache(10)
+		started, release, done := get(c, "k", "old")
+		<-started
+		c.Invalidate("k")
+		// a get after the invalidation does not wait for the load that began
+		// before it
+		if v, _ := c.Get(ctx, "k", loader("new", &loads)); v.Value != "new" {
+			t.Errorf("Get after invalidation = %q, want new", v.Value)
+		}
+		close(release)
+		<-done
+		if v, _ := c.Get(ctx, "k", loader("newer", &loads)); v.Value != "new" {
+			t.Errorf("Get after the stale load = %q, want new", v.Value)
+		}
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		c := newCache(10)
+		started, release, done := get(c, "k", "value")
+		<-started
+		c.Invalidate("other")
+		close(release)
+		<-done
+		n := loads
+		if v, _ := c.Get(ctx, "k", loader("reloaded", &loads)); v.Value != "value" || loads != n {
+			t.Errorf("Get = %q after %d loads, want the cached value", v.Value, loads-n)
+		}
+	})
+}
+
+// results is a Tracing that keeps the result of every lookup, and tells of
+// every lookup that starts.
+type results struct {
+	started chan struct{}
+
+	mu      sync.Mutex
+	results []string
+}
+
+func (r *results) Lookup(ctx context.Context, key string) (context.Context, func(string, error)) {
+	r.started <- struct{}{}
+	return ctx, func(result string, err error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.results = append(r.results, result)
+	}
+}
+
+// Concurrent misses of a key share one load, which goes on when the get that
+// started it is canceled.
+func TestSharedLoad(t *testing.T) {
+	tracing := &results{started: make(chan struct{}, 4)}
+	c := New(10, time.Minute, tracing, metricnoop.NewMeterProvider())
+
+	loads := 0
+	started, release := make(chan struct{}), make(chan struct{})
+	load := func(ctx context.Context) (Value, error) {
+		loads++
+		close(started)
+		<-release
+		if err := ctx.Err(); err != nil {
+			return Value{}, err
+		}
+		return Value{Value: "v"}, nil
+	}
+
+	firstCtx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := c.Get(firstCtx, "k", load)
+		first <- err
+	}()
+	<-started
+	<-tracing.started
+
+	const waiters = 3
+	var wg sync.WaitGroup
+	values := make([]Value, waiters)
+	errs := make([]error, waiters)
+	for i := range waiters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			values[i], errs[i] = c.Get(context.Background(), "k", load)
+		}()
+		<-tracing.started
+	}
+	// let the waiters get from their lookup to the load
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled get = %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	wg.Wait()
+
+	for i := range waiters {
+		if errs[i] != nil || values[i].Value != "v" {
+			t.Errorf("waiter %d got %+v, %v", i, values[i], errs[i])
+		}
+	}
+	if loads != 1 {
+		t.Errorf("loads = %d, want 1", loads)
+	}
+	slices.Sort(tracing.results)
+	if want := []string{ResultMiss, ResultShared, ResultShared, ResultShared}; !slices.Equal(tracing.results, want) {
+		t.Errorf("results = %q, want %q", tracing.results, want)
+	}
+	// the shared load is cached
+	if v, err := c.Get(context.Background(), "k", load); err != nil || v.Value != "v" {
+		t.Errorf("Get after the load = %+v, %v", v, err)
+	}
+}
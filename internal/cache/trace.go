@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/openzipkin/zipkin-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute names of cache.lookup spans and metrics.
+const (
+	AttrKey    = "cache.key"
+	AttrHit    = "cache.hit"
+	AttrResult = "cache.result"
+)
+
+// Tracing records lookups with the tracer of a service.
+type Tracing interface {
+	// Lookup starts the cache.lookup span of key beneath the span in ctx;
+	// end finishes it with the result of the lookup and the error of the
+	// load.
+	Lookup(ctx context.Context, key string) (lookupCtx context.Context, end func(result string, err error))
+}
+
+type otelTracing struct {
+	tracer trace.Tracer
+}
+
+// OTel records lookups as OpenTelemetry spans of tracer.
+func OTel(tracer trace.Tracer) Tracing {
+	return otelTracing{tracer: tracer}
+}
+
+func (t otelTracing) Lookup(ctx context.Context, key string) (context.Context, func(string, error)) {
+	ctx, span := t.tracer.Start(ctx, "cache.lookup", trace.WithAttributes(
+		attribute.String(AttrKey, key),
+	))
+	return ctx, func(result string, err error) {
+		span.SetAttributes(
+			attribute.Bool(AttrHit, result == ResultHit),
+			attribute.String(AttrResult, result),
+		)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+type zipkinTracing struct {
+	tracer *zipkin.Tracer
+}
+
+// Zipkin records lookups as spans of tracer.
+func Zipkin(tracer *zipkin.Tracer) Tracing {
+	return zipkinTracing{tracer: tracer}
+}
+
+func (t zipkinTracing) Lookup(ctx context.Context, key string) (context.Context, func(string, error)) {
+	span, ctx := t.tracer.StartSpanFromContext(ctx, "cache.lookup")
+	span.Tag(AttrKey, key)
+	return ctx, func(result string, err error) {
+		span.Tag(AttrHit, strconv.FormatBool(result == ResultHit))
+		span.Tag(AttrResult, result)
+		if err != nil {
+			zipkin.TagError.Set(span, err.Error())
+		}
+		span.Finish()
+	}
+}
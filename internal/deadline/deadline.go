@@ -0,0 +1,172 @@
+// Package deadline gives every request a time budget that shrinks as it
+// travels through the services.
+//
+// A service caps the budget it receives with a timeout of its own per route
+// or RPC, keeps HopReserve of it to answer its caller, and forwards the rest:
+// gRPC carries the deadline itself, HTTP calls carry it in the Grpc-Timeout
+// header that grpc-gateway understands as well. Redis commands get what is
+// left, up to a maximum.
+package deadline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HopReserve is the part of the budget a service keeps for itself when it
+// calls the next one, so that it can still answer after a downstream call
+// used up its share.
+const HopReserve = 10 * time.Millisecond
+
+// Header carries the remaining budget of an HTTP request, in the encoding of
+// the gRPC timeout header, e.g. 250m for 250 milliseconds.
+const Header = "Grpc-Timeout"
+
+// Attribute names for the budget a span started with and whether it ran out.
+const (
+	AttrBudget   = "deadline.budget_ms"
+	AttrExceeded = "deadline.exceeded"
+)
+
+// Report is told the budget of a finished unit of work and whether it was
+// exceeded, typically to annotate the span in ctx.
+type Report func(ctx context.Context, budget time.Duration, exceeded bool)
+
+// Timeouts caps the budget per route or RPC.
+type Timeouts struct {
+	// Default applies to routes without a timeout of their own; 0 leaves
+	// their budget as the caller set it.
+	Default time.Duration
+	// Routes maps a route, e.g. "GET /v1/kv/:key", or a full RPC method,
+	// e.g. "/storage.v1.Storage/Get", to its timeout.
+	Routes map[string]time.Duration
+}
+
+// ParseTimeouts reads a comma-separated list of route=duration pairs, where
+// the route "default" sets Default:
+//
+//	default=2s,GET /v1/kv=5s
+func ParseTimeouts(s string) (Timeouts, error) {
+	t := Timeouts{Routes: map[string]time.Duration{}}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, "=")
+		if i < 0 {
+			return t, fmt.Errorf("timeout %q: want route=duration", pair)
+		}
+		route := strings.TrimSpace(pair[:i])
+		d, err := time.ParseDuration(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			return t, fmt.Errorf("timeout %q: %w", pair, err)
+		}
+		if d < 0 {
+			return t, fmt.Errorf("timeout %q: negative duration", pair)
+		}
+		if route == "default" {
+			t.Default = d
+		} else {
+			t.Routes[route] = d
+		}
+	}
+	return t, nil
+}
+
+// For returns the timeout of route.
+func (t Timeouts) For(route string) time.Duration {
+	if d, ok := t.Routes[route]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// Start caps the deadline of ctx at timeout from now. The caller's deadline
+// wins when it is earlier, so a budget can only shrink along the way.
+func Start(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Forward returns the context for a call to the next service: its deadline
+// is HopReserve earlier than that of ctx. Contexts without a deadline are
+// returned as is.
+func Forward(ctx context.Context) (context.Context, context.CancelFunc) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, d.Add(-HopReserve))
+}
+
+// Budget returns the time left until the deadline of ctx, 0 once it has
+// passed, and false when ctx has no deadline.
+func Budget(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return max(time.Until(d), 0), true
+}
+
+// Exceeded reports whether ctx, or err, shows that the budget ran out.
+func Exceeded(ctx context.Context, err error) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded) || IsExceeded(err)
+}
+
+// IsExceeded reports whether err comes from a budget running out: the
+// context's own error, a connection deadline set from it, as Redis clients
+// with ContextTimeoutEnabled do, or a DeadlineExceeded status of a gRPC call.
+func IsExceeded(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	return status.Code(err) == codes.DeadlineExceeded
+}
+
+// EncodeTimeout formats d for the Header, e.g. 1500m.
+func EncodeTimeout(d time.Duration) string {
+	if d < time.Millisecond {
+		return strconv.FormatInt(max(d.Microseconds(), 1), 10) + "u"
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10) + "m"
+}
+
+var timeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// DecodeTimeout parses a value of the Header: up to eight digits followed by
+// one of the units H, M, S, m, u or n.
+func DecodeTimeout(s string) (time.Duration, error) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, fmt.Errorf("invalid timeout %q", s)
+	}
+	unit, ok := timeoutUnits[s[len(s)-1]]
+	if !ok {
+		return 0, fmt.Errorf("invalid timeout unit in %q", s)
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid timeout %q", s)
+	}
+	return time.Duration(n) * unit, nil
+}
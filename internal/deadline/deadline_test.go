@@ -0,0 +1,85 @@
+package deadline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseTimeouts(t *testing.T) {
+	to, err := ParseTimeouts("default=2s, GET /v1/kv/:key=500ms,/storage.v1.Storage/List=5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for route, want := range map[string]time.Duration{
+		"GET /v1/kv/:key":          500 * time.Millisecond,
+		"/storage.v1.Storage/List": 5 * time.Second,
+		"PUT /v1/kv/:key":          2 * time.Second,
+	} {
+		if got := to.For(route); got != want {
+			t.Errorf("For(%q) = %s, want %s", route, got, want)
+		}
+	}
+
+	for _, s := range []string{"default", "default=soon", "default=-1s"} {
+		if _, err := ParseTimeouts(s); err == nil {
+			t.Errorf("ParseTimeouts(%q) succeeded", s)
+		}
+	}
+}
+
+func TestTimeoutEncoding(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		1500 * time.Millisecond: "1500m",
+		250 * time.Microsecond:  "250u",
+		0:                       "1u",
+	} {
+		if got := EncodeTimeout(d); got != want {
+			t.Errorf("EncodeTimeout(%s) = %q, want %q", d, got, want)
+		}
+	}
+	for s, want := range map[string]time.Duration{
+		"1500m": 1500 * time.Millisecond,
+		"2S":    2 * time.Second,
+		"1H":    time.Hour,
+		"10n":   10,
+	} {
+		if got, err := DecodeTimeout(s); err != nil || got != want {
+			t.Errorf("DecodeTimeout(%q) = %s, %v, want %s", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "m", "5", "5x", "-5m", "123456789m"} {
+		if _, err := DecodeTimeout(s); err == nil {
+			t.Errorf("DecodeTimeout(%q) succeeded", s)
+		}
+	}
+}
+
+// A budget only shrinks: a timeout cannot extend the deadline of the caller,
+// and every hop keeps HopReserve of it.
+func TestBudget(t *testing.T) {
+	if _, ok := Budget(context.Background()); ok {
+		t.Fatal("Budget of a context without deadline is ok")
+	}
+
+	ctx, cancel := Start(context.Background(), time.Second)
+	defer cancel()
+	short, cancel := Start(ctx, time.Hour)
+	defer cancel()
+	if budget, _ := Budget(short); budget > time.Second {
+		t.Errorf("budget = %s after a longer timeout, want at most 1s", budget)
+	}
+
+	deadline, _ := ctx.Deadline()
+	forward, cancel := Forward(ctx)
+	defer cancel()
+	if got, _ := forward.Deadline(); !got.Equal(deadline.Add(-HopReserve)) {
+		t.Errorf("forwarded deadline = %s, want %s", got, deadline.Add(-HopReserve))
+	}
+
+	unbounded, cancel := Forward(context.Background())
+	defer cancel()
+	if _, ok := unbounded.Deadline(); ok {
+		t.Error("Forward added a deadline")
+	}
+}
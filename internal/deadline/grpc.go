@@ -0,0 +1,34 @@
+package deadline
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// UnaryServerInterceptor caps the deadline of every unary call with the
+// timeout of its full method and reports the budget once the call returns.
+// Streams are long-lived and keep the deadline of their caller.
+func UnaryServerInterceptor(t Timeouts, report Report) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, cancel := Start(ctx, t.For(info.FullMethod))
+		defer cancel()
+
+		budget, ok := Budget(ctx)
+		resp, err := handler(ctx, req)
+		if ok && report != nil {
+			report(ctx, budget, Exceeded(ctx, err))
+		}
+		return resp, err
+	}
+}
+
+// UnaryClientInterceptor sends the next service the budget of the call
+// less HopReserve.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := Forward(ctx)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,110 @@
+package deadline
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	storagev1 "go-service-tracing/api/storage/v1"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// storageServer gets keys from a slow Redis and notes the deadline of the
+// calls it receives.
+type storageServer struct {
+	storagev1.UnimplementedStorageServer
+	redis    *redis.Client
+	deadline chan time.Time
+}
+
+func (s *storageServer) Get(ctx context.Context, req *storagev1.GetRequest) (*storagev1.GetResponse, error) {
+	d, _ := ctx.Deadline()
+	s.deadline <- d
+	value, err := s.redis.Get(ctx, req.Key).Result()
+	if IsExceeded(err) {
+		return nil, status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	return &storagev1.GetResponse{Value: value}, nil
+}
+
+func startStorage(t *testing.T, timeouts Timeouts, redisDelay time.Duration) (storagev1.StorageClient, *storageServer, *[]report) {
+	t.Helper()
+	c, _ := slowClient(t, time.Second, redisDelay)
+	srv := &storageServer{redis: c, deadline: make(chan time.Time, 1)}
+
+	var reports []report
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.UnaryInterceptor(UnaryServerInterceptor(timeouts, func(_ context.Context, budget time.Duration, exceeded bool) {
+		reports = append(reports, report{budget, exceeded})
+	})))
+	storagev1.RegisterStorageServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return storagev1.NewStorageClient(conn), srv, &reports
+}
+
+// The server receives the deadline of the caller less HopReserve, and a
+// budget that holds reports as not exceeded.
+func TestGRPCForwardsBudget(t *testing.T) {
+	client, srv, reports := startStorage(t, Timeouts{}, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.Get(ctx, &storagev1.GetRequest{Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+
+	caller, _ := ctx.Deadline()
+	want := caller.Add(-HopReserve)
+	// the deadline travels as a timeout, rounded up to its unit
+	if got := <-srv.deadline; got.Sub(want).Abs() > 5*time.Millisecond {
+		t.Errorf("server deadline = %s, want about %s", got, want)
+	}
+	if len(*reports) != 1 || (*reports)[0].exceeded {
+		t.Errorf("reports = %+v, want one not exceeded", *reports)
+	}
+}
+
+// The timeout of the method caps the budget of the caller, and a Redis slower
+// than that fails the call with DeadlineExceeded.
+func TestGRPCTimeoutExceeded(t *testing.T) {
+	timeouts := Timeouts{Default: time.Second, Routes: map[string]time.Duration{
+		"/storage.v1.Storage/Get": 50 * time.Millisecond,
+	}}
+	client, srv, reports := startStorage(t, timeouts, time.Second)
+	start := time.Now()
+	_, err := client.Get(context.Background(), &storagev1.GetRequest{Key: "k"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("call returned after %s, want it bounded by 50ms", elapsed)
+	}
+	if got := time.Until(<-srv.deadline); got > 50*time.Millisecond {
+		t.Errorf("server deadline in %s, want at most 50ms", got)
+	}
+	if len(*reports) != 1 || !(*reports)[0].exceeded || (*reports)[0].budget > 50*time.Millisecond {
+		t.Errorf("reports = %+v, want one of at most 50ms exceeded", *reports)
+	}
+}
@@ -0,0 +1,54 @@
+package deadline
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gin takes the budget of a request from the Header, caps it with the
+// timeout of the route, e.g. "GET /v1/kv/:key", and reports it once the
+// handlers are done. Use it after the tracing middleware so that the report
+// lands on the server span.
+func Gin(t Timeouts, report Report) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if v := c.GetHeader(Header); v != "" {
+			d, err := DecodeTimeout(v)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+				return
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		ctx, cancel := Start(ctx, t.For(c.Request.Method+" "+c.FullPath()))
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		budget, ok := Budget(ctx)
+		c.Next()
+		if ok && report != nil {
+			report(ctx, budget, Exceeded(ctx, nil))
+		}
+	}
+}
+
+// Outgoing prepares req for a call to the next service: its context keeps
+// HopReserve of the budget and what is left goes into the Header.
+func Outgoing(req *http.Request) (*http.Request, context.CancelFunc) {
+	ctx, cancel := Forward(req.Context())
+	req = req.WithContext(ctx)
+	SetHeader(req)
+	return req, cancel
+}
+
+// SetHeader puts the budget left in the context of req into the Header. Call
+// it again before sending req a second time.
+func SetHeader(req *http.Request) {
+	if budget, ok := Budget(req.Context()); ok {
+		req.Header.Set(Header, EncodeTimeout(budget))
+	}
+}
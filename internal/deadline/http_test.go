@@ -0,0 +1,62 @@
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gin takes the budget from the Header, the route timeout caps it, and
+// Outgoing forwards what is left less HopReserve.
+func TestGin(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	var reports []report
+	var forwarded string
+	r := gin.New()
+	r.Use(Gin(Timeouts{Routes: map[string]time.Duration{"GET /slow/:key": 20 * time.Millisecond}},
+		func(_ context.Context, budget time.Duration, exceeded bool) {
+			reports = append(reports, report{budget, exceeded})
+		}))
+	handler := func(c *gin.Context) {
+		req, cancel := Outgoing(httptest.NewRequest(http.MethodGet, "/", nil).WithContext(c.Request.Context()))
+		defer cancel()
+		forwarded = req.Header.Get(Header)
+		if c.FullPath() == "/slow/:key" {
+			<-c.Request.Context().Done()
+		}
+		c.Status(http.StatusOK)
+	}
+	r.GET("/fast/:key", handler)
+	r.GET("/slow/:key", handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/fast/k", nil)
+	req.Header.Set(Header, "500m")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	budget, err := DecodeTimeout(forwarded)
+	if err != nil || budget > 500*time.Millisecond-HopReserve || budget < 400*time.Millisecond {
+		t.Errorf("forwarded budget = %q, want a little less than %s", forwarded, 500*time.Millisecond-HopReserve)
+	}
+	if len(reports) != 1 || reports[0].exceeded || reports[0].budget > 500*time.Millisecond {
+		t.Errorf("reports = %+v, want one of at most 500ms not exceeded", reports)
+	}
+
+	reports = nil
+	req = httptest.NewRequest(http.MethodGet, "/slow/k", nil)
+	req.Header.Set(Header, "5S")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if len(reports) != 1 || !reports[0].exceeded || reports[0].budget > 20*time.Millisecond {
+		t.Errorf("reports = %+v, want one of at most 20ms exceeded", reports)
+	}
+
+	w := httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/fast/k", nil)
+	req.Header.Set(Header, "soon")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status of an invalid %s = %d, want %d", Header, w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,100 @@
+package deadline
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type redisHook struct {
+	max    time.Duration
+	report Report
+}
+
+// RedisHook bounds every Redis command, or pipeline, by the budget left in
+// its context and by max, and reports that bound. The client needs
+// ContextTimeoutEnabled for the bound to reach the connection.
+func RedisHook(max time.Duration, report Report) redis.Hook {
+	return redisHook{max: max, report: report}
+}
+
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		return h.bound(ctx, func(ctx context.Context) error {
+			return next(ctx, cmd)
+		})
+	}
+}
+
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		return h.bound(ctx, func(ctx context.Context) error {
+			return next(ctx, cmds)
+		})
+	}
+}
+
+func (h redisHook) bound(ctx context.Context, process func(context.Context) error) error {
+	ctx, cancel := Start(ctx, h.max)
+	defer cancel()
+
+	budget, ok := Budget(ctx)
+	err := process(ctx)
+	if ok && h.report != nil {
+		h.report(ctx, budget, Exceeded(ctx, err))
+	}
+	return err
+}
+
+type slowRedis struct {
+	delay time.Duration
+}
+
+// SlowRedis holds every Redis command back for delay, or until its context
+// is done, standing in for an overloaded Redis. Add it after RedisHook so
+// that it waits within the bound.
+func SlowRedis(delay time.Duration) redis.Hook {
+	return slowRedis{delay: delay}
+}
+
+func (h slowRedis) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h slowRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if err := h.wait(ctx); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h slowRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if err := h.wait(ctx); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func (h slowRedis) wait(ctx context.Context) error {
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
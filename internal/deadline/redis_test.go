@@ -0,0 +1,92 @@
+package deadline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type report struct {
+	budget   time.Duration
+	exceeded bool
+}
+
+// slowClient returns a client of Redis in memory whose commands are bounded
+// by max and held back for delay, and the reports of the bound.
+func slowClient(t *testing.T, max, delay time.Duration) (*redis.Client, *[]report) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	c := redis.NewClient(&redis.Options{Addr: mr.Addr(), ContextTimeoutEnabled: true})
+	t.Cleanup(func() { c.Close() })
+
+	var reports []report
+	c.AddHook(RedisHook(max, func(_ context.Context, budget time.Duration, exceeded bool) {
+		reports = append(reports, report{budget, exceeded})
+	}))
+	c.AddHook(SlowRedis(delay))
+	return c, &reports
+}
+
+func TestRedisHook(t *testing.T) {
+	c, reports := slowClient(t, time.Second, 10*time.Millisecond)
+	if err := c.Set(context.Background(), "k", "v", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(*reports))
+	}
+	if r := (*reports)[0]; r.exceeded || r.budget <= 900*time.Millisecond || r.budget > time.Second {
+		t.Fatalf("report = %+v, want a budget of about 1s not exceeded", r)
+	}
+}
+
+func TestRedisHookExceeded(t *testing.T) {
+	c, reports := slowClient(t, 50*time.Millisecond, time.Second)
+	start := time.Now()
+	err := c.Get(context.Background(), "k").Err()
+	if !IsExceeded(err) {
+		t.Fatalf("error = %v, want the budget exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("command returned after %s, want it bounded by 50ms", elapsed)
+	}
+	if len(*reports) != 1 || !(*reports)[0].exceeded {
+		t.Fatalf("reports = %+v, want one exceeded", *reports)
+	}
+}
+
+// The budget left in the context bounds a command when it is shorter than the
+// maximum.
+func TestRedisHookCallerBudget(t *testing.T) {
+	c, reports := slowClient(t, time.Second, 200*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := c.Get(ctx, "k").Err(); !IsExceeded(err) {
+		t.Fatalf("error = %v, want the budget exceeded", err)
+	}
+	if len(*reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(*reports))
+	}
+	if r := (*reports)[0]; !r.exceeded || r.budget > 30*time.Millisecond {
+		t.Fatalf("report = %+v, want a budget of at most 30ms exceeded", r)
+	}
+}
+
+func TestRedisHookPipeline(t *testing.T) {
+	c, reports := slowClient(t, 50*time.Millisecond, time.Second)
+	pipe := c.Pipeline()
+	set := pipe.Set(context.Background(), "k", "v", 0)
+	get := pipe.Get(context.Background(), "k")
+	if _, err := pipe.Exec(context.Background()); !IsExceeded(err) {
+		t.Fatalf("error = %v, want the budget exceeded", err)
+	}
+	if !IsExceeded(set.Err()) || !IsExceeded(get.Err()) {
+		t.Fatalf("command errors = %v, %v, want the budget exceeded", set.Err(), get.Err())
+	}
+	if len(*reports) != 1 || !(*reports)[0].exceeded {
+		t.Fatalf("reports = %+v, want one exceeded", *reports)
+	}
+}
@@ -0,0 +1,29 @@
+package deadline
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OTel is a Report that sets AttrBudget and AttrExceeded on the
+// OpenTelemetry span in ctx.
+func OTel(ctx context.Context, budget time.Duration, exceeded bool) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int64(AttrBudget, budget.Milliseconds()),
+		attribute.Bool(AttrExceeded, exceeded),
+	)
+}
+
+// Zipkin is a Report that tags the zipkin-go span in ctx with AttrBudget and
+// AttrExceeded.
+func Zipkin(ctx context.Context, budget time.Duration, exceeded bool) {
+	if span := zipkin.SpanFromContext(ctx); span != nil {
+		span.Tag(AttrBudget, strconv.FormatInt(budget.Milliseconds(), 10))
+		span.Tag(AttrExceeded, strconv.FormatBool(exceeded))
+	}
+}
@@ -0,0 +1,62 @@
+// Package grpctrace builds the otelgrpc stats handlers used by the OpenTelemetry
+// gRPC examples.
+package grpctrace
+
+import (
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"google.golang.org/grpc/stats"
+)
+
+// Options configures the stats handlers. The zero value traces every RPC
+// without message events or metrics.
+type Options struct {
+	// Filter decides which RPCs are recorded; nil records all of them.
+	Filter otelgrpc.Filter
+	// MessageEvents adds a span event for every message sent and received.
+	MessageEvents bool
+	// PublicEndpoint makes the server start a new trace for each request and
+	// link the caller's span instead of continuing the caller's trace. Use it
+	// when callers are not trusted to pick trace IDs or sampling.
+	PublicEndpoint bool
+	// MeterProvider receives rpc.server.* and rpc.client.* metrics; nil
+	// disables them.
+	MeterProvider metric.MeterProvider
+}
+
+// ExcludeHealthChecks is a Filter that drops grpc.health.v1 calls.
+var ExcludeHealthChecks = filters.Not(filters.HealthCheck())
+
+// NewServerHandler returns the otelgrpc server stats handler for o.
+func NewServerHandler(o Options) stats.Handler {
+	opts := o.options()
+	if o.PublicEndpoint {
+		opts = append(opts, otelgrpc.WithTracerProvider(publicEndpoint{otel.GetTracerProvider()}))
+	}
+	return otelgrpc.NewServerHandler(opts...)
+}
+
+// NewClientHandler returns the otelgrpc client stats handler for o.
+// PublicEndpoint only affects servers.
+func NewClientHandler(o Options) stats.Handler {
+	return otelgrpc.NewClientHandler(o.options()...)
+}
+
+func (o Options) options() []otelgrpc.Option {
+	var opts []otelgrpc.Option
+	if o.Filter != nil {
+		opts = append(opts, otelgrpc.WithFilter(o.Filter))
+	}
+	if o.MessageEvents {
+		opts = append(opts, otelgrpc.WithMessageEvents(otelgrpc.ReceivedEvents, otelgrpc.SentEvents))
+	}
+	if o.MeterProvider != nil {
+		opts = append(opts, otelgrpc.WithMeterProvider(o.MeterProvider))
+	} else {
+		opts = append(opts, otelgrpc.WithMeterProvider(noop.NewMeterProvider()))
+	}
+	return opts
+}
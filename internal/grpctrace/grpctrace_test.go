@@ -0,0 +1,255 @@
+package grpctrace
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	storagev1 "go-service-tracing/api/storage/v1"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type storageServer struct {
+	storagev1.UnimplementedStorageServer
+}
+
+func (storageServer) Put(context.Context, *storagev1.PutRequest) (*storagev1.PutResponse, error) {
+	return &storagev1.PutResponse{Version: 1}, nil
+}
+
+func (storageServer) Get(context.Context, *storagev1.GetRequest) (*storagev1.GetResponse, error) {
+	return nil, status.Error(grpccodes.NotFound, "redis: nil")
+}
+
+// serve records spans in memory, serves Storage and the health service over
+// bufconn with the handlers of o and returns a connection to them.
+func serve(t *testing.T, o Options) (*tracetest.InMemoryExporter, *grpc.ClientConn) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prevTP, prevProp := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+	})
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.StatsHandler(NewServerHandler(o)))
+	storagev1.RegisterStorageServer(s, storageServer{})
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithStatsHandler(NewClientHandler(o)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return exporter, conn
+}
+
+// spanOfKind returns the only span of kind, failing if there is not exactly
+// one.
+func spanOfKind(t *testing.T, spans tracetest.SpanStubs, kind trace.SpanKind) tracetest.SpanStub {
+	t.Helper()
+	var found []tracetest.SpanStub
+	for _, s := range spans {
+		if s.SpanKind == kind {
+			found = append(found, s)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("got %d spans of kind %s, want 1", len(found), kind)
+	}
+	return found[0]
+}
+
+func attrs(s tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	m := map[attribute.Key]attribute.Value{}
+	for _, kv := range s.Attributes {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestSpans(t *testing.T) {
+	tests := []struct {
+		method     string
+		call       func(storagev1.StorageClient) error
+		code       grpccodes.Code
+		serverCode codes.Code
+		clientCode codes.Code
+	}{
+		{
+			method: "Put",
+			call: func(c storagev1.StorageClient) error {
+				_, err := c.Put(context.Background(), &storagev1.PutRequest{Key: "k", Value: "v"})
+				return err
+			},
+			code: grpccodes.OK,
+		},
+		{
+			// a missing key is the caller's error rather than the server's
+			method: "Get",
+			call: func(c storagev1.StorageClient) error {
+				_, err := c.Get(context.Background(), &storagev1.GetRequest{Key: "k"})
+				return err
+			},
+			code:       grpccodes.NotFound,
+			clientCode: codes.Error,
+		},
+		{
+			method: "Delete",
+			call: func(c storagev1.StorageClient) error {
+				_, err := c.Delete(context.Background(), &storagev1.DeleteRequest{Key: "k"})
+				return err
+			},
+			code:       grpccodes.Unimplemented,
+			serverCode: codes.Error,
+			clientCode: codes.Error,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			exporter, conn := serve(t, Options{})
+			if err := tt.call(storagev1.NewStorageClient(conn)); status.Code(err) != tt.code {
+				t.Fatalf("call error = %v, want code %s", err, tt.code)
+			}
+
+			spans := exporter.GetSpans()
+			server := spanOfKind(t, spans, trace.SpanKindServer)
+			client := spanOfKind(t, spans, trace.SpanKindClient)
+			name := "storage.v1.Storage/" + tt.method
+			for _, s := range []tracetest.SpanStub{server, client} {
+				if s.Name != name {
+					t.Errorf("%s span name = %q, want %q", s.SpanKind, s.Name, name)
+				}
+				a := attrs(s)
+				want := map[attribute.Key]attribute.Value{
+					"rpc.system":           attribute.StringValue("grpc"),
+					"rpc.service":          attribute.StringValue("storage.v1.Storage"),
+					"rpc.method":           attribute.StringValue(tt.method),
+					"rpc.grpc.status_code": attribute.Int64Value(int64(tt.code)),
+				}
+				for k, v := range want {
+					if a[k] != v {
+						t.Errorf("%s span %s = %v, want %v", s.SpanKind, k, a[k].Emit(), v.Emit())
+					}
+				}
+			}
+			if server.Status.Code != tt.serverCode {
+				t.Errorf("server span status = %s, want %s", server.Status.Code, tt.serverCode)
+			}
+			if client.Status.Code != tt.clientCode {
+				t.Errorf("client span status = %s, want %s", client.Status.Code, tt.clientCode)
+			}
+			if server.Parent.SpanID() != client.SpanContext.SpanID() || server.SpanContext.TraceID() != client.SpanContext.TraceID() {
+				t.Errorf("server span is not a child of the client span")
+			}
+			if len(server.Events) != 0 {
+				t.Errorf("server span has %d events without MessageEvents", len(server.Events))
+			}
+		})
+	}
+}
+
+func TestExcludeHealthChecks(t *testing.T) {
+	exporter, conn := serve(t, Options{Filter: ExcludeHealthChecks})
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Fatalf("got %d spans of a health check, want none", len(spans))
+	}
+
+	if _, err := storagev1.NewStorageClient(conn).Put(context.Background(), &storagev1.PutRequest{Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if spans := exporter.GetSpans(); len(spans) != 2 {
+		t.Fatalf("got %d spans of Put, want 2", len(spans))
+	}
+}
+
+func TestMessageEvents(t *testing.T) {
+	exporter, conn := serve(t, Options{MessageEvents: true})
+	if _, err := storagev1.NewStorageClient(conn).Put(context.Background(), &storagev1.PutRequest{Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []trace.SpanKind{trace.SpanKindServer, trace.SpanKindClient} {
+		s := spanOfKind(t, exporter.GetSpans(), kind)
+		types := map[string]bool{}
+		for _, e := range s.Events {
+			for _, kv := range e.Attributes {
+				if kv.Key == "message.type" {
+					types[kv.Value.AsString()] = true
+				}
+			}
+		}
+		if !types["SENT"] || !types["RECEIVED"] {
+			t.Errorf("%s span message events = %v, want SENT and RECEIVED", kind, types)
+		}
+	}
+}
+
+func TestPublicEndpoint(t *testing.T) {
+	exporter, conn := serve(t, Options{PublicEndpoint: true})
+	if _, err := storagev1.NewStorageClient(conn).Put(context.Background(), &storagev1.PutRequest{Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	server := spanOfKind(t, spans, trace.SpanKindServer)
+	client := spanOfKind(t, spans, trace.SpanKindClient)
+	if server.Parent.IsValid() || server.SpanContext.TraceID() == client.SpanContext.TraceID() {
+		t.Fatalf("server span continues the trace of the client")
+	}
+	if len(server.Links) != 1 || server.Links[0].SpanContext.SpanID() != client.SpanContext.SpanID() {
+		t.Fatalf("server span links = %v, want the client span", server.Links)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	_, conn := serve(t, Options{MeterProvider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))})
+	if _, err := storagev1.NewStorageClient(conn).Put(context.Background(), &storagev1.PutRequest{Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			names[m.Name] = true
+		}
+	}
+	for _, name := range []string{"rpc.server.duration", "rpc.client.duration"} {
+		if !names[name] {
+			t.Errorf("metric %s is missing, got %v", name, names)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package grpctrace
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// publicEndpoint wraps a TracerProvider so that spans started under a remote
+// parent become new roots linked to that parent.
+type publicEndpoint struct {
+	trace.TracerProvider
+}
+
+func (p publicEndpoint) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return publicTracer{Tracer: p.TracerProvider.Tracer(name, opts...)}
+}
+
+type publicTracer struct {
+	trace.Tracer
+}
+
+func (t publicTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if parent := trace.SpanContextFromContext(ctx); parent.IsValid() && parent.IsRemote() {
+		opts = append(opts,
+			trace.WithNewRoot(),
+			trace.WithLinks(trace.Link{SpanContext: parent}),
+		)
+	}
+	return t.Tracer.Start(ctx, name, opts...)
+}
@@ -0,0 +1,231 @@
+// Package kvhttp holds what the gin examples share for the versioned KV
+// routes: request validation, response types with content negotiation, the
+// marker for deprecated routes and route-based span names.
+package kvhttp
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-service-tracing/internal/breaker"
+	"go-service-tracing/internal/deadline"
+	"go-service-tracing/internal/kvstore"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Offered are the response formats, the first one being the default for
+// requests without an Accept header.
+var Offered = []string{binding.MIMEJSON, binding.MIMEXML, binding.MIMEYAML}
+
+// KeyURI is the path of a single key, e.g. /v1/kv/:key.
+type KeyURI struct {
+	Key string `uri:"key" binding:"required,max=256"`
+}
+
+// PutBody is the JSON body of PUT /v1/kv/:key.
+type PutBody struct {
+	Value string `json:"value" binding:"required"`
+	// TTLSeconds is 0 for the default of one minute and negative for no
+	// expiry.
+	TTLSeconds int64 `json:"ttl_seconds"`
+	// Version makes the put conditional, 0 meaning the key must not exist.
+	Version *int64 `json:"version" binding:"omitempty,min=0"`
+}
+
+// Options converts the body to the options of kvstore.Put.
+func (b PutBody) Options() kvstore.PutOptions {
+	return kvstore.PutOptions{
+		TTL:             kvstore.TTLFromSeconds(b.TTLSeconds),
+		ExpectedVersion: b.Version,
+	}
+}
+
+// ListQuery is the query of GET /v1/kv.
+type ListQuery struct {
+	Prefix string `form:"prefix" binding:"max=256"`
+	Cursor uint64 `form:"cursor"`
+	Limit  int64  `form:"limit" binding:"omitempty,min=1,max=1000"`
+}
+
+// KV describes a key. Value is left out of the response to a put.
+type KV struct {
+	XMLName xml.Name `json:"-" yaml:"-" xml:"kv"`
+	Key     string   `json:"key" xml:"key" yaml:"key"`
+	Value   string   `json:"value,omitempty" xml:"value,omitempty" yaml:"value,omitempty"`
+	Version int64    `json:"version" xml:"version" yaml:"version"`
+}
+
+// KeyList is a page of keys. NextCursor is 0 after the last page.
+type KeyList struct {
+	XMLName    xml.Name `json:"-" yaml:"-" xml:"keys"`
+	Keys       []string `json:"keys" xml:"key" yaml:"keys"`
+	NextCursor uint64   `json:"next_cursor" xml:"next_cursor,attr" yaml:"next_cursor"`
+}
+
+// Error is the body of every error response.
+type Error struct {
+	XMLName xml.Name `json:"-" yaml:"-" xml:"error"`
+	Message string   `json:"message" xml:"message" yaml:"message"`
+	// Version is the current version of the key when a conditional put
+	// failed.
+	Version *int64 `json:"version,omitempty" xml:"version,omitempty" yaml:"version,omitempty"`
+}
+
+// Negotiate answers 406 to requests that accept none of the Offered
+// formats, before the handler does any work.
+func Negotiate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.NegotiateFormat(Offered...) == "" {
+			c.AbortWithStatus(http.StatusNotAcceptable)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Respond writes obj in the format negotiated from the Accept header.
+func Respond(c *gin.Context, code int, obj any) {
+	switch c.NegotiateFormat(Offered...) {
+	case binding.MIMEXML:
+		c.XML(code, obj)
+	case binding.MIMEYAML:
+		c.YAML(code, obj)
+	default:
+		c.JSON(code, obj)
+	}
+}
+
+// RespondError writes an Error with message.
+func RespondError(c *gin.Context, code int, message string) {
+	Respond(c, code, Error{Message: message})
+}
+
+// StatusError is a failed response of service2, which service1 answers with
+// the same status.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// DecodeKV reads the JSON body of a get from service2, or its Error as a
+// StatusError.
+func DecodeKV(resp *http.Response) (KV, error) {
+	var kv KV
+	if resp.StatusCode != http.StatusOK {
+		var e Error
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Message == "" {
+			e.Message = resp.Status
+		}
+		return kv, &StatusError{Code: resp.StatusCode, Message: e.Message}
+	}
+	err := json.NewDecoder(resp.Body).Decode(&kv)
+	return kv, err
+}
+
+// FailureCode returns the status for a failed request: that of a
+// StatusError, 504 when its deadline ran out, 503 when a circuit breaker or
+// bulkhead turned it away, 429 when the tenant is out of keys, 500 otherwise.
+func FailureCode(err error) int {
+	var se *StatusError
+	switch {
+	case errors.As(err, &se):
+		return se.Code
+	case deadline.IsExceeded(err):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, breaker.ErrRejected):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, kvstore.ErrQuotaExceeded):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// BindKey validates the key in the path. On failure it has already answered
+// the request.
+func BindKey(c *gin.Context) (string, bool) {
+	var uri KeyURI
+	if err := c.ShouldBindUri(&uri); err != nil {
+		RespondError(c, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	return uri.Key, true
+}
+
+// BindPut validates the JSON body of a put. On failure it has already
+// answered the request.
+func BindPut(c *gin.Context) (PutBody, bool) {
+	var body PutBody
+	if c.ContentType() != binding.MIMEJSON {
+		RespondError(c, http.StatusUnsupportedMediaType, "body must be "+binding.MIMEJSON)
+		return body, false
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		RespondError(c, http.StatusBadRequest, err.Error())
+		return body, false
+	}
+	return body, true
+}
+
+// BindList validates the query of a listing. On failure it has already
+// answered the request.
+func BindList(c *gin.Context) (ListQuery, bool) {
+	var query ListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		RespondError(c, http.StatusBadRequest, err.Error())
+		return query, false
+	}
+	return query, true
+}
+
+// DeprecatedSince is announced in the Deprecation header of the old routes.
+var DeprecatedSince = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+// Deprecated marks the responses of an old route with the Deprecation
+// header of RFC 9745 and a link to the route replacing it.
+func Deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", fmt.Sprintf("@%d", DeprecatedSince.Unix()))
+		c.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		c.Next()
+	}
+}
+
+// SpanName names the server span of a request after its route template,
+// e.g. "GET /v1/kv/:key", so that every key shares one span name. Requests
+// that matched no route are named after the method alone.
+func SpanName(method, route string) string {
+	if route == "" {
+		return method
+	}
+	return method + " " + route
+}
+
+type routeKey struct{}
+
+// WithRoute keeps the matched route in the request context. otelgin hands
+// its span name formatter the *http.Request only, so WithRoute must run
+// before the otelgin middleware for RouteSpanName to see the route.
+func WithRoute() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), routeKey{}, c.FullPath())
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// RouteSpanName is an otelgin span name formatter based on SpanName.
+func RouteSpanName(r *http.Request) string {
+	route, _ := r.Context().Value(routeKey{}).(string)
+	return SpanName(r.Method, route)
+}
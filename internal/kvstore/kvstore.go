@@ -0,0 +1,249 @@
+// Package kvstore is the Redis data model shared by the service2 examples.
+//
+// Every key is a hash holding its value and a version that starts at 1 and
+// grows with each put, so that puts can be made conditional on the version a
+// client last read. Tracing stays in the services, which wrap these calls in
+// their own spans.
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultTTL is how long keys live unless a put asks otherwise.
+const DefaultTTL = time.Minute
+
+// DefaultListLimit is the page size of List when none is given.
+const DefaultListLimit = 100
+
+var (
+	// ErrNotFound is returned when the key does not exist.
+	ErrNotFound = errors.New("key not found")
+	// ErrVersionMismatch is returned by a conditional put when the key is
+	// not at the expected version.
+	ErrVersionMismatch = errors.New("version mismatch")
+	// ErrQuotaExceeded is returned by a put that would create a key beyond
+	// the quota of its namespace.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+)
+
+// putScript writes the value and bumps the version in one step.
+// KEYS[1] is the key, ARGV[1] the value, ARGV[2] the TTL in milliseconds or
+// 0 for none, ARGV[3] the expected version or empty for an unconditional put.
+// With a quota, KEYS[2] is its index, ARGV[4] the maximum number of keys and
+// ARGV[5] the current time in milliseconds.
+// It returns {1, new version}, {0, current version} on a mismatch or
+// {2, number of keys} when a new key would exceed the quota.
+var putScript = `
+local version = tonumber(redis.call('HGET', KEYS[1], 'version') or '0')
+if ARGV[3] ~= '' and version ~= tonumber(ARGV[3]) then
+  return {0, version}
+end
+if KEYS[2] then
+  redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[5])
+  local keys = redis.call('ZCARD', KEYS[2])
+  if version == 0 and not redis.call('ZSCORE', KEYS[2], KEYS[1]) and keys >= tonumber(ARGV[4]) then
+    return {2, keys}
+  end
+end
+version = version + 1
+redis.call('HSET', KEYS[1], 'value', ARGV[1], 'version', version)
+if tonumber(ARGV[2]) > 0 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+else
+  redis.call('PERSIST', KEYS[1])
+end
+if KEYS[2] then
+  local expiry = '+inf'
+  if tonumber(ARGV[2]) > 0 then
+    expiry = tonumber(ARGV[5]) + tonumber(ARGV[2])
+  end
+  redis.call('ZADD', KEYS[2], expiry, KEYS[1])
+end
+return {1, version}
+`
+
+// deleteScript removes KEYS[1] and, with a quota, drops it from the index
+// KEYS[2]. It returns the number of keys removed.
+var deleteScript = `
+if KEYS[2] then
+  redis.call('ZREM', KEYS[2], KEYS[1])
+end
+return redis.call('DEL', KEYS[1])
+`
+
+// Quota caps the number of keys in a namespace, e.g. the keys of a tenant.
+// The keys are tracked in a sorted set by when they expire, so expired keys
+// stop counting without a cleanup of their own. Only puts and deletes that
+// carry the quota keep the index up to date.
+type Quota struct {
+	// Index is the sorted set that tracks the keys. It must not be a key of
+	// the namespace itself, and on a Redis Cluster it must share the hash tag
+	// of the keys, since the scripts touch both.
+	Index string
+	// MaxKeys is how many keys the namespace may hold.
+	MaxKeys int64
+}
+
+// PutOptions controls expiry and the version check of a put.
+type PutOptions struct {
+	// TTL is how long the key lives; zero or less keeps it forever.
+	TTL time.Duration
+	// ExpectedVersion makes the put fail with ErrVersionMismatch unless the
+	// key is at this version. 0 means the key must not exist.
+	ExpectedVersion *int64
+	// Quota makes a put that creates a key fail with ErrQuotaExceeded once
+	// the namespace of the key is full.
+	Quota *Quota
+}
+
+// TTLFromSeconds converts the ttl of a request: 0 means DefaultTTL and a
+// negative value means no expiry.
+func TTLFromSeconds(seconds int64) time.Duration {
+	switch {
+	case seconds == 0:
+		return DefaultTTL
+	case seconds < 0:
+		return 0
+	default:
+		return time.Duration(seconds) * time.Second
+	}
+}
+
+// PutCmd is a put that may still be queued in a pipeline.
+type PutCmd struct {
+	cmd *redis.Cmd
+}
+
+// Put writes value under key. c may be a pipeline, in which case the result
+// is only available after it has been executed.
+func Put(ctx context.Context, c redis.Scripter, key, value string, o PutOptions) *PutCmd {
+	expected := ""
+	if o.ExpectedVersion != nil {
+		expected = strconv.FormatInt(*o.ExpectedVersion, 10)
+	}
+	ttl := int64(0)
+	if o.TTL > 0 {
+		ttl = o.TTL.Milliseconds()
+	}
+	if o.Quota == nil {
+		return &PutCmd{cmd: c.Eval(ctx, putScript, []string{key}, value, ttl, expected)}
+	}
+	keys := []string{key, o.Quota.Index}
+	now := time.Now().UnixMilli()
+	return &PutCmd{cmd: c.Eval(ctx, putScript, keys, value, ttl, expected, o.Quota.MaxKeys, now)}
+}
+
+// Result returns the new version of the key.
+func (p *PutCmd) Result() (int64, error) {
+	res, err := p.cmd.Int64Slice()
+	if err != nil {
+		return 0, err
+	}
+	if len(res) != 2 {
+		return 0, errors.New("unexpected put script result")
+	}
+	switch res[0] {
+	case 0:
+		return res[1], ErrVersionMismatch
+	case 2:
+		return 0, ErrQuotaExceeded
+	}
+	return res[1], nil
+}
+
+// Get returns the value and version of key.
+func Get(ctx context.Context, c redis.Cmdable, key string) (string, int64, error) {
+	res, err := c.HMGet(ctx, key, "value", "version").Result()
+	if err != nil {
+		return "", 0, err
+	}
+	value, ok := res[0].(string)
+	if !ok {
+		return "", 0, ErrNotFound
+	}
+	version, _ := res[1].(string)
+	v, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return "", 0, errors.New("invalid version of " + key)
+	}
+	return value, v, nil
+}
+
+// Delete removes key and reports whether it existed. q is the quota the key
+// was put with, if any.
+func Delete(ctx context.Context, c redis.Scripter, key string, q *Quota) (bool, error) {
+	keys := []string{key}
+	if q != nil {
+		keys = append(keys, q.Index)
+	}
+	n, err := c.Eval(ctx, deleteScript, keys).Int64()
+	return n > 0, err
+}
+
+// Exists reports whether key exists.
+func Exists(ctx context.Context, c redis.Cmdable, key string) (bool, error) {
+	n, err := c.Exists(ctx, key).Result()
+	return n > 0, err
+}
+
+// List returns keys starting with prefix, scanning from cursor, which is 0
+// for the first page. The returned cursor is 0 once every key has been seen.
+//
+// SCAN only approximates page sizes, so a page holds at least limit keys
+// unless the scan is complete, and may hold somewhat more. A key may show up
+// on more than one page if the keyspace changes during the listing.
+//
+// On a Redis Cluster, prefix must start with a hash tag, e.g. "{acme}:", so
+// that every key it matches is on the master of one slot, which List scans.
+// A listing fails if the slot moves to another node on the way.
+func List(ctx context.Context, c redis.Cmdable, prefix string, cursor uint64, limit int64) ([]string, uint64, error) {
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if cluster, ok := c.(*redis.ClusterClient); ok {
+		if !hashTagged(prefix) {
+			return nil, 0, fmt.Errorf("list %q: a Redis Cluster lists hash tagged prefixes only", prefix)
+		}
+		node, err := cluster.MasterForKey(ctx, prefix)
+		if err != nil {
+			return nil, 0, err
+		}
+		c = node
+	}
+	match := globEscaper.Replace(prefix) + "*"
+	var keys []string
+	for {
+		page, next, err := c.Scan(ctx, cursor, match, limit).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		keys = append(keys, page...)
+		cursor = next
+		if cursor == 0 || int64(len(keys)) >= limit {
+			return keys, cursor, nil
+		}
+	}
+}
+
+// hashTagged reports whether prefix starts with a hash tag, a non-empty
+// part in braces, which alone decides the slot of the keys it starts.
+func hashTagged(prefix string) bool {
+	end := strings.IndexByte(prefix, '}')
+	return strings.HasPrefix(prefix, "{") && end > 1
+}
+
+var globEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`*`, `\*`,
+	`?`, `\?`,
+	`[`, `\[`,
+	`]`, `\]`,
+)
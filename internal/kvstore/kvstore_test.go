@@ -0,0 +1,91 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// clients returns a plain and a Cluster client of a fresh miniredis, which
+// serves every slot itself.
+func clients(t *testing.T) map[string]redis.UniversalClient {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	single := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{mr.Addr()}})
+	t.Cleanup(func() {
+		single.Close()
+		cluster.Close()
+	})
+	return map[string]redis.UniversalClient{"single": single, "cluster": cluster}
+}
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range clients(t) {
+		t.Run(name, func(t *testing.T) {
+			q := &Quota{Index: "#quota:{" + name + "}", MaxKeys: 1}
+			first, second := "{"+name+"}:a", "{"+name+"}:b"
+			if _, err := Put(ctx, c, first, "v", PutOptions{Quota: q}).Result(); err != nil {
+				t.Fatalf("put: %v", err)
+			}
+			if _, err := Put(ctx, c, second, "v", PutOptions{Quota: q}).Result(); !errors.Is(err, ErrQuotaExceeded) {
+				t.Fatalf("put beyond the quota: %v, want %v", err, ErrQuotaExceeded)
+			}
+			if v, err := Put(ctx, c, first, "w", PutOptions{Quota: q}).Result(); err != nil || v != 2 {
+				t.Fatalf("overwrite = %d, %v, want version 2", v, err)
+			}
+			if ok, err := Delete(ctx, c, first, q); !ok || err != nil {
+				t.Fatalf("delete = %v, %v", ok, err)
+			}
+			if _, err := Put(ctx, c, second, "v", PutOptions{Quota: q}).Result(); err != nil {
+				t.Fatalf("put after a delete: %v", err)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range clients(t) {
+		t.Run(name, func(t *testing.T) {
+			var want []string
+			for _, k := range []string{"a", "b", "c", "d", "e"} {
+				key := "{" + name + "}:" + k
+				want = append(want, key)
+				Put(ctx, c, key, "v", PutOptions{})
+			}
+			Put(ctx, c, "{other}:"+name, "v", PutOptions{})
+
+			var got []string
+			var cursor uint64
+			for {
+				page, next, err := List(ctx, c, "{"+name+"}:", cursor, 2)
+				if err != nil {
+					t.Fatalf("list: %v", err)
+				}
+				got = append(got, page...)
+				if cursor = next; cursor == 0 {
+					break
+				}
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Errorf("listed %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestListClusterNeedsHashTag(t *testing.T) {
+	c := clients(t)["cluster"]
+	for _, prefix := range []string{"acme:", "{}:", "a{acme}:"} {
+		if _, _, err := List(context.Background(), c, prefix, 0, 10); err == nil {
+			t.Errorf("list of %q on a cluster succeeded", prefix)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package messaging
+
+import (
+	"github.com/openzipkin/zipkin-go/model"
+	zipkinpropagation "github.com/openzipkin/zipkin-go/propagation"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// b3Keys are the keys of the B3 context, single and multiple header.
+var b3Keys = []string{b3.Context, b3.TraceID, b3.SpanID, b3.ParentSpanID, b3.Sampled, b3.Flags}
+
+// InjectB3 injects a span context into c in the B3 multiple header format, or
+// in the single header one with b3.WithSingleHeaderOnly.
+func InjectB3(c propagation.TextMapCarrier, opts ...b3.InjectOption) zipkinpropagation.Injector {
+	return func(sc model.SpanContext) error {
+		m := b3.Map{}
+		if err := m.Inject(opts...)(sc); err != nil {
+			return err
+		}
+		for k, v := range m {
+			c.Set(k, v)
+		}
+		return nil
+	}
+}
+
+// ExtractB3 extracts the span context that c carries in either B3 format.
+func ExtractB3(c propagation.TextMapCarrier) zipkinpropagation.Extractor {
+	return func() (*model.SpanContext, error) {
+		m := b3.Map{}
+		for _, k := range b3Keys {
+			if v := c.Get(k); v != "" {
+				m[k] = v
+			}
+		}
+		return m.Extract()
+	}
+}
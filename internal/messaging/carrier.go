@@ -0,0 +1,143 @@
+// Package messaging propagates trace contexts through messages rather than
+// requests: carriers for the places a message keeps its metadata in, which
+// serve the OpenTelemetry TextMapPropagator as they are and B3 through
+// InjectB3 and ExtractB3, and the producer and consumer spans of the
+// messaging semantic conventions.
+//
+// Unlike HTTP headers, the keys of these carriers are case sensitive; both
+// propagators use lower case ones.
+package messaging
+
+import (
+	"encoding/json"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Map carries a context in a map[string]string, e.g. the TraceContext of a
+// WatchEvent.
+type Map = propagation.MapCarrier
+
+// Fields carries a context in the fields of a Redis stream entry, the Values
+// of both redis.XAddArgs and redis.XMessage.
+type Fields map[string]any
+
+// Get returns the value of a field, or "" if it is missing or not a string.
+func (f Fields) Get(key string) string {
+	s, _ := f[key].(string)
+	return s
+}
+
+// Set sets a field.
+func (f Fields) Set(key, value string) {
+	f[key] = value
+}
+
+// Keys lists the fields.
+func (f Fields) Keys() []string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// FieldList carries a context in the alternating fields and values of a
+// Redis stream entry as XADD takes them and XRANGE returns them.
+type FieldList []string
+
+// Get returns the value of the first occurrence of a field.
+func (l FieldList) Get(key string) string {
+	for i := 0; i+1 < len(l); i += 2 {
+		if l[i] == key {
+			return l[i+1]
+		}
+	}
+	return ""
+}
+
+// Set sets the value of a field, appending it if it is missing.
+func (l *FieldList) Set(key, value string) {
+	for i := 0; i+1 < len(*l); i += 2 {
+		if (*l)[i] == key {
+			(*l)[i+1] = value
+			return
+		}
+	}
+	*l = append(*l, key, value)
+}
+
+// Keys lists the fields.
+func (l FieldList) Keys() []string {
+	keys := make([]string, 0, len(l)/2)
+	for i := 0; i+1 < len(l); i += 2 {
+		keys = append(keys, l[i])
+	}
+	return keys
+}
+
+// Envelope wraps the payload of a message that has no room for metadata, e.g.
+// one published on a Redis channel, as JSON next to the headers that carry
+// its context.
+type Envelope struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	Payload json.RawMessage   `json:"payload"`
+}
+
+// Get returns a header.
+func (e *Envelope) Get(key string) string {
+	return e.Headers[key]
+}
+
+// Set sets a header.
+func (e *Envelope) Set(key, value string) {
+	if e.Headers == nil {
+		e.Headers = make(map[string]string)
+	}
+	e.Headers[key] = value
+}
+
+// Keys lists the headers.
+func (e *Envelope) Keys() []string {
+	return Map(e.Headers).Keys()
+}
+
+// Header is a header of binary value, as Kafka and AMQP have them.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Headers carries a context in a list of binary headers.
+type Headers []Header
+
+// Get returns the value of the first header of key.
+func (h Headers) Get(key string) string {
+	for _, header := range h {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+// Set sets the value of the first header of key, appending one if there is
+// none.
+func (h *Headers) Set(key, value string) {
+	for i := range *h {
+		if (*h)[i].Key == key {
+			(*h)[i].Value = []byte(value)
+			return
+		}
+	}
+	*h = append(*h, Header{Key: key, Value: []byte(value)})
+}
+
+// Keys lists the keys of the headers.
+func (h Headers) Keys() []string {
+	keys := make([]string, len(h))
+	for i, header := range h {
+		keys[i] = header.Key
+	}
+	return keys
+}
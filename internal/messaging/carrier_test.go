@@ -0,0 +1,194 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newCarrier returns a carrier without a context and send, which passes the
+// carrier through its encoding as a message would.
+type newCarrier func() (c propagation.TextMapCarrier, send func(propagation.TextMapCarrier) propagation.TextMapCarrier)
+
+// carriers returns a newCarrier of every kind.
+func carriers() map[string]newCarrier {
+	return map[string]newCarrier{
+		"Map": func() (propagation.TextMapCarrier, func(propagation.TextMapCarrier) propagation.TextMapCarrier) {
+			return Map{}, func(c propagation.TextMapCarrier) propagation.TextMapCarrier { return c }
+		},
+		"Fields": func() (propagation.TextMapCarrier, func(propagation.TextMapCarrier) propagation.TextMapCarrier) {
+			return Fields{"key": "k"}, func(c propagation.TextMapCarrier) propagation.TextMapCarrier {
+				// the values of an entry read back from Redis are strings
+				// as well
+				read := Fields{}
+				for k, v := range c.(Fields) {
+					read[k] = v
+				}
+				return read
+			}
+		},
+		"FieldList": func() (propagation.TextMapCarrier, func(propagation.TextMapCarrier) propagation.TextMapCarrier) {
+			return &FieldList{"key", "k"}, func(c propagation.TextMapCarrier) propagation.TextMapCarrier {
+				l := slices.Clone(*c.(*FieldList))
+				return &l
+			}
+		},
+		"Envelope": func() (propagation.TextMapCarrier, func(propagation.TextMapCarrier) propagation.TextMapCarrier) {
+			return &Envelope{Payload: json.RawMessage(`{"key":"k"}`)}, func(c propagation.TextMapCarrier) propagation.TextMapCarrier {
+				data, err := json.Marshal(c)
+				if err != nil {
+					panic(err)
+				}
+				var e Envelope
+				if err := json.Unmarshal(data, &e); err != nil {
+					panic(err)
+				}
+				return &e
+			}
+		},
+		"Headers": func() (propagation.TextMapCarrier, func(propagation.TextMapCarrier) propagation.TextMapCarrier) {
+			return &Headers{{Key: "content-type", Value: []byte("application/json")}}, func(c propagation.TextMapCarrier) propagation.TextMapCarrier {
+				h := slices.Clone(*c.(*Headers))
+				return &h
+			}
+		},
+	}
+}
+
+var sc = trace.NewSpanContext(trace.SpanContextConfig{
+	TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+	SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	TraceFlags: trace.FlagsSampled,
+})
+
+func TestW3C(t *testing.T) {
+	propagator := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	member, _ := baggage.NewMember("tenant.id", "acme")
+	b, _ := baggage.New(member)
+	ctx := baggage.ContextWithBaggage(trace.ContextWithSpanContext(context.Background(), sc), b)
+
+	for name, carrier := range carriers() {
+		t.Run(name, func(t *testing.T) {
+			c, send := carrier()
+			n := len(c.Keys())
+			propagator.Inject(ctx, c)
+			// injecting again replaces the context rather than adding to it
+			propagator.Inject(ctx, c)
+			if keys := c.Keys(); len(keys) != n+2 || !slices.Contains(keys, "traceparent") || !slices.Contains(keys, "baggage") {
+				t.Errorf("keys = %q, want the %d before, traceparent and baggage", keys, n)
+			}
+
+			got := propagator.Extract(context.Background(), send(c))
+			if gotSC := trace.SpanContextFromContext(got); !gotSC.Equal(sc.WithRemote(true)) {
+				t.Errorf("span context = %v, want %v", gotSC, sc)
+			}
+			if v := baggage.FromContext(got).Member("tenant.id").Value(); v != "acme" {
+				t.Errorf("baggage tenant.id = %q, want acme", v)
+			}
+		})
+	}
+}
+
+func TestB3(t *testing.T) {
+	parent := model.ID(0x0102030405060708)
+	want := model.SpanContext{
+		TraceID:  model.TraceID{High: 0x4bf92f3577b34da6, Low: 0xa3ce929d0e0e4736},
+		ID:       model.ID(0x00f067aa0ba902b7),
+		ParentID: &parent,
+	}
+	want.Sampled = new(bool)
+	*want.Sampled = true
+
+	for _, format := range []struct {
+		name string
+		opts []b3.InjectOption
+		keys []string
+	}{
+		{"multiple", nil, []string{b3.TraceID, b3.SpanID, b3.ParentSpanID, b3.Sampled}},
+		{"single", []b3.InjectOption{b3.WithSingleHeaderOnly()}, []string{b3.Context}},
+	} {
+		for name, carrier := range carriers() {
+			t.Run(format.name+"/"+name, func(t *testing.T) {
+				c, send := carrier()
+				if err := InjectB3(c, format.opts...)(want); err != nil {
+					t.Fatalf("inject: %v", err)
+				}
+				for _, k := range format.keys {
+					if c.Get(k) == "" {
+						t.Errorf("no %s in %q", k, c.Keys())
+					}
+				}
+
+				got, err := ExtractB3(send(c))()
+				if err != nil {
+					t.Fatalf("extract: %v", err)
+				}
+				if got.TraceID != want.TraceID || got.ID != want.ID || got.ParentID == nil || *got.ParentID != parent ||
+					got.Sampled == nil || !*got.Sampled {
+					t.Errorf("extracted %+v, want %+v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	for name, carrier := range carriers() {
+		t.Run(name, func(t *testing.T) {
+			c, _ := carrier()
+			if v := c.Get("traceparent"); v != "" {
+				t.Errorf("Get of a missing key = %q", v)
+			}
+			ctx := propagation.TraceContext{}.Extract(context.Background(), c)
+			if trace.SpanContextFromContext(ctx).IsValid() {
+				t.Error("extracted a span context from a carrier without one")
+			}
+			if sc, err := ExtractB3(c)(); err == nil && !sc.TraceID.Empty() {
+				t.Errorf("extracted B3 context %+v from a carrier without one", sc)
+			}
+		})
+	}
+}
+
+func TestFieldsNotString(t *testing.T) {
+	f := Fields{"traceparent": []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")}
+	if v := f.Get("traceparent"); v != "" {
+		t.Errorf("Get of a non-string field = %q, want empty", v)
+	}
+}
+
+func TestFieldListOddLength(t *testing.T) {
+	// a truncated list has a last field without a value
+	l := FieldList{"key", "k", "traceparent"}
+	if v := l.Get("traceparent"); v != "" {
+		t.Errorf("Get of a field without a value = %q", v)
+	}
+	if keys := l.Keys(); !slices.Equal(keys, []string{"key"}) {
+		t.Errorf("keys = %q, want [key]", keys)
+	}
+}
+
+func TestEnvelopeJSON(t *testing.T) {
+	e := Envelope{Payload: json.RawMessage(`{"key":"k","value":"v"}`)}
+	data, _ := json.Marshal(&e)
+	// an envelope without headers has none in its JSON
+	if string(data) != `{"payload":{"key":"k","value":"v"}}` {
+		t.Errorf("JSON = %s", data)
+	}
+	e.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	data, _ = json.Marshal(&e)
+	var got Envelope
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("traceparent") != e.Get("traceparent") || string(got.Payload) != string(e.Payload) {
+		t.Errorf("decoded %+v, want %+v", got, e)
+	}
+}
@@ -0,0 +1,162 @@
+package messaging
+
+import (
+	"context"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute names of the producer and consumer spans, after the messaging
+// semantic conventions.
+const (
+	AttrSystem      = "messaging.system"
+	AttrDestination = "messaging.destination.name"
+	AttrOperation   = "messaging.operation"
+	AttrMessageID   = "messaging.message.id"
+	AttrGroup       = "messaging.consumer.group.name"
+	AttrBodySize    = "messaging.message.body.size"
+)
+
+// Operations of the spans.
+const (
+	OperationPublish = "publish"
+	OperationProcess = "process"
+)
+
+// Message is a message as its spans record it.
+type Message struct {
+	// System is the messaging system, e.g. redis.
+	System      string
+	Destination string
+	// ID is the ID of the message, which a producer often only learns once
+	// it is sent.
+	ID string
+	// Group is the consumer group of a consumer, if any.
+	Group string
+	// Attributes are recorded on the span besides those of the conventions.
+	Attributes []attribute.KeyValue
+}
+
+// attributes returns the attributes of the span of op on m.
+func (m Message) attributes(op string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String(AttrSystem, m.System),
+		attribute.String(AttrDestination, m.Destination),
+		attribute.String(AttrOperation, op),
+	}
+	if m.ID != "" {
+		attrs = append(attrs, attribute.String(AttrMessageID, m.ID))
+	}
+	if m.Group != "" {
+		attrs = append(attrs, attribute.String(AttrGroup, m.Group))
+	}
+	return append(attrs, m.Attributes...)
+}
+
+// Tracing records messages with the tracer of a service. The spans are named
+// after the destination and the operation, e.g. "{kv:puts} publish".
+type Tracing interface {
+	// Publish starts the producer span of m beneath the span in ctx and
+	// injects its context into c, the carrier of the message; end finishes
+	// it with the error of sending the message and attrs, e.g. its ID.
+	Publish(ctx context.Context, m Message, c propagation.TextMapCarrier) (publishCtx context.Context, end func(err error, attrs ...attribute.KeyValue))
+	// Process starts the consumer span of m beneath the span in ctx, if
+	// any, and linked to or beneath the producer span whose context c
+	// carries; end finishes it with the error of processing the message and
+	// attrs, e.g. its outcome.
+	Process(ctx context.Context, m Message, c propagation.TextMapCarrier) (processCtx context.Context, end func(err error, attrs ...attribute.KeyValue))
+}
+
+type otelTracing struct {
+	tracer trace.Tracer
+}
+
+// OTel records messages as OpenTelemetry spans of tracer. Messages carry the
+// context of the global TextMapPropagator, e.g. the W3C traceparent and
+// baggage, and a consumer span outside of any other starts a new trace,
+// linked to the producer span.
+func OTel(tracer trace.Tracer) Tracing {
+	return otelTracing{tracer: tracer}
+}
+
+func (t otelTracing) Publish(ctx context.Context, m Message, c propagation.TextMapCarrier) (context.Context, func(error, ...attribute.KeyValue)) {
+	ctx, span := t.tracer.Start(ctx, m.Destination+" "+OperationPublish,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(m.attributes(OperationPublish)...),
+	)
+	otel.GetTextMapPropagator().Inject(ctx, c)
+	return ctx, endOTel(span)
+}
+
+func (t otelTracing) Process(ctx context.Context, m Message, c propagation.TextMapCarrier) (context.Context, func(error, ...attribute.KeyValue)) {
+	producer := otel.GetTextMapPropagator().Extract(context.Background(), c)
+	ctx, span := t.tracer.Start(ctx, m.Destination+" "+OperationProcess,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(trace.LinkFromContext(producer)),
+		trace.WithAttributes(m.attributes(OperationProcess)...),
+	)
+	return ctx, endOTel(span)
+}
+
+func endOTel(span trace.Span) func(error, ...attribute.KeyValue) {
+	return func(err error, attrs ...attribute.KeyValue) {
+		span.SetAttributes(attrs...)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+type zipkinTracing struct {
+	tracer *zipkin.Tracer
+}
+
+// Zipkin records messages as spans of tracer, with the attributes as tags.
+// Messages carry the B3 context of the producer spans, and the consumer spans
+// are their children, as Zipkin has no links; those of messages without a
+// context are children of the span in ctx, if any.
+func Zipkin(tracer *zipkin.Tracer) Tracing {
+	return zipkinTracing{tracer: tracer}
+}
+
+func (t zipkinTracing) Publish(ctx context.Context, m Message, c propagation.TextMapCarrier) (context.Context, func(error, ...attribute.KeyValue)) {
+	span, ctx := t.tracer.StartSpanFromContext(ctx, m.Destination+" "+OperationPublish, zipkin.Kind(model.Producer))
+	tag(span, m.attributes(OperationPublish))
+	InjectB3(c)(span.Context())
+	return ctx, endZipkin(span)
+}
+
+func (t zipkinTracing) Process(ctx context.Context, m Message, c propagation.TextMapCarrier) (context.Context, func(error, ...attribute.KeyValue)) {
+	parent := t.tracer.Extract(ExtractB3(c))
+	if parent.TraceID.Empty() {
+		if span := zipkin.SpanFromContext(ctx); span != nil {
+			parent = span.Context()
+		}
+	}
+	span := t.tracer.StartSpan(m.Destination+" "+OperationProcess, zipkin.Kind(model.Consumer), zipkin.Parent(parent))
+	tag(span, m.attributes(OperationProcess))
+	return zipkin.NewContext(ctx, span), endZipkin(span)
+}
+
+func endZipkin(span zipkin.Span) func(error, ...attribute.KeyValue) {
+	return func(err error, attrs ...attribute.KeyValue) {
+		tag(span, attrs)
+		if err != nil {
+			zipkin.TagError.Set(span, err.Error())
+		}
+		span.Finish()
+	}
+}
+
+func tag(span zipkin.Span, attrs []attribute.KeyValue) {
+	for _, kv := range attrs {
+		span.Tag(string(kv.Key), kv.Value.Emit())
+	}
+}
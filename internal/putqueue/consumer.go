@@ -0,0 +1,283 @@
+package putqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go-service-tracing/internal/kvstore"
+	"go-service-tracing/internal/messaging"
+	"go-service-tracing/internal/tenant"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Outcomes of the delivery of an entry.
+const (
+	// OutcomeApplied is an entry whose put was applied.
+	OutcomeApplied = "applied"
+	// OutcomeRetry is an entry whose put failed and that stays pending.
+	OutcomeRetry = "retry"
+	// OutcomeDropped is an entry that is not a valid put, or whose put
+	// failed in a way a retry cannot fix, e.g. a version mismatch.
+	OutcomeDropped = "dropped"
+	// OutcomeDead is an entry delivered more than MaxDeliveries times, which
+	// moved to the dead letter stream.
+	OutcomeDead = "dead"
+)
+
+// Options configures a Consumer.
+type Options struct {
+	Stream string
+	Group  string
+	// Consumer names the consumer within the group, which must be unique
+	// among the replicas.
+	Consumer string
+	// Count is the most entries read at once.
+	Count int64
+	// Block is how long a read waits for new entries.
+	Block time.Duration
+	// MinIdle is how long an entry stays pending before it is delivered
+	// again.
+	MinIdle time.Duration
+	// MaxDeliveries is how often an entry is delivered before it moves to
+	// the dead letter stream, DeadStream(Stream).
+	MaxDeliveries int64
+}
+
+// DefaultOptions consumes DefaultStream as the group service2, named after
+// the host and the process.
+func DefaultOptions() Options {
+	host, _ := os.Hostname()
+	return Options{
+		Stream:        DefaultStream,
+		Group:         "service2",
+		Consumer:      fmt.Sprintf("%s-%d", host, os.Getpid()),
+		Count:         16,
+		Block:         2 * time.Second,
+		MinIdle:       DefaultMinIdle,
+		MaxDeliveries: DefaultMaxDeliveries,
+	}
+}
+
+// Apply applies a put. ctx carries the consumer span and the tenant of the
+// put.
+type Apply func(ctx context.Context, p Put) error
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error of Apply that a retry cannot fix, so that the
+// entry is dropped rather than delivered again. The version mismatches and
+// exhausted quotas of kvstore need no marking.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+func permanent(err error) bool {
+	var pe permanentError
+	return errors.As(err, &pe) ||
+		errors.Is(err, kvstore.ErrVersionMismatch) ||
+		errors.Is(err, kvstore.ErrQuotaExceeded)
+}
+
+// Consumer applies the puts of a stream as a consumer of a group.
+type Consumer struct {
+	client  redis.Cmdable
+	opts    Options
+	apply   Apply
+	tracing messaging.Tracing
+	// grouped is whether the group is known to exist
+	grouped bool
+
+	entries metric.Int64Counter
+}
+
+// NewConsumer returns a consumer that applies puts with apply. Metrics go to
+// mp, the global MeterProvider if nil.
+func NewConsumer(c redis.Cmdable, o Options, apply Apply, t messaging.Tracing, mp metric.MeterProvider) *Consumer {
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter("go-service-tracing/internal/putqueue")
+	entries, _ := meter.Int64Counter("putqueue.entries",
+		metric.WithDescription("Deliveries of entries by outcome: applied, retry, dropped or dead"))
+	return &Consumer{client: c, opts: o, apply: apply, tracing: t, entries: entries}
+}
+
+// Run consumes the stream until ctx is done, creating the group first if it
+// does not exist. It takes over the entries left pending by any consumer
+// before reading new ones. Failures of Redis are logged and retried.
+func (c *Consumer) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := c.poll(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("consume %s: %v", c.opts.Stream, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+func (c *Consumer) poll(ctx context.Context) error {
+	if !c.grouped {
+		err := c.client.XGroupCreateMkStream(ctx, c.opts.Stream, c.opts.Group, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return err
+		}
+		c.grouped = true
+	}
+	if err := c.claim(ctx); err != nil {
+		return c.regroup(err)
+	}
+	streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.opts.Group,
+		Consumer: c.opts.Consumer,
+		Streams:  []string{c.opts.Stream, ">"},
+		Count:    c.opts.Count,
+		Block:    c.opts.Block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return c.regroup(err)
+	}
+	for _, s := range streams {
+		for _, m := range s.Messages {
+			c.handle(ctx, m, 1)
+		}
+	}
+	return nil
+}
+
+// regroup makes the next poll create the group again if err says that it is
+// gone, e.g. because the stream was deleted.
+func (c *Consumer) regroup(err error) error {
+	if strings.HasPrefix(err.Error(), "NOGROUP") {
+		c.grouped = false
+	}
+	return err
+}
+
+// claim takes over the entries pending for at least MinIdle: those whose put
+// failed and those of consumers that went away before acknowledging them.
+func (c *Consumer) claim(ctx context.Context) error {
+	pending, err := c.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: c.opts.Stream,
+		Group:  c.opts.Group,
+		Idle:   c.opts.MinIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  c.opts.Count,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	ids := make([]string, len(pending))
+	deliveries := make(map[string]int64, len(pending))
+	for i, p := range pending {
+		ids[i] = p.ID
+		deliveries[p.ID] = p.RetryCount
+	}
+	// another consumer may claim the same entries at the same time; MinIdle
+	// lets only the first of them have each
+	messages, err := c.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   c.opts.Stream,
+		Group:    c.opts.Group,
+		Consumer: c.opts.Consumer,
+		MinIdle:  c.opts.MinIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		c.handle(ctx, m, deliveries[m.ID]+1)
+	}
+	return nil
+}
+
+// handle applies the put of an entry delivered for the deliveries-th time
+// within its consumer span and acknowledges the entry unless it is to be
+// retried.
+func (c *Consumer) handle(ctx context.Context, m redis.XMessage, deliveries int64) {
+	fields := messaging.Fields(m.Values)
+	p, err := parse(fields)
+	if err == nil && p.Tenant != "" {
+		err = tenant.Validate(p.Tenant)
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String(AttrKey, p.Key),
+		attribute.Int64(AttrDeliveries, deliveries),
+	}
+	if err == nil {
+		ctx = tenant.NewContext(ctx, p.Tenant)
+		// zipkin-go has no hook for the start of a span to record the
+		// tenant of the put with
+		if p.Tenant != "" {
+			attrs = append(attrs, attribute.String(tenant.AttrTenantID, p.Tenant))
+		}
+	}
+	msg := message(c.opts.Stream, m.ID, attrs...)
+	msg.Group = c.opts.Group
+	ctx, end := c.tracing.Process(ctx, msg, fields)
+
+	outcome := OutcomeApplied
+	switch {
+	case err != nil:
+		outcome = OutcomeDropped
+	case deliveries > c.opts.MaxDeliveries:
+		outcome = OutcomeDead
+		err = fmt.Errorf("put of %q not applied in %d deliveries", p.Key, deliveries-1)
+	default:
+		if err = c.apply(ctx, p); err != nil {
+			outcome = OutcomeRetry
+			if permanent(err) {
+				outcome = OutcomeDropped
+			}
+		}
+	}
+	if outcome != OutcomeRetry {
+		if ackErr := c.ack(ctx, m, outcome == OutcomeDead); ackErr != nil {
+			err = errors.Join(err, fmt.Errorf("ack error: %w", ackErr))
+		}
+	}
+	if outcome == OutcomeApplied {
+		key := tenant.Prefix(ctx) + p.Key
+		if pubErr := c.client.Publish(ctx, AppliedChannel(c.opts.Stream), key).Err(); pubErr != nil {
+			err = errors.Join(err, fmt.Errorf("publish error: %w", pubErr))
+		}
+	}
+	c.entries.Add(ctx, 1, metric.WithAttributes(attribute.String(AttrOutcome, outcome)))
+	end(err, attribute.String(AttrOutcome, outcome))
+}
+
+// ack acknowledges and deletes an entry, after copying it to the dead letter
+// stream if it is dead.
+func (c *Consumer) ack(ctx context.Context, m redis.XMessage, dead bool) error {
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if dead {
+			pipe.XAdd(ctx, &redis.XAddArgs{Stream: DeadStream(c.opts.Stream), Values: m.Values})
+		}
+		pipe.XAck(ctx, c.opts.Stream, c.opts.Group, m.ID)
+		pipe.XDel(ctx, c.opts.Stream, m.ID)
+		return nil
+	})
+	return err
+}
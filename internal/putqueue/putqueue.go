@@ -0,0 +1,182 @@
+// Package putqueue is the asynchronous write path of the examples: service1
+// adds a put to a Redis Stream and answers at once, and the workers of
+// service2, the consumers of a group, apply it later.
+//
+// Every entry carries the trace context of the request that added it among
+// its fields, so that the span that applies it is linked to the request, or
+// with Zipkin, which has no links, is its child. An entry is acknowledged
+// and deleted once applied; one whose put failed stays pending and is
+// delivered again once it has been idle for a while, to any consumer, until
+// it has been delivered too often and moves to a dead letter stream. The key
+// of every put applied is published on AppliedChannel, so that service1 can
+// drop it from its cache.
+package putqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-service-tracing/internal/kvstore"
+	"go-service-tracing/internal/messaging"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DefaultStream is the stream of the puts unless the services name another.
+// Its name is a hash tag, which DeadStream shares, so that both streams are
+// in the same slot of a Redis Cluster.
+const DefaultStream = "{kv:puts}"
+
+// DefaultMinIdle is how long an entry stays pending before it is delivered
+// again.
+const DefaultMinIdle = 30 * time.Second
+
+// DefaultMaxDeliveries is how often an entry is delivered before it moves to
+// the dead letter stream.
+const DefaultMaxDeliveries = 5
+
+// Fields of an entry besides those of its trace context.
+const (
+	fieldTenant  = "tenant"
+	fieldKey     = "key"
+	fieldValue   = "value"
+	fieldTTL     = "ttl_seconds"
+	fieldVersion = "version"
+)
+
+// ErrInvalid is returned for an entry that is not a put.
+var ErrInvalid = errors.New("invalid put entry")
+
+// Put is a put of Key, queued by the tenant of the request that made it.
+type Put struct {
+	Tenant string
+	Key    string
+	Value  string
+	// TTLSeconds is 0 for the default of one minute and negative for no
+	// expiry.
+	TTLSeconds int64
+	// ExpectedVersion makes the put conditional, 0 meaning the key must not
+	// exist.
+	ExpectedVersion *int64
+}
+
+// Options converts the put to the options of kvstore.Put.
+func (p Put) Options() kvstore.PutOptions {
+	return kvstore.PutOptions{
+		TTL:             kvstore.TTLFromSeconds(p.TTLSeconds),
+		ExpectedVersion: p.ExpectedVersion,
+	}
+}
+
+// fields encodes p as the fields of an entry, to which the trace context is
+// added.
+func (p Put) fields() messaging.Fields {
+	fields := messaging.Fields{
+		fieldKey:   p.Key,
+		fieldValue: p.Value,
+		fieldTTL:   strconv.FormatInt(p.TTLSeconds, 10),
+	}
+	if p.Tenant != "" {
+		fields[fieldTenant] = p.Tenant
+	}
+	if p.ExpectedVersion != nil {
+		fields[fieldVersion] = strconv.FormatInt(*p.ExpectedVersion, 10)
+	}
+	return fields
+}
+
+// parse returns the put of the fields of an entry.
+func parse(fields messaging.Fields) (Put, error) {
+	p := Put{
+		Tenant: fields.Get(fieldTenant),
+		Key:    fields.Get(fieldKey),
+		Value:  fields.Get(fieldValue),
+	}
+	if p.Key == "" {
+		return p, fmt.Errorf("%w: no key", ErrInvalid)
+	}
+	var err error
+	if p.TTLSeconds, err = strconv.ParseInt(fields.Get(fieldTTL), 10, 64); err != nil {
+		return p, fmt.Errorf("%w: ttl %q", ErrInvalid, fields.Get(fieldTTL))
+	}
+	if v := fields.Get(fieldVersion); v != "" {
+		version, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("%w: version %q", ErrInvalid, v)
+		}
+		p.ExpectedVersion = &version
+	}
+	return p, nil
+}
+
+// Producer adds puts to a stream.
+type Producer struct {
+	client  redis.Cmdable
+	stream  string
+	tracing messaging.Tracing
+}
+
+// NewProducer returns a producer of puts on stream.
+func NewProducer(c redis.Cmdable, stream string, t messaging.Tracing) *Producer {
+	return &Producer{client: c, stream: stream, tracing: t}
+}
+
+// Add adds p to the stream and returns the ID of its entry. The entry
+// carries the context of the producer span, which Add starts beneath the
+// span in ctx.
+func (pr *Producer) Add(ctx context.Context, p Put) (string, error) {
+	fields := p.fields()
+	ctx, end := pr.tracing.Publish(ctx, message(pr.stream, "", attribute.String(AttrKey, p.Key)), fields)
+	id, err := pr.client.XAdd(ctx, &redis.XAddArgs{Stream: pr.stream, Values: map[string]any(fields)}).Result()
+	if err != nil {
+		end(err)
+		return "", err
+	}
+	end(nil, attribute.String(messaging.AttrMessageID, id))
+	return id, nil
+}
+
+// DeadStream is the dead letter stream of stream, in the same slot of a
+// Redis Cluster, as one transaction moves a dead entry from one to the other:
+// it shares the hash tag of stream, or has all of the name of a stream
+// without braces as its own.
+func DeadStream(stream string) string {
+	if i := strings.IndexByte(stream, '{'); i >= 0 {
+		if j := strings.IndexByte(stream[i+1:], '}'); j > 0 {
+			return stream + ":dead"
+		}
+	}
+	return "{" + stream + "}:dead"
+}
+
+// AppliedChannel is the Pub/Sub channel on which the consumers of stream
+// publish the key of every put they applied, prefixed as tenant.Prefix has
+// it.
+func AppliedChannel(stream string) string {
+	return stream + ":applied"
+}
+
+// Applied calls f with the key of every put applied from stream, prefixed as
+// tenant.Prefix has it, until ctx is done. Pub/Sub delivers at most once, so
+// the puts applied while the subscription is broken are missed.
+func Applied(ctx context.Context, c redis.UniversalClient, stream string, f func(key string)) {
+	pubsub := c.Subscribe(ctx, AppliedChannel(stream))
+	defer pubsub.Close()
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m, ok := <-ch:
+			if !ok {
+				return
+			}
+			f(m.Payload)
+		}
+	}
+}
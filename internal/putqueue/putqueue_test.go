@@ -0,0 +1,247 @@
+package putqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-service-tracing/internal/messaging"
+	"go-service-tracing/internal/tenant"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// setup returns a client of a fresh miniredis, a recorder of the spans of
+// the producer and consumer, and options that let entries be claimed again
+// at once.
+func setup(t *testing.T) (*redis.Client, *tracetest.SpanRecorder, messaging.Tracing, Options) {
+	t.Helper()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	opts := Options{
+		Stream:        DefaultStream,
+		Group:         "service2",
+		Consumer:      "test",
+		Count:         16,
+		Block:         10 * time.Millisecond,
+		MinIdle:       time.Millisecond,
+		MaxDeliveries: 2,
+	}
+	return client, recorder, messaging.OTel(tp.Tracer("test")), opts
+}
+
+// outcomes returns the outcome of every consumer span in order.
+func outcomes(recorder *tracetest.SpanRecorder) []string {
+	var out []string
+	for _, s := range recorder.Ended() {
+		for _, a := range s.Attributes() {
+			if a.Key == AttrOutcome {
+				out = append(out, a.Value.AsString())
+			}
+		}
+	}
+	return out
+}
+
+func attr(attrs []attribute.KeyValue, key attribute.Key) string {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestApply(t *testing.T) {
+	client, recorder, tracing, opts := setup(t)
+	ctx := context.Background()
+
+	var keys []string
+	applied := make(chan string, 2)
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go Applied(subCtx, client, opts.Stream, func(key string) { applied <- key })
+	waitSubscribed(t, client, AppliedChannel(opts.Stream))
+
+	producer := NewProducer(client, opts.Stream, tracing)
+	version := int64(3)
+	puts := []Put{
+		{Tenant: "acme", Key: "k", Value: "v", TTLSeconds: -1, ExpectedVersion: &version},
+		{Key: "j", Value: "w"},
+	}
+	for _, p := range puts {
+		if _, err := producer.Add(ctx, p); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	var got []Put
+	consumer := NewConsumer(client, opts, func(ctx context.Context, p Put) error {
+		id, _ := tenant.FromContext(ctx)
+		if id != p.Tenant {
+			t.Errorf("tenant of the context = %q, want %q", id, p.Tenant)
+		}
+		got = append(got, p)
+		keys = append(keys, tenant.Prefix(ctx)+p.Key)
+		return nil
+	}, tracing, metricnoop.NewMeterProvider())
+	if err := consumer.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+
+	if len(got) != 2 || got[0].Tenant != "acme" || got[0].TTLSeconds != -1 || *got[0].ExpectedVersion != 3 ||
+		got[1].Tenant != "" || got[1].Value != "w" || got[1].ExpectedVersion != nil {
+		t.Fatalf("applied %+v", got)
+	}
+	if n := client.XLen(ctx, opts.Stream).Val(); n != 0 {
+		t.Errorf("%d entries left in the stream", n)
+	}
+	for _, want := range []string{"{acme}:k", "{_}:j"} {
+		select {
+		case key := <-applied:
+			if key != want {
+				t.Errorf("applied key %q, want %q", key, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no applied key %q", want)
+		}
+	}
+	if len(keys) != 2 || keys[0] != "{acme}:k" || keys[1] != "{_}:j" {
+		t.Errorf("keys = %q", keys)
+	}
+
+	// the consumer spans are linked to the producer spans
+	spans := recorder.Ended()
+	producers := map[string]bool{}
+	for _, s := range spans {
+		if s.Name() == opts.Stream+" "+messaging.OperationPublish {
+			producers[s.SpanContext().SpanID().String()] = true
+		}
+	}
+	consumers := 0
+	for _, s := range spans {
+		if s.Name() != opts.Stream+" "+messaging.OperationProcess {
+			continue
+		}
+		consumers++
+		if len(s.Links()) != 1 || !producers[s.Links()[0].SpanContext.SpanID().String()] {
+			t.Errorf("consumer span links %v, want a producer span", s.Links())
+		}
+		if attr(s.Attributes(), AttrOutcome) != OutcomeApplied || attr(s.Attributes(), AttrDeliveries) != "1" {
+			t.Errorf("consumer span attributes %v", s.Attributes())
+		}
+	}
+	if consumers != 2 {
+		t.Errorf("%d consumer spans, want 2", consumers)
+	}
+}
+
+// waitSubscribed waits until channel has a subscriber.
+func waitSubscribed(t *testing.T, client *redis.Client, channel string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if n := client.PubSubNumSub(context.Background(), channel).Val()[channel]; n > 0 {
+			return
+		}
+	}
+	t.Fatalf("no subscriber of %s", channel)
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		outcomes []string
+		dead     int64
+	}{
+		{"applied", nil, []string{OutcomeApplied}, 0},
+		{"retried", errors.New("redis down"), []string{OutcomeRetry, OutcomeRetry, OutcomeDead}, 1},
+		{"permanent", Permanent(errors.New("bad put")), []string{OutcomeDropped}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, recorder, tracing, opts := setup(t)
+			ctx := context.Background()
+			if _, err := NewProducer(client, opts.Stream, tracing).Add(ctx, Put{Key: "k", Value: "v"}); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+			consumer := NewConsumer(client, opts, func(context.Context, Put) error { return tt.err },
+				tracing, metricnoop.NewMeterProvider())
+			for range 4 {
+				if err := consumer.poll(ctx); err != nil {
+					t.Fatalf("poll: %v", err)
+				}
+				// let the pending entry idle for MinIdle
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			got := outcomes(recorder)
+			if len(got) != len(tt.outcomes) {
+				t.Fatalf("outcomes = %q, want %q", got, tt.outcomes)
+			}
+			for i := range got {
+				if got[i] != tt.outcomes[i] {
+					t.Fatalf("outcomes = %q, want %q", got, tt.outcomes)
+				}
+			}
+			if n := client.XLen(ctx, opts.Stream).Val(); n != 0 {
+				t.Errorf("%d entries left in the stream", n)
+			}
+			if n := client.XLen(ctx, DeadStream(opts.Stream)).Val(); n != tt.dead {
+				t.Errorf("%d entries in the dead letter stream, want %d", n, tt.dead)
+			}
+		})
+	}
+}
+
+func TestDeadStream(t *testing.T) {
+	for stream, want := range map[string]string{
+		DefaultStream: "{kv:puts}:dead",
+		"puts":        "{puts}:dead",
+		"{acme}:puts": "{acme}:puts:dead",
+		// an empty tag is no tag
+		"{}:puts": "{{}:puts}:dead",
+	} {
+		if got := DeadStream(stream); got != want {
+			t.Errorf("DeadStream(%q) = %q, want %q", stream, got, want)
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	client, recorder, tracing, opts := setup(t)
+	ctx := context.Background()
+	for _, fields := range []map[string]any{
+		{fieldValue: "v", fieldTTL: "0"},
+		{fieldKey: "k", fieldTTL: "soon"},
+		{fieldKey: "k", fieldTTL: "0", fieldTenant: "_:"},
+	} {
+		client.XAdd(ctx, &redis.XAddArgs{Stream: opts.Stream, Values: fields})
+	}
+	consumer := NewConsumer(client, opts, func(context.Context, Put) error {
+		t.Error("invalid put applied")
+		return nil
+	}, tracing, metricnoop.NewMeterProvider())
+	if err := consumer.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	got := outcomes(recorder)
+	if len(got) != 3 || got[0] != OutcomeDropped || got[1] != OutcomeDropped || got[2] != OutcomeDropped {
+		t.Errorf("outcomes = %q, want 3 dropped", got)
+	}
+	if n := client.XLen(ctx, opts.Stream).Val(); n != 0 {
+		t.Errorf("%d entries left in the stream", n)
+	}
+}
@@ -0,0 +1,25 @@
+package putqueue
+
+import (
+	"go-service-tracing/internal/messaging"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Attribute names of the producer and consumer spans besides those of
+// messaging, and of metrics.
+const (
+	AttrKey        = "kv.key"
+	AttrDeliveries = "putqueue.deliveries"
+	AttrOutcome    = "putqueue.outcome"
+)
+
+// message describes an entry of stream to its spans.
+func message(stream, id string, attrs ...attribute.KeyValue) messaging.Message {
+	return messaging.Message{
+		System:      "redis",
+		Destination: stream,
+		ID:          id,
+		Attributes:  attrs,
+	}
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// peerIP returns the IP address of the caller. Behind the gateway that is the
+// gateway's.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// throttle decides on a call, failing with ResourceExhausted as Gin answers
+// 429. The status carries a RetryInfo detail and the header a retry-after,
+// which the gateway answers as the Retry-After header.
+func (th *Throttle) throttle(ctx context.Context, fullMethod string) error {
+	if th == nil || strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/") {
+		return nil
+	}
+	d := th.allow(ctx, peerIP(ctx))
+	if d.Allowed {
+		return nil
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("retry-after", d.retryAfter()))
+	st := status.New(codes.ResourceExhausted, ErrLimited.Error())
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(d.RetryAfter)})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// UnaryServerInterceptor throttles every call but the health checks. It goes
+// after the interceptors that authenticate the call and take its tenant, or
+// before them for KeyUnauthenticated.
+func (th *Throttle) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := th.throttle(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams, which take a
+// single token each.
+func (th *Throttle) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := th.throttle(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,29 @@
+package ratelimit
+
+import (
+	"net/http"
+
+	"go-service-tracing/internal/readiness"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gin throttles every request but the probes, answering 429 with a
+// Retry-After header to those beyond the limit. It goes after the
+// middleware that authenticates the request and takes its tenant, or
+// before it for KeyUnauthenticated.
+func (th *Throttle) Gin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if th == nil || readiness.IsProbe(c.Request) {
+			c.Next()
+			return
+		}
+		d := th.allow(c.Request.Context(), c.ClientIP())
+		if !d.Allowed {
+			c.Header("Retry-After", d.retryAfter())
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": ErrLimited.Error()})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-service-tracing/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// Failed attempts at an API key run into the limit of the IP address before
+// authentication, which has buckets of its own.
+func TestUnauthenticated(t *testing.T) {
+	limit := NewLocal(Limit{Rate: 0.001, Burst: 3})
+	authThrottle := New(limit, KeyUnauthenticated, OTel, nil)
+	throttle := New(limit, KeyIP, OTel, nil)
+	r := gin.New()
+	r.Use(authThrottle.Gin(), auth.Gin(auth.APIKeys(map[string]string{"k1": "alice"}), auth.OTel), throttle.Gin())
+	r.GET("/v1/kv/k", func(c *gin.Context) { c.Status(http.StatusOK) })
+	get := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/kv/k", nil)
+		req.Header.Set(auth.APIKeyHeader, key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get("k1"); code != http.StatusOK {
+		t.Fatalf("first request: %d", code)
+	}
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		if code := get("guess"); code != want {
+			t.Errorf("guess %d: %d, want %d", i, code, want)
+		}
+	}
+	if code := get("k1"); code != http.StatusTooManyRequests {
+		t.Errorf("good key after the guesses: %d, want 429", code)
+	}
+
+	// the bucket after authentication only counted the good request
+	if d, _ := limit.Allow(context.Background(), string(KeyIP)+":192.0.2.1"); !d.Allowed || d.Remaining != 1 {
+		t.Errorf("bucket of the IP address = %+v, want 1 token left", d)
+	}
+}
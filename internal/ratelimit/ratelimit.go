@@ -0,0 +1,153 @@
+// Package ratelimit throttles the clients of service1 with token buckets,
+// one per client, kept in memory by Local or shared through Redis by Redis.
+//
+// A Key decides what a client is: its IP address, the principal that
+// authenticated it or its tenant. Gin and the gRPC interceptors answer the
+// requests beyond the limit with 429 or ResourceExhausted and a Retry-After,
+// and record every decision on the server span and in metrics.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go-service-tracing/internal/auth"
+	"go-service-tracing/internal/tenant"
+)
+
+// ErrLimited is the error of the requests beyond the limit.
+var ErrLimited = errors.New("rate limited")
+
+// Limit is the token bucket of every client: it holds up to Burst requests
+// and refills at Rate requests per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Decision is what a Limiter decided on a request.
+type Decision struct {
+	Allowed bool
+	// Limit is the size of the bucket, Remaining what is left of it.
+	Limit     int
+	Remaining int
+	// RetryAfter is when the next request will be allowed, for the requests
+	// that are not.
+	RetryAfter time.Duration
+}
+
+// Limiter takes a token from the bucket of a client.
+type Limiter interface {
+	Allow(ctx context.Context, client string) (Decision, error)
+}
+
+// Key is what tells the clients apart.
+type Key string
+
+// The keys of ParseKey. KeyPrincipal and KeyTenant fall back to the IP address
+// for the requests without a principal or a tenant.
+const (
+	KeyIP        Key = "ip"
+	KeyPrincipal Key = "principal"
+	KeyTenant    Key = "tenant"
+)
+
+// KeyUnauthenticated tells the clients apart by IP address in buckets of
+// their own, for a Throttle in front of authentication, which limits the
+// attempts at guessing credentials too.
+const KeyUnauthenticated Key = "unauthenticated"
+
+// ParseKey reads ip, principal or tenant.
+func ParseKey(s string) (Key, error) {
+	switch k := Key(s); k {
+	case KeyIP, KeyPrincipal, KeyTenant:
+		return k, nil
+	}
+	return "", fmt.Errorf("unknown key %q, want ip, principal or tenant", s)
+}
+
+// client returns the client of a request from ip, e.g. tenant:acme.
+func (k Key) client(ctx context.Context, ip string) string {
+	switch k {
+	case KeyUnauthenticated:
+		return string(KeyUnauthenticated) + ":" + ip
+	case KeyPrincipal:
+		if p, ok := auth.FromContext(ctx); ok {
+			return string(KeyPrincipal) + ":" + p.ID
+		}
+	case KeyTenant:
+		if id, ok := tenant.FromContext(ctx); ok {
+			return string(KeyTenant) + ":" + id
+		}
+	}
+	return string(KeyIP) + ":" + ip
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills b until now and takes a token from it.
+func (b *bucket) take(l Limit, now time.Time) Decision {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(l.Burst), b.tokens+elapsed*l.Rate)
+		b.last = now
+	}
+	d := Decision{Limit: l.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	}
+	d.Remaining = int(b.tokens)
+	return d
+}
+
+// Local keeps the buckets in memory, which limits every replica of a service
+// on its own.
+type Local struct {
+	limit Limit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewLocal returns a Limiter with buckets of l.
+func NewLocal(l Limit) *Local {
+	return &Local{limit: l, buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+func (l *Local) Allow(_ context.Context, client string) (Decision, error) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[client] = b
+	}
+	return b.take(l.limit, now), nil
+}
+
+// sweep forgets, once a minute, the buckets that have refilled since their
+// last request: a new one is no different.
+func (l *Local) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, client)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix starts the keys of the buckets, which no tenant namespace of
+// service2 starts with.
+const keyPrefix = "#ratelimit:"
+
+// takeScript refills the bucket KEYS[1] and takes a token from it in one
+// step. ARGV[1] is the rate per second, ARGV[2] the burst and ARGV[3] the
+// current time in milliseconds. It returns {1 if allowed, tokens left, ms
+// until the next token if not}. The bucket expires once it would be full
+// again.
+var takeScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(bucket[1]) or burst
+local last = tonumber(bucket[2]) or now
+if now > last then
+  tokens = math.min(burst, tokens + (now - last) * rate / 1000)
+  last = now
+end
+local allowed, retry = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', last)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * 1000 / rate) + 1000)
+return {allowed, math.floor(tokens), retry}
+`
+
+type redisLimiter struct {
+	c     redis.Scripter
+	limit Limit
+}
+
+// Redis keeps the buckets in Redis, which limits the clients across every
+// replica of a service that shares c.
+func Redis(c redis.Scripter, l Limit) Limiter {
+	return redisLimiter{c: c, limit: l}
+}
+
+func (r redisLimiter) Allow(ctx context.Context, client string) (Decision, error) {
+	res, err := r.c.Eval(ctx, takeScript, []string{keyPrefix + client},
+		strconv.FormatFloat(r.limit.Rate, 'f', -1, 64), r.limit.Burst, time.Now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+	return Decision{
+		Allowed:    res[0] == 1,
+		Limit:      r.limit.Burst,
+		Remaining:  int(res[1]),
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+	}, nil
+}
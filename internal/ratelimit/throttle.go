@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Throttle puts a Limiter in front of the requests of a service. A nil
+// *Throttle lets every request through.
+type Throttle struct {
+	limiter Limiter
+	key     Key
+	tracing Tracing
+
+	decisions metric.Int64Counter
+}
+
+// New throttles the clients told apart by k with l. Metrics go to mp, the
+// global MeterProvider if nil.
+func New(l Limiter, k Key, t Tracing, mp metric.MeterProvider) *Throttle {
+	th := &Throttle{limiter: l, key: k, tracing: t}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter("go-service-tracing/internal/ratelimit")
+	th.decisions, _ = meter.Int64Counter("ratelimit.decisions",
+		metric.WithDescription("Requests let through or turned away by the rate limiter"))
+	return th
+}
+
+// allow decides on a request from ip, letting it through if the limiter
+// fails.
+func (th *Throttle) allow(ctx context.Context, ip string) Decision {
+	client := th.key.client(ctx, ip)
+	d, err := th.limiter.Allow(ctx, client)
+	if err != nil {
+		th.tracing.Failed(ctx, err)
+		return Decision{Allowed: true}
+	}
+	// the client itself would make too many series
+	th.decisions.Add(ctx, 1, metric.WithAttributes(
+		attribute.String(AttrKey, string(th.key)),
+		attribute.Bool(AttrAllowed, d.Allowed),
+	))
+	th.tracing.Decided(ctx, client, d)
+	return d
+}
+
+// retryAfter is RetryAfter in whole seconds, as the Retry-After header has it.
+func (d Decision) retryAfter() string {
+	return strconv.FormatInt(int64((d.RetryAfter+time.Second-1)/time.Second), 10)
+}
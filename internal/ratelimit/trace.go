@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/openzipkin/zipkin-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute names of the decisions on server spans and in metrics.
+const (
+	AttrClient     = "ratelimit.client"
+	AttrKey        = "ratelimit.key"
+	AttrAllowed    = "ratelimit.allowed"
+	AttrLimit      = "ratelimit.limit"
+	AttrRemaining  = "ratelimit.remaining"
+	AttrRetryAfter = "ratelimit.retry_after_ms"
+)
+
+// Tracing records the decisions on the span in ctx, the server span of the
+// request.
+type Tracing interface {
+	// Decided records d on the request of client.
+	Decided(ctx context.Context, client string, d Decision)
+	// Failed records that the limiter failed with err, which lets the
+	// request through.
+	Failed(ctx context.Context, err error)
+}
+
+type otelTracing struct{}
+
+// OTel records on OpenTelemetry spans.
+var OTel Tracing = otelTracing{}
+
+func (otelTracing) Decided(ctx context.Context, client string, d Decision) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String(AttrClient, client),
+		attribute.Bool(AttrAllowed, d.Allowed),
+		attribute.Int(AttrLimit, d.Limit),
+		attribute.Int(AttrRemaining, d.Remaining),
+	)
+	if !d.Allowed {
+		span.SetAttributes(attribute.Int64(AttrRetryAfter, d.RetryAfter.Milliseconds()))
+		span.SetStatus(codes.Error, ErrLimited.Error())
+	}
+}
+
+func (otelTracing) Failed(ctx context.Context, err error) {
+	trace.SpanFromContext(ctx).AddEvent("ratelimit.failed", trace.WithAttributes(
+		attribute.String("error.message", err.Error()),
+	))
+}
+
+type zipkinTracing struct{}
+
+// Zipkin records on Zipkin spans; a failure becomes an annotation.
+var Zipkin Tracing = zipkinTracing{}
+
+func (zipkinTracing) Decided(ctx context.Context, client string, d Decision) {
+	span := zipkin.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	span.Tag(AttrClient, client)
+	span.Tag(AttrAllowed, strconv.FormatBool(d.Allowed))
+	span.Tag(AttrLimit, strconv.Itoa(d.Limit))
+	span.Tag(AttrRemaining, strconv.Itoa(d.Remaining))
+	if !d.Allowed {
+		span.Tag(AttrRetryAfter, strconv.FormatInt(d.RetryAfter.Milliseconds(), 10))
+		zipkin.TagError.Set(span, ErrLimited.Error())
+	}
+}
+
+func (zipkinTracing) Failed(ctx context.Context, err error) {
+	if span := zipkin.SpanFromContext(ctx); span != nil {
+		span.Annotate(time.Now(), "ratelimit failed: "+err.Error())
+	}
+}
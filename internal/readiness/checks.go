@@ -0,0 +1,84 @@
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Redis pings c.
+func Redis(c redis.Cmdable) Check {
+	return func(ctx context.Context) error {
+		return c.Ping(ctx).Err()
+	}
+}
+
+// GRPCHealth asks the grpc.health.v1 service behind conn whether service is
+// SERVING, which makes a caller only as ready as its upstream.
+func GRPCHealth(conn grpc.ClientConnInterface, service string) Check {
+	client := healthpb.NewHealthClient(conn)
+	return func(ctx context.Context) error {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("%s is %s", service, resp.Status)
+		}
+		return nil
+	}
+}
+
+// GRPCConn fails while conn cannot connect, e.g. the connection of an OTLP
+// exporter to a collector that is down. An idle conn is asked to connect and
+// counts as usable until it fails.
+func GRPCConn(conn *grpc.ClientConn) Check {
+	return func(ctx context.Context) error {
+		switch state := conn.GetState(); state {
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return fmt.Errorf("connection to %s is %s", conn.Target(), state)
+		case connectivity.Idle:
+			conn.Connect()
+		}
+		return nil
+	}
+}
+
+// HTTP expects a 2xx answer to a GET of url, e.g. the /readyz endpoint of
+// an upstream service. client should not be traced.
+func HTTP(client *http.Client, url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		return nil
+	}
+}
+
+// Dial succeeds when a TCP connection to addr can be opened, for exporters
+// that keep no connection of their own, like the Zipkin HTTP reporter.
+func Dial(addr string) Check {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
@@ -0,0 +1,169 @@
+// Package readiness decides whether a service can take traffic by checking
+// its dependencies periodically. The result is published through the
+// grpc.health.v1 service of the gRPC examples and the /readyz endpoint of the
+// gin examples.
+package readiness
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Interval is how often the checks run.
+const Interval = 5 * time.Second
+
+// Timeout bounds a single check.
+const Timeout = 2 * time.Second
+
+// Check returns nil when the dependency it checks is usable.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs checks and remembers their last results. A service is ready
+// once every check has passed in the latest round, so it starts out not
+// ready.
+type Checker struct {
+	checks []namedCheck
+
+	mu      sync.Mutex
+	errs    map[string]error
+	ready   bool
+	servers []servedHealth
+}
+
+type servedHealth struct {
+	server   *health.Server
+	services []string
+}
+
+// NewChecker returns a Checker without checks; add them with Add before
+// calling Start.
+func NewChecker() *Checker {
+	return &Checker{errs: map[string]error{}}
+}
+
+// Add registers a check under name, e.g. "redis".
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Serve keeps the status of services in s in line with the checks: SERVING
+// when ready and NOT_SERVING otherwise. The empty service name stands for the
+// server as a whole.
+func (c *Checker) Serve(s *health.Server, services ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.servers = append(c.servers, servedHealth{server: s, services: services})
+	c.publishLocked()
+}
+
+// Start runs the first round of checks before returning, so that the status
+// is known when the service starts listening, then keeps checking every
+// Interval until ctx is done.
+func (c *Checker) Start(ctx context.Context) {
+	c.run(ctx)
+	go func() {
+		ticker := time.NewTicker(Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.run(ctx)
+			}
+		}
+	}()
+}
+
+func (c *Checker) run(ctx context.Context) {
+	errs := make(map[string]error, len(c.checks))
+	for _, nc := range c.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, Timeout)
+		errs[nc.name] = nc.check(checkCtx)
+		cancel()
+	}
+
+	ready := true
+	for _, err := range errs {
+		if err != nil {
+			ready = false
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errs = errs
+	if ready != c.ready {
+		c.ready = ready
+		c.publishLocked()
+	}
+}
+
+func (c *Checker) publishLocked() {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if c.ready {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	for _, sh := range c.servers {
+		for _, name := range sh.services {
+			sh.server.SetServingStatus(name, status)
+		}
+	}
+}
+
+// Ready reports whether every check passed in the latest round, along with
+// the result of each check: "ok" or the error.
+func (c *Checker) Ready() (bool, map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	results := make(map[string]string, len(c.errs))
+	for name, err := range c.errs {
+		if err != nil {
+			results[name] = err.Error()
+		} else {
+			results[name] = "ok"
+		}
+	}
+	return c.ready, results
+}
+
+// Healthz answers 200 as long as the process serves requests. It does not
+// look at the checks: a service whose dependencies are down should be taken
+// out of rotation, not restarted.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
+
+// Readyz answers 200 when c is ready and 503 otherwise, listing the result
+// of every check.
+func (c *Checker) Readyz(w http.ResponseWriter, r *http.Request) {
+	ready, results := c.Ready()
+	code, status := http.StatusOK, "ready"
+	if !ready {
+		code, status = http.StatusServiceUnavailable, "not ready"
+	}
+	writeJSON(w, code, map[string]any{"status": status, "checks": results})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// IsProbe reports whether r is a request to /healthz or /readyz, which
+// the services leave out of their traces.
+func IsProbe(r *http.Request) bool {
+	return r.URL.Path == "/healthz" || r.URL.Path == "/readyz"
+}
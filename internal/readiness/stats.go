@@ -0,0 +1,36 @@
+package readiness
+
+import (
+	"context"
+	"strings"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/stats"
+)
+
+type skippedKey struct{}
+
+// SkipHealthChecks wraps a stats handler that has no filter of its own, such
+// as zipkin-go's, so that grpc.health.v1 calls never reach it and produce no
+// spans. otelgrpc handlers filter with grpctrace.ExcludeHealthChecks instead.
+func SkipHealthChecks(h stats.Handler) stats.Handler {
+	return skipHealth{h}
+}
+
+type skipHealth struct {
+	stats.Handler
+}
+
+func (s skipHealth) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	if strings.HasPrefix(info.FullMethodName, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") {
+		return context.WithValue(ctx, skippedKey{}, true)
+	}
+	return s.Handler.TagRPC(ctx, info)
+}
+
+func (s skipHealth) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	if skipped, _ := ctx.Value(skippedKey{}).(bool); skipped {
+		return
+	}
+	s.Handler.HandleRPC(ctx, rs)
+}
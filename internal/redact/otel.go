@@ -0,0 +1,115 @@
+package redact
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type spanProcessor struct {
+	redactor *Redactor
+	next     sdktrace.SpanProcessor
+}
+
+// SpanProcessor hands next the ended spans with their attributes, the
+// attributes of their events and links, and their status redacted by r.
+// next is typically the batch span processor of the exporter:
+//
+//	sdktrace.WithSpanProcessor(redact.SpanProcessor(r, sdktrace.NewBatchSpanProcessor(exporter)))
+func SpanProcessor(r *Redactor, next sdktrace.SpanProcessor) sdktrace.SpanProcessor {
+	return spanProcessor{redactor: r, next: next}
+}
+
+func (p spanProcessor) OnStart(ctx context.Context, s sdktrace.ReadWriteSpan) {
+	p.next.OnStart(ctx, s)
+}
+
+func (p spanProcessor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if p.redactor == nil {
+		p.next.OnEnd(s)
+		return
+	}
+	p.next.OnEnd(redactedSpan{ReadOnlySpan: s, redactor: p.redactor})
+}
+
+func (p spanProcessor) Shutdown(ctx context.Context) error {
+	return p.next.Shutdown(ctx)
+}
+
+func (p spanProcessor) ForceFlush(ctx context.Context) error {
+	return p.next.ForceFlush(ctx)
+}
+
+// redactedSpan is an ended span as seen through a Redactor. The span does not
+// change once ended, so it is redacted whenever it is read.
+type redactedSpan struct {
+	sdktrace.ReadOnlySpan
+	redactor *Redactor
+}
+
+func (s redactedSpan) Attributes() []attribute.KeyValue {
+	return s.redactor.Attributes(s.ReadOnlySpan.Attributes())
+}
+
+func (s redactedSpan) Events() []sdktrace.Event {
+	events := s.ReadOnlySpan.Events()
+	redacted := make([]sdktrace.Event, len(events))
+	for i, e := range events {
+		e.Attributes = s.redactor.Attributes(e.Attributes)
+		redacted[i] = e
+	}
+	return redacted
+}
+
+func (s redactedSpan) Links() []sdktrace.Link {
+	links := s.ReadOnlySpan.Links()
+	redacted := make([]sdktrace.Link, len(links))
+	for i, l := range links {
+		l.Attributes = s.redactor.Attributes(l.Attributes)
+		redacted[i] = l
+	}
+	return redacted
+}
+
+func (s redactedSpan) Status() sdktrace.Status {
+	status := s.ReadOnlySpan.Status()
+	if status.Code == codes.Error {
+		status.Description, _ = s.redactor.Text(status.Description)
+	}
+	return status
+}
+
+// Attributes returns attrs with their string values redacted, leaving out the
+// dropped ones. attrs itself is not changed.
+func (r *Redactor) Attributes(attrs []attribute.KeyValue) []attribute.KeyValue {
+	if r == nil || len(attrs) == 0 {
+		return attrs
+	}
+	redacted := make([]attribute.KeyValue, 0, len(attrs))
+	for _, kv := range attrs {
+		key := string(kv.Key)
+		switch kv.Value.Type() {
+		case attribute.STRING:
+			v, ok := r.Value(key, kv.Value.AsString())
+			if !ok {
+				continue
+			}
+			kv = attribute.String(key, v)
+		case attribute.STRINGSLICE:
+			var values []string
+			for _, v := range kv.Value.AsStringSlice() {
+				if v, ok := r.Value(key, v); ok {
+					values = append(values, v)
+				}
+			}
+			if values == nil {
+				continue
+			}
+			kv = attribute.StringSlice(key, values)
+		}
+		redacted = append(redacted, kv)
+	}
+	return redacted
+}
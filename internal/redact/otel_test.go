@@ -0,0 +1,62 @@
+package redact
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// No raw value of the attributes, events, links or status of a span reaches
+// the exporter, while what is not sensitive does.
+func TestSpanProcessor(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(
+		SpanProcessor(defaultRedactor(t), sdktrace.NewSimpleSpanProcessor(exporter))))
+	tracer := tp.Tracer("test")
+
+	_, linked := tracer.Start(context.Background(), "linked")
+	linked.End()
+	_, span := tracer.Start(context.Background(), "put",
+		trace.WithLinks(trace.Link{
+			SpanContext: linked.SpanContext(),
+			Attributes:  []attribute.KeyValue{attribute.String("payment", raw["card"])},
+		}),
+		trace.WithAttributes(
+			attribute.String("redis.key", "k"),
+			attribute.String("value", raw["kv-value"]),
+			attribute.StringSlice("values", []string{"plain", raw["jwt"]}),
+			attribute.String("db.password", raw["credential"]),
+			attribute.Int("retries", 2),
+		))
+	span.AddEvent("sent", trace.WithAttributes(attribute.String("header", raw["bearer"])))
+	span.SetStatus(codes.Error, "unknown user "+raw["email"])
+	span.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	put := spans[1]
+	if found := leaked(fmt.Sprintf("%+v", put)); len(found) > 0 {
+		t.Fatalf("exported span holds raw values %q", found)
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range put.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["redis.key"].AsString() != "k" || attrs["retries"].AsInt64() != 2 {
+		t.Errorf("attributes = %v, want redis.key and retries kept", put.Attributes)
+	}
+	if got := attrs["values"].AsStringSlice(); len(got) != 2 || got[0] != "plain" || got[1] != Masked {
+		t.Errorf("values = %q, want the token masked", got)
+	}
+	if len(put.Links) != 1 || len(put.Events) != 1 || put.Status.Code != codes.Error {
+		t.Errorf("span lost its links, events or status: %+v", put)
+	}
+}
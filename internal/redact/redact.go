@@ -0,0 +1,238 @@
+// Package redact keeps sensitive values out of the exported spans.
+//
+// A Policy is a list of rules that mask, hash or drop span attributes by key
+// pattern and rewrite what a regular expression finds in their values, such
+// as tokens, email addresses or card numbers. SpanProcessor applies it to the
+// OpenTelemetry pipeline, Reporter to the zipkin-go one, in both cases after
+// the span ended and before anything leaves the process.
+package redact
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Action says what a rule does with what it matched.
+type Action string
+
+const (
+	// Mask replaces the value, or the matched part of it, with Masked.
+	Mask Action = "mask"
+	// Hash replaces the value, or the matched part of it, with a short
+	// sha256, so that equal values can still be told apart.
+	Hash Action = "hash"
+	// Drop removes the whole attribute.
+	Drop Action = "drop"
+)
+
+// Masked stands in for a masked value.
+const Masked = "[redacted]"
+
+// Rule selects attributes by Keys, parts of their values by Match, or both.
+type Rule struct {
+	Name string `yaml:"name"`
+	// Keys are case-insensitive globs, e.g. "*.value"; empty means every
+	// attribute, as well as span status and Zipkin annotations.
+	Keys []string `yaml:"keys,omitempty"`
+	// Match is a regular expression; empty means the whole value.
+	Match string `yaml:"match,omitempty"`
+	// Luhn only keeps the matches of Match whose digits pass the Luhn check,
+	// which tells card numbers from other long numbers.
+	Luhn   bool   `yaml:"luhn,omitempty"`
+	Action Action `yaml:"action"`
+}
+
+// Policy is the list of rules, applied in order.
+type Policy struct {
+	// Salt prefixes the values before they are hashed, which keeps short
+	// values from being guessed by hashing candidates.
+	Salt  string `yaml:"salt,omitempty"`
+	Rules []Rule `yaml:"rules"`
+}
+
+//go:embed default.yaml
+var defaultPolicy []byte
+
+// DefaultPolicy masks the values stored in the KV service and anything that
+// looks like a credential or a card number, and hashes email addresses. See
+// default.yaml.
+func DefaultPolicy() Policy {
+	p, err := ParsePolicy(defaultPolicy)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// ParsePolicy reads a Policy from YAML.
+func ParsePolicy(data []byte) (Policy, error) {
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// LoadPolicy reads a Policy from a YAML file.
+func LoadPolicy(file string) (Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Policy{}, err
+	}
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return p, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return p, nil
+}
+
+// Load makes a Redactor from the policy in file, or from DefaultPolicy if
+// file is empty.
+func Load(file string) (*Redactor, error) {
+	if file == "" {
+		return New(DefaultPolicy())
+	}
+	p, err := LoadPolicy(file)
+	if err != nil {
+		return nil, err
+	}
+	return New(p)
+}
+
+type rule struct {
+	keys   []string
+	match  *regexp.Regexp
+	luhn   bool
+	action Action
+}
+
+// Redactor applies a Policy. A nil *Redactor leaves every value as it is.
+type Redactor struct {
+	salt  string
+	rules []rule
+}
+
+// New checks p and compiles its rules.
+func New(p Policy) (*Redactor, error) {
+	r := &Redactor{salt: p.Salt}
+	for i, pr := range p.Rules {
+		name := pr.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		switch pr.Action {
+		case Mask, Hash, Drop:
+		default:
+			return nil, fmt.Errorf("rule %s: unknown action %q", name, pr.Action)
+		}
+		if len(pr.Keys) == 0 && pr.Match == "" {
+			return nil, fmt.Errorf("rule %s: keys or match is required", name)
+		}
+		ru := rule{luhn: pr.Luhn, action: pr.Action}
+		for _, key := range pr.Keys {
+			key = strings.ToLower(key)
+			if _, err := path.Match(key, ""); err != nil {
+				return nil, fmt.Errorf("rule %s: key %q: %w", name, key, err)
+			}
+			ru.keys = append(ru.keys, key)
+		}
+		if pr.Match != "" {
+			re, err := regexp.Compile(pr.Match)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", name, err)
+			}
+			ru.match = re
+		}
+		r.rules = append(r.rules, ru)
+	}
+	return r, nil
+}
+
+// Value returns what to export of the attribute key with value v, or false
+// if the attribute must be dropped.
+func (r *Redactor) Value(key, v string) (string, bool) {
+	return r.apply(strings.ToLower(key), true, v)
+}
+
+// Text returns what to export of free text such as a span status or an
+// annotation, or false if it must be dropped. Only the rules without keys
+// apply to it.
+func (r *Redactor) Text(v string) (string, bool) {
+	return r.apply("", false, v)
+}
+
+func (r *Redactor) apply(key string, keyed bool, v string) (string, bool) {
+	if r == nil {
+		return v, true
+	}
+	for _, ru := range r.rules {
+		if len(ru.keys) > 0 && (!keyed || !matchKey(ru.keys, key)) {
+			continue
+		}
+		if ru.match == nil {
+			// the rule takes the whole value, so later rules have nothing
+			// left to look at
+			if ru.action == Drop {
+				return "", false
+			}
+			return r.replace(ru.action, v), true
+		}
+		found := false
+		v = ru.match.ReplaceAllStringFunc(v, func(m string) string {
+			if ru.luhn && !luhn(m) {
+				return m
+			}
+			found = true
+			return r.replace(ru.action, m)
+		})
+		if found && ru.action == Drop {
+			return "", false
+		}
+	}
+	return v, true
+}
+
+func (r *Redactor) replace(action Action, v string) string {
+	if action == Hash {
+		sum := sha256.Sum256([]byte(r.salt + v))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	}
+	return Masked
+}
+
+func matchKey(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// luhn reports whether the digits in s pass the Luhn check.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
@@ -0,0 +1,52 @@
+package redact
+
+import (
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+)
+
+type zipkinReporter struct {
+	redactor *Redactor
+	next     reporter.Reporter
+}
+
+// Reporter sends the spans to next with their tags and annotations redacted
+// by r:
+//
+//	zipkin.NewTracer(redact.Reporter(r, httpreporter.NewReporter(url)), ...)
+func Reporter(r *Redactor, next reporter.Reporter) reporter.Reporter {
+	return zipkinReporter{redactor: r, next: next}
+}
+
+func (z zipkinReporter) Send(s model.SpanModel) {
+	if z.redactor == nil {
+		z.next.Send(s)
+		return
+	}
+	// the tracer may still hold the map of a span it sent, so the redacted
+	// tags go into a new one
+	if len(s.Tags) > 0 {
+		tags := make(map[string]string, len(s.Tags))
+		for key, v := range s.Tags {
+			if v, ok := z.redactor.Value(key, v); ok {
+				tags[key] = v
+			}
+		}
+		s.Tags = tags
+	}
+	if len(s.Annotations) > 0 {
+		annotations := make([]model.Annotation, 0, len(s.Annotations))
+		for _, a := range s.Annotations {
+			if v, ok := z.redactor.Text(a.Value); ok {
+				a.Value = v
+				annotations = append(annotations, a)
+			}
+		}
+		s.Annotations = annotations
+	}
+	z.next.Send(s)
+}
+
+func (z zipkinReporter) Close() error {
+	return z.next.Close()
+}
@@ -0,0 +1,46 @@
+package redact
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+)
+
+// No raw value of the tags or annotations of a span reaches the reporter, and
+// the span the tracer holds keeps its own tags.
+func TestReporter(t *testing.T) {
+	rec := recorder.NewReporter()
+	rep := Reporter(defaultRedactor(t), rec)
+	tags := map[string]string{
+		"redis.key":   "k",
+		"value":       raw["kv-value"],
+		"db.password": raw["credential"],
+		"error":       "token " + raw["jwt"],
+	}
+	rep.Send(model.SpanModel{
+		Name: "put",
+		Tags: tags,
+		Annotations: []model.Annotation{
+			{Timestamp: time.Now(), Value: "sent " + raw["bearer"]},
+			{Timestamp: time.Now(), Value: "charged " + raw["card"]},
+			{Timestamp: time.Now(), Value: "mailed " + raw["email"]},
+		},
+	})
+
+	spans := rec.Flush()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if found := leaked(fmt.Sprintf("%+v", spans[0])); len(found) > 0 {
+		t.Fatalf("reported span holds raw values %q", found)
+	}
+	if spans[0].Tags["redis.key"] != "k" || len(spans[0].Annotations) != 3 {
+		t.Errorf("span = %+v, want redis.key and the annotations kept", spans[0])
+	}
+	if tags["value"] != raw["kv-value"] {
+		t.Error("Send changed the tags of the span it was given")
+	}
+}
@@ -0,0 +1,149 @@
+package redisclient
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute names of the statistics of the pool on spans.
+const (
+	AttrExhausted  = "redis.pool.exhausted"
+	AttrHits       = "redis.pool.hits"
+	AttrMisses     = "redis.pool.misses"
+	AttrTimeouts   = "redis.pool.timeouts"
+	AttrIdleConns  = "redis.pool.idle_conns"
+	AttrTotalConns = "redis.pool.total_conns"
+)
+
+// errPoolTimeout is the message of the error of a command that waited for a
+// connection in vain, which go-redis does not export.
+const errPoolTimeout = "redis: connection pool timeout"
+
+// Report records s, the statistics of an exhausted pool, on the span in ctx.
+type Report func(ctx context.Context, s *redis.PoolStats)
+
+// OTel is a Report that sets the attributes of s and AttrExhausted on the
+// OpenTelemetry span in ctx.
+func OTel(ctx context.Context, s *redis.PoolStats) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Bool(AttrExhausted, true),
+		attribute.Int64(AttrHits, int64(s.Hits)),
+		attribute.Int64(AttrMisses, int64(s.Misses)),
+		attribute.Int64(AttrTimeouts, int64(s.Timeouts)),
+		attribute.Int64(AttrIdleConns, int64(s.IdleConns)),
+		attribute.Int64(AttrTotalConns, int64(s.TotalConns)),
+	)
+}
+
+// Zipkin is a Report that tags the zipkin-go span in ctx with s and
+// AttrExhausted.
+func Zipkin(ctx context.Context, s *redis.PoolStats) {
+	if span := zipkin.SpanFromContext(ctx); span != nil {
+		span.Tag(AttrExhausted, "true")
+		span.Tag(AttrHits, strconv.FormatUint(uint64(s.Hits), 10))
+		span.Tag(AttrMisses, strconv.FormatUint(uint64(s.Misses), 10))
+		span.Tag(AttrTimeouts, strconv.FormatUint(uint64(s.Timeouts), 10))
+		span.Tag(AttrIdleConns, strconv.FormatUint(uint64(s.IdleConns), 10))
+		span.Tag(AttrTotalConns, strconv.FormatUint(uint64(s.TotalConns), 10))
+	}
+}
+
+// instrument exports the statistics of the pool of c and reports them on the
+// commands that found it exhausted: those that started while all of the
+// poolSize connections were in use, unless poolSize is 0, and those that
+// waited for one in vain. Metrics go to mp, the global MeterProvider if nil.
+func instrument(c redis.UniversalClient, poolSize int, report Report, mp metric.MeterProvider) {
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter("go-service-tracing/internal/redisclient")
+	hits, _ := meter.Int64ObservableCounter("redis.pool.hits",
+		metric.WithDescription("Commands that found an idle connection in the pool"))
+	misses, _ := meter.Int64ObservableCounter("redis.pool.misses",
+		metric.WithDescription("Commands that found no idle connection in the pool"))
+	timeouts, _ := meter.Int64ObservableCounter("redis.pool.timeouts",
+		metric.WithDescription("Commands that waited for a connection in vain"))
+	idle, _ := meter.Int64ObservableGauge("redis.pool.idle_conns",
+		metric.WithDescription("Idle connections in the pool"))
+	total, _ := meter.Int64ObservableGauge("redis.pool.total_conns",
+		metric.WithDescription("Connections in the pool, idle or in use"))
+	meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		s := c.PoolStats()
+		o.ObserveInt64(hits, int64(s.Hits))
+		o.ObserveInt64(misses, int64(s.Misses))
+		o.ObserveInt64(timeouts, int64(s.Timeouts))
+		o.ObserveInt64(idle, int64(s.IdleConns))
+		o.ObserveInt64(total, int64(s.TotalConns))
+		return nil
+	}, hits, misses, timeouts, idle, total)
+
+	exhausted, _ := meter.Int64Counter("redis.pool.exhausted",
+		metric.WithDescription("Commands, or pipelines, that found the pool exhausted"))
+	c.AddHook(poolHook{client: c, poolSize: poolSize, report: report, exhausted: exhausted})
+}
+
+type poolHook struct {
+	client   redis.UniversalClient
+	poolSize int
+	report   Report
+
+	exhausted metric.Int64Counter
+}
+
+func (h poolHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h poolHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		stats := h.full()
+		err := next(ctx, cmd)
+		h.observe(ctx, stats, err)
+		return err
+	}
+}
+
+func (h poolHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		stats := h.full()
+		err := next(ctx, cmds)
+		h.observe(ctx, stats, err)
+		return err
+	}
+}
+
+// full returns the statistics of the pool if every connection of it is in
+// use, or else nil.
+func (h poolHook) full() *redis.PoolStats {
+	if h.poolSize == 0 {
+		return nil
+	}
+	s := h.client.PoolStats()
+	if s.IdleConns > 0 || int(s.TotalConns) < h.poolSize {
+		return nil
+	}
+	return s
+}
+
+// observe reports a command that started with the pool full, with the
+// statistics of that moment, or that waited for a connection in vain.
+func (h poolHook) observe(ctx context.Context, full *redis.PoolStats, err error) {
+	stats := full
+	if stats == nil {
+		if err == nil || err.Error() != errPoolTimeout {
+			return
+		}
+		stats = h.client.PoolStats()
+	}
+	h.exhausted.Add(ctx, 1)
+	if h.report != nil {
+		h.report(ctx, stats)
+	}
+}
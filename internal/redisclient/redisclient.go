@@ -0,0 +1,158 @@
+// Package redisclient creates the Redis clients of the examples: one of a
+// single server, of the master that Sentinels watch or of a Cluster, with its
+// connection pool sized and timed by Config.
+//
+// A service waits a while for Redis to answer rather than exit when it does
+// not, and the pool reports its statistics as metrics, and on the spans of
+// the commands that found it exhausted.
+package redisclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Modes of deployment.
+const (
+	ModeSingle   = "single"
+	ModeSentinel = "sentinel"
+	ModeCluster  = "cluster"
+)
+
+// DefaultAddr is the address of the single server unless the services name
+// another.
+const DefaultAddr = "localhost:6379"
+
+// DefaultWait is how long a service waits for Redis to answer when it starts.
+const DefaultWait = 30 * time.Second
+
+// Config configures a client.
+type Config struct {
+	// Mode is ModeSingle, ModeSentinel or ModeCluster.
+	Mode string
+	// Addrs are the address of the server, those of the Sentinels or those
+	// of some nodes of the Cluster, from which it learns the others.
+	Addrs []string
+	// MasterName is the master the Sentinels watch.
+	MasterName string
+
+	// PoolSize is the most connections to each node, 0 for 10 per CPU.
+	PoolSize int
+	// MinIdleConns is how many idle connections to each node stay open.
+	MinIdleConns int
+	// PoolTimeout is how long a command waits for a connection when the pool
+	// is exhausted, 0 for ReadTimeout and another second.
+	PoolTimeout time.Duration
+	// DialTimeout, ReadTimeout and WriteTimeout bound connecting, reading
+	// and writing, 0 for the defaults of go-redis: 5s, 3s and ReadTimeout.
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// ContextTimeoutEnabled lets the deadline of the context of a command
+	// bound its reads and writes as well.
+	ContextTimeoutEnabled bool
+
+	TLSConfig *tls.Config
+	// Report records the statistics of the pool on the spans of the
+	// commands that found it exhausted; nil records nothing.
+	Report Report
+	// MeterProvider receives the metrics of the pool, the global one if
+	// nil.
+	MeterProvider metric.MeterProvider
+}
+
+// ParseAddrs splits a comma separated list of addresses.
+func ParseAddrs(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// New returns a client configured by cfg, which does not connect before its
+// first command. Metrics of its pool go to the global MeterProvider.
+func New(cfg Config) (redis.UniversalClient, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, errors.New("no Redis address")
+	}
+	poolSize := cfg.PoolSize
+	if poolSize <= 0 {
+		poolSize = 10 * runtime.GOMAXPROCS(0)
+	}
+	opts := &redis.UniversalOptions{
+		Addrs:                 cfg.Addrs,
+		MasterName:            cfg.MasterName,
+		PoolSize:              poolSize,
+		MinIdleConns:          cfg.MinIdleConns,
+		PoolTimeout:           cfg.PoolTimeout,
+		DialTimeout:           cfg.DialTimeout,
+		ReadTimeout:           cfg.ReadTimeout,
+		WriteTimeout:          cfg.WriteTimeout,
+		ContextTimeoutEnabled: cfg.ContextTimeoutEnabled,
+		TLSConfig:             cfg.TLSConfig,
+	}
+
+	var c redis.UniversalClient
+	switch cfg.Mode {
+	case ModeSingle, "":
+		if len(cfg.Addrs) > 1 {
+			return nil, fmt.Errorf("one Redis address expected in mode %s, got %d", ModeSingle, len(cfg.Addrs))
+		}
+		c = redis.NewClient(opts.Simple())
+	case ModeSentinel:
+		if cfg.MasterName == "" {
+			return nil, fmt.Errorf("no master name in mode %s", ModeSentinel)
+		}
+		c = redis.NewFailoverClient(opts.Failover())
+	case ModeCluster:
+		// the statistics add up the pools of all nodes, which are not
+		// known in advance, so a full pool shows by its timeouts only
+		poolSize = 0
+		c = redis.NewClusterClient(opts.Cluster())
+	default:
+		return nil, fmt.Errorf("unknown Redis mode %q", cfg.Mode)
+	}
+
+	instrument(c, poolSize, cfg.Report, cfg.MeterProvider)
+	return c, nil
+}
+
+// Wait pings c until it answers or wait has passed, backing off from 100ms to
+// 5s between attempts, and returns the last error. Every attempt is bounded
+// by 5s.
+func Wait(ctx context.Context, c redis.UniversalClient, wait time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	backoff := 100 * time.Millisecond
+	for {
+		pingCtx, cancelPing := context.WithTimeout(ctx, 5*time.Second)
+		err := c.Ping(pingCtx).Err()
+		cancelPing()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		log.Printf("redis ping error, retrying in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 5*time.Second)
+	}
+}
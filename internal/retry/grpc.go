@@ -0,0 +1,121 @@
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// UnaryClientInterceptor retries unary calls as p allows. Put it ahead of
+// interceptors that should run once per attempt.
+func UnaryClientInterceptor(p Policy, t Tracing) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return p.run(ctx, t, method, Idempotent(method), func(ctx context.Context) (bool, error) {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			return retryable(ctx, err) && slices.Contains(p.Codes, status.Code(err)), err
+		})
+	}
+}
+
+// Idempotent reports whether the proto of a full method name, e.g.
+// /storage.v1.Storage/Get, marks it safe to repeat.
+func Idempotent(fullMethod string) bool {
+	name := strings.ReplaceAll(strings.TrimPrefix(fullMethod, "/"), "/", ".")
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return false
+	}
+	m, ok := d.(protoreflect.MethodDescriptor)
+	return ok && idempotent(m)
+}
+
+func idempotent(m protoreflect.MethodDescriptor) bool {
+	opts, ok := m.Options().(*descriptorpb.MethodOptions)
+	return ok && opts.GetIdempotencyLevel() != descriptorpb.MethodOptions_IDEMPOTENCY_UNKNOWN
+}
+
+// ServiceConfig returns a gRPC service config that has grpc-go retry the
+// unary methods of service itself, as an alternative to
+// UnaryClientInterceptor. grpc-go caps the attempts at five and randomizes
+// every pause fully; each attempt still gets a client span of its own from
+// the stats handler, but neither attempt numbers nor an outcome are recorded.
+//
+// A policy that makes no retries, with one attempt or no Codes, gives an
+// empty config, as grpc-go would drop its retry policy with no more than a
+// warning. So would it one without a positive backoff, which is an error.
+func ServiceConfig(p Policy, service protoreflect.ServiceDescriptor) (string, error) {
+	if p.MaxAttempts <= 1 || len(p.Codes) == 0 {
+		return "{}", nil
+	}
+	if p.InitialBackoff <= 0 || p.MaxBackoff <= 0 || p.Multiplier <= 0 {
+		return "", fmt.Errorf("a service config needs a positive backoff and multiplier, not %v up to %v times %v",
+			p.InitialBackoff, p.MaxBackoff, p.Multiplier)
+	}
+	type methodName struct {
+		Service string `json:"service"`
+		Method  string `json:"method"`
+	}
+	var names []methodName
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		m := methods.Get(i)
+		if m.IsStreamingClient() || m.IsStreamingServer() {
+			continue
+		}
+		if idempotent(m) || p.NonIdempotent {
+			names = append(names, methodName{Service: string(service.FullName()), Method: string(m.Name())})
+		}
+	}
+
+	retryable := make([]string, len(p.Codes))
+	for i, c := range p.Codes {
+		retryable[i] = codeName(c)
+	}
+	config := map[string]any{
+		"methodConfig": []map[string]any{{
+			"name": names,
+			"retryPolicy": map[string]any{
+				"maxAttempts":          p.MaxAttempts,
+				"initialBackoff":       seconds(p.InitialBackoff.Seconds()),
+				"maxBackoff":           seconds(p.MaxBackoff.Seconds()),
+				"backoffMultiplier":    p.Multiplier,
+				"retryableStatusCodes": retryable,
+			},
+		}},
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func seconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', -1, 64) + "s"
+}
+
+// codeName spells c the way service configs do, e.g. RESOURCE_EXHAUSTED.
+func codeName(c codes.Code) string {
+	if c == codes.OK {
+		return "OK"
+	}
+	var b strings.Builder
+	for i, r := range c.String() {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodDelete:  true,
+}
+
+// statusError fails an attempt that got a response with a status worth
+// retrying.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return "HTTP " + strconv.Itoa(e.code)
+}
+
+// Do sends req with send as p allows and returns the last response. The body
+// is replayed through req.GetBody, which http.NewRequest sets for in-memory
+// bodies; a request without it is sent once. PUT and POST are not safe to
+// repeat here, as every write bumps the version of its key.
+func Do(p Policy, t Tracing, req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		p.MaxAttempts = 1
+	}
+
+	var resp *http.Response
+	err := p.run(req.Context(), t, req.Method, idempotentMethods[req.Method], func(ctx context.Context) (bool, error) {
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			resp = nil
+		}
+
+		attempt := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return false, err
+			}
+			attempt.Body = body
+		}
+
+		var err error
+		resp, err = send(attempt)
+		if err != nil {
+			return retryable(ctx, err), err
+		}
+		if slices.Contains(p.StatusCodes, resp.StatusCode) {
+			return true, &statusError{code: resp.StatusCode}
+		}
+		return false, nil
+	})
+
+	// a retryable status that was not retried is still a response to relay
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return resp, nil
+	}
+	return resp, err
+}
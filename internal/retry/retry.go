@@ -0,0 +1,147 @@
+// Package retry repeats calls from service1 to service2 that failed for a
+// reason that may go away, such as service2 restarting.
+//
+// Only calls that are safe to repeat are retried unless a Policy allows
+// others: gRPC methods whose proto marks them NO_SIDE_EFFECTS or IDEMPOTENT,
+// and GET, HEAD, OPTIONS and DELETE requests. Every attempt gets a span of its
+// own beneath the caller's span, which records how the call ended.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"go-service-tracing/internal/breaker"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Attribute names of attempt spans and of the caller's span.
+const (
+	AttrAttempt   = "retry.attempt"
+	AttrOperation = "retry.operation"
+	AttrAttempts  = "retry.attempts"
+	AttrOutcome   = "retry.outcome"
+)
+
+// Outcomes of a call, recorded as AttrOutcome.
+const (
+	// OutcomeOK is a call whose last attempt succeeded.
+	OutcomeOK = "ok"
+	// OutcomeFailed is a call that failed for a reason not worth retrying.
+	OutcomeFailed = "failed"
+	// OutcomeNotIdempotent is a call that could have been retried, had it
+	// been safe to repeat.
+	OutcomeNotIdempotent = "not_idempotent"
+	// OutcomeExhausted is a call that failed on every attempt.
+	OutcomeExhausted = "exhausted"
+	// OutcomeDeadline is a call whose deadline left no time for another
+	// attempt.
+	OutcomeDeadline = "deadline"
+)
+
+// Policy decides whether and when a failed call is repeated.
+type Policy struct {
+	// MaxAttempts counts the first attempt too, so 1 disables retries.
+	MaxAttempts int
+	// InitialBackoff is the pause before the second attempt. It grows by
+	// Multiplier per attempt up to MaxBackoff, and every pause is spread
+	// randomly over ±Jitter of its length.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+	// Codes are the gRPC codes worth retrying.
+	Codes []codes.Code
+	// StatusCodes are the HTTP statuses worth retrying. Transport errors are
+	// always retried.
+	StatusCodes []int
+	// NonIdempotent allows retrying calls that are not safe to repeat, such
+	// as Put, which bumps the version of its key on every attempt.
+	NonIdempotent bool
+}
+
+// DefaultPolicy makes up to three attempts, 50ms and then 100ms apart, when
+// service2 is unavailable.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:    3,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		Codes:          []codes.Code{codes.Unavailable},
+		StatusCodes:    []int{502, 503},
+	}
+}
+
+// Backoff returns the pause after attempt n, counting from 1.
+func (p Policy) Backoff(n int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(n-1))
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	d += d * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(d)
+}
+
+// run makes attempts until one succeeds or p gives up. attempt reports
+// whether its error is worth retrying.
+func (p Policy) run(ctx context.Context, t Tracing, op string, idempotent bool, attempt func(ctx context.Context) (bool, error)) error {
+	for n := 1; ; n++ {
+		attemptCtx, end := t.Attempt(ctx, op, n)
+		retryable, err := attempt(attemptCtx)
+		end(err)
+
+		outcome := p.outcome(n, err, retryable, idempotent)
+		if outcome == "" {
+			if sleep(ctx, p.Backoff(n)) {
+				continue
+			}
+			outcome = OutcomeDeadline
+		}
+		t.Outcome(ctx, n, outcome)
+		return err
+	}
+}
+
+// outcome returns how the call ends after attempt n, or "" if it goes on.
+func (p Policy) outcome(n int, err error, retryable, idempotent bool) string {
+	switch {
+	case err == nil:
+		return OutcomeOK
+	case !retryable:
+		return OutcomeFailed
+	case !idempotent && !p.NonIdempotent:
+		return OutcomeNotIdempotent
+	case n >= p.MaxAttempts:
+		return OutcomeExhausted
+	}
+	return ""
+}
+
+// retryable rules out errors that another attempt cannot fix: the deadline
+// has passed, or a circuit breaker turned the attempt away before it reached
+// the network.
+func retryable(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && !errors.Is(err, breaker.ErrRejected)
+}
+
+// sleep pauses for d and reports whether there is time for another attempt
+// afterwards. It does not wait for a deadline that comes first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= d {
+		return false
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,251 @@
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/breaker"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// recorder is a Tracing that keeps the outcome of the last call.
+type recorder struct {
+	attempts int
+	outcome  string
+}
+
+func (r *recorder) Attempt(ctx context.Context, op string, n int) (context.Context, func(error)) {
+	return ctx, func(error) {}
+}
+
+func (r *recorder) Outcome(ctx context.Context, attempts int, outcome string) {
+	r.attempts, r.outcome = attempts, outcome
+}
+
+// policy retries Unavailable and 503 three times with hardly a pause.
+func policy() Policy {
+	p := DefaultPolicy()
+	p.InitialBackoff = time.Millisecond
+	p.MaxBackoff = 2 * time.Millisecond
+	return p
+}
+
+func TestBackoff(t *testing.T) {
+	p := Policy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.2}
+	for _, tc := range []struct {
+		n        int
+		min, max time.Duration
+	}{
+		{1, 80 * time.Millisecond, 120 * time.Millisecond},
+		{2, 160 * time.Millisecond, 240 * time.Millisecond},
+		{4, 640 * time.Millisecond, 960 * time.Millisecond},
+		// 1.6s is capped at MaxBackoff before the jitter
+		{5, 800 * time.Millisecond, 1200 * time.Millisecond},
+	} {
+		for range 100 {
+			if d := p.Backoff(tc.n); d < tc.min || d > tc.max {
+				t.Fatalf("Backoff(%d) = %v, want within [%v, %v]", tc.n, d, tc.min, tc.max)
+			}
+		}
+	}
+	p.Jitter = 0
+	if d := p.Backoff(3); d != 400*time.Millisecond {
+		t.Errorf("Backoff(3) without jitter = %v, want 400ms", d)
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	for method, want := range map[string]bool{
+		"/storage.v1.Storage/Get":    true,
+		"/storage.v1.Storage/Delete": true,
+		"/storage.v1.Storage/List":   true,
+		"/storage.v1.Storage/Put":    false,
+		"/storage.v1.Storage/Nope":   false,
+		"/grpc.health.v1.Health/Foo": false,
+	} {
+		if got := Idempotent(method); got != want {
+			t.Errorf("Idempotent(%s) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		method        string
+		nonIdempotent bool
+		errs          []error
+		attempts      int
+		outcome       string
+	}{
+		{"ok", "/storage.v1.Storage/Get", false, nil, 1, OutcomeOK},
+		{"retried", "/storage.v1.Storage/Get", false, []error{status.Error(codes.Unavailable, "down")}, 2, OutcomeOK},
+		{"exhausted", "/storage.v1.Storage/Delete", false, []error{
+			status.Error(codes.Unavailable, "down"), status.Error(codes.Unavailable, "down"), status.Error(codes.Unavailable, "down"),
+		}, 3, OutcomeExhausted},
+		{"not retryable", "/storage.v1.Storage/Get", false, []error{status.Error(codes.NotFound, "no key")}, 1, OutcomeFailed},
+		{"rejected by the breaker", "/storage.v1.Storage/Get", false, []error{breaker.ErrRejected}, 1, OutcomeFailed},
+		{"not idempotent", "/storage.v1.Storage/Put", false, []error{status.Error(codes.Unavailable, "down")}, 1, OutcomeNotIdempotent},
+		{"not idempotent, allowed", "/storage.v1.Storage/Put", true, []error{status.Error(codes.Unavailable, "down")}, 2, OutcomeOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := policy()
+			p.NonIdempotent = tc.nonIdempotent
+			var r recorder
+			calls := 0
+			err := UnaryClientInterceptor(p, &r)(context.Background(), tc.method, nil, nil, nil,
+				func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+					calls++
+					if calls <= len(tc.errs) {
+						return tc.errs[calls-1]
+					}
+					return nil
+				})
+			if calls != tc.attempts || r.attempts != tc.attempts || r.outcome != tc.outcome {
+				t.Errorf("%d calls, recorded %d attempts and %q, want %d and %q", calls, r.attempts, r.outcome, tc.attempts, tc.outcome)
+			}
+			if tc.outcome == OutcomeOK && err != nil || tc.outcome != OutcomeOK && err == nil {
+				t.Errorf("error %v after outcome %q", err, tc.outcome)
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		method   string
+		body     func() io.Reader
+		statuses []int
+		attempts int
+		outcome  string
+		status   int
+	}{
+		{"retried", http.MethodGet, nil, []int{503, 502}, 3, OutcomeOK, 200},
+		{"body replayed", http.MethodDelete, func() io.Reader { return strings.NewReader("body") }, []int{503}, 2, OutcomeOK, 200},
+		// the final retryable status is relayed rather than failing the call
+		{"exhausted", http.MethodGet, nil, []int{503, 503, 503}, 3, OutcomeExhausted, 503},
+		{"not retryable", http.MethodGet, nil, []int{500}, 1, OutcomeOK, 500},
+		{"not idempotent", http.MethodPut, func() io.Reader { return strings.NewReader("body") }, []int{503}, 1, OutcomeNotIdempotent, 503},
+		// a body that cannot be read again is sent once
+		{"body not replayable", http.MethodDelete, func() io.Reader { return io.MultiReader(strings.NewReader("body")) }, []int{503}, 1, OutcomeExhausted, 503},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var body io.Reader
+			if tc.body != nil {
+				body = tc.body()
+			}
+			req, err := http.NewRequest(tc.method, "http://service2/v1/kv/k", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var r recorder
+			calls := 0
+			resp, err := Do(policy(), &r, req, func(req *http.Request) (*http.Response, error) {
+				calls++
+				if tc.body != nil {
+					if b, _ := io.ReadAll(req.Body); string(b) != "body" {
+						t.Errorf("attempt %d sent body %q", calls, b)
+					}
+				}
+				code := 200
+				if calls <= len(tc.statuses) {
+					code = tc.statuses[calls-1]
+				}
+				return &http.Response{StatusCode: code, Body: io.NopCloser(strings.NewReader(""))}, nil
+			})
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			if resp.StatusCode != tc.status || calls != tc.attempts || r.outcome != tc.outcome {
+				t.Errorf("status %d after %d calls, %q, want %d after %d, %q",
+					resp.StatusCode, calls, r.outcome, tc.status, tc.attempts, tc.outcome)
+			}
+		})
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	p := DefaultPolicy()
+	p.InitialBackoff = 100 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var r recorder
+	calls := 0
+	began := time.Now()
+	err := UnaryClientInterceptor(p, &r)(ctx, "/storage.v1.Storage/Get", nil, nil, nil,
+		func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+			calls++
+			return status.Error(codes.Unavailable, "down")
+		})
+	if status.Code(err) != codes.Unavailable || calls != 1 || r.outcome != OutcomeDeadline {
+		t.Errorf("%v after %d calls, %q, want Unavailable after 1, %q", err, calls, r.outcome, OutcomeDeadline)
+	}
+	// the pause that would outlast the deadline is not waited for
+	if d := time.Since(began); d > 50*time.Millisecond {
+		t.Errorf("gave up after %v", d)
+	}
+}
+
+func TestServiceConfig(t *testing.T) {
+	service := storagev1.File_storage_v1_storage_proto.Services().ByName("Storage")
+	config, err := ServiceConfig(DefaultPolicy(), service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		MethodConfig []struct {
+			Name        []struct{ Method string }
+			RetryPolicy struct {
+				MaxAttempts          int
+				RetryableStatusCodes []string
+			}
+		}
+	}
+	if err := json.Unmarshal([]byte(config), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient("passthrough:///service2",
+		grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultServiceConfig(config))
+	if err != nil {
+		t.Fatalf("grpc-go rejects the config: %v", err)
+	}
+	conn.Close()
+	mc := parsed.MethodConfig[0]
+	var methods []string
+	for _, n := range mc.Name {
+		methods = append(methods, n.Method)
+	}
+	// Put is not idempotent, and the streams are left out
+	if strings.Join(methods, ",") != "Get,Delete,Exists,List" {
+		t.Errorf("methods %q", methods)
+	}
+	if mc.RetryPolicy.MaxAttempts != 3 || strings.Join(mc.RetryPolicy.RetryableStatusCodes, ",") != "UNAVAILABLE" {
+		t.Errorf("retry policy %+v", mc.RetryPolicy)
+	}
+
+	for name, p := range map[string]Policy{
+		"one attempt": {MaxAttempts: 1, Codes: []codes.Code{codes.Unavailable}},
+		"no codes":    {MaxAttempts: 3},
+	} {
+		if config, err := ServiceConfig(p, service); err != nil || config != "{}" {
+			t.Errorf("%s: %q, %v, want an empty config", name, config, err)
+		}
+	}
+	p := DefaultPolicy()
+	p.InitialBackoff = 0
+	if _, err := ServiceConfig(p, service); err == nil {
+		t.Error("ServiceConfig without a backoff succeeded")
+	}
+}
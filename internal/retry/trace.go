@@ -0,0 +1,85 @@
+package retry
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/openzipkin/zipkin-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing records attempts with the tracer of a service. Calls made outside a
+// trace, such as health checks, are not recorded.
+type Tracing interface {
+	// Attempt starts the span of attempt n of op beneath the span in ctx;
+	// end finishes it with the error of the attempt.
+	Attempt(ctx context.Context, op string, n int) (attemptCtx context.Context, end func(err error))
+	// Outcome records on the span in ctx how many attempts a call took and
+	// how it ended.
+	Outcome(ctx context.Context, attempts int, outcome string)
+}
+
+type otelTracing struct {
+	tracer trace.Tracer
+}
+
+// OTel records attempts as OpenTelemetry spans of tracer.
+func OTel(tracer trace.Tracer) Tracing {
+	return otelTracing{tracer: tracer}
+}
+
+func (t otelTracing) Attempt(ctx context.Context, op string, n int) (context.Context, func(error)) {
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return ctx, func(error) {}
+	}
+	ctx, span := t.tracer.Start(ctx, "retry.attempt", trace.WithAttributes(
+		attribute.Int(AttrAttempt, n),
+		attribute.String(AttrOperation, op),
+	))
+	return ctx, func(err error) {
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func (t otelTracing) Outcome(ctx context.Context, attempts int, outcome string) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int(AttrAttempts, attempts),
+		attribute.String(AttrOutcome, outcome),
+	)
+}
+
+type zipkinTracing struct {
+	tracer *zipkin.Tracer
+}
+
+// Zipkin records attempts as spans of tracer.
+func Zipkin(tracer *zipkin.Tracer) Tracing {
+	return zipkinTracing{tracer: tracer}
+}
+
+func (t zipkinTracing) Attempt(ctx context.Context, op string, n int) (context.Context, func(error)) {
+	if zipkin.SpanFromContext(ctx) == nil {
+		return ctx, func(error) {}
+	}
+	span, ctx := t.tracer.StartSpanFromContext(ctx, "retry.attempt")
+	span.Tag(AttrAttempt, strconv.Itoa(n))
+	span.Tag(AttrOperation, op)
+	return ctx, func(err error) {
+		if err != nil {
+			zipkin.TagError.Set(span, err.Error())
+		}
+		span.Finish()
+	}
+}
+
+func (t zipkinTracing) Outcome(ctx context.Context, attempts int, outcome string) {
+	if span := zipkin.SpanFromContext(ctx); span != nil {
+		span.Tag(AttrAttempts, strconv.Itoa(attempts))
+		span.Tag(AttrOutcome, outcome)
+	}
+}
@@ -0,0 +1,141 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go-service-tracing/internal/auth"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// QuotaError is the status of a put beyond the quota of its tenant:
+// ResourceExhausted with a QuotaFailure detail, which tells the circuit
+// breakers of the callers that the tenant ran out of keys rather than the
+// service out of capacity.
+func QuotaError(err error) error {
+	st := status.New(codes.ResourceExhausted, err.Error())
+	detailed, derr := st.WithDetails(&errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{{
+			Subject:     "tenant",
+			Description: "number of keys",
+		}},
+	})
+	if derr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// fromMetadata returns the first value of name in the metadata of ctx.
+func fromMetadata(ctx context.Context, name string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(name); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// withTenant returns ctx with the tenant of the call, failing with
+// PermissionDenied and InvalidArgument as Gin answers 403 and 400.
+func withTenant(ctx context.Context, fullMethod string, required bool, t Tracing) (context.Context, error) {
+	if strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/") {
+		return ctx, nil
+	}
+	id, err := resolve(ctx, fromMetadata(ctx, Header), required)
+	if err != nil {
+		t.Rejected(ctx, err)
+		if errors.Is(err, ErrForbidden) {
+			return ctx, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return ctx, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if id != "" {
+		t.Tenant(ctx, id)
+	}
+	return NewContext(ctx, id), nil
+}
+
+// UnaryServerInterceptor takes the tenant of every call as Gin does, from
+// the x-tenant-id metadata if it was not authenticated. It goes after the
+// interceptors that authenticate the call.
+func UnaryServerInterceptor(required bool, t Tracing) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := withTenant(ctx, info.FullMethod, required, t)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func StreamServerInterceptor(required bool, t Tracing) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := withTenant(ss.Context(), info.FullMethod, required, t)
+		if err != nil {
+			return err
+		}
+		return handler(srv, serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// withBaggage returns ctx with the tenant of the caller's baggage, failing
+// with PermissionDenied and InvalidArgument as FromBaggage answers 403 and
+// 400.
+func withBaggage(ctx context.Context, t Tracing) (context.Context, error) {
+	header := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		header = strings.Join(md.Get("baggage"), ",")
+	}
+	ctx, err := fromBaggage(ctx, header, auth.CertifiedPeer(ctx))
+	if err != nil {
+		t.Rejected(ctx, err)
+		if errors.Is(err, ErrForbidden) {
+			return ctx, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return ctx, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if id, ok := FromContext(ctx); ok {
+		t.Tenant(ctx, id)
+	}
+	return ctx, nil
+}
+
+// BaggageUnaryServerInterceptor takes the tenant that the caller passed on in
+// its baggage, for the services behind service1.
+func BaggageUnaryServerInterceptor(t Tracing) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := withBaggage(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// BaggageStreamServerInterceptor is BaggageUnaryServerInterceptor for
+// streams.
+func BaggageStreamServerInterceptor(t Tracing) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := withBaggage(ss.Context(), t)
+		if err != nil {
+			return err
+		}
+		return handler(srv, serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,73 @@
+package tenant
+
+import (
+	"errors"
+	"net/http"
+
+	"go-service-tracing/internal/auth"
+	"go-service-tracing/internal/readiness"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gin takes the tenant of every request but the probes from its principal,
+// or from the X-Tenant-Id header if it was not authenticated. It answers 403
+// to those whose header names another tenant than the principal, and 400 to
+// those with an invalid tenant, or none if required.
+func Gin(required bool, t Tracing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if readiness.IsProbe(c.Request) {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		id, err := resolve(ctx, c.GetHeader(Header), required)
+		if err != nil {
+			t.Rejected(ctx, err)
+			code := http.StatusBadRequest
+			if errors.Is(err, ErrForbidden) {
+				code = http.StatusForbidden
+			}
+			c.AbortWithStatusJSON(code, gin.H{"message": err.Error()})
+			return
+		}
+		if id != "" {
+			t.Tenant(ctx, id)
+		}
+		c.Request = c.Request.WithContext(NewContext(ctx, id))
+		c.Next()
+	}
+}
+
+// FromBaggage takes the tenant that the caller passed on in its baggage, for
+// the services behind service1. It answers 403 to callers without a client
+// certificate that name a tenant, and 400 to those with an invalid one.
+func FromBaggage(t Tracing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, err := fromBaggage(c.Request.Context(), c.GetHeader("baggage"), auth.Certified(c.Request))
+		if err != nil {
+			t.Rejected(ctx, err)
+			code := http.StatusBadRequest
+			if errors.Is(err, ErrForbidden) {
+				code = http.StatusForbidden
+			}
+			c.AbortWithStatusJSON(code, gin.H{"message": err.Error()})
+			return
+		}
+		if id, ok := FromContext(ctx); ok {
+			t.Tenant(ctx, id)
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+func check(id string, required bool) error {
+	if id == "" {
+		if required {
+			return ErrMissing
+		}
+		return nil
+	}
+	return Validate(id)
+}
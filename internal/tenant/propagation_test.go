@@ -0,0 +1,156 @@
+package tenant
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"go-service-tracing/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// service1 takes the tenant of the principal and calls through to service2
+// with call, returning the prefix that service2 saw.
+func service1(t *testing.T, principal auth.Principal, call func(ctx context.Context) string) string {
+	t.Helper()
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), principal))
+	}, Gin(false, OTel))
+	r.GET("/v1/kv/k", func(c *gin.Context) {
+		c.String(http.StatusOK, call(c.Request.Context()))
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/kv/k", nil))
+	return w.Body.String()
+}
+
+// certifiedPeer is the TLS state of a caller that presented a client
+// certificate, which tlsconfig verified.
+var certifiedPeer = tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+
+// The tenant of service1 reaches service2 in the baggage header of HTTP, if
+// service1 presented a client certificate.
+func TestHTTPBaggage(t *testing.T) {
+	r := gin.New()
+	r.Use(FromBaggage(OTel))
+	r.GET("/v1/kv/k", func(c *gin.Context) {
+		c.String(http.StatusOK, Prefix(c.Request.Context()))
+	})
+	client := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport, otelhttp.WithPropagators(propagator))}
+
+	for _, tt := range []struct {
+		principal auth.Principal
+		certified bool
+		want      string
+	}{
+		{auth.Principal{ID: "alice", Tenant: "acme"}, true, "200 {acme}:"},
+		{auth.Principal{ID: "bob"}, true, "200 {_}:"},
+		{auth.Principal{ID: "alice", Tenant: "acme"}, false, "403"},
+		{auth.Principal{ID: "bob"}, false, "200 {_}:"},
+	} {
+		service2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if tt.certified {
+				req.TLS = &certifiedPeer
+			}
+			r.ServeHTTP(w, req)
+		}))
+		got := service1(t, tt.principal, func(ctx context.Context) string {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, service2.URL+"/v1/kv/k", nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return strconv.Itoa(resp.StatusCode)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			return "200 " + string(body)
+		})
+		service2.Close()
+		if got != tt.want {
+			t.Errorf("%s, certified %v: service2 answered %q, want %q", tt.principal.ID, tt.certified, got, tt.want)
+		}
+	}
+}
+
+// The tenant of service1 reaches service2 in the baggage metadata of gRPC, if
+// service1 presented a client certificate.
+func TestGRPCBaggage(t *testing.T) {
+	var certified bool
+	prefixes := make(chan string, 1)
+	lis := bufconn.Listen(1 << 16)
+	s := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithPropagators(propagator))),
+		grpc.ChainUnaryInterceptor(
+			func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				if certified {
+					ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: certifiedPeer}})
+				}
+				return handler(ctx, req)
+			},
+			BaggageUnaryServerInterceptor(OTel),
+			func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				prefixes <- Prefix(ctx)
+				return handler(ctx, req)
+			}),
+	)
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	defer s.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler(otelgrpc.WithPropagators(propagator))),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, tt := range []struct {
+		principal auth.Principal
+		certified bool
+		want      string
+	}{
+		{auth.Principal{ID: "alice", Tenant: "acme"}, true, "{acme}:"},
+		{auth.Principal{ID: "bob"}, true, "{_}:"},
+		{auth.Principal{ID: "alice", Tenant: "acme"}, false, codes.PermissionDenied.String()},
+		{auth.Principal{ID: "bob"}, false, "{_}:"},
+	} {
+		certified = tt.certified
+		got := service1(t, tt.principal, func(ctx context.Context) string {
+			if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+				return status.Code(err).String()
+			}
+			return <-prefixes
+		})
+		if got != tt.want {
+			t.Errorf("%s, certified %v: service2 answered %q, want %q", tt.principal.ID, tt.certified, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go-service-tracing/internal/kvstore"
+)
+
+// quotaIndex starts the index of the keys of a tenant, which ends with the
+// hash tag of the tenant. Keys start with a hash tag, so no tenant has a key
+// of that name.
+const quotaIndex = "#quota:"
+
+// Quotas caps the number of keys per tenant.
+type Quotas struct {
+	// Default applies to tenants without a quota of their own; 0 leaves
+	// them unlimited.
+	Default int64
+	// Tenants maps a tenant to its maximum number of keys, 0 for none.
+	Tenants map[string]int64
+}
+
+// ParseQuotas reads a comma-separated list of tenant=keys pairs, where the
+// tenant "default" sets Default:
+//
+//	default=1000,acme=50
+func ParseQuotas(s string) (Quotas, error) {
+	q := Quotas{Tenants: map[string]int64{}}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		id, keys, ok := strings.Cut(pair, "=")
+		if !ok {
+			return q, fmt.Errorf("quota %q: want tenant=keys", pair)
+		}
+		id = strings.TrimSpace(id)
+		n, err := strconv.ParseInt(strings.TrimSpace(keys), 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("quota %q: %w", pair, err)
+		}
+		if n < 0 {
+			return q, fmt.Errorf("quota %q: negative number of keys", pair)
+		}
+		if id == "default" {
+			q.Default = n
+			continue
+		}
+		if err := Validate(id); err != nil {
+			return q, fmt.Errorf("quota %q: %w", pair, err)
+		}
+		q.Tenants[id] = n
+	}
+	return q, nil
+}
+
+// For returns the quota of the tenant in ctx, or nil if it has none or there
+// is no tenant.
+func (q Quotas) For(ctx context.Context) *kvstore.Quota {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	n, ok := q.Tenants[id]
+	if !ok {
+		n = q.Default
+	}
+	if n == 0 {
+		return nil
+	}
+	return &kvstore.Quota{Index: quotaIndex + hashTag(id), MaxKeys: n}
+}
@@ -0,0 +1,143 @@
+// Package tenant keeps the keys of the tenants of the KV API apart.
+//
+// service1 takes the tenant of a request from the principal that
+// authenticated it, or from the X-Tenant-Id header if the API is open, and
+// passes it on as the tenant.id member of the W3C baggage. service2 puts the
+// keys of a tenant under the prefix "{<tenant>}:" in Redis, so tenants neither
+// see nor overwrite each other's keys, and holds each tenant to its quota of
+// keys. Requests without a tenant work on the keys under "{_}:", as no tenant
+// ID starts with "_", unless service1 requires one.
+//
+// The braces make the tenant the hash tag of its keys and of its quota
+// index, which a Redis Cluster keeps in one slot, so that a put can update
+// both at once and a listing needs to scan one node only.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"go-service-tracing/internal/auth"
+
+	"go.opentelemetry.io/otel/baggage"
+)
+
+// Header is the request header, or the gRPC metadata, that names the tenant.
+// The HMAC signature of a request covers it.
+const Header = auth.TenantHeader
+
+// untenanted is the prefix of the keys of the requests without a tenant.
+const untenanted = "{_}:"
+
+// BaggageKey is the baggage member that carries the tenant downstream.
+const BaggageKey = "tenant.id"
+
+// AttrTenantID is the attribute of the tenant on spans.
+const AttrTenantID = "tenant.id"
+
+// ErrInvalid is the error of a malformed tenant ID.
+var ErrInvalid = errors.New("invalid tenant")
+
+// ErrMissing is the error of a request without a tenant where one is
+// required.
+var ErrMissing = fmt.Errorf("%w: %s is required", ErrInvalid, Header)
+
+// ErrForbidden is the error of a request for a tenant that its principal
+// does not act for.
+var ErrForbidden = errors.New("tenant forbidden")
+
+// validID keeps tenant IDs free of the braces of their hash tags, and of the
+// glob characters of SCAN and PSUBSCRIBE patterns.
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Validate checks that id is a valid tenant ID.
+func Validate(id string) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("%w %q: want up to 64 letters, digits, '.', '_' or '-'", ErrInvalid, id)
+	}
+	return nil
+}
+
+// resolvedKey is the context key of the tenant that NewContext resolved,
+// which unlike the baggage of the context a caller cannot choose.
+type resolvedKey struct{}
+
+// NewContext returns a context whose baggage carries id, or carries no tenant
+// if id is empty, so that a caller cannot pass one on in its own baggage.
+func NewContext(ctx context.Context, id string) context.Context {
+	b := baggage.FromContext(ctx)
+	if id == "" {
+		ctx = context.WithValue(ctx, resolvedKey{}, "")
+		return baggage.ContextWithBaggage(ctx, b.DeleteMember(BaggageKey))
+	}
+	m, err := baggage.NewMemberRaw(BaggageKey, id)
+	if err != nil {
+		return ctx
+	}
+	if b, err = b.SetMember(m); err != nil {
+		return ctx
+	}
+	return baggage.ContextWithBaggage(context.WithValue(ctx, resolvedKey{}, id), b)
+}
+
+// FromContext returns the tenant in the baggage of ctx.
+func FromContext(ctx context.Context) (string, bool) {
+	id := baggage.FromContext(ctx).Member(BaggageKey).Value()
+	return id, id != ""
+}
+
+// Prefix is the prefix of the keys of the tenant in ctx, e.g. "{acme}:", or
+// "{_}:" without a tenant.
+func Prefix(ctx context.Context) string {
+	if id, ok := FromContext(ctx); ok {
+		return hashTag(id) + ":"
+	}
+	return untenanted
+}
+
+// hashTag is the hash tag of the keys of tenant id.
+func hashTag(id string) string {
+	return "{" + id + "}"
+}
+
+// resolve returns the tenant of a request to service1 whose header names
+// header: the one of its principal if it was authenticated, which header may
+// only repeat, or else header.
+func resolve(ctx context.Context, header string, required bool) (string, error) {
+	id := header
+	if p, ok := auth.FromContext(ctx); ok {
+		if header != "" && header != p.Tenant {
+			if p.Tenant == "" {
+				return "", fmt.Errorf("%w: %s acts for no tenant", ErrForbidden, p.ID)
+			}
+			return "", fmt.Errorf("%w: %s acts for tenant %s", ErrForbidden, p.ID, p.Tenant)
+		}
+		id = p.Tenant
+	}
+	return id, check(id, required)
+}
+
+// fromBaggage returns the context of a request to service2 with the tenant
+// in its baggage. The OpenTelemetry propagator has already extracted the
+// baggage into ctx; the Zipkin services only have the header. Only a
+// certified caller, one that presented a client certificate, may name a
+// tenant, since anyone who reaches service2 could otherwise act for any.
+func fromBaggage(ctx context.Context, header string, certified bool) (context.Context, error) {
+	id, ok := FromContext(ctx)
+	if !ok && header != "" {
+		b, _ := baggage.Parse(header)
+		id = b.Member(BaggageKey).Value()
+	}
+	if id == "" {
+		return ctx, nil
+	}
+	if !certified {
+		return NewContext(ctx, ""), fmt.Errorf("%w: %s baggage from a caller without a client certificate", ErrForbidden, BaggageKey)
+	}
+	if err := Validate(id); err != nil {
+		return ctx, err
+	}
+	return NewContext(ctx, id), nil
+}
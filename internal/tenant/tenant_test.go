@@ -0,0 +1,168 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-service-tracing/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/baggage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestPrefix(t *testing.T) {
+	if got := Prefix(context.Background()); got != "{_}:" {
+		t.Errorf("Prefix without tenant = %q, want {_}:", got)
+	}
+	if got := Prefix(NewContext(context.Background(), "acme")); got != "{acme}:" {
+		t.Errorf("Prefix of acme = %q, want {acme}:", got)
+	}
+	// no tenant ID starts the namespace of the requests without one
+	if err := Validate("_"); err == nil {
+		t.Error("_ is a valid tenant ID")
+	}
+	for _, id := range []string{"", "a:b", "a*", "#quota", "a b", "{a}"} {
+		if err := Validate(id); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Validate(%q) = %v, want ErrInvalid", id, err)
+		}
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	q, err := ParseQuotas("default=1000, acme=50,globex=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := q.For(NewContext(context.Background(), "acme")); got == nil || got.Index != "#quota:{acme}" || got.MaxKeys != 50 {
+		t.Errorf("quota of acme = %+v", got)
+	}
+	if got := q.For(NewContext(context.Background(), "initech")); got == nil || got.MaxKeys != 1000 {
+		t.Errorf("quota of initech = %+v, want the default", got)
+	}
+	if got := q.For(NewContext(context.Background(), "globex")); got != nil {
+		t.Errorf("quota of globex = %+v, want none", got)
+	}
+	if got := q.For(context.Background()); got != nil {
+		t.Errorf("quota without tenant = %+v, want none", got)
+	}
+	for _, s := range []string{"acme", "acme=-1", "a:b=1", "acme=x"} {
+		if _, err := ParseQuotas(s); err == nil {
+			t.Errorf("ParseQuotas(%q) succeeded", s)
+		}
+	}
+}
+
+// The tenant of an authenticated request is the one of its principal, which
+// the header can only repeat; an open API takes the header.
+func TestGin(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		principal *auth.Principal
+		header    string
+		required  bool
+		code      int
+		tenant    string
+	}{
+		{name: "open", header: "acme", code: http.StatusOK, tenant: "acme"},
+		{name: "open without tenant", code: http.StatusOK},
+		{name: "open, required", required: true, code: http.StatusBadRequest},
+		{name: "open, invalid", header: "a:b", code: http.StatusBadRequest},
+		{name: "principal", principal: &auth.Principal{ID: "alice", Tenant: "acme"}, code: http.StatusOK, tenant: "acme"},
+		{name: "principal, same header", principal: &auth.Principal{ID: "alice", Tenant: "acme"}, header: "acme", code: http.StatusOK, tenant: "acme"},
+		{name: "principal, other header", principal: &auth.Principal{ID: "alice", Tenant: "acme"}, header: "globex", code: http.StatusForbidden},
+		{name: "principal without tenant, header", principal: &auth.Principal{ID: "bob"}, header: "acme", code: http.StatusForbidden},
+		{name: "principal without tenant", principal: &auth.Principal{ID: "bob"}, code: http.StatusOK},
+		{name: "principal without tenant, required", principal: &auth.Principal{ID: "bob"}, required: true, code: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(func(c *gin.Context) {
+				if tc.principal != nil {
+					c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), *tc.principal))
+				}
+			}, Gin(tc.required, OTel))
+			tenant := ""
+			r.GET("/v1/kv/k", func(c *gin.Context) {
+				tenant, _ = FromContext(c.Request.Context())
+			})
+			req := httptest.NewRequest(http.MethodGet, "/v1/kv/k", nil)
+			if tc.header != "" {
+				req.Header.Set(Header, tc.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tc.code || tenant != tc.tenant {
+				t.Errorf("got %d, tenant %q, want %d, %q", w.Code, tenant, tc.code, tc.tenant)
+			}
+		})
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	intercept := UnaryServerInterceptor(false, OTel)
+	call := func(p auth.Principal, header string) (string, error) {
+		ctx := auth.NewContext(context.Background(), p)
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(Header, header))
+		tenant := ""
+		_, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/storage.v1.Storage/Get"}, func(ctx context.Context, _ any) (any, error) {
+			tenant, _ = FromContext(ctx)
+			return nil, nil
+		})
+		return tenant, err
+	}
+
+	if id, err := call(auth.Principal{ID: "alice", Tenant: "acme"}, ""); err != nil || id != "acme" {
+		t.Errorf("principal of acme = %q, %v", id, err)
+	}
+	if _, err := call(auth.Principal{ID: "alice", Tenant: "acme"}, "globex"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("principal of acme for globex: %v, want PermissionDenied", err)
+	}
+	if _, err := call(auth.Principal{ID: "alice", Tenant: "acme"}, "a:b"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("principal of acme for a:b: %v, want PermissionDenied", err)
+	}
+}
+
+// Only the tenant that was resolved reaches the spans, not the one a caller
+// put in its baggage.
+func TestSpanProcessor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(SpanProcessor()), sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	member, _ := baggage.NewMember(BaggageKey, "acme")
+	b, _ := baggage.New(member)
+	raw := baggage.ContextWithBaggage(context.Background(), b)
+
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"caller baggage", raw, ""},
+		{"resolved", NewContext(raw, "globex"), "globex"},
+		{"resolved without tenant", NewContext(raw, ""), ""},
+	} {
+		_, span := tracer.Start(tc.ctx, tc.name)
+		span.End()
+		got := ""
+		for _, a := range recorder.Ended()[len(recorder.Ended())-1].Attributes() {
+			if a.Key == AttrTenantID {
+				got = a.Value.AsString()
+			}
+		}
+		if got != tc.want {
+			t.Errorf("%s: tenant.id = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package tenant
+
+import (
+	"context"
+
+	"github.com/openzipkin/zipkin-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing records the tenant of a request on the span in ctx, the server
+// span of the request.
+type Tracing interface {
+	// Tenant records id as tenant.id.
+	Tenant(ctx context.Context, id string)
+	// Rejected marks the span as failed with err.
+	Rejected(ctx context.Context, err error)
+}
+
+type otelTracing struct{}
+
+// OTel records on OpenTelemetry spans. The spans started within the request
+// get the tenant from SpanProcessor.
+var OTel Tracing = otelTracing{}
+
+func (otelTracing) Tenant(ctx context.Context, id string) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String(AttrTenantID, id))
+}
+
+func (otelTracing) Rejected(ctx context.Context, err error) {
+	trace.SpanFromContext(ctx).SetStatus(codes.Error, err.Error())
+}
+
+type zipkinTracing struct{}
+
+// Zipkin records on Zipkin spans. zipkin-go has no hook for the start of a
+// span, so only the server span gets the tenant.
+var Zipkin Tracing = zipkinTracing{}
+
+func (zipkinTracing) Tenant(ctx context.Context, id string) {
+	if span := zipkin.SpanFromContext(ctx); span != nil {
+		span.Tag(AttrTenantID, id)
+	}
+}
+
+func (zipkinTracing) Rejected(ctx context.Context, err error) {
+	if span := zipkin.SpanFromContext(ctx); span != nil {
+		zipkin.TagError.Set(span, err.Error())
+	}
+}
+
+type spanProcessor struct{}
+
+// SpanProcessor records the tenant that NewContext resolved in the parent
+// context on every span as it starts. The server spans start before the
+// tenant is known, with the baggage the caller chose; Tracing records the
+// tenant on them once it is resolved.
+func SpanProcessor() sdktrace.SpanProcessor {
+	return spanProcessor{}
+}
+
+func (spanProcessor) OnStart(parent context.Context, s sdktrace.ReadWriteSpan) {
+	if id, _ := parent.Value(resolvedKey{}).(string); id != "" {
+		s.SetAttributes(attribute.String(AttrTenantID, id))
+	}
+}
+
+func (spanProcessor) OnEnd(sdktrace.ReadOnlySpan)      {}
+func (spanProcessor) Shutdown(context.Context) error   { return nil }
+func (spanProcessor) ForceFlush(context.Context) error { return nil }
@@ -0,0 +1,82 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIssue(t *testing.T) {
+	ca, err := NewCA("test CA", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM, err := ca.Issue("test", []string{"localhost", "127.0.0.1"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca.CertPEM()) {
+		t.Fatal("CertPEM holds no certificate")
+	}
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: pool, KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+			t.Errorf("verify for usage %v: %v", usage, err)
+		}
+	}
+	if len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("IP addresses = %v, want 127.0.0.1", cert.IPAddresses)
+	}
+	if d := cert.NotAfter.Sub(time.Now()); d > time.Minute {
+		t.Errorf("certificate valid for another %s, want at most 1m", d)
+	}
+
+	other, err := NewCA("other CA", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPool := x509.NewCertPool()
+	otherPool.AppendCertsFromPEM(other.CertPEM())
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: otherPool}); err == nil {
+		t.Error("certificate verified against another CA")
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	ca, err := NewCA("test CA", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "certs")
+	if err := ca.WriteFiles(dir, "test", []string{"localhost"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for name, perm := range map[string]os.FileMode{"ca.pem": 0o644, "cert.pem": 0o644, "key.pem": 0o600} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != perm {
+			t.Errorf("%s mode = %v, want %v", name, fi.Mode().Perm(), perm)
+		}
+	}
+	if _, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
@@ -0,0 +1,24 @@
+package tlsconfig
+
+import (
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// ServerCredentials are the credentials of the service's own gRPC server:
+// TLS if r uses it for "server", plaintext otherwise.
+func ServerCredentials(r *Reloader) credentials.TransportCredentials {
+	if cfg := r.ServerConfig(); cfg != nil {
+		return credentials.NewTLS(cfg)
+	}
+	return insecure.NewCredentials()
+}
+
+// ClientCredentials are the credentials of the gRPC connection conn to addr:
+// TLS if r uses it for conn, plaintext otherwise.
+func ClientCredentials(r *Reloader, conn, addr string) credentials.TransportCredentials {
+	if cfg := r.ClientConfig(conn, addr); cfg != nil {
+		return credentials.NewTLS(cfg)
+	}
+	return insecure.NewCredentials()
+}
@@ -0,0 +1,36 @@
+package tlsconfig
+
+import (
+	"net/http"
+)
+
+// ListenAndServe serves h on addr, over TLS if r uses it for "server".
+func ListenAndServe(r *Reloader, addr string, h http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: h, TLSConfig: r.ServerConfig()}
+	if srv.TLSConfig == nil {
+		return srv.ListenAndServe()
+	}
+	// the certificate comes from TLSConfig, not from files given here
+	return srv.ListenAndServeTLS("", "")
+}
+
+// URL is the base URL of the HTTP connection conn to addr, a host:port, e.g.
+// https://localhost:8081 if r uses TLS for conn.
+func URL(r *Reloader, conn, addr string) string {
+	if r.Uses(conn) {
+		return "https://" + addr
+	}
+	return "http://" + addr
+}
+
+// Transport is a copy of http.DefaultTransport for the HTTP connection conn to
+// addr, using TLS if r uses it for conn.
+func Transport(r *Reloader, conn, addr string) http.RoundTripper {
+	cfg := r.ClientConfig(conn, addr)
+	if cfg == nil {
+		return http.DefaultTransport
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = cfg
+	return t
+}
@@ -0,0 +1,224 @@
+// Package tlsconfig turns on TLS, and optionally mTLS, for the connections of
+// a service: its own server, the calls to other services, the collector and
+// Redis.
+//
+// A service has one certificate, which it serves and presents to peers that
+// ask for a client certificate, and one CA bundle, which it verifies its
+// peers against. Both are read from PEM files that are checked for changes
+// at most every ReloadInterval while handshakes happen, so rotated files take
+// effect without a restart.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReloadInterval is how often, at most, the files are checked for changes.
+const ReloadInterval = time.Second
+
+// Files configures TLS for the connections named in Use.
+type Files struct {
+	// Cert and Key are the certificate of the service. It is required to
+	// serve TLS and presented to peers that ask for one.
+	Cert, Key string
+	// CA verifies the peers; empty means the system roots for servers and
+	// no client certificates for clients.
+	CA string
+	// ClientAuth requires clients to present a certificate signed by CA.
+	ClientAuth bool
+	// Use names the connections that use TLS, e.g. "server" for the
+	// service's own server and "service2", "collector" or "redis" for the
+	// ones it makes.
+	Use []string
+}
+
+// ParseUse reads a comma-separated list of connections.
+func ParseUse(s string) []string {
+	var use []string
+	for _, conn := range strings.Split(s, ",") {
+		if conn = strings.TrimSpace(conn); conn != "" {
+			use = append(use, conn)
+		}
+	}
+	return use
+}
+
+// Reloader holds the current certificate and CA pool of a service. A nil
+// *Reloader uses TLS nowhere.
+type Reloader struct {
+	files Files
+
+	mu       sync.Mutex
+	checked  time.Time
+	modTimes [3]time.Time
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+}
+
+// Load reads the files, or returns nil if f uses TLS nowhere.
+func Load(f Files) (*Reloader, error) {
+	if len(f.Use) == 0 {
+		return nil, nil
+	}
+	if (f.Cert == "") != (f.Key == "") {
+		return nil, errors.New("a certificate needs both a cert and a key file")
+	}
+	if slices.Contains(f.Use, "server") && f.Cert == "" {
+		return nil, errors.New("serving TLS needs a certificate")
+	}
+	if f.ClientAuth && f.CA == "" {
+		return nil, errors.New("verifying client certificates needs a CA")
+	}
+	r := &Reloader{files: f}
+	r.modTimes = r.stat()
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+// Uses reports whether conn uses TLS.
+func (r *Reloader) Uses(conn string) bool {
+	return r != nil && slices.Contains(r.files.Use, conn)
+}
+
+// ServerConfig is the configuration of the service's own server, or nil if
+// it does not use TLS.
+func (r *Reloader) ServerConfig() *tls.Config {
+	if !r.Uses("server") {
+		return nil
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+	if r.files.ClientAuth {
+		// tls only verifies against a fixed ClientCAs, so the verification
+		// against the current pool is done here
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return r.verify(cs, "", x509.ExtKeyUsageClientAuth)
+		}
+	}
+	return cfg
+}
+
+// ClientConfig is the configuration of the connection conn to addr, a
+// host:port, or nil if it does not use TLS.
+func (r *Reloader) ClientConfig(conn, addr string) *tls.Config {
+	if !r.Uses(conn) {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: host,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert, _ := r.current(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	}
+	if r.files.CA != "" {
+		// the same as the default verification, only against the current
+		// pool instead of a fixed RootCAs
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return r.verify(cs, host, x509.ExtKeyUsageServerAuth)
+		}
+	}
+	return cfg
+}
+
+func (r *Reloader) verify(cs tls.ConnectionState, host string, usage x509.ExtKeyUsage) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: peer sent no certificate")
+	}
+	_, pool := r.current()
+	opts := x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// current returns the certificate and the CA pool, reloading them first if
+// the files changed. A change that does not load, e.g. a certificate whose
+// key is not written yet, keeps the previous ones.
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); now.Sub(r.checked) >= ReloadInterval {
+		r.checked = now
+		if modTimes := r.stat(); modTimes != r.modTimes {
+			r.modTimes = modTimes
+			if err := r.load(); err != nil {
+				log.Printf("tls: keeping the previous certificates: %v", err)
+			} else {
+				log.Printf("tls: reloaded certificates")
+			}
+		}
+	}
+	return r.cert, r.pool
+}
+
+func (r *Reloader) stat() [3]time.Time {
+	var modTimes [3]time.Time
+	for i, file := range []string{r.files.Cert, r.files.Key, r.files.CA} {
+		if file == "" {
+			continue
+		}
+		if fi, err := os.Stat(file); err == nil {
+			modTimes[i] = fi.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func (r *Reloader) load() error {
+	var cert *tls.Certificate
+	if r.files.Cert != "" {
+		c, err := tls.LoadX509KeyPair(r.files.Cert, r.files.Key)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.files.CA != "" {
+		data, err := os.ReadFile(r.files.CA)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("%s: no certificates", r.files.CA)
+		}
+	}
+	r.cert, r.pool = cert, pool
+	return nil
+}
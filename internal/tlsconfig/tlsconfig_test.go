@@ -0,0 +1,206 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCerts writes a certificate for localhost issued by ca to a directory
+// of its own and returns it.
+func writeCerts(t *testing.T, ca *CA) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := ca.WriteFiles(dir, "test", []string{"localhost", "127.0.0.1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newCA(t *testing.T) *CA {
+	t.Helper()
+	ca, err := NewCA("test CA", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func load(t *testing.T, f Files) *Reloader {
+	t.Helper()
+	r, err := Load(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// files uses the certificate, key and CA in dir for use.
+func files(dir string, use ...string) Files {
+	return Files{
+		Cert: filepath.Join(dir, "cert.pem"),
+		Key:  filepath.Join(dir, "key.pem"),
+		CA:   filepath.Join(dir, "ca.pem"),
+		Use:  use,
+	}
+}
+
+// handshake runs a TLS handshake between server and client over loopback and
+// returns the error each side saw.
+func handshake(t *testing.T, server, client *tls.Config) (serverErr, clientErr error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		conn := tls.Server(c, server)
+		if err := conn.Handshake(); err != nil {
+			done <- err
+			return
+		}
+		// confirm the handshake, as with TLS 1.3 the client finishes before
+		// the server has verified its certificate
+		_, err = conn.Write([]byte{1})
+		done <- err
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	conn := tls.Client(c, client)
+	if clientErr = conn.Handshake(); clientErr == nil {
+		_, clientErr = conn.Read(make([]byte, 1))
+	}
+	if clientErr != nil {
+		// let the server see the failure
+		c.Close()
+	}
+	return <-done, clientErr
+}
+
+func TestLoad(t *testing.T) {
+	if r, err := Load(Files{}); r != nil || err != nil {
+		t.Fatalf("Load without Use = %v, %v, want nil", r, err)
+	}
+	dir := writeCerts(t, newCA(t))
+	for name, f := range map[string]Files{
+		"cert without key":          {Cert: filepath.Join(dir, "cert.pem"), Use: []string{"redis"}},
+		"server without cert":       {Use: []string{"server"}},
+		"client auth without CA":    {Cert: filepath.Join(dir, "cert.pem"), Key: filepath.Join(dir, "key.pem"), ClientAuth: true, Use: []string{"server"}},
+		"missing CA file":           {CA: filepath.Join(dir, "none.pem"), Use: []string{"redis"}},
+		"CA file of no certificate": {CA: filepath.Join(dir, "key.pem"), Use: []string{"redis"}},
+	} {
+		if _, err := Load(f); err == nil {
+			t.Errorf("%s: Load succeeded", name)
+		}
+	}
+
+	r := load(t, files(dir, "service2"))
+	if !r.Uses("service2") || r.Uses("redis") || r.ServerConfig() != nil || r.ClientConfig("redis", "localhost:6379") != nil {
+		t.Error("TLS used for a connection that is not named")
+	}
+	var none *Reloader
+	if none.Uses("server") || none.ClientConfig("redis", "localhost:6379") != nil {
+		t.Error("nil Reloader uses TLS")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newCA(t)
+	dir := writeCerts(t, ca)
+	server := load(t, Files{
+		Cert:       filepath.Join(dir, "cert.pem"),
+		Key:        filepath.Join(dir, "key.pem"),
+		CA:         filepath.Join(dir, "ca.pem"),
+		ClientAuth: true,
+		Use:        []string{"server"},
+	})
+	client := load(t, files(writeCerts(t, ca), "service2"))
+
+	if serverErr, clientErr := handshake(t, server.ServerConfig(), client.ClientConfig("service2", "localhost:8081")); serverErr != nil || clientErr != nil {
+		t.Fatalf("handshake errors = %v, %v", serverErr, clientErr)
+	}
+
+	t.Run("client without certificate", func(t *testing.T) {
+		anonymous := load(t, Files{CA: filepath.Join(dir, "ca.pem"), Use: []string{"service2"}})
+		if serverErr, _ := handshake(t, server.ServerConfig(), anonymous.ClientConfig("service2", "localhost:8081")); serverErr == nil {
+			t.Fatal("server accepted a client without certificate")
+		}
+	})
+	t.Run("client of another CA", func(t *testing.T) {
+		stranger := load(t, files(writeCerts(t, newCA(t)), "service2"))
+		cfg := stranger.ClientConfig("service2", "localhost:8081")
+		// trust the server, so that only the server can refuse
+		cfg.VerifyConnection = nil
+		if serverErr, _ := handshake(t, server.ServerConfig(), cfg); serverErr == nil {
+			t.Fatal("server accepted a certificate of another CA")
+		}
+	})
+	t.Run("server of another CA", func(t *testing.T) {
+		impostor := load(t, files(writeCerts(t, newCA(t)), "server"))
+		if _, clientErr := handshake(t, impostor.ServerConfig(), client.ClientConfig("service2", "localhost:8081")); clientErr == nil {
+			t.Fatal("client accepted a server certificate of another CA")
+		}
+	})
+	t.Run("wrong host", func(t *testing.T) {
+		if _, clientErr := handshake(t, server.ServerConfig(), client.ClientConfig("service2", "service2.example:8081")); clientErr == nil {
+			t.Fatal("client accepted a certificate for another host")
+		}
+	})
+}
+
+// Rotated files take effect on the handshakes after ReloadInterval.
+func TestReload(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for ReloadInterval")
+	}
+	dir := writeCerts(t, newCA(t))
+	server := load(t, files(dir, "server"))
+	// a client that keeps trusting the first CA only
+	first := load(t, Files{CA: filepath.Join(writeCopy(t, dir), "ca.pem"), Use: []string{"service2"}})
+	if _, clientErr := handshake(t, server.ServerConfig(), first.ClientConfig("service2", "localhost:8081")); clientErr != nil {
+		t.Fatal(clientErr)
+	}
+
+	rotated := newCA(t)
+	if err := rotated.WriteFiles(dir, "test", []string{"localhost"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(ReloadInterval + 100*time.Millisecond)
+	if _, clientErr := handshake(t, server.ServerConfig(), first.ClientConfig("service2", "localhost:8081")); clientErr == nil {
+		t.Fatal("server still serves the certificate of the first CA")
+	}
+	second := load(t, Files{CA: filepath.Join(dir, "ca.pem"), Use: []string{"service2"}})
+	if _, clientErr := handshake(t, server.ServerConfig(), second.ClientConfig("service2", "localhost:8081")); clientErr != nil {
+		t.Fatalf("handshake with the rotated certificate: %v", clientErr)
+	}
+}
+
+// writeCopy copies ca.pem of dir to a directory of its own.
+func writeCopy(t *testing.T, dir string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := t.TempDir()
+	if err := os.WriteFile(filepath.Join(copied, "ca.pem"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return copied
+}
@@ -0,0 +1,112 @@
+package topology
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+)
+
+// Collector keeps received spans in memory. It accepts OTLP over gRPC, so it
+// can stand in for the OpenTelemetry collector on :4317, and Zipkin v2 JSON
+// over HTTP, so it can stand in for Zipkin on :9411.
+type Collector struct {
+	collectorpb.UnimplementedTraceServiceServer
+
+	mu       sync.Mutex
+	spans    []Span
+	received time.Time
+
+	// Zipkin spans are converted on read: the two halves of a shared span
+	// are reported by different services, usually in different requests.
+	zipkinSpans []model.SpanModel
+}
+
+// NewCollector returns an empty collector.
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+// Export implements the OTLP trace service.
+func (c *Collector) Export(ctx context.Context, req *collectorpb.ExportTraceServiceRequest) (*collectorpb.ExportTraceServiceResponse, error) {
+	c.add(FromOTLP(req.ResourceSpans))
+	return &collectorpb.ExportTraceServiceResponse{}, nil
+}
+
+// ServeHTTP implements the Zipkin /api/v2/spans endpoint.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var spans []model.SpanModel
+	if err := json.NewDecoder(r.Body).Decode(&spans); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.zipkinSpans = append(c.zipkinSpans, spans...)
+	c.received = time.Now()
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Add records spans obtained some other way, e.g. from FromSDK.
+func (c *Collector) Add(spans []Span) {
+	c.add(spans)
+}
+
+func (c *Collector) add(spans []Span) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, spans...)
+	c.received = time.Now()
+}
+
+// Spans returns a copy of everything received since the last Reset.
+func (c *Collector) Spans() []Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append(append([]Span(nil), c.spans...), FromZipkin(c.zipkinSpans)...)
+}
+
+// Reset drops all received spans.
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = nil
+	c.zipkinSpans = nil
+	c.received = time.Time{}
+}
+
+// Settle blocks until no span has arrived for quiet, or ctx is done.
+// Exporters batch spans, so the spans of one request arrive over time.
+// A quiet of 0 or less returns at once.
+func (c *Collector) Settle(ctx context.Context, quiet time.Duration) {
+	if quiet <= 0 {
+		return
+	}
+	ticker := time.NewTicker(quiet / 10)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			last := c.received
+			c.mu.Unlock()
+			if last.IsZero() {
+				last = start
+			}
+			if now.Sub(last) >= quiet {
+				return
+			}
+		}
+	}
+}
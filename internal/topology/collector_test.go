@@ -0,0 +1,150 @@
+package topology
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestFromSDK(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "service1"))),
+	)
+	tracer := tp.Tracer("test")
+	ctx, parent := tracer.Start(context.Background(), "service1.Put")
+	_, child := tracer.Start(ctx, "storage.v1.Storage/Put",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("rpc.grpc.status_code", 0)))
+	child.End()
+	parent.End()
+
+	c := NewCollector()
+	c.Add(FromSDK(exporter.GetSpans().Snapshots()))
+	r := Match(Expect("service1.Put").In("service1").
+		Child(Expect("storage.v1.Storage/Put").OfKind("client").Attr("rpc.grpc.status_code", "0")), c.Spans())
+	if !r.OK() {
+		t.Fatalf("Match = %+v", r)
+	}
+}
+
+func TestCollectorExport(t *testing.T) {
+	c := NewCollector()
+	_, err := c.Export(context.Background(), &collectorpb.ExportTraceServiceRequest{
+		ResourceSpans: []*tracepb.ResourceSpans{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "service2"}}},
+			}},
+			ScopeSpans: []*tracepb.ScopeSpans{{Spans: []*tracepb.Span{{
+				TraceId: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+				SpanId:  []byte{1, 2, 3, 4, 5, 6, 7, 8},
+				Name:    "redis.set",
+				Kind:    tracepb.Span_SPAN_KIND_INTERNAL,
+				Attributes: []*commonpb.KeyValue{
+					{Key: "kv.version", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 3}}},
+				},
+			}}}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := c.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	want := Span{
+		TraceID: "0102030405060708090a0b0c0d0e0f10", SpanID: "0102030405060708",
+		Name: "redis.set", Service: "service2", Kind: "internal",
+	}
+	s := spans[0]
+	if s.TraceID != want.TraceID || s.SpanID != want.SpanID || s.ParentID != "" ||
+		s.Name != want.Name || s.Service != want.Service || s.Kind != want.Kind || s.Attributes["kv.version"] != "3" {
+		t.Fatalf("span = %+v, want %+v with kv.version 3", s, want)
+	}
+
+	c.Reset()
+	if spans := c.Spans(); len(spans) != 0 {
+		t.Fatalf("got %d spans after Reset, want none", len(spans))
+	}
+}
+
+// The client and server halves of a Zipkin RPC share their ID; the server
+// half becomes a child of the client half.
+func TestCollectorZipkin(t *testing.T) {
+	traceID := model.TraceID{Low: 1}
+	root, rpc := model.ID(1), model.ID(2)
+	local := func(service string) *model.Endpoint { return &model.Endpoint{ServiceName: service} }
+	spans := []model.SpanModel{
+		{SpanContext: model.SpanContext{TraceID: traceID, ID: root}, Name: "service1.put", LocalEndpoint: local("service1")},
+		{SpanContext: model.SpanContext{TraceID: traceID, ID: rpc, ParentID: &root}, Name: "storage.v1.storage/put", Kind: model.Client, LocalEndpoint: local("service1")},
+		{SpanContext: model.SpanContext{TraceID: traceID, ID: rpc, ParentID: &root}, Name: "storage.v1.storage/put", Kind: model.Server, Shared: true, LocalEndpoint: local("service2")},
+		{SpanContext: model.SpanContext{TraceID: traceID, ID: 3, ParentID: &rpc}, Name: "redis.set", LocalEndpoint: local("service2"), Tags: map[string]string{"redis.key": "k"}},
+	}
+	body, err := json.Marshal(spans)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCollector()
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v2/spans", bytes.NewReader(body)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+	if r := Match(putExpectation(), c.Spans()); !r.OK() {
+		t.Fatalf("Match = %+v", r)
+	}
+
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v2/spans", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status of GET = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestSettle(t *testing.T) {
+	c := NewCollector()
+	done := make(chan struct{})
+	go func() {
+		c.Settle(context.Background(), 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Settle(0) did not return")
+	}
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			c.Add([]Span{{TraceID: "t", SpanID: "s"}})
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	start := time.Now()
+	c.Settle(context.Background(), 50*time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("Settle returned after %s while spans kept arriving", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Settle(ctx, time.Hour)
+}
@@ -0,0 +1,182 @@
+//go:build integration
+
+package topology_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-service-tracing/internal/tlsconfig"
+	"go-service-tracing/internal/topology"
+
+	"github.com/alicebob/miniredis/v2"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+)
+
+// root is the module root, relative to this package.
+const root = "../.."
+
+// settle is how long no span must arrive before a scenario is matched. The
+// services export every 100ms in the test, and zipkin-go reports every second.
+const settle = 2 * time.Second
+
+// examples are the specs of the example services and the flags they need.
+var examples = []struct {
+	dir, spec string
+	s1, s2    []string
+}{
+	{dir: "jaeger/grpcexample", spec: "topology.yaml"},
+	{dir: "jaeger/grpcexample", spec: "topology-async.yaml", s1: []string{"-async-put"}, s2: []string{"-put-worker"}},
+	{dir: "jaeger/ginexample", spec: "topology.yaml"},
+	{dir: "jaeger/ginexample", spec: "topology-async.yaml", s1: []string{"-async-put"}, s2: []string{"-put-worker"}},
+	{dir: "zipkin/grpcexample", spec: "topology.yaml"},
+	{dir: "zipkin/grpcexample", spec: "topology-async.yaml", s1: []string{"-async-put"}, s2: []string{"-put-worker"}},
+	{dir: "zipkin/ginexample", spec: "topology.yaml"},
+	{dir: "zipkin/ginexample", spec: "topology-async.yaml", s1: []string{"-async-put"}, s2: []string{"-put-worker"}},
+}
+
+// TestExamples runs the example services on their fixed ports, with Redis in
+// memory and the collector of this package in place of the OpenTelemetry
+// collector and Zipkin, and checks the spans of the scenarios of their specs.
+func TestExamples(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the example services")
+	}
+	for _, port := range []string{":4317", ":9411", ":8080", ":8081", ":8082"} {
+		lis, err := net.Listen("tcp", port)
+		if err != nil {
+			t.Skipf("port %s is taken, stop the collector and the services: %v", port, err)
+		}
+		lis.Close()
+	}
+
+	collector := topology.NewCollector()
+	lis, err := net.Listen("tcp", ":4317")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	collectorpb.RegisterTraceServiceServer(s, collector)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/v2/spans", collector)
+	zipkin := &http.Server{Addr: ":9411", Handler: mux}
+	go zipkin.ListenAndServe()
+	defer zipkin.Close()
+
+	// service2 trusts the tenant that service1 sends in the baggage only over
+	// mTLS
+	certs := t.TempDir()
+	ca, err := tlsconfig.NewCA("examples CA", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.WriteFiles(certs, "service", []string{"localhost", "127.0.0.1", "::1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	tlsArgs := []string{
+		"-tls-cert", filepath.Join(certs, "cert.pem"),
+		"-tls-key", filepath.Join(certs, "key.pem"),
+		"-tls-ca", filepath.Join(certs, "ca.pem"),
+	}
+
+	bin := t.TempDir()
+	for _, ex := range examples {
+		t.Run(ex.dir+"/"+ex.spec, func(t *testing.T) {
+			scenarios, err := topology.Load(filepath.Join(root, ex.dir, ex.spec))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			redis := miniredis.RunT(t)
+			redisArgs := []string{"-redis-addrs", redis.Addr()}
+			s2 := append(append([]string{"-tls", "server", "-tls-client-auth"}, tlsArgs...), redisArgs...)
+			start(t, bin, ex.dir+"/service2", append(s2, ex.s2...)...)
+			s1 := append(append([]string{"-tls", "service2"}, tlsArgs...), redisArgs...)
+			start(t, bin, ex.dir+"/service1", append(s1, ex.s1...)...)
+			if _, err := os.Stat(filepath.Join(root, ex.dir, "gateway")); err == nil {
+				start(t, bin, ex.dir+"/gateway")
+			}
+			for _, sc := range scenarios {
+				waitReady(t, sc.Request.Addr())
+			}
+
+			for _, sc := range scenarios {
+				t.Run(sc.Name, func(t *testing.T) {
+					ctx, cancel := context.WithTimeout(context.Background(), settle+30*time.Second)
+					defer cancel()
+					result, err := topology.Run(ctx, collector, sc, settle, nil)
+					if err != nil {
+						t.Fatalf("request error: %v", err)
+					}
+					if !result.OK() {
+						t.Errorf("matched %d/%d spans in trace %s", result.Matched, result.Expected, result.TraceID)
+						for _, m := range result.Mismatches {
+							t.Errorf("    %s", m)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+// start builds the command of pkg, a directory below the module root, and
+// runs it until the test ends. Its output is logged if the test fails.
+func start(t *testing.T, bin, pkg string, args ...string) {
+	t.Helper()
+	path := filepath.Join(bin, filepath.FromSlash(pkg))
+	if _, err := os.Stat(path); err != nil {
+		build := exec.Command("go", "build", "-o", path, "./"+pkg)
+		build.Dir = root
+		if out, err := build.CombinedOutput(); err != nil {
+			t.Fatalf("build %s: %v\n%s", pkg, err, out)
+		}
+	}
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdout, cmd.Stderr = out, out
+	cmd.Env = append(os.Environ(), "OTEL_BSP_SCHEDULE_DELAY=100")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		out.Close()
+		if t.Failed() {
+			if data, err := os.ReadFile(out.Name()); err == nil {
+				t.Logf("output of %s:\n%s", pkg, data)
+			}
+		}
+	})
+}
+
+func waitReady(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is not ready: %v", addr, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
@@ -0,0 +1,55 @@
+package topology
+
+// AnyValue as an expected attribute value only requires the attribute to be
+// present.
+const AnyValue = "*"
+
+// Node describes one expected span and the spans expected beneath it.
+// Children may be direct children or deeper descendants, so intermediate
+// spans added by instrumentation libraries do not break an expectation.
+type Node struct {
+	Name       string            `yaml:"name"`
+	Service    string            `yaml:"service,omitempty"`
+	Kind       string            `yaml:"kind,omitempty"`
+	Attributes map[string]string `yaml:"attributes,omitempty"`
+	Children   []*Node           `yaml:"children,omitempty"`
+}
+
+// Expect starts a Go builder for an expected span named name.
+//
+//	topology.Expect("service1.Put").
+//		Child(topology.Expect("storage.v1.Storage/Put").OfKind("client").
+//			Child(topology.Expect("storage.v1.Storage/Put").OfKind("server").
+//				Child(topology.Expect("redis.set").Attr("redis.key", topology.AnyValue))))
+func Expect(name string) *Node {
+	return &Node{Name: name}
+}
+
+// In sets the service the span must have been reported by.
+func (n *Node) In(service string) *Node {
+	n.Service = service
+	return n
+}
+
+// OfKind sets the expected span kind: internal, server, client, producer or
+// consumer.
+func (n *Node) OfKind(kind string) *Node {
+	n.Kind = kind
+	return n
+}
+
+// Attr requires the span to carry attribute key with value, or any value when
+// value is AnyValue.
+func (n *Node) Attr(key, value string) *Node {
+	if n.Attributes == nil {
+		n.Attributes = map[string]string{}
+	}
+	n.Attributes[key] = value
+	return n
+}
+
+// Child appends expected descendants.
+func (n *Node) Child(children ...*Node) *Node {
+	n.Children = append(n.Children, children...)
+	return n
+}
@@ -0,0 +1,190 @@
+package topology
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Mismatch describes one expected span that could not be matched.
+type Mismatch struct {
+	Path   string
+	Reason string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s: %s", m.Path, m.Reason)
+}
+
+// Result is the outcome of matching an expectation against collected spans.
+// When no trace matches completely, it describes the closest trace.
+type Result struct {
+	TraceID    string
+	Matched    int
+	Expected   int
+	Mismatches []Mismatch
+}
+
+// OK reports whether every expected span was found in a single trace.
+func (r Result) OK() bool {
+	return r.Expected > 0 && r.Matched == r.Expected
+}
+
+// Match looks for a trace containing the whole expected tree. The expected
+// root may sit anywhere in the trace, which allows expectations to start
+// below spans created by callers such as a load generator.
+func Match(root *Node, spans []Span) Result {
+	best := Result{Expected: root.size()}
+	traces := map[string][]Span{}
+	for _, s := range spans {
+		traces[s.TraceID] = append(traces[s.TraceID], s)
+	}
+	ids := make([]string, 0, len(traces))
+	for id := range traces {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		t := newTree(traces[id])
+		for _, s := range t.spans {
+			if !strings.EqualFold(s.Name, root.Name) {
+				continue
+			}
+			matched, mismatches, _ := t.match(root, s, nil, root.Name)
+			if matched > best.Matched || best.TraceID == "" {
+				best.TraceID = id
+				best.Matched = matched
+				best.Mismatches = mismatches
+			}
+			if best.OK() {
+				return best
+			}
+		}
+	}
+	if best.TraceID == "" {
+		reason := fmt.Sprintf("no span with this name in %d traces", len(ids))
+		best.Mismatches = []Mismatch{{Path: root.Name, Reason: reason}}
+	}
+	return best
+}
+
+func (n *Node) size() int {
+	size := 1
+	for _, c := range n.Children {
+		size += c.size()
+	}
+	return size
+}
+
+type tree struct {
+	spans    []Span
+	children map[string][]Span
+}
+
+func newTree(spans []Span) *tree {
+	t := &tree{spans: spans, children: map[string][]Span{}}
+	for _, s := range spans {
+		if s.ParentID != "" {
+			t.children[s.ParentID] = append(t.children[s.ParentID], s)
+		}
+	}
+	return t
+}
+
+func (t *tree) descendants(s Span) []Span {
+	var out []Span
+	queue := append([]Span(nil), t.children[s.SpanID]...)
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		out = append(out, c)
+		queue = append(queue, t.children[c.SpanID]...)
+	}
+	return out
+}
+
+// match returns how many nodes of n's subtree were found beneath s and the
+// spans they were matched to. Each child node is assigned to a distinct
+// descendant, greedily picking the candidate that matches the most of the
+// child's own subtree.
+func (t *tree) match(n *Node, s Span, used map[string]bool, path string) (int, []Mismatch, []string) {
+	if reason := n.mismatch(s); reason != "" {
+		return 0, []Mismatch{{Path: path, Reason: reason}}, nil
+	}
+	claimed := []string{s.SpanID}
+	matched := 1
+	var mismatches []Mismatch
+	candidates := t.descendants(s)
+	for _, child := range n.Children {
+		childPath := path + " > " + child.Name
+		found := false
+		bestCount := 0
+		var bestMismatches []Mismatch
+		var bestClaimed []string
+		for _, c := range candidates {
+			if used[c.SpanID] || contains(claimed, c.SpanID) || !strings.EqualFold(c.Name, child.Name) {
+				continue
+			}
+			count, ms, ids := t.match(child, c, withClaimed(used, claimed), childPath)
+			if !found || count > bestCount {
+				found, bestCount, bestMismatches, bestClaimed = true, count, ms, ids
+			}
+		}
+		if !found {
+			mismatches = append(mismatches, Mismatch{Path: childPath, Reason: "no descendant span with this name"})
+			continue
+		}
+		matched += bestCount
+		mismatches = append(mismatches, bestMismatches...)
+		claimed = append(claimed, bestClaimed...)
+	}
+	return matched, mismatches, claimed
+}
+
+func withClaimed(used map[string]bool, claimed []string) map[string]bool {
+	out := make(map[string]bool, len(used)+len(claimed))
+	for k := range used {
+		out[k] = true
+	}
+	for _, id := range claimed {
+		out[id] = true
+	}
+	return out
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) mismatch(s Span) string {
+	if !strings.EqualFold(s.Name, n.Name) {
+		return fmt.Sprintf("name is %q", s.Name)
+	}
+	if n.Service != "" && !strings.EqualFold(s.Service, n.Service) {
+		return fmt.Sprintf("reported by %q, want %q", s.Service, n.Service)
+	}
+	if n.Kind != "" && !strings.EqualFold(s.Kind, n.Kind) {
+		return fmt.Sprintf("kind is %s, want %s", s.Kind, n.Kind)
+	}
+	keys := make([]string, 0, len(n.Attributes))
+	for k := range n.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		got, ok := s.Attributes[k]
+		if !ok {
+			return fmt.Sprintf("attribute %s is missing", k)
+		}
+		if want := n.Attributes[k]; want != AnyValue && got != want {
+			return fmt.Sprintf("attribute %s is %q, want %q", k, got, want)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,174 @@
+package topology
+
+import (
+	"strings"
+	"testing"
+)
+
+// span returns a span of trace t with the given ID, parent and name, and
+// attributes as alternating keys and values.
+func span(t, id, parent, name string, attrs ...string) Span {
+	s := Span{TraceID: t, SpanID: id, ParentID: parent, Name: name, Attributes: map[string]string{}}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.Attributes[attrs[i]] = attrs[i+1]
+	}
+	return s
+}
+
+func putExpectation() *Node {
+	return Expect("service1.Put").
+		Child(Expect("storage.v1.Storage/Put").OfKind("client").
+			Child(Expect("storage.v1.Storage/Put").OfKind("server").In("service2").
+				Child(Expect("redis.set").Attr("redis.key", AnyValue))))
+}
+
+func putTrace(t string) []Span {
+	client := span(t, "2", "1", "storage.v1.Storage/Put")
+	client.Kind = "client"
+	server := span(t, "3", "2", "storage.v1.Storage/Put")
+	server.Kind, server.Service = "server", "service2"
+	return []Span{
+		span(t, "1", "", "service1.Put"),
+		client,
+		server,
+		span(t, "4", "3", "redis.set", "redis.key", "k"),
+	}
+}
+
+func TestMatch(t *testing.T) {
+	r := Match(putExpectation(), putTrace("t1"))
+	if !r.OK() || r.TraceID != "t1" || r.Matched != 4 || len(r.Mismatches) != 0 {
+		t.Fatalf("Match = %+v, want all 4 spans of t1", r)
+	}
+}
+
+func TestMatchRootBelowCaller(t *testing.T) {
+	spans := putTrace("t1")
+	spans[0].ParentID = "0"
+	spans = append(spans, span("t1", "0", "", "loadgen.Put"))
+	if r := Match(putExpectation(), spans); !r.OK() {
+		t.Fatalf("Match = %+v, want the expectation to start below the caller", r)
+	}
+}
+
+func TestMatchSkipsIntermediateSpans(t *testing.T) {
+	spans := putTrace("t1")
+	// a span of an instrumentation library between the server and redis.set
+	spans[3].ParentID = "5"
+	spans = append(spans, span("t1", "5", "3", "kvstore.put"))
+	if r := Match(putExpectation(), spans); !r.OK() {
+		t.Fatalf("Match = %+v, want deeper descendants to match", r)
+	}
+}
+
+func TestMatchIsCaseInsensitive(t *testing.T) {
+	spans := putTrace("t1")
+	spans[0].Name = "Service1.put"
+	if r := Match(putExpectation(), spans); !r.OK() {
+		t.Fatalf("Match = %+v, want names to match regardless of case", r)
+	}
+}
+
+func TestMatchMismatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(spans []Span)
+		reason string
+	}{
+		{
+			name:   "missing attribute",
+			change: func(spans []Span) { delete(spans[3].Attributes, "redis.key") },
+			reason: "attribute redis.key is missing",
+		},
+		{
+			name:   "wrong kind",
+			change: func(spans []Span) { spans[1].Kind = "internal" },
+			reason: "kind is internal, want client",
+		},
+		{
+			name:   "wrong service",
+			change: func(spans []Span) { spans[2].Service = "service3" },
+			reason: `reported by "service3", want "service2"`,
+		},
+		{
+			name:   "missing span",
+			change: func(spans []Span) { spans[3].Name = "redis.get" },
+			reason: "no descendant span with this name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans := putTrace("t1")
+			tt.change(spans)
+			r := Match(putExpectation(), spans)
+			if r.OK() {
+				t.Fatalf("Match = %+v, want a mismatch", r)
+			}
+			if len(r.Mismatches) == 0 || !strings.Contains(r.Mismatches[len(r.Mismatches)-1].Reason, tt.reason) {
+				t.Fatalf("mismatches = %v, want one with %q", r.Mismatches, tt.reason)
+			}
+		})
+	}
+}
+
+func TestMatchAttributeValue(t *testing.T) {
+	spans := []Span{span("t1", "1", "", "redis.set", "redis.key", "a")}
+	if r := Match(Expect("redis.set").Attr("redis.key", "a"), spans); !r.OK() {
+		t.Errorf("Match = %+v, want the value to match", r)
+	}
+	r := Match(Expect("redis.set").Attr("redis.key", "b"), spans)
+	if r.OK() || len(r.Mismatches) != 1 || r.Mismatches[0].Reason != `attribute redis.key is "a", want "b"` {
+		t.Errorf("Match = %+v, want a mismatch of the value", r)
+	}
+}
+
+// Children of the same name are matched to distinct spans, each to the
+// candidate that matches most of its own subtree.
+func TestMatchAssignsDistinctSpans(t *testing.T) {
+	expect := Expect("service1.Get").Child(
+		Expect("retry.attempt").Attr("retry.attempt", "1"),
+		Expect("retry.attempt").Attr("retry.attempt", "2").
+			Child(Expect("storage.v1.Storage/Get")),
+	)
+	spans := []Span{
+		span("t1", "1", "", "service1.Get"),
+		span("t1", "2", "1", "retry.attempt", "retry.attempt", "1"),
+		span("t1", "3", "1", "retry.attempt", "retry.attempt", "2"),
+		span("t1", "4", "3", "storage.v1.Storage/Get"),
+	}
+	if r := Match(expect, spans); !r.OK() {
+		t.Fatalf("Match = %+v, want both attempts matched", r)
+	}
+
+	// one attempt cannot satisfy both expectations
+	r := Match(expect, spans[:2])
+	if r.OK() || r.Matched != 2 || r.Expected != 4 {
+		t.Fatalf("Match = %+v, want 2 of 4 spans matched", r)
+	}
+}
+
+func TestMatchClosestTrace(t *testing.T) {
+	partial := putTrace("t1")[:2]
+	full := putTrace("t2")
+	full[3].Attributes = map[string]string{}
+	r := Match(putExpectation(), append(partial, full...))
+	if r.OK() || r.TraceID != "t2" || r.Matched != 3 {
+		t.Fatalf("Match = %+v, want 3 spans of t2", r)
+	}
+}
+
+func TestMatchNoRoot(t *testing.T) {
+	r := Match(putExpectation(), append(putTrace("t1"), putTrace("t2")...)[1:4])
+	if r.OK() || r.Matched != 0 || r.Expected != 4 {
+		t.Fatalf("Match = %+v, want nothing matched", r)
+	}
+	if len(r.Mismatches) != 1 || r.Mismatches[0].Reason != "no span with this name in 1 traces" {
+		t.Fatalf("mismatches = %v", r.Mismatches)
+	}
+}
+
+func TestMatchEmptyExpectation(t *testing.T) {
+	if r := Match(Expect("service1.Put"), nil); r.OK() {
+		t.Fatalf("Match of no spans = %+v, want not OK", r)
+	}
+}
@@ -0,0 +1,254 @@
+package topology
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/tlsconfig"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is one request to drive against the running example services and
+// the span tree it must produce.
+type Scenario struct {
+	Name    string  `yaml:"name"`
+	Request Request `yaml:"request"`
+	Expect  *Node   `yaml:"expect"`
+	// TraceID, when set, requires the expected spans to be in this trace,
+	// e.g. the one the request sends in a traceparent or b3 header.
+	TraceID string `yaml:"trace_id,omitempty"`
+}
+
+// Request is either an HTTP request to a gin service or a unary call to a
+// gRPC Storage service.
+type Request struct {
+	HTTP *HTTPRequest `yaml:"http,omitempty"`
+	GRPC *GRPCRequest `yaml:"grpc,omitempty"`
+}
+
+// HTTPRequest is sent form-encoded when Form is set, like the deprecated gin
+// routes expect, and as JSON when JSON is set, like the /v1 routes expect.
+// Headers are set as is, which lets a scenario send its own trace context.
+type HTTPRequest struct {
+	Method  string            `yaml:"method"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Form    map[string]string `yaml:"form,omitempty"`
+	JSON    map[string]any    `yaml:"json,omitempty"`
+}
+
+// GRPCRequest calls a Storage method by its full name, e.g.
+// /storage.v1.Storage/Put. For List, Key is the prefix. For Watch, the
+// request opens the stream and then puts Value under Key until the event
+// arrives. Metadata is sent with every call, e.g. the x-tenant-id.
+type GRPCRequest struct {
+	Target   string            `yaml:"target"`
+	Method   string            `yaml:"method"`
+	Key      string            `yaml:"key"`
+	Value    string            `yaml:"value,omitempty"`
+	Metadata map[string]string `yaml:"metadata,omitempty"`
+}
+
+// Load reads scenarios from a YAML file.
+func Load(path string) ([]Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec struct {
+		Scenarios []Scenario `yaml:"scenarios"`
+	}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, sc := range spec.Scenarios {
+		if sc.Expect == nil {
+			return nil, fmt.Errorf("scenario %d (%s): expect is empty", i, sc.Name)
+		}
+		if (sc.Request.HTTP == nil) == (sc.Request.GRPC == nil) {
+			return nil, fmt.Errorf("scenario %d (%s): exactly one of http or grpc is required", i, sc.Name)
+		}
+	}
+	return spec.Scenarios, nil
+}
+
+// Run drives the scenario's request, waits for its spans to arrive in c and
+// matches them against the expectation. The request uses TLS if certs, which
+// may be nil, uses it for the connection "target".
+func Run(ctx context.Context, c *Collector, sc Scenario, settle time.Duration, certs *tlsconfig.Reloader) (Result, error) {
+	c.Reset()
+	if err := sc.Request.Do(ctx, certs); err != nil {
+		return Result{}, err
+	}
+	c.Settle(ctx, settle)
+	spans := c.Spans()
+	if sc.TraceID != "" {
+		spans = inTrace(spans, sc.TraceID)
+	}
+	return Match(sc.Expect, spans), nil
+}
+
+func inTrace(spans []Span, traceID string) []Span {
+	var in []Span
+	for _, s := range spans {
+		if strings.EqualFold(s.TraceID, traceID) {
+			in = append(in, s)
+		}
+	}
+	return in
+}
+
+// Addr returns the host:port the request is sent to.
+func (r Request) Addr() string {
+	if r.GRPC != nil {
+		return r.GRPC.Target
+	}
+	u, err := url.Parse(r.HTTP.URL)
+	if err != nil || u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return u.Host + ":443"
+	}
+	return u.Host + ":80"
+}
+
+// Do sends the request and fails on transport errors or error statuses. If
+// certs uses TLS for the connection "target", http URLs are requested over
+// https.
+func (r Request) Do(ctx context.Context, certs *tlsconfig.Reloader) error {
+	if r.HTTP != nil {
+		return r.HTTP.do(ctx, certs, r.Addr())
+	}
+	return r.GRPC.do(ctx, certs)
+}
+
+func (r *HTTPRequest) do(ctx context.Context, certs *tlsconfig.Reloader, addr string) error {
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	contentType := ""
+	switch {
+	case len(r.JSON) > 0:
+		data, err := json.Marshal(r.JSON)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	case len(r.Form) > 0:
+		form := url.Values{}
+		for k, v := range r.Form {
+			form.Set(k, v)
+		}
+		body = strings.NewReader(form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	}
+	target := r.URL
+	if certs.Uses("target") && strings.HasPrefix(target, "http://") {
+		target = "https://" + strings.TrimPrefix(target, "http://")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Transport: tlsconfig.Transport(certs, "target", addr)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s %s: %s", method, r.URL, resp.Status)
+	}
+	return nil
+}
+
+func (r *GRPCRequest) do(ctx context.Context, certs *tlsconfig.Reloader) error {
+	conn, err := grpc.NewClient(r.Target, grpc.WithTransportCredentials(tlsconfig.ClientCredentials(certs, "target", r.Target)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if len(r.Metadata) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(r.Metadata))
+	}
+
+	switch {
+	case strings.HasSuffix(r.Method, "/Put"):
+		req := &storagev1.PutRequest{Key: r.Key, Value: r.Value}
+		return conn.Invoke(ctx, r.Method, req, &storagev1.PutResponse{})
+	case strings.HasSuffix(r.Method, "/Get"):
+		req := &storagev1.GetRequest{Key: r.Key}
+		return conn.Invoke(ctx, r.Method, req, &storagev1.GetResponse{})
+	case strings.HasSuffix(r.Method, "/List"):
+		req := &storagev1.ListRequest{Prefix: r.Key}
+		return conn.Invoke(ctx, r.Method, req, &storagev1.ListResponse{})
+	case strings.HasSuffix(r.Method, "/Watch"):
+		return r.watch(ctx, conn)
+	default:
+		return fmt.Errorf("unsupported method %s", r.Method)
+	}
+}
+
+func (r *GRPCRequest) watch(ctx context.Context, conn *grpc.ClientConn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	stream, err := conn.NewStream(ctx, desc, r.Method)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&storagev1.WatchRequest{Key: r.Key}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	received := make(chan error, 1)
+	go func() {
+		received <- stream.RecvMsg(&storagev1.WatchEvent{})
+	}()
+
+	// the subscription is set up asynchronously, so keep writing until the
+	// first event comes through
+	put := strings.TrimSuffix(r.Method, "/Watch") + "/Put"
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		req := &storagev1.PutRequest{Key: r.Key, Value: r.Value}
+		if err := conn.Invoke(ctx, put, req, &storagev1.PutResponse{}); err != nil {
+			return err
+		}
+		select {
+		case err := <-received:
+			return err
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
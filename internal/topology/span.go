@@ -0,0 +1,174 @@
+// Package topology checks collected spans against declarative expectations
+// of the span tree an operation should produce.
+package topology
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go/model"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// Span is the backend-neutral view of a finished span used for matching.
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	Service    string
+	Kind       string
+	Attributes map[string]string
+}
+
+// FromSDK converts spans recorded by an OpenTelemetry SDK exporter, such as
+// tracetest.InMemoryExporter.
+func FromSDK(spans []sdktrace.ReadOnlySpan) []Span {
+	out := make([]Span, 0, len(spans))
+	for _, s := range spans {
+		span := Span{
+			TraceID:    s.SpanContext().TraceID().String(),
+			SpanID:     s.SpanContext().SpanID().String(),
+			Name:       s.Name(),
+			Kind:       kindName(s.SpanKind()),
+			Attributes: map[string]string{},
+		}
+		if s.Parent().IsValid() {
+			span.ParentID = s.Parent().SpanID().String()
+		}
+		for _, kv := range s.Resource().Attributes() {
+			if kv.Key == "service.name" {
+				span.Service = kv.Value.Emit()
+			}
+		}
+		for _, kv := range s.Attributes() {
+			span.Attributes[string(kv.Key)] = kv.Value.Emit()
+		}
+		out = append(out, span)
+	}
+	return out
+}
+
+// FromOTLP converts spans received by an OTLP trace receiver.
+func FromOTLP(resourceSpans []*tracepb.ResourceSpans) []Span {
+	var out []Span
+	for _, rs := range resourceSpans {
+		service := ""
+		for _, kv := range rs.GetResource().GetAttributes() {
+			if kv.Key == "service.name" {
+				service = anyValue(kv.Value)
+			}
+		}
+		for _, ss := range rs.GetScopeSpans() {
+			for _, s := range ss.GetSpans() {
+				span := Span{
+					TraceID:    hex.EncodeToString(s.TraceId),
+					SpanID:     hex.EncodeToString(s.SpanId),
+					ParentID:   hex.EncodeToString(s.ParentSpanId),
+					Name:       s.Name,
+					Service:    service,
+					Kind:       otlpKindName(s.Kind),
+					Attributes: map[string]string{},
+				}
+				for _, kv := range s.Attributes {
+					span.Attributes[kv.Key] = anyValue(kv.Value)
+				}
+				out = append(out, span)
+			}
+		}
+	}
+	return out
+}
+
+// FromZipkin converts spans reported by zipkin-go. Zipkin shares one span ID
+// between the client and server side of an RPC. The client half gets a
+// distinct ID and becomes the parent of the server half, which keeps the ID
+// its children refer to, so the tree has the same shape as with
+// OpenTelemetry.
+func FromZipkin(spans []model.SpanModel) []Span {
+	shared := map[string]bool{}
+	for _, s := range spans {
+		if s.Shared && s.Kind == model.Server {
+			shared[s.TraceID.String()+s.ID.String()] = true
+		}
+	}
+
+	out := make([]Span, 0, len(spans))
+	for _, s := range spans {
+		span := Span{
+			TraceID:    s.TraceID.String(),
+			SpanID:     s.ID.String(),
+			Name:       s.Name,
+			Kind:       strings.ToLower(string(s.Kind)),
+			Attributes: map[string]string{},
+		}
+		if s.ParentID != nil {
+			span.ParentID = s.ParentID.String()
+		}
+		if shared[span.TraceID+span.SpanID] {
+			switch {
+			case s.Kind == model.Client:
+				span.SpanID += "-client"
+			case s.Shared:
+				span.ParentID = span.SpanID + "-client"
+			}
+		}
+		if s.LocalEndpoint != nil {
+			span.Service = s.LocalEndpoint.ServiceName
+		}
+		for k, v := range s.Tags {
+			span.Attributes[k] = v
+		}
+		out = append(out, span)
+	}
+	return out
+}
+
+func kindName(kind trace.SpanKind) string {
+	switch kind {
+	case trace.SpanKindServer:
+		return "server"
+	case trace.SpanKindClient:
+		return "client"
+	case trace.SpanKindProducer:
+		return "producer"
+	case trace.SpanKindConsumer:
+		return "consumer"
+	default:
+		return "internal"
+	}
+}
+
+func otlpKindName(kind tracepb.Span_SpanKind) string {
+	switch kind {
+	case tracepb.Span_SPAN_KIND_SERVER:
+		return "server"
+	case tracepb.Span_SPAN_KIND_CLIENT:
+		return "client"
+	case tracepb.Span_SPAN_KIND_PRODUCER:
+		return "producer"
+	case tracepb.Span_SPAN_KIND_CONSUMER:
+		return "consumer"
+	default:
+		return "internal"
+	}
+}
+
+func anyValue(v *commonpb.AnyValue) string {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return x.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(x.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(x.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(x.DoubleValue, 'g', -1, 64)
+	default:
+		return ""
+	}
+}
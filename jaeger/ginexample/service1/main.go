@@ -1,44 +1,287 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"flag"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"go-service-tracing/internal/auth"
+	"go-service-tracing/internal/baggageattr"
+	"go-service-tracing/internal/breaker"
+	"go-service-tracing/internal/cache"
+	"go-service-tracing/internal/deadline"
+	"go-service-tracing/internal/kvhttp"
+	"go-service-tracing/internal/messaging"
+	"go-service-tracing/internal/putqueue"
+	"go-service-tracing/internal/ratelimit"
+	"go-service-tracing/internal/readiness"
+	"go-service-tracing/internal/redact"
+	"go-service-tracing/internal/redisclient"
+	"go-service-tracing/internal/retry"
+	"go-service-tracing/internal/tenant"
+	"go-service-tracing/internal/tlsconfig"
+
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 var (
-	redisClient *redis.Client
-	httpClient  *http.Client
+	service2URL   string
+	tlsCerts      *tlsconfig.Reloader
+	redisClient   redis.UniversalClient
+	httpClient    *http.Client
+	retryPolicy   retry.Policy
+	collectorConn *grpc.ClientConn
+	meterProvider metric.MeterProvider
+	baggagePolicy baggageattr.Policy
+	valueCache    *cache.Cache
+	putQueue      *putqueue.Producer
+
+	timeouts      = flag.String("timeouts", "default=2s", "timeouts per route, e.g. default=2s,GET /v1/kv=5s")
+	retries       = flag.Int("retries", 3, "attempts per request to service2, 1 to disable retries")
+	retryBackoff  = flag.Duration("retry-backoff", 50*time.Millisecond, "pause before the first retry, doubled for each one after")
+	retryPut      = flag.Bool("retry-put", false, "retry PUT and POST as well, which may write a value twice")
+	failureRate   = flag.Float64("breaker-failure-rate", 0.5, "share of failed requests to service2 that opens the circuit breaker, 0 to disable it")
+	openTimeout   = flag.Duration("breaker-open-timeout", 5*time.Second, "how long the circuit breaker stays open")
+	bulkhead      = flag.Int("bulkhead", 32, "requests to service2 in flight at once, 0 for no limit")
+	redactPolicy  = flag.String("redact-policy", "", "YAML policy that redacts span attributes, the built-in one if empty")
+	tlsUse        = flag.String("tls", "", "connections that use TLS, any of server,service2,collector,redis")
+	tlsCert       = flag.String("tls-cert", "", "certificate file of this service, served and presented to peers that ask for one")
+	tlsKey        = flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA         = flag.String("tls-ca", "", "CA file to verify peers against, the system roots if empty")
+	tlsClientAuth = flag.Bool("tls-client-auth", false, "require clients to present a certificate signed by -tls-ca")
+	authConfig    = flag.String("auth", "", "authentication config file of API keys, HMAC keys and a JWKS, empty to leave the API open")
+	requireTenant = flag.Bool("require-tenant", false, "reject requests without a tenant")
+	baggageAttrs  = flag.String("baggage-attributes", "", "baggage members recorded on every span and the only ones kept from clients, e.g. user.tier,experiment.*")
+	baggageMax    = flag.Int("baggage-max-members", baggageattr.DefaultMaxMembers, "most baggage members kept, 0 for no limit")
+	baggageValue  = flag.Int("baggage-max-value", baggageattr.DefaultMaxValueLength, "longest baggage value kept, 0 for no limit")
+	rateLimit     = flag.Float64("rate-limit", 0, "requests per second per client, 0 for no limit")
+	rateBurst     = flag.Int("rate-burst", 20, "requests a client may make at once before -rate-limit applies")
+	rateLimitKey  = flag.String("rate-limit-key", "ip", "what a client is: ip, principal or tenant, the last two falling back to ip")
+	rateRedis     = flag.Bool("rate-limit-redis", false, "keep the buckets of the clients in Redis, shared by every replica of service1")
+	authRateLimit = flag.Float64("auth-rate-limit", 50, "requests per second per client IP before authentication, which bounds the attempts at guessing credentials, 0 for no limit")
+	authRateBurst = flag.Int("auth-rate-burst", 100, "requests a client IP may make at once before -auth-rate-limit applies")
+	redisMode     = flag.String("redis-mode", redisclient.ModeSingle, "Redis deployment: single, sentinel or cluster")
+	redisAddrs    = flag.String("redis-addrs", redisclient.DefaultAddr, "comma separated addresses of the Redis server, of the Sentinels or of some Cluster nodes")
+	redisMaster   = flag.String("redis-master", "", "master that the Sentinels watch, with -redis-mode sentinel")
+	redisPool     = flag.Int("redis-pool-size", 0, "most connections to each Redis node, 0 for 10 per CPU")
+	redisMinIdle  = flag.Int("redis-min-idle", 0, "idle connections to each Redis node kept open")
+	redisPoolWait = flag.Duration("redis-pool-timeout", 0, "how long a Redis command waits for a connection when the pool is exhausted, 0 for the read timeout plus 1s")
+	redisDial     = flag.Duration("redis-dial-timeout", 0, "bound of connecting to Redis, 0 for 5s")
+	redisRead     = flag.Duration("redis-read-timeout", 0, "bound of reading a Redis reply, 0 for 3s")
+	redisWrite    = flag.Duration("redis-write-timeout", 0, "bound of writing a Redis command, 0 for the read timeout")
+	redisWait     = flag.Duration("redis-wait", redisclient.DefaultWait, "how long startup waits for Redis to answer before serving without it")
+	cacheSize     = flag.Int("cache-size", 0, "keys in the read-through cache of gets, 0 to disable it")
+	cacheTTL      = flag.Duration("cache-ttl", 5*time.Second, "how long the cache serves a value")
+	asyncPut      = flag.Bool("async-put", false, "queue PUT /v1/kv/:key on a Redis Stream for the workers of service2 rather than forward it")
+	putStream     = flag.String("put-stream", putqueue.DefaultStream, "Redis Stream of the queued puts")
 )
 
 func main() {
+	flag.Parse()
+	loadCerts()
+	baggagePolicy = baggageattr.Policy{
+		Allow:          baggageattr.ParseAllow(*baggageAttrs),
+		MaxMembers:     *baggageMax,
+		MaxValueLength: *baggageValue,
+	}
+	authenticator, err := auth.Load(*authConfig)
+	if err != nil {
+		log.Fatalf("invalid -auth: %v", err)
+	}
+	routeTimeouts, err := deadline.ParseTimeouts(*timeouts)
+	if err != nil {
+		log.Fatalf("invalid -timeouts: %v", err)
+	}
+
 	shutdown := initTracer()
 	defer shutdown()
+	shutdownMeter := initMeter()
+	defer shutdownMeter()
 
 	createRedisClient()
+	if *asyncPut {
+		putQueue = putqueue.NewProducer(redisClient, *putStream, messaging.OTel(otel.Tracer("service1")))
+	}
 	createHttpClient()
+	authThrottle := createAuthThrottle(authenticator != nil)
+	throttle := createThrottle()
+	valueCache = cache.New(*cacheSize, *cacheTTL, cache.OTel(otel.Tracer("service1")), meterProvider)
+	// a queued put leaves the cache once a worker of service2 applied it
+	if putQueue != nil && valueCache != nil {
+		go putqueue.Applied(context.Background(), redisClient, *putStream, valueCache.Invalidate)
+	}
+
+	// service1 is only as ready as service2. The check uses a client of its
+	// own, so that it is not traced.
+	checker := readiness.NewChecker()
+	checker.Add("redis", readiness.Redis(redisClient))
+	checker.Add("service2", readiness.HTTP(&http.Client{
+		Transport: tlsconfig.Transport(tlsCerts, "service2", "localhost:8081"),
+	}, service2URL+"/readyz"))
+	checker.Add("collector", readiness.GRPCConn(collectorConn))
+	checker.Start(context.Background())
 
 	r := gin.Default()
-	r.Use(otelgin.Middleware("service1"))
+	// clients keep only the baggage that -baggage-attributes allows
+	r.Use(kvhttp.WithRoute(), baggageattr.Strip(baggagePolicy), otelgin.Middleware("service1",
+		otelgin.WithSpanNameFormatter(kvhttp.RouteSpanName),
+		otelgin.WithFilter(func(r *http.Request) bool { return !readiness.IsProbe(r) }),
+	), deadline.Gin(routeTimeouts, deadline.OTel), authThrottle.Gin(), auth.Gin(authenticator, auth.OTel),
+		tenant.Gin(*requireTenant, tenant.OTel), throttle.Gin())
+	r.GET("/healthz", gin.WrapF(readiness.Healthz))
+	r.GET("/readyz", gin.WrapF(checker.Readyz))
+
+	v1 := r.Group("/v1/kv", kvhttp.Negotiate())
+	v1.PUT("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+		body, ok := kvhttp.BindPut(c)
+		if !ok {
+			return
+		}
+		tracer := otel.Tracer("service1")
+
+		ctx, span := tracer.Start(c.Request.Context(), "kv.set")
+		defer span.End()
+
+		if putQueue != nil {
+			// the cache forgets the key once the put is applied, see main
+			enqueue(ctx, c, key, body)
+			return
+		}
+		defer valueCache.Invalidate(tenant.Prefix(ctx) + key)
 
-	r.POST("/kv/put", func(c *gin.Context) {
+		data, err := json.Marshal(body)
+		if err != nil {
+			kvhttp.RespondError(c, 500, err.Error())
+			return
+		}
+		req, err := http.NewRequestWithContext(ctx, "PUT", service2KeyURL(key), bytes.NewReader(data))
+		if err != nil {
+			kvhttp.RespondError(c, 500, err.Error())
+			return
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		forward(c, req)
+	})
+
+	v1.GET("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+		tracer := otel.Tracer("service1")
+
+		ctx, span := tracer.Start(c.Request.Context(), "kv.get")
+		defer span.End()
+
+		v, err := valueCache.Get(ctx, tenant.Prefix(ctx)+key, func(ctx context.Context) (cache.Value, error) {
+			req, err := http.NewRequestWithContext(ctx, "GET", service2KeyURL(key), nil)
+			if err != nil {
+				return cache.Value{}, err
+			}
+			return fetch(req)
+		})
+		if err != nil {
+			kvhttp.RespondError(c, kvhttp.FailureCode(err), err.Error())
+			return
+		}
+
+		kvhttp.Respond(c, 200, kvhttp.KV{Key: key, Value: v.Value, Version: v.Version})
+	})
+
+	v1.DELETE("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+		tracer := otel.Tracer("service1")
+
+		ctx, span := tracer.Start(c.Request.Context(), "kv.delete")
+		defer span.End()
+
+		req, err := http.NewRequestWithContext(ctx, "DELETE", service2KeyURL(key), nil)
+		if err != nil {
+			kvhttp.RespondError(c, 500, err.Error())
+			return
+		}
+
+		defer valueCache.Invalidate(tenant.Prefix(ctx) + key)
+		forward(c, req)
+	})
+
+	v1.HEAD("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+		tracer := otel.Tracer("service1")
+
+		ctx, span := tracer.Start(c.Request.Context(), "kv.exists")
+		defer span.End()
+
+		req, err := http.NewRequestWithContext(ctx, "HEAD", service2KeyURL(key), nil)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+
+		forward(c, req)
+	})
+
+	v1.GET("", func(c *gin.Context) {
+		query, ok := kvhttp.BindList(c)
+		if !ok {
+			return
+		}
+		tracer := otel.Tracer("service1")
+
+		ctx, span := tracer.Start(c.Request.Context(), "kv.list")
+		defer span.End()
+
+		params := url.Values{
+			"prefix": {query.Prefix},
+			"cursor": {strconv.FormatUint(query.Cursor, 10)},
+		}
+		if query.Limit > 0 {
+			params.Set("limit", strconv.FormatInt(query.Limit, 10))
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET",
+			service2URL+"/v1/kv?"+params.Encode(), nil)
+		if err != nil {
+			kvhttp.RespondError(c, 500, err.Error())
+			return
+		}
+
+		forward(c, req)
+	})
+
+	// deprecated routes, kept until every client has moved to /v1/kv
+	r.POST("/kv/put", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
 		key := c.PostForm("key")
 		value := c.PostForm("value")
 		if key == "" || value == "" {
@@ -56,7 +299,13 @@ func main() {
 			"key":   {key},
 			"value": {value},
 		}
-		req, err := http.NewRequestWithContext(ctx, "POST", "http://localhost:8081/kv/put",
+		// ttl and version are validated by service2
+		for _, name := range []string{"ttl", "version"} {
+			if v, ok := c.GetPostForm(name); ok {
+				params.Set(name, v)
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", service2URL+"/kv/put",
 			strings.NewReader(params.Encode()))
 		if err != nil {
 			c.JSON(500, gin.H{"message": err.Error()})
@@ -64,18 +313,11 @@ func main() {
 		}
 
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			c.JSON(500, gin.H{"message": err.Error()})
-			return
-		}
-
-		defer resp.Body.Close()
-		c.Status(resp.StatusCode)
-		io.Copy(c.Writer, resp.Body)
+		defer valueCache.Invalidate(tenant.Prefix(ctx) + key)
+		forward(c, req)
 	})
 
-	r.GET("/kv/get", func(c *gin.Context) {
+	r.GET("/kv/get", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
 		key := c.Query("key")
 		if key == "" {
 			c.JSON(400, gin.H{
@@ -92,43 +334,243 @@ func main() {
 			"key": {key},
 		}
 
-		req, err := http.NewRequestWithContext(ctx, "GET",
-			"http://localhost:8081/kv/get?"+params.Encode(), nil)
+		v, err := valueCache.Get(ctx, tenant.Prefix(ctx)+key, func(ctx context.Context) (cache.Value, error) {
+			req, err := http.NewRequestWithContext(ctx, "GET",
+				service2URL+"/kv/get?"+params.Encode(), nil)
+			if err != nil {
+				return cache.Value{}, err
+			}
+			return fetch(req)
+		})
+		if err != nil {
+			c.JSON(kvhttp.FailureCode(err), gin.H{"message": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"value": v.Value, "version": v.Version})
+	})
+
+	r.DELETE("/kv/:key", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
+		tracer := otel.Tracer("service1")
+		ctx, span := tracer.Start(c.Request.Context(), "kv.delete")
+		defer span.End()
+
+		req, err := http.NewRequestWithContext(ctx, "DELETE",
+			service2URL+"/kv/"+url.PathEscape(c.Param("key")), nil)
 		if err != nil {
 			c.JSON(500, gin.H{"message": err.Error()})
 			return
 		}
 
-		resp, err := httpClient.Do(req)
+		defer valueCache.Invalidate(tenant.Prefix(ctx) + c.Param("key"))
+		forward(c, req)
+	})
+
+	r.HEAD("/kv/:key", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
+		tracer := otel.Tracer("service1")
+		ctx, span := tracer.Start(c.Request.Context(), "kv.exists")
+		defer span.End()
+
+		req, err := http.NewRequestWithContext(ctx, "HEAD",
+			service2URL+"/kv/"+url.PathEscape(c.Param("key")), nil)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+
+		forward(c, req)
+	})
+
+	r.GET("/kv/list", kvhttp.Deprecated("/v1/kv"), func(c *gin.Context) {
+		tracer := otel.Tracer("service1")
+		ctx, span := tracer.Start(c.Request.Context(), "kv.list")
+		defer span.End()
+
+		// prefix, cursor and limit are validated by service2
+		req, err := http.NewRequestWithContext(ctx, "GET",
+			service2URL+"/kv/list?"+c.Request.URL.RawQuery, nil)
 		if err != nil {
 			c.JSON(500, gin.H{"message": err.Error()})
 			return
 		}
 
-		defer resp.Body.Close()
-		c.Status(resp.StatusCode)
-		io.Copy(c.Writer, resp.Body)
+		forward(c, req)
 	})
 	log.Printf("service1 running on port 8082")
-	log.Fatal(r.Run(":8082"))
+	log.Fatal(tlsconfig.ListenAndServe(tlsCerts, ":8082", r))
+}
+
+func service2KeyURL(key string) string {
+	return service2URL + "/v1/kv/" + url.PathEscape(key)
+}
+
+// forward sends req to service2 and relays its response. The Accept header
+// is passed on, so service2 answers in the format the caller negotiated.
+func forward(c *gin.Context, req *http.Request) {
+	if accept := c.GetHeader("Accept"); accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	// service2 gets the remaining budget less what service1 needs to answer,
+	// refreshed for every attempt
+	req, cancel := deadline.Outgoing(req)
+	defer cancel()
+	tracing := retry.OTel(otel.Tracer("service1"))
+	resp, err := retry.Do(retryPolicy, tracing, req, func(req *http.Request) (*http.Response, error) {
+		deadline.SetHeader(req)
+		return httpClient.Do(req)
+	})
+	if err != nil {
+		c.JSON(kvhttp.FailureCode(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	c.Status(resp.StatusCode)
+	io.Copy(c.Writer, resp.Body)
+}
+
+// enqueue adds a put to the stream of the workers of service2 and answers
+// 202 with the ID of its entry in X-Put-Id. Whether the put succeeds only
+// shows in the trace of the worker that applies it.
+func enqueue(ctx context.Context, c *gin.Context, key string, body kvhttp.PutBody) {
+	tenantID, _ := tenant.FromContext(ctx)
+	id, err := putQueue.Add(ctx, putqueue.Put{
+		Tenant:          tenantID,
+		Key:             key,
+		Value:           body.Value,
+		TTLSeconds:      body.TTLSeconds,
+		ExpectedVersion: body.Version,
+	})
+	if err != nil {
+		kvhttp.RespondError(c, kvhttp.FailureCode(err), err.Error())
+		return
+	}
+
+	c.Header("X-Put-Id", id)
+	kvhttp.Respond(c, 202, kvhttp.KV{Key: key})
+}
+
+// fetch gets the value of a key from service2 through req, for valueCache,
+// which keeps values rather than responses: service1 answers every get in
+// the format it asks for.
+func fetch(req *http.Request) (cache.Value, error) {
+	req.Header.Set("Accept", "application/json")
+	req, cancel := deadline.Outgoing(req)
+	defer cancel()
+	tracing := retry.OTel(otel.Tracer("service1"))
+	resp, err := retry.Do(retryPolicy, tracing, req, func(req *http.Request) (*http.Response, error) {
+		deadline.SetHeader(req)
+		return httpClient.Do(req)
+	})
+	if err != nil {
+		return cache.Value{}, err
+	}
+
+	defer resp.Body.Close()
+	kv, err := kvhttp.DecodeKV(resp)
+	if err != nil {
+		return cache.Value{}, err
+	}
+	return cache.Value{Value: kv.Value, Version: kv.Version}, nil
 }
 
 func createHttpClient() {
+	service2URL = tlsconfig.URL(tlsCerts, "service2", "localhost:8081")
+	settings := breaker.DefaultSettings()
+	settings.FailureRate = *failureRate
+	settings.OpenTimeout = *openTimeout
+	guard := breaker.NewGuard("service2", settings, breaker.NewBulkhead(*bulkhead, 0),
+		breaker.OTel(otel.Tracer("service1")), meterProvider)
+	// rejected requests never reach otelhttp, the guard records them itself
 	httpClient = &http.Client{
-		Transport: otelhttp.NewTransport(http.DefaultTransport),
+		Transport: breaker.Transport(guard, otelhttp.NewTransport(tlsconfig.Transport(tlsCerts, "service2", "localhost:8081"))),
+	}
+	retryPolicy = retry.DefaultPolicy()
+	retryPolicy.MaxAttempts = *retries
+	retryPolicy.InitialBackoff = *retryBackoff
+	retryPolicy.NonIdempotent = *retryPut
+}
+
+func loadCerts() {
+	var err error
+	tlsCerts, err = tlsconfig.Load(tlsconfig.Files{
+		Cert:       *tlsCert,
+		Key:        *tlsKey,
+		CA:         *tlsCA,
+		ClientAuth: *tlsClientAuth,
+		Use:        tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
 	}
 }
 
 func createRedisClient() {
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
+	addrs := redisclient.ParseAddrs(*redisAddrs)
+	if len(addrs) == 0 {
+		log.Fatalf("invalid -redis-addrs: %q\n", *redisAddrs)
+	}
+	// with TLS, every node is verified against the host of the first address
+	var err error
+	redisClient, err = redisclient.New(redisclient.Config{
+		Mode:          *redisMode,
+		Addrs:         addrs,
+		MasterName:    *redisMaster,
+		PoolSize:      *redisPool,
+		MinIdleConns:  *redisMinIdle,
+		PoolTimeout:   *redisPoolWait,
+		DialTimeout:   *redisDial,
+		ReadTimeout:   *redisRead,
+		WriteTimeout:  *redisWrite,
+		TLSConfig:     tlsCerts.ClientConfig("redis", addrs[0]),
+		Report:        redisclient.OTel,
+		MeterProvider: meterProvider,
 	})
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	err := redisClient.Ping(ctx).Err()
 	if err != nil {
-		log.Fatalf("redis ping error: %+v\n", err)
+		log.Fatalf("invalid Redis configuration: %+v\n", err)
+	}
+	// serve without Redis rather than exit, /readyz failing until it answers
+	if err := redisclient.Wait(context.Background(), redisClient, *redisWait); err != nil {
+		log.Printf("redis ping error: %+v\n", err)
+	}
+}
+
+// createThrottle returns the rate limiter of the API, nil if -rate-limit is 0.
+func createThrottle() *ratelimit.Throttle {
+	key, err := ratelimit.ParseKey(*rateLimitKey)
+	if err != nil {
+		log.Fatalf("invalid -rate-limit-key: %v", err)
+	}
+	if *rateLimit <= 0 {
+		return nil
+	}
+	if *rateBurst < 1 {
+		log.Fatalf("invalid -rate-burst: %d", *rateBurst)
+	}
+	return newThrottle(ratelimit.Limit{Rate: *rateLimit, Burst: *rateBurst}, key)
+}
+
+// createAuthThrottle returns the rate limiter of the requests by client IP
+// before they are authenticated, nil if they are not or -auth-rate-limit is 0.
+func createAuthThrottle(authenticated bool) *ratelimit.Throttle {
+	if !authenticated || *authRateLimit <= 0 {
+		return nil
+	}
+	if *authRateBurst < 1 {
+		log.Fatalf("invalid -auth-rate-burst: %d", *authRateBurst)
+	}
+	return newThrottle(ratelimit.Limit{Rate: *authRateLimit, Burst: *authRateBurst}, ratelimit.KeyUnauthenticated)
+}
+
+func newThrottle(limit ratelimit.Limit, key ratelimit.Key) *ratelimit.Throttle {
+	var limiter ratelimit.Limiter = ratelimit.NewLocal(limit)
+	if *rateRedis {
+		limiter = ratelimit.Redis(redisClient, limit)
 	}
+	return ratelimit.New(limiter, key, ratelimit.OTel, meterProvider)
 }
 
 func initTracer() func() {
@@ -145,18 +587,29 @@ func initTracer() func() {
 	}
 
 	conn, err := grpc.Dial("127.0.0.1:4317",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "collector", "127.0.0.1:4317")),
 		grpc.WithBlock(),
 	)
 	if err != nil {
 		panic(err)
 	}
+	collectorConn = conn
 	traceExporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithGRPCConn(conn))
 	if err != nil {
 		panic(err)
 	}
+	redactor, err := redact.Load(*redactPolicy)
+	if err != nil {
+		log.Fatalf("invalid -redact-policy: %v", err)
+	}
 	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(traceExporter),
+		// every span of a tenant's request records the tenant
+		sdktrace.WithSpanProcessor(tenant.SpanProcessor()),
+		// and the baggage members that -baggage-attributes allows
+		sdktrace.WithSpanProcessor(baggageattr.SpanProcessor(baggagePolicy)),
+		// redact before the batch holds on to the spans, by default KV values
+		// never leave the process
+		sdktrace.WithSpanProcessor(redact.SpanProcessor(redactor, sdktrace.NewBatchSpanProcessor(traceExporter))),
 		sdktrace.WithResource(res),
 	)
 	otel.SetTracerProvider(tracerProvider)
@@ -171,3 +624,35 @@ func initTracer() func() {
 		}
 	}
 }
+
+// initMeter exports the metrics of the service on the connection to the
+// collector that initTracer opened.
+func initMeter() func() {
+	ctx := context.Background()
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName("service1"),
+			semconv.ServiceVersion("1.0.0"),
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	metricExporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithGRPCConn(collectorConn))
+	if err != nil {
+		panic(err)
+	}
+
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(provider)
+	meterProvider = provider
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			panic(err)
+		}
+	}
+}
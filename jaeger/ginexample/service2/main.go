@@ -2,36 +2,226 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"go-service-tracing/internal/auth"
+	"go-service-tracing/internal/baggageattr"
+	"go-service-tracing/internal/deadline"
+	"go-service-tracing/internal/kvhttp"
+	"go-service-tracing/internal/kvstore"
+	"go-service-tracing/internal/messaging"
+	"go-service-tracing/internal/putqueue"
+	"go-service-tracing/internal/readiness"
+	"go-service-tracing/internal/redact"
+	"go-service-tracing/internal/redisclient"
+	"go-service-tracing/internal/tenant"
+	"go-service-tracing/internal/tlsconfig"
+
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 var (
-	redisClient *redis.Client
+	tlsCerts      *tlsconfig.Reloader
+	redisClient   redis.UniversalClient
+	quotas        tenant.Quotas
+	collectorConn *grpc.ClientConn
+	meterProvider metric.MeterProvider
+	baggagePolicy baggageattr.Policy
+
+	timeouts      = flag.String("timeouts", "default=1s", "timeouts per route, e.g. default=1s,GET /v1/kv=3s")
+	redisTimeout  = flag.Duration("redis-timeout", 500*time.Millisecond, "upper bound of a Redis command")
+	redisDelay    = flag.Duration("redis-delay", 0, "hold every Redis command back this long, to try out timeouts")
+	redisMode     = flag.String("redis-mode", redisclient.ModeSingle, "Redis deployment: single, sentinel or cluster")
+	redisAddrs    = flag.String("redis-addrs", redisclient.DefaultAddr, "comma separated addresses of the Redis server, of the Sentinels or of some Cluster nodes")
+	redisMaster   = flag.String("redis-master", "", "master that the Sentinels watch, with -redis-mode sentinel")
+	redisPool     = flag.Int("redis-pool-size", 0, "most connections to each Redis node, 0 for 10 per CPU")
+	redisMinIdle  = flag.Int("redis-min-idle", 0, "idle connections to each Redis node kept open")
+	redisPoolWait = flag.Duration("redis-pool-timeout", 0, "how long a Redis command waits for a connection when the pool is exhausted, 0 for the read timeout plus 1s")
+	redisDial     = flag.Duration("redis-dial-timeout", 0, "bound of connecting to Redis, 0 for 5s")
+	redisRead     = flag.Duration("redis-read-timeout", 0, "bound of reading a Redis reply, 0 for 3s")
+	redisWrite    = flag.Duration("redis-write-timeout", 0, "bound of writing a Redis command, 0 for the read timeout")
+	redisWait     = flag.Duration("redis-wait", redisclient.DefaultWait, "how long startup waits for Redis to answer before serving without it")
+	redactPolicy  = flag.String("redact-policy", "", "YAML policy that redacts span attributes, the built-in one if empty")
+	tlsUse        = flag.String("tls", "", "connections that use TLS, any of server,collector,redis")
+	tlsCert       = flag.String("tls-cert", "", "certificate file of this service, served and presented to peers that ask for one")
+	tlsKey        = flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA         = flag.String("tls-ca", "", "CA file to verify peers against, the system roots if empty")
+	tlsClientAuth = flag.Bool("tls-client-auth", false, "require clients to present a certificate signed by -tls-ca")
+	tenantQuotas  = flag.String("tenant-quotas", "", "maximum number of keys per tenant, e.g. default=1000,acme=50")
+	baggageAttrs  = flag.String("baggage-attributes", "", "baggage members recorded on every span, e.g. user.tier,experiment.*")
+	baggageMax    = flag.Int("baggage-max-members", baggageattr.DefaultMaxMembers, "most baggage members recorded, 0 for no limit")
+	baggageValue  = flag.Int("baggage-max-value", baggageattr.DefaultMaxValueLength, "longest baggage value recorded, 0 for no limit")
+	putWorker     = flag.Bool("put-worker", false, "apply the puts that service1 queues with -async-put")
+	putStream     = flag.String("put-stream", putqueue.DefaultStream, "Redis Stream of the queued puts")
+	putMinIdle    = flag.Duration("put-min-idle", putqueue.DefaultMinIdle, "how long a queued put that failed waits before it is delivered again")
+	putDeliveries = flag.Int64("put-max-deliveries", putqueue.DefaultMaxDeliveries, "deliveries of a queued put before it moves to the dead letter stream")
 )
 
 func main() {
+	flag.Parse()
+	loadCerts()
+	baggagePolicy = baggageattr.Policy{
+		Allow:          baggageattr.ParseAllow(*baggageAttrs),
+		MaxMembers:     *baggageMax,
+		MaxValueLength: *baggageValue,
+	}
+	routeTimeouts, err := deadline.ParseTimeouts(*timeouts)
+	if err != nil {
+		log.Fatalf("invalid -timeouts: %v", err)
+	}
+	quotas, err = tenant.ParseQuotas(*tenantQuotas)
+	if err != nil {
+		log.Fatalf("invalid -tenant-quotas: %v", err)
+	}
+
 	shutdown := initTracer()
 	defer shutdown()
+	shutdownMeter := initMeter()
+	defer shutdownMeter()
 
 	createRedisClient()
+	if *putWorker {
+		startPutWorker()
+	}
+
+	checker := readiness.NewChecker()
+	checker.Add("redis", readiness.Redis(redisClient))
+	checker.Add("collector", readiness.GRPCConn(collectorConn))
+	checker.Start(context.Background())
+
+	r := newRouter(checker, routeTimeouts)
+	log.Printf("service2 running on port 8081")
+	log.Fatal(tlsconfig.ListenAndServe(tlsCerts, ":8081", r))
+}
 
+// newRouter returns the routes of the service, with checker answering
+// /readyz.
+func newRouter(checker *readiness.Checker, routeTimeouts deadline.Timeouts) *gin.Engine {
 	r := gin.Default()
-	r.Use(otelgin.Middleware("service2"))
+	r.Use(kvhttp.WithRoute(), otelgin.Middleware("service2",
+		otelgin.WithSpanNameFormatter(kvhttp.RouteSpanName),
+		otelgin.WithFilter(func(r *http.Request) bool { return !readiness.IsProbe(r) }),
+	), deadline.Gin(routeTimeouts, deadline.OTel), auth.Identity(auth.OTel),
+		tenant.FromBaggage(tenant.OTel))
+	r.GET("/healthz", gin.WrapF(readiness.Healthz))
+	r.GET("/readyz", gin.WrapF(checker.Readyz))
 
-	r.POST("/kv/put", func(c *gin.Context) {
+	v1 := r.Group("/v1/kv", kvhttp.Negotiate())
+	v1.PUT("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+		body, ok := kvhttp.BindPut(c)
+		if !ok {
+			return
+		}
+
+		version, err := putValue(c.Request.Context(), key, body)
+		if errors.Is(err, kvstore.ErrVersionMismatch) {
+			kvhttp.Respond(c, 409, kvhttp.Error{Message: err.Error(), Version: &version})
+			return
+		}
+		if err != nil {
+			kvhttp.RespondError(c, kvhttp.FailureCode(err), err.Error())
+			return
+		}
+
+		kvhttp.Respond(c, 200, kvhttp.KV{Key: key, Version: version})
+	})
+
+	v1.GET("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+
+		value, version, err := getValue(c.Request.Context(), key)
+		if errors.Is(err, kvstore.ErrNotFound) {
+			kvhttp.RespondError(c, 404, err.Error())
+			return
+		}
+		if err != nil {
+			kvhttp.RespondError(c, kvhttp.FailureCode(err), err.Error())
+			return
+		}
+
+		kvhttp.Respond(c, 200, kvhttp.KV{Key: key, Value: value, Version: version})
+	})
+
+	v1.DELETE("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+
+		deleted, err := deleteKey(c.Request.Context(), key)
+		if err != nil {
+			kvhttp.RespondError(c, kvhttp.FailureCode(err), err.Error())
+			return
+		}
+		if !deleted {
+			kvhttp.RespondError(c, 404, kvstore.ErrNotFound.Error())
+			return
+		}
+
+		c.Status(204)
+	})
+
+	v1.HEAD("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+
+		exists, err := keyExists(c.Request.Context(), key)
+		switch {
+		case err != nil:
+			c.Status(kvhttp.FailureCode(err))
+		case !exists:
+			c.Status(404)
+		default:
+			c.Status(200)
+		}
+	})
+
+	v1.GET("", func(c *gin.Context) {
+		query, ok := kvhttp.BindList(c)
+		if !ok {
+			return
+		}
+
+		keys, next, err := listKeys(c.Request.Context(), query.Prefix, query.Cursor, query.Limit)
+		if err != nil {
+			kvhttp.RespondError(c, kvhttp.FailureCode(err), err.Error())
+			return
+		}
+
+		kvhttp.Respond(c, 200, kvhttp.KeyList{Keys: keys, NextCursor: next})
+	})
+
+	// deprecated routes, kept until every client has moved to /v1/kv
+	r.POST("/kv/put", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
 		key := c.PostForm("key")
 		value := c.PostForm("value")
 		if key == "" || value == "" {
@@ -40,20 +230,26 @@ func main() {
 			})
 			return
 		}
-		tracer := otel.Tracer("service2")
-
-		ctx, span := tracer.Start(c.Request.Context(), "redis.set")
-		defer span.End()
+		body, err := putForm(c, value)
+		if err != nil {
+			c.JSON(400, gin.H{"message": err.Error()})
+			return
+		}
 
-		if err := redisClient.Set(ctx, key, value, time.Minute).Err(); err != nil {
-			c.JSON(500, gin.H{"message": err.Error()})
+		version, err := putValue(c.Request.Context(), key, body)
+		if errors.Is(err, kvstore.ErrVersionMismatch) {
+			c.JSON(409, gin.H{"message": err.Error(), "version": version})
+			return
+		}
+		if err != nil {
+			c.JSON(kvhttp.FailureCode(err), gin.H{"message": err.Error()})
 			return
 		}
 
-		c.JSON(200, gin.H{"message": "success"})
+		c.JSON(200, gin.H{"message": "success", "version": version})
 	})
 
-	r.GET("/kv/get", func(c *gin.Context) {
+	r.GET("/kv/get", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
 		key := c.Query("key")
 		if key == "" {
 			c.JSON(400, gin.H{
@@ -61,32 +257,262 @@ func main() {
 			})
 			return
 		}
-		tracer := otel.Tracer("service2")
 
-		ctx, span := tracer.Start(c.Request.Context(), "redis.get")
-		defer span.End()
+		value, version, err := getValue(c.Request.Context(), key)
+		if errors.Is(err, kvstore.ErrNotFound) {
+			c.JSON(404, gin.H{"message": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(kvhttp.FailureCode(err), gin.H{"message": err.Error()})
+			return
+		}
 
-		value, err := redisClient.Get(ctx, key).Result()
+		c.JSON(200, gin.H{"value": value, "version": version})
+	})
+
+	r.DELETE("/kv/:key", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
+		deleted, err := deleteKey(c.Request.Context(), c.Param("key"))
 		if err != nil {
-			c.JSON(500, gin.H{"message": err.Error()})
+			c.JSON(kvhttp.FailureCode(err), gin.H{"message": err.Error()})
 			return
 		}
 
-		c.JSON(200, gin.H{"value": value})
+		c.JSON(200, gin.H{"deleted": deleted})
 	})
-	log.Printf("service2 running on port 8081")
-	log.Fatal(r.Run(":8081"))
+
+	r.HEAD("/kv/:key", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
+		exists, err := keyExists(c.Request.Context(), c.Param("key"))
+		switch {
+		case err != nil:
+			c.Status(kvhttp.FailureCode(err))
+		case !exists:
+			c.Status(404)
+		default:
+			c.Status(200)
+		}
+	})
+
+	r.GET("/kv/list", kvhttp.Deprecated("/v1/kv"), func(c *gin.Context) {
+		prefix := c.Query("prefix")
+		cursor, err := strconv.ParseUint(c.DefaultQuery("cursor", "0"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"message": "invalid cursor"})
+			return
+		}
+		limit, err := strconv.ParseInt(c.DefaultQuery("limit", "0"), 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"message": "invalid limit"})
+			return
+		}
+
+		keys, next, err := listKeys(c.Request.Context(), prefix, cursor, limit)
+		if err != nil {
+			c.JSON(kvhttp.FailureCode(err), gin.H{"message": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"keys": keys, "next_cursor": next})
+	})
+	return r
+}
+
+// putValue writes the value of body. The span records the ttl of the request
+// as it came, where 0 is the default of one minute.
+func putValue(ctx context.Context, key string, body kvhttp.PutBody) (int64, error) {
+	// the keys of a tenant live under its prefix
+	key = tenant.Prefix(ctx) + key
+	tracer := otel.Tracer("service2")
+	ctx, span := tracer.Start(ctx, "redis.set")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("redis.key", key),
+		attribute.Int64("kv.ttl_seconds", body.TTLSeconds),
+	)
+	if body.Version != nil {
+		span.SetAttributes(attribute.Int64("kv.expected_version", *body.Version))
+	}
+
+	opts := body.Options()
+	opts.Quota = quotas.For(ctx)
+	version, err := kvstore.Put(ctx, redisClient, key, body.Value, opts).Result()
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return version, err
+	}
+	span.SetAttributes(attribute.Int64("kv.version", version))
+	return version, nil
+}
+
+func getValue(ctx context.Context, key string) (string, int64, error) {
+	key = tenant.Prefix(ctx) + key
+	tracer := otel.Tracer("service2")
+	ctx, span := tracer.Start(ctx, "redis.get")
+	defer span.End()
+	span.SetAttributes(attribute.String("redis.key", key))
+
+	value, version, err := kvstore.Get(ctx, redisClient, key)
+	if err != nil {
+		if !errors.Is(err, kvstore.ErrNotFound) {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return "", 0, err
+	}
+	span.SetAttributes(attribute.Int64("kv.version", version))
+	return value, version, nil
+}
+
+func deleteKey(ctx context.Context, key string) (bool, error) {
+	key = tenant.Prefix(ctx) + key
+	tracer := otel.Tracer("service2")
+	ctx, span := tracer.Start(ctx, "redis.del")
+	defer span.End()
+	span.SetAttributes(attribute.String("redis.key", key))
+
+	deleted, err := kvstore.Delete(ctx, redisClient, key, quotas.For(ctx))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return false, err
+	}
+	span.SetAttributes(attribute.Bool("kv.deleted", deleted))
+	return deleted, nil
+}
+
+func keyExists(ctx context.Context, key string) (bool, error) {
+	key = tenant.Prefix(ctx) + key
+	tracer := otel.Tracer("service2")
+	ctx, span := tracer.Start(ctx, "redis.exists")
+	defer span.End()
+	span.SetAttributes(attribute.String("redis.key", key))
+
+	exists, err := kvstore.Exists(ctx, redisClient, key)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return false, err
+	}
+	span.SetAttributes(attribute.Bool("kv.exists", exists))
+	return exists, nil
+}
+
+func listKeys(ctx context.Context, prefix string, cursor uint64, limit int64) ([]string, uint64, error) {
+	namespace := tenant.Prefix(ctx)
+	prefix = namespace + prefix
+	tracer := otel.Tracer("service2")
+	ctx, span := tracer.Start(ctx, "redis.scan")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("kv.prefix", prefix),
+		attribute.Int64("kv.cursor", int64(cursor)),
+		attribute.Int64("kv.limit", limit),
+	)
+
+	keys, next, err := kvstore.List(ctx, redisClient, prefix, cursor, limit)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, 0, err
+	}
+	span.SetAttributes(
+		attribute.Int("kv.keys", len(keys)),
+		attribute.Int64("kv.next_cursor", int64(next)),
+	)
+	if keys == nil {
+		keys = []string{}
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, namespace)
+	}
+	return keys, next, nil
+}
+
+// putForm reads a put of value with the optional ttl (seconds, 0 for one
+// minute, negative for no expiry) and version (expected version, 0 for a new
+// key) form fields.
+func putForm(c *gin.Context, value string) (kvhttp.PutBody, error) {
+	body := kvhttp.PutBody{Value: value}
+	var err error
+	if body.TTLSeconds, err = strconv.ParseInt(c.DefaultPostForm("ttl", "0"), 10, 64); err != nil {
+		return body, errors.New("invalid ttl")
+	}
+	if v, ok := c.GetPostForm("version"); ok {
+		version, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return body, errors.New("invalid version")
+		}
+		body.Version = &version
+	}
+	return body, nil
+}
+
+func loadCerts() {
+	var err error
+	tlsCerts, err = tlsconfig.Load(tlsconfig.Files{
+		Cert:       *tlsCert,
+		Key:        *tlsKey,
+		CA:         *tlsCA,
+		ClientAuth: *tlsClientAuth,
+		Use:        tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
+	}
+}
+
+// startPutWorker applies the puts that service1 queues in the background, as
+// a consumer of the group of service2. Each put gets a trace of its own, which
+// starts with the consumer span.
+func startPutWorker() {
+	opts := putqueue.DefaultOptions()
+	opts.Stream = *putStream
+	opts.MinIdle = *putMinIdle
+	opts.MaxDeliveries = *putDeliveries
+	// a read waits for new puts within the bound of -redis-timeout
+	if *redisTimeout > 0 && opts.Block >= *redisTimeout {
+		opts.Block = *redisTimeout / 2
+	}
+	consumer := putqueue.NewConsumer(redisClient, opts, applyPut, messaging.OTel(otel.Tracer("service2")), meterProvider)
+	go consumer.Run(context.Background())
+}
+
+// applyPut applies a put that service1 queued like a PUT of a client.
+func applyPut(ctx context.Context, p putqueue.Put) error {
+	_, err := putValue(ctx, p.Key, kvhttp.PutBody{Value: p.Value, TTLSeconds: p.TTLSeconds, Version: p.ExpectedVersion})
+	return err
 }
 
 func createRedisClient() {
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
+	addrs := redisclient.ParseAddrs(*redisAddrs)
+	if len(addrs) == 0 {
+		log.Fatalf("invalid -redis-addrs: %q\n", *redisAddrs)
+	}
+	// with TLS, every node is verified against the host of the first address
+	var err error
+	redisClient, err = redisclient.New(redisclient.Config{
+		Mode:                  *redisMode,
+		Addrs:                 addrs,
+		MasterName:            *redisMaster,
+		PoolSize:              *redisPool,
+		MinIdleConns:          *redisMinIdle,
+		PoolTimeout:           *redisPoolWait,
+		DialTimeout:           *redisDial,
+		ReadTimeout:           *redisRead,
+		WriteTimeout:          *redisWrite,
+		ContextTimeoutEnabled: true,
+		TLSConfig:             tlsCerts.ClientConfig("redis", addrs[0]),
+		Report:                redisclient.OTel,
+		MeterProvider:         meterProvider,
 	})
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	err := redisClient.Ping(ctx).Err()
 	if err != nil {
-		log.Fatalf("redis ping error: %+v\n", err)
+		log.Fatalf("invalid Redis configuration: %+v\n", err)
+	}
+	// serve without Redis rather than exit, /readyz failing until it answers
+	if err := redisclient.Wait(context.Background(), redisClient, *redisWait); err != nil {
+		log.Printf("redis ping error: %+v\n", err)
+	}
+	// every command gets what is left of the request's budget, up to
+	// -redis-timeout, and records it on its span
+	redisClient.AddHook(deadline.RedisHook(*redisTimeout, deadline.OTel))
+	if *redisDelay > 0 {
+		redisClient.AddHook(deadline.SlowRedis(*redisDelay))
 	}
 }
 
@@ -104,18 +530,29 @@ func initTracer() func() {
 	}
 
 	conn, err := grpc.Dial("127.0.0.1:4317",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "collector", "127.0.0.1:4317")),
 		grpc.WithBlock(),
 	)
 	if err != nil {
 		panic(err)
 	}
+	collectorConn = conn
 	traceExporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithGRPCConn(conn))
 	if err != nil {
 		panic(err)
 	}
+	redactor, err := redact.Load(*redactPolicy)
+	if err != nil {
+		log.Fatalf("invalid -redact-policy: %v", err)
+	}
 	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(traceExporter),
+		// every span of a tenant's request records the tenant
+		sdktrace.WithSpanProcessor(tenant.SpanProcessor()),
+		// and the baggage members that -baggage-attributes allows
+		sdktrace.WithSpanProcessor(baggageattr.SpanProcessor(baggagePolicy)),
+		// redact before the batch holds on to the spans, by default KV values
+		// never leave the process
+		sdktrace.WithSpanProcessor(redact.SpanProcessor(redactor, sdktrace.NewBatchSpanProcessor(traceExporter))),
 		sdktrace.WithResource(res),
 	)
 	otel.SetTracerProvider(tracerProvider)
@@ -130,3 +567,35 @@ func initTracer() func() {
 		}
 	}
 }
+
+// initMeter exports the metrics of the service on the connection to the
+// collector that initTracer opened.
+func initMeter() func() {
+	ctx := context.Background()
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName("service2"),
+			semconv.ServiceVersion("1.0.0"),
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	metricExporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithGRPCConn(collectorConn))
+	if err != nil {
+		panic(err)
+	}
+
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(provider)
+	meterProvider = provider
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-service-tracing/internal/deadline"
+	"go-service-tracing/internal/readiness"
+	"go-service-tracing/internal/tenant"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// startServer serves the routes of main on Redis in memory.
+func startServer(t *testing.T) (http.Handler, *miniredis.Miniredis) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	mr := miniredis.RunT(t)
+	redisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+	timeouts, err := deadline.ParseTimeouts("default=2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newRouter(readiness.NewChecker(), timeouts), mr
+}
+
+// certifiedPeer is the TLS state of a caller that presented a client
+// certificate, as service1 does over mTLS.
+var certifiedPeer = tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+
+// do sends a request for the tenant id, none if empty, as service1 passes it
+// on in the baggage over mTLS.
+func do(h http.Handler, id, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if id != "" {
+		req.Header.Set("baggage", tenant.BaggageKey+"="+id)
+	}
+	req.TLS = &certifiedPeer
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// Tenants, and the callers without one, neither read nor list each other's
+// keys.
+func TestTenantIsolation(t *testing.T) {
+	h, mr := startServer(t)
+	defer func(prev tenant.Quotas) { quotas = prev }(quotas)
+	quotas = tenant.Quotas{Default: 10}
+
+	if w := do(h, "acme", http.MethodPut, "/v1/kv/secret", `{"value":"a"}`); w.Code != http.StatusOK {
+		t.Fatalf("acme PUT: %d %s", w.Code, w.Body)
+	}
+	if w := do(h, "", http.MethodPut, "/v1/kv/plain", `{"value":"n"}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT without tenant: %d %s", w.Code, w.Body)
+	}
+	if mr.HGet("{acme}:secret", "value") != "a" || mr.HGet("{_}:plain", "value") != "n" {
+		t.Fatalf("keys = %v, want {acme}:secret and {_}:plain", mr.Keys())
+	}
+
+	if w := do(h, "acme", http.MethodGet, "/v1/kv/secret", ""); w.Code != http.StatusOK {
+		t.Fatalf("acme GET: %d %s", w.Code, w.Body)
+	}
+	for _, get := range []struct{ tenant, key string }{
+		{"globex", "secret"},
+		{"", "secret"},
+		{"", "{acme}:secret"},
+		{"acme", "plain"},
+		{"acme", "{_}:plain"},
+		{"acme", "%23quota:%7Bacme%7D"},
+	} {
+		if w := do(h, get.tenant, http.MethodGet, "/v1/kv/"+get.key, ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s for %q: %d, want 404", get.key, get.tenant, w.Code)
+		}
+	}
+	if w := do(h, "globex", http.MethodDelete, "/v1/kv/secret", ""); w.Code != http.StatusNotFound {
+		t.Errorf("globex DELETE: %d, want 404", w.Code)
+	}
+
+	// a caller without a client certificate cannot name a tenant
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/v1/kv/secret", strings.NewReader(`{"value":"b"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("baggage", tenant.BaggageKey+"=acme")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s for acme without a certificate: %d, want 403", method, w.Code)
+		}
+	}
+	if mr.HGet("{acme}:secret", "value") != "a" {
+		t.Errorf("acme:secret = %q after the uncertified requests, want a", mr.HGet("{acme}:secret", "value"))
+	}
+
+	for id, want := range map[string]string{"acme": "secret", "globex": "", "": "plain"} {
+		w := do(h, id, http.MethodGet, "/v1/kv", "")
+		var list struct {
+			Keys []string `json:"keys"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatalf("list for %q: %d %s", id, w.Code, w.Body)
+		}
+		if got := strings.Join(list.Keys, ","); got != want {
+			t.Errorf("list for %q = %q, want %q", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Command gateway serves the Storage service of service1 as HTTP/JSON through
+// grpc-gateway, e.g. PUT /v1/kv/{key} with a body of {"value": "..."}.
+//
+// Callers may send their trace context as W3C traceparent or B3 headers; the
+// gateway continues that trace and passes it on to service1.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/grpctrace"
+	"go-service-tracing/internal/tenant"
+	"go-service-tracing/internal/tlsconfig"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+var (
+	tlsCerts *tlsconfig.Reloader
+
+	addr          = flag.String("addr", ":8080", "HTTP listen address")
+	service1Addr  = flag.String("service1", "localhost:8082", "address of the service1 gRPC server")
+	tlsUse        = flag.String("tls", "", "connections that use TLS, any of server,service1,collector")
+	tlsCert       = flag.String("tls-cert", "", "certificate file of the gateway, served and presented to peers that ask for one")
+	tlsKey        = flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA         = flag.String("tls-ca", "", "CA file to verify peers against, the system roots if empty")
+	tlsClientAuth = flag.Bool("tls-client-auth", false, "require clients to present a certificate signed by -tls-ca")
+)
+
+func main() {
+	flag.Parse()
+	loadCerts()
+
+	shutdown := initTracer()
+	defer shutdown()
+
+	conn, err := grpc.Dial(*service1Addr,
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "service1", *service1Addr)),
+		grpc.WithStatsHandler(grpctrace.NewClientHandler(grpctrace.Options{})),
+	)
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	mux := runtime.NewServeMux(
+		runtime.WithMiddlewares(routeSpanName),
+		runtime.WithIncomingHeaderMatcher(incomingHeader),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeader),
+	)
+	if err := storagev1.RegisterStorageHandler(context.Background(), mux, conn); err != nil {
+		log.Fatalf("register gateway error: %v", err)
+	}
+
+	// otelhttp extracts the caller's trace context with the global
+	// propagator; the gateway handlers then call service1 within the server
+	// span, so the gRPC client span continues the same trace
+	handler := otelhttp.NewHandler(mux, "gateway")
+
+	log.Printf("gateway listening at %v", *addr)
+	if err := tlsconfig.ListenAndServe(tlsCerts, *addr, handler); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+// routeSpanName names the server span after the path template of the matched
+// HTTP rule, e.g. "GET /v1/kv/{key}". otelhttp only sees the raw path, which
+// would give every key a span name of its own.
+func routeSpanName(next runtime.HandlerFunc) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		if pattern, ok := runtime.HTTPPattern(r.Context()); ok {
+			route := httpRoute(pattern)
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		next(w, r, pathParams)
+	}
+}
+
+// httpRoute returns the path template as written in the HTTP rule. Pattern
+// spells single-segment variables out as {key=*}.
+func httpRoute(pattern runtime.Pattern) string {
+	return strings.ReplaceAll(pattern.String(), "=*}", "}")
+}
+
+// incomingHeader passes the X-Tenant-Id header on to service1 as metadata,
+// next to the headers grpc-gateway forwards by default.
+func incomingHeader(key string) (string, bool) {
+	if http.CanonicalHeaderKey(key) == tenant.Header {
+		return key, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// outgoingHeader answers the retry-after of a rate limited call as the
+// Retry-After header, and the rest of the metadata as grpc-gateway does by
+// default.
+func outgoingHeader(key string) (string, bool) {
+	if key == "retry-after" {
+		return "Retry-After", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+func loadCerts() {
+	var err error
+	tlsCerts, err = tlsconfig.Load(tlsconfig.Files{
+		Cert:       *tlsCert,
+		Key:        *tlsKey,
+		CA:         *tlsCA,
+		ClientAuth: *tlsClientAuth,
+		Use:        tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
+	}
+}
+
+func initTracer() func() {
+	ctx := context.Background()
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName("gateway"),
+			semconv.ServiceVersion("1.0.0"),
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	conn, err := grpc.Dial("localhost:4317",
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "collector", "localhost:4317")),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	traceExporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithGRPCConn(conn))
+	if err != nil {
+		panic(err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	// B3 comes last so that it wins over traceparent when a caller sends
+	// both. Downstream gets both formats as well.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+		b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)),
+	))
+
+	return func() {
+		if err := tracerProvider.Shutdown(context.Background()); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -1,33 +1,138 @@
+//go:build ignore
+
+// Run with: go run client.go, and against a service started with -tls server
+// e.g. go run client.go -tls service1 -tls-ca ../../../certs/ca.pem
 package main
 
 import (
 	"context"
-	"go-service-tracing/jaeger/grpcexample/service1/service1"
+	"flag"
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/tlsconfig"
 	"log"
+	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+)
+
+var (
+	tlsCerts *tlsconfig.Reloader
+
+	tlsUse  = flag.String("tls", "", "connections that use TLS, any of service1,collector")
+	tlsCert = flag.String("tls-cert", "", "certificate file presented to servers that ask for one")
+	tlsKey  = flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA   = flag.String("tls-ca", "", "CA file to verify servers against, the system roots if empty")
 )
 
 func main() {
-	conn, err := grpc.NewClient("localhost:8082", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	flag.Parse()
+	loadCerts()
+	shutdown := initTracer()
+	defer shutdown()
+
+	conn, err := grpc.NewClient("localhost:8082",
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "service1", "localhost:8082")),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
 	if err != nil {
 		panic(err)
 	}
 	defer conn.Close()
 
-	client := service1.NewStorageClient(conn)
-	_, err = client.Put(context.Background(), &service1.PutRequest{
+	client := storagev1.NewStorageClient(conn)
+	tracer := otel.Tracer("client")
+
+	// the deadline travels with every call and shrinks at each hop
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	putCtx, span := tracer.Start(ctx, "client.Put")
+	log.Printf("put trace id: %s", span.SpanContext().TraceID())
+	_, err = client.Put(putCtx, &storagev1.PutRequest{
 		Key:   "test",
 		Value: "test2",
 	})
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
 		panic(err)
 	}
+	span.End()
 	log.Printf("put success")
-	resp, err := client.Get(context.Background(), &service1.GetRequest{Key: "test"})
+
+	getCtx, span := tracer.Start(ctx, "client.Get")
+	log.Printf("get trace id: %s", span.SpanContext().TraceID())
+	resp, err := client.Get(getCtx, &storagev1.GetRequest{Key: "test"})
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
 		panic(err)
 	}
+	span.End()
 	log.Printf("get success, value: %s", resp.Value)
 }
+
+func loadCerts() {
+	var err error
+	tlsCerts, err = tlsconfig.Load(tlsconfig.Files{
+		Cert: *tlsCert,
+		Key:  *tlsKey,
+		CA:   *tlsCA,
+		Use:  tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
+	}
+}
+
+func initTracer() func() {
+	ctx := context.Background()
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName("client"),
+			semconv.ServiceVersion("1.0.0"),
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	conn, err := grpc.Dial("localhost:4317",
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "collector", "localhost:4317")),
+		grpc.WithBlock(),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	traceExporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithGRPCConn(conn))
+	if err != nil {
+		panic(err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	// Shutdown flushes the batcher, so spans of a short-lived client are
+	// not lost when it exits.
+	return func() {
+		if err := tracerProvider.Shutdown(context.Background()); err != nil {
+			panic(err)
+		}
+	}
+}
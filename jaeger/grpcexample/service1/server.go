@@ -2,70 +2,447 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"flag"
+	"io"
 	"log"
 	"net"
+	"sync"
+	"time"
 
-	"go-service-tracing/jaeger/grpcexample/service1/service1"
-	"go-service-tracing/jaeger/grpcexample/service2/service2"
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/auth"
+	"go-service-tracing/internal/baggageattr"
+	"go-service-tracing/internal/breaker"
+	"go-service-tracing/internal/cache"
+	"go-service-tracing/internal/deadline"
+	"go-service-tracing/internal/grpctrace"
+	"go-service-tracing/internal/messaging"
+	"go-service-tracing/internal/putqueue"
+	"go-service-tracing/internal/ratelimit"
+	"go-service-tracing/internal/readiness"
+	"go-service-tracing/internal/redact"
+	"go-service-tracing/internal/redisclient"
+	"go-service-tracing/internal/retry"
+	"go-service-tracing/internal/tenant"
+	"go-service-tracing/internal/tlsconfig"
 
-	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 var (
-	svc2Client service2.StorageClient
+	tlsCerts      *tlsconfig.Reloader
+	svc2Conn      *grpc.ClientConn
+	svc2Client    storagev1.StorageClient
+	redisClient   redis.UniversalClient
+	collectorConn *grpc.ClientConn
+	meterProvider metric.MeterProvider
+	baggagePolicy baggageattr.Policy
+	valueCache    *cache.Cache
+	putQueue      *putqueue.Producer
+
+	messageEvents  = flag.Bool("message-events", false, "record a span event for every gRPC message")
+	publicEndpoint = flag.Bool("public-endpoint", false, "start a new trace per request, linked to the caller's span")
+	metrics        = flag.Bool("metrics", false, "export otelgrpc metrics to the collector as well")
+	batchSpans     = flag.String("batch-spans", "stream", "BatchPut spans: stream for one span per stream, message for a child span per message")
+	timeouts       = flag.String("timeouts", "default=2s", "timeouts per RPC, e.g. default=2s,/storage.v1.Storage/List=5s")
+	retries        = flag.Int("retries", 3, "attempts per call to service2, 1 to disable retries")
+	retryBackoff   = flag.Duration("retry-backoff", 50*time.Millisecond, "pause before the first retry, doubled for each one after")
+	retryPut       = flag.Bool("retry-put", false, "retry Put as well, which may write a value twice")
+	retryConfig    = flag.Bool("retry-service-config", false, "leave retries to grpc-go through a service config instead of an interceptor")
+	failureRate    = flag.Float64("breaker-failure-rate", 0.5, "share of failed calls to service2 that opens the circuit breaker, 0 to disable it")
+	openTimeout    = flag.Duration("breaker-open-timeout", 5*time.Second, "how long the circuit breaker stays open")
+	bulkhead       = flag.Int("bulkhead", 32, "calls to service2 in flight at once, 0 for no limit")
+	redactPolicy   = flag.String("redact-policy", "", "YAML policy that redacts span attributes, the built-in one if empty")
+	tlsUse         = flag.String("tls", "", "connections that use TLS, any of server,service2,collector,redis")
+	tlsCert        = flag.String("tls-cert", "", "certificate file of this service, served and presented to peers that ask for one")
+	tlsKey         = flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA          = flag.String("tls-ca", "", "CA file to verify peers against, the system roots if empty")
+	tlsClientAuth  = flag.Bool("tls-client-auth", false, "require clients to present a certificate signed by -tls-ca")
+	authConfig     = flag.String("auth", "", "authentication config file of API keys, HMAC keys and a JWKS, empty to leave the API open")
+	requireTenant  = flag.Bool("require-tenant", false, "reject requests without a tenant")
+	baggageAttrs   = flag.String("baggage-attributes", "", "baggage members recorded on every span and the only ones kept from clients, e.g. user.tier,experiment.*")
+	baggageMax     = flag.Int("baggage-max-members", baggageattr.DefaultMaxMembers, "most baggage members kept, 0 for no limit")
+	baggageValue   = flag.Int("baggage-max-value", baggageattr.DefaultMaxValueLength, "longest baggage value kept, 0 for no limit")
+	rateLimit      = flag.Float64("rate-limit", 0, "requests per second per client, 0 for no limit")
+	rateBurst      = flag.Int("rate-burst", 20, "requests a client may make at once before -rate-limit applies")
+	rateLimitKey   = flag.String("rate-limit-key", "ip", "what a client is: ip, principal or tenant, the last two falling back to ip")
+	rateRedis      = flag.Bool("rate-limit-redis", false, "keep the buckets of the clients in Redis, shared by every replica of service1")
+	authRateLimit  = flag.Float64("auth-rate-limit", 50, "requests per second per client IP before authentication, which bounds the attempts at guessing credentials, 0 for no limit")
+	authRateBurst  = flag.Int("auth-rate-burst", 100, "requests a client IP may make at once before -auth-rate-limit applies")
+	redisMode      = flag.String("redis-mode", redisclient.ModeSingle, "Redis deployment: single, sentinel or cluster")
+	redisAddrs     = flag.String("redis-addrs", redisclient.DefaultAddr, "comma separated addresses of the Redis server, of the Sentinels or of some Cluster nodes")
+	redisMaster    = flag.String("redis-master", "", "master that the Sentinels watch, with -redis-mode sentinel")
+	redisPool      = flag.Int("redis-pool-size", 0, "most connections to each Redis node, 0 for 10 per CPU")
+	redisMinIdle   = flag.Int("redis-min-idle", 0, "idle connections to each Redis node kept open")
+	redisPoolWait  = flag.Duration("redis-pool-timeout", 0, "how long a Redis command waits for a connection when the pool is exhausted, 0 for the read timeout plus 1s")
+	redisDial      = flag.Duration("redis-dial-timeout", 0, "bound of connecting to Redis, 0 for 5s")
+	redisRead      = flag.Duration("redis-read-timeout", 0, "bound of reading a Redis reply, 0 for 3s")
+	redisWrite     = flag.Duration("redis-write-timeout", 0, "bound of writing a Redis command, 0 for the read timeout")
+	redisWait      = flag.Duration("redis-wait", redisclient.DefaultWait, "how long startup waits for Redis to answer before serving without it")
+	cacheSize      = flag.Int("cache-size", 0, "keys in the read-through cache of gets, 0 to disable it")
+	cacheTTL       = flag.Duration("cache-ttl", 5*time.Second, "how long the cache serves a value")
+	asyncPut       = flag.Bool("async-put", false, "queue Put on a Redis Stream for the workers of service2 rather than forward it")
+	putStream      = flag.String("put-stream", putqueue.DefaultStream, "Redis Stream of the queued puts")
 )
 
 type server struct {
-	service1.UnimplementedStorageServer
+	storagev1.UnimplementedStorageServer
 }
 
-func (s *server) Put(ctx context.Context, req *service1.PutRequest) (*service1.PutResponse, error) {
+func (s *server) Put(ctx context.Context, req *storagev1.PutRequest) (*storagev1.PutResponse, error) {
 	tracer := otel.Tracer("service1")
 	ctx, span := tracer.Start(ctx, "service1.Put")
 	defer span.End()
 
-	_, err := svc2Client.Put(ctx, &service2.PutRequest{
-		Key:   req.Key,
-		Value: req.Value,
-	})
+	if putQueue != nil {
+		// the cache forgets the key once the put is applied, see main
+		return enqueue(ctx, req)
+	}
+	defer valueCache.Invalidate(tenant.Prefix(ctx) + req.Key)
+	resp, err := svc2Client.Put(ctx, req)
 	if err != nil {
-		return nil, fmt.Errorf("service2 put error: %v", err)
+		return nil, relayError("put", err)
 	}
 
-	return &service1.PutResponse{}, nil
+	return resp, nil
 }
 
-func (s *server) Get(ctx context.Context, req *service1.GetRequest) (*service1.GetResponse, error) {
+func (s *server) Get(ctx context.Context, req *storagev1.GetRequest) (*storagev1.GetResponse, error) {
 	tracer := otel.Tracer("service1")
 	ctx, span := tracer.Start(ctx, "service1.Get")
 	defer span.End()
 
-	resp, err := svc2Client.Get(ctx, &service2.GetRequest{
-		Key: req.Key,
+	v, err := valueCache.Get(ctx, tenant.Prefix(ctx)+req.Key, func(ctx context.Context) (cache.Value, error) {
+		resp, err := svc2Client.Get(ctx, req)
+		if err != nil {
+			return cache.Value{}, err
+		}
+		return cache.Value{Value: resp.Value, Version: resp.Version}, nil
+	})
+	if err != nil {
+		return nil, relayError("get", err)
+	}
+
+	return &storagev1.GetResponse{Value: v.Value, Version: v.Version}, nil
+}
+
+func (s *server) Delete(ctx context.Context, req *storagev1.DeleteRequest) (*storagev1.DeleteResponse, error) {
+	tracer := otel.Tracer("service1")
+	ctx, span := tracer.Start(ctx, "service1.Delete")
+	defer span.End()
+
+	defer valueCache.Invalidate(tenant.Prefix(ctx) + req.Key)
+	resp, err := svc2Client.Delete(ctx, req)
+	if err != nil {
+		return nil, relayError("delete", err)
+	}
+
+	return resp, nil
+}
+
+func (s *server) Exists(ctx context.Context, req *storagev1.ExistsRequest) (*storagev1.ExistsResponse, error) {
+	tracer := otel.Tracer("service1")
+	ctx, span := tracer.Start(ctx, "service1.Exists")
+	defer span.End()
+
+	resp, err := svc2Client.Exists(ctx, req)
+	if err != nil {
+		return nil, relayError("exists", err)
+	}
+
+	return resp, nil
+}
+
+func (s *server) List(ctx context.Context, req *storagev1.ListRequest) (*storagev1.ListResponse, error) {
+	tracer := otel.Tracer("service1")
+	ctx, span := tracer.Start(ctx, "service1.List")
+	defer span.End()
+
+	resp, err := svc2Client.List(ctx, req)
+	if err != nil {
+		return nil, relayError("list", err)
+	}
+
+	return resp, nil
+}
+
+// enqueue adds a put to the stream of the workers of service2 and answers
+// with the ID of its entry in the put-id header. The version of the
+// response is 0, as the put is applied later.
+func enqueue(ctx context.Context, req *storagev1.PutRequest) (*storagev1.PutResponse, error) {
+	tenantID, _ := tenant.FromContext(ctx)
+	id, err := putQueue.Add(ctx, putqueue.Put{
+		Tenant:          tenantID,
+		Key:             req.Key,
+		Value:           req.Value,
+		TTLSeconds:      req.TtlSeconds,
+		ExpectedVersion: req.ExpectedVersion,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("service2 get error: %v", err)
+		return nil, status.Errorf(grpccodes.Unavailable, "queue put error: %v", err)
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("put-id", id))
+	return &storagev1.PutResponse{}, nil
+}
+
+// relayError keeps the status code and details of a service2 error, so that
+// callers can still tell a missing key, a version conflict or an exhausted
+// quota from a failure.
+func relayError(op string, err error) error {
+	st := status.Convert(err).Proto()
+	st.Message = "service2 " + op + " error: " + st.Message
+	return status.FromProto(st).Err()
+}
+
+// Watch relays service2's event stream. Every relayed event gets its own span,
+// linked to the Put that produced it.
+func (s *server) Watch(req *storagev1.WatchRequest, stream storagev1.Storage_WatchServer) error {
+	ctx := stream.Context()
+	events, err := svc2Client.Watch(ctx, req)
+	if err != nil {
+		return relayError("watch", err)
 	}
 
-	return &service1.GetResponse{
-		Value: resp.Value,
-	}, nil
+	tracer := otel.Tracer("service1")
+	for {
+		event, err := events.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return relayError("watch", err)
+		}
+
+		putCtx := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(event.TraceContext))
+		_, span := tracer.Start(ctx, "service1.Watch.event",
+			trace.WithLinks(trace.LinkFromContext(putCtx)),
+			trace.WithAttributes(attribute.String("kv.key", event.Key)),
+		)
+		err = stream.Send(event)
+		span.End()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// BatchPut relays the stream to service2 and its results back. Results come
+// back in request order, so with -batch-spans message the span of a request
+// ends when the next result arrives.
+func (s *server) BatchPut(stream storagev1.Storage_BatchPutServer) error {
+	tracer := otel.Tracer("service1")
+	ctx, span := tracer.Start(stream.Context(), "service1.BatchPut")
+	defer span.End()
+
+	upstreamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	upstream, err := svc2Client.BatchPut(upstreamCtx)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return relayError("batch put", err)
+	}
+
+	var (
+		counts batchCounts
+		mu     sync.Mutex
+		spans  []trace.Span
+		// keys of the requests still waiting for their result
+		keys []string
+		// stopped is set once the stream ends; the forwarding stops then, as
+		// it may still be waiting for a request of the client
+		stopped bool
+	)
+	defer func() {
+		cancel()
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		for _, msgSpan := range spans {
+			msgSpan.End()
+		}
+		// the writes may have happened without their result coming back
+		for _, key := range keys {
+			valueCache.Invalidate(tenant.Prefix(ctx) + key)
+		}
+		span.SetAttributes(counts.attributes()...)
+	}()
+
+	forwardErr := make(chan error, 1)
+	go func() {
+		var index int64
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				forwardErr <- upstream.CloseSend()
+				return
+			}
+			if err != nil {
+				forwardErr <- err
+				return
+			}
+
+			mu.Lock()
+			if stopped {
+				mu.Unlock()
+				forwardErr <- nil
+				return
+			}
+			counts.received++
+			counts.receivedBytes += int64(proto.Size(req))
+			keys = append(keys, req.Key)
+			if *batchSpans == "message" {
+				_, msgSpan := tracer.Start(ctx, "service1.BatchPut.message",
+					trace.WithAttributes(
+						attribute.Int64("rpc.message.id", index),
+						attribute.Int("rpc.message.uncompressed_size", proto.Size(req)),
+						attribute.String("kv.key", req.Key),
+					),
+				)
+				spans = append(spans, msgSpan)
+			}
+			mu.Unlock()
+			index++
+
+			err = upstream.Send(req)
+			if err != nil {
+				// the reason is returned by upstream.Recv
+				forwardErr <- nil
+				return
+			}
+		}
+	}()
+
+	for {
+		result, err := upstream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return relayError("batch put", err)
+		}
+
+		mu.Lock()
+		if len(spans) > 0 {
+			if result.Error != "" {
+				spans[0].SetStatus(codes.Error, result.Error)
+			}
+			spans[0].End()
+			spans = spans[1:]
+		}
+		if result.Error != "" {
+			counts.errors++
+		}
+		if len(keys) > 0 {
+			valueCache.Invalidate(tenant.Prefix(ctx) + keys[0])
+			keys = keys[1:]
+		}
+		mu.Unlock()
+
+		err = stream.Send(result)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+
+		mu.Lock()
+		counts.sent++
+		counts.sentBytes += int64(proto.Size(result))
+		mu.Unlock()
+	}
+
+	if err := <-forwardErr; err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// batchCounts is recorded on the span of a BatchPut stream.
+type batchCounts struct {
+	received, receivedBytes int64
+	sent, sentBytes         int64
+	errors                  int64
+}
+
+func (c batchCounts) attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int64("rpc.batch.messages_received", c.received),
+		attribute.Int64("rpc.batch.bytes_received", c.receivedBytes),
+		attribute.Int64("rpc.batch.messages_sent", c.sent),
+		attribute.Int64("rpc.batch.bytes_sent", c.sentBytes),
+		attribute.Int64("rpc.batch.errors", c.errors),
+	}
 }
 
 func main() {
+	flag.Parse()
+	loadCerts()
+	baggagePolicy = baggageattr.Policy{
+		Allow:          baggageattr.ParseAllow(*baggageAttrs),
+		MaxMembers:     *baggageMax,
+		MaxValueLength: *baggageValue,
+	}
+	authenticator, err := auth.Load(*authConfig)
+	if err != nil {
+		log.Fatalf("invalid -auth: %v", err)
+	}
+	if *batchSpans != "stream" && *batchSpans != "message" {
+		log.Fatalf("unknown -batch-spans %q", *batchSpans)
+	}
+	rpcTimeouts, err := deadline.ParseTimeouts(*timeouts)
+	if err != nil {
+		log.Fatalf("invalid -timeouts: %v", err)
+	}
+
 	shutdown := initTracer()
 	defer shutdown()
 
-	createService2Client()
+	traceOpts := grpctrace.Options{
+		Filter:         grpctrace.ExcludeHealthChecks,
+		MessageEvents:  *messageEvents,
+		PublicEndpoint: *publicEndpoint,
+	}
+	shutdownMeter := initMeter()
+	defer shutdownMeter()
+	if *metrics {
+		traceOpts.MeterProvider = meterProvider
+	}
+
+	createService2Client(traceOpts)
+	if *rateRedis || *asyncPut {
+		createRedisClient()
+	}
+	if *asyncPut {
+		putQueue = putqueue.NewProducer(redisClient, *putStream, messaging.OTel(otel.Tracer("service1")))
+	}
+	authThrottle := createAuthThrottle(authenticator != nil)
+	throttle := createThrottle()
+	valueCache = cache.New(*cacheSize, *cacheTTL, cache.OTel(otel.Tracer("service1")), meterProvider)
+	// a queued put leaves the cache once a worker of service2 applied it
+	if putQueue != nil && valueCache != nil {
+		go putqueue.Applied(context.Background(), redisClient, *putStream, valueCache.Invalidate)
+	}
 
 	lis, err := net.Listen("tcp", ":8082")
 	if err != nil {
@@ -73,10 +450,40 @@ func main() {
 	}
 
 	s := grpc.NewServer(
-		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
-		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
+		grpc.Creds(tlsconfig.ServerCredentials(tlsCerts)),
+		grpc.StatsHandler(grpctrace.NewServerHandler(traceOpts)),
+		// clients keep only the baggage that -baggage-attributes allows
+		grpc.ChainUnaryInterceptor(
+			baggageattr.StripUnaryServerInterceptor(baggagePolicy),
+			authThrottle.UnaryServerInterceptor(),
+			auth.UnaryServerInterceptor(authenticator, auth.OTel),
+			tenant.UnaryServerInterceptor(*requireTenant, tenant.OTel),
+			throttle.UnaryServerInterceptor(),
+			deadline.UnaryServerInterceptor(rpcTimeouts, deadline.OTel),
+		),
+		grpc.ChainStreamInterceptor(
+			baggageattr.StripStreamServerInterceptor(baggagePolicy),
+			authThrottle.StreamServerInterceptor(),
+			auth.StreamServerInterceptor(authenticator, auth.OTel),
+			tenant.StreamServerInterceptor(*requireTenant, tenant.OTel),
+			throttle.StreamServerInterceptor(),
+		),
 	)
-	service1.RegisterStorageServer(s, &server{})
+	storagev1.RegisterStorageServer(s, &server{})
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	reflection.Register(s)
+
+	// service1 is only as ready as service2, whose health checks are
+	// filtered out of the traces like those of service1's own callers
+	checker := readiness.NewChecker()
+	if redisClient != nil {
+		checker.Add("redis", readiness.Redis(redisClient))
+	}
+	checker.Add("service2", readiness.GRPCHealth(svc2Conn, storagev1.Storage_ServiceDesc.ServiceName))
+	checker.Add("collector", readiness.GRPCConn(collectorConn))
+	checker.Serve(healthServer, "", storagev1.Storage_ServiceDesc.ServiceName)
+	checker.Start(context.Background())
 
 	log.Printf("server listening at %v", lis.Addr())
 	if err := s.Serve(lis); err != nil {
@@ -84,16 +491,135 @@ func main() {
 	}
 }
 
-func createService2Client() {
-	conn, err := grpc.Dial("localhost:8081",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
-		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()),
-	)
+func createService2Client(traceOpts grpctrace.Options) {
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "service2", "localhost:8081")),
+		grpc.WithStatsHandler(grpctrace.NewClientHandler(traceOpts)),
+	}
+	conn, err := grpc.Dial("localhost:8081", append(opts, service2Options()...)...)
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
-	svc2Client = service2.NewStorageClient(conn)
+	svc2Conn = conn
+	svc2Client = storagev1.NewStorageClient(conn)
+}
+
+// service2Options retry failed calls to service2 within their deadline,
+// either with an interceptor that records every attempt or through grpc-go's
+// own retry support. Every attempt passes the circuit breaker and the
+// bulkhead, and gets the remaining budget less what service1 needs to answer.
+func service2Options() []grpc.DialOption {
+	policy := retry.DefaultPolicy()
+	policy.MaxAttempts = *retries
+	policy.InitialBackoff = *retryBackoff
+	policy.NonIdempotent = *retryPut
+
+	settings := breaker.DefaultSettings()
+	settings.FailureRate = *failureRate
+	settings.OpenTimeout = *openTimeout
+	tracer := otel.Tracer("service1")
+	guard := breaker.NewGuard("service2", settings, breaker.NewBulkhead(*bulkhead, 0), breaker.OTel(tracer), meterProvider)
+
+	if *retryConfig {
+		service := storagev1.File_storage_v1_storage_proto.Services().ByName("Storage")
+		config, err := retry.ServiceConfig(policy, service)
+		if err != nil {
+			log.Fatalf("invalid -retry-backoff: %v", err)
+		}
+		return []grpc.DialOption{
+			grpc.WithDefaultServiceConfig(config),
+			grpc.WithChainUnaryInterceptor(
+				breaker.UnaryClientInterceptor(guard),
+				deadline.UnaryClientInterceptor(),
+			),
+		}
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(
+			retry.UnaryClientInterceptor(policy, retry.OTel(tracer)),
+			breaker.UnaryClientInterceptor(guard),
+			deadline.UnaryClientInterceptor(),
+		),
+	}
+}
+
+func loadCerts() {
+	var err error
+	tlsCerts, err = tlsconfig.Load(tlsconfig.Files{
+		Cert:       *tlsCert,
+		Key:        *tlsKey,
+		CA:         *tlsCA,
+		ClientAuth: *tlsClientAuth,
+		Use:        tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
+	}
+}
+
+// createThrottle returns the rate limiter of the API, nil if -rate-limit is 0.
+func createThrottle() *ratelimit.Throttle {
+	key, err := ratelimit.ParseKey(*rateLimitKey)
+	if err != nil {
+		log.Fatalf("invalid -rate-limit-key: %v", err)
+	}
+	if *rateLimit <= 0 {
+		return nil
+	}
+	if *rateBurst < 1 {
+		log.Fatalf("invalid -rate-burst: %d", *rateBurst)
+	}
+	return newThrottle(ratelimit.Limit{Rate: *rateLimit, Burst: *rateBurst}, key)
+}
+
+// createAuthThrottle returns the rate limiter of the requests by client IP
+// before they are authenticated, nil if they are not or -auth-rate-limit is 0.
+func createAuthThrottle(authenticated bool) *ratelimit.Throttle {
+	if !authenticated || *authRateLimit <= 0 {
+		return nil
+	}
+	if *authRateBurst < 1 {
+		log.Fatalf("invalid -auth-rate-burst: %d", *authRateBurst)
+	}
+	return newThrottle(ratelimit.Limit{Rate: *authRateLimit, Burst: *authRateBurst}, ratelimit.KeyUnauthenticated)
+}
+
+func newThrottle(limit ratelimit.Limit, key ratelimit.Key) *ratelimit.Throttle {
+	var limiter ratelimit.Limiter = ratelimit.NewLocal(limit)
+	if *rateRedis {
+		limiter = ratelimit.Redis(redisClient, limit)
+	}
+	return ratelimit.New(limiter, key, ratelimit.OTel, meterProvider)
+}
+
+func createRedisClient() {
+	addrs := redisclient.ParseAddrs(*redisAddrs)
+	if len(addrs) == 0 {
+		log.Fatalf("invalid -redis-addrs: %q", *redisAddrs)
+	}
+	// with TLS, every node is verified against the host of the first address
+	var err error
+	redisClient, err = redisclient.New(redisclient.Config{
+		Mode:          *redisMode,
+		Addrs:         addrs,
+		MasterName:    *redisMaster,
+		PoolSize:      *redisPool,
+		MinIdleConns:  *redisMinIdle,
+		PoolTimeout:   *redisPoolWait,
+		DialTimeout:   *redisDial,
+		ReadTimeout:   *redisRead,
+		WriteTimeout:  *redisWrite,
+		TLSConfig:     tlsCerts.ClientConfig("redis", addrs[0]),
+		Report:        redisclient.OTel,
+		MeterProvider: meterProvider,
+	})
+	if err != nil {
+		log.Fatalf("invalid Redis configuration: %v", err)
+	}
+	// serve without Redis rather than exit, /readyz failing until it answers
+	if err := redisclient.Wait(context.Background(), redisClient, *redisWait); err != nil {
+		log.Printf("redis ping error: %v", err)
+	}
 }
 
 func initTracer() func() {
@@ -109,20 +635,31 @@ func initTracer() func() {
 	}
 
 	conn, err := grpc.Dial("localhost:4317",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "collector", "localhost:4317")),
 		grpc.WithBlock(),
 	)
 	if err != nil {
 		panic(err)
 	}
+	collectorConn = conn
 
 	traceExporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithGRPCConn(conn))
 	if err != nil {
 		panic(err)
 	}
 
+	redactor, err := redact.Load(*redactPolicy)
+	if err != nil {
+		log.Fatalf("invalid -redact-policy: %v", err)
+	}
 	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(traceExporter),
+		// every span of a tenant's request records the tenant
+		sdktrace.WithSpanProcessor(tenant.SpanProcessor()),
+		// and the baggage members that -baggage-attributes allows
+		sdktrace.WithSpanProcessor(baggageattr.SpanProcessor(baggagePolicy)),
+		// redact before the batch holds on to the spans, by default KV values
+		// never leave the process
+		sdktrace.WithSpanProcessor(redact.SpanProcessor(redactor, sdktrace.NewBatchSpanProcessor(traceExporter))),
 		sdktrace.WithResource(res),
 	)
 	otel.SetTracerProvider(tracerProvider)
@@ -137,3 +674,36 @@ func initTracer() func() {
 		}
 	}
 }
+
+// initMeter exports the metrics of the service on the connection to the
+// collector that initTracer opened.
+func initMeter() func() {
+	ctx := context.Background()
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName("service1"),
+			semconv.ServiceVersion("1.0.0"),
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	metricExporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithGRPCConn(collectorConn))
+	if err != nil {
+		panic(err)
+	}
+
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(provider)
+	meterProvider = provider
+
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			panic(err)
+		}
+	}
+}
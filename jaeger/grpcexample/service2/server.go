@@ -2,67 +2,518 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"strings"
 	"time"
 
-	"go-service-tracing/jaeger/grpcexample/service2/service2"
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/auth"
+	"go-service-tracing/internal/baggageattr"
+	"go-service-tracing/internal/deadline"
+	"go-service-tracing/internal/grpctrace"
+	"go-service-tracing/internal/kvstore"
+	"go-service-tracing/internal/messaging"
+	"go-service-tracing/internal/putqueue"
+	"go-service-tracing/internal/readiness"
+	"go-service-tracing/internal/redact"
+	"go-service-tracing/internal/redisclient"
+	"go-service-tracing/internal/tenant"
+	"go-service-tracing/internal/tlsconfig"
 
 	"github.com/redis/go-redis/v9"
 
-	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	watchChannel = "kv:watch:"
+
+	// maxPipeline caps how many BatchPut requests go into one Redis pipeline.
+	maxPipeline = 100
 )
 
 var (
-	redisClient *redis.Client
+	tlsCerts      *tlsconfig.Reloader
+	redisClient   redis.UniversalClient
+	collectorConn *grpc.ClientConn
+	meterProvider metric.MeterProvider
+	quotas        tenant.Quotas
+	baggagePolicy baggageattr.Policy
+
+	messageEvents  = flag.Bool("message-events", false, "record a span event for every gRPC message")
+	publicEndpoint = flag.Bool("public-endpoint", false, "start a new trace per request, linked to the caller's span")
+	metrics        = flag.Bool("metrics", false, "export otelgrpc metrics to the collector as well")
+	batchSpans     = flag.String("batch-spans", "stream", "BatchPut spans: stream for one span per stream, message for a child span per message")
+	timeouts       = flag.String("timeouts", "default=1s", "timeouts per RPC, e.g. default=1s,/storage.v1.Storage/List=3s")
+	redisTimeout   = flag.Duration("redis-timeout", 500*time.Millisecond, "upper bound of a Redis command")
+	redisDelay     = flag.Duration("redis-delay", 0, "hold every Redis command back this long, to try out timeouts")
+	redisMode      = flag.String("redis-mode", redisclient.ModeSingle, "Redis deployment: single, sentinel or cluster")
+	redisAddrs     = flag.String("redis-addrs", redisclient.DefaultAddr, "comma separated addresses of the Redis server, of the Sentinels or of some Cluster nodes")
+	redisMaster    = flag.String("redis-master", "", "master that the Sentinels watch, with -redis-mode sentinel")
+	redisPool      = flag.Int("redis-pool-size", 0, "most connections to each Redis node, 0 for 10 per CPU")
+	redisMinIdle   = flag.Int("redis-min-idle", 0, "idle connections to each Redis node kept open")
+	redisPoolWait  = flag.Duration("redis-pool-timeout", 0, "how long a Redis command waits for a connection when the pool is exhausted, 0 for the read timeout plus 1s")
+	redisDial      = flag.Duration("redis-dial-timeout", 0, "bound of connecting to Redis, 0 for 5s")
+	redisRead      = flag.Duration("redis-read-timeout", 0, "bound of reading a Redis reply, 0 for 3s")
+	redisWrite     = flag.Duration("redis-write-timeout", 0, "bound of writing a Redis command, 0 for the read timeout")
+	redisWait      = flag.Duration("redis-wait", redisclient.DefaultWait, "how long startup waits for Redis to answer before serving without it")
+	redactPolicy   = flag.String("redact-policy", "", "YAML policy that redacts span attributes, the built-in one if empty")
+	tlsUse         = flag.String("tls", "", "connections that use TLS, any of server,collector,redis")
+	tlsCert        = flag.String("tls-cert", "", "certificate file of this service, served and presented to peers that ask for one")
+	tlsKey         = flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA          = flag.String("tls-ca", "", "CA file to verify peers against, the system roots if empty")
+	tlsClientAuth  = flag.Bool("tls-client-auth", false, "require clients to present a certificate signed by -tls-ca")
+	tenantQuotas   = flag.String("tenant-quotas", "", "maximum number of keys per tenant, e.g. default=1000,acme=50")
+	baggageAttrs   = flag.String("baggage-attributes", "", "baggage members recorded on every span, e.g. user.tier,experiment.*")
+	baggageMax     = flag.Int("baggage-max-members", baggageattr.DefaultMaxMembers, "most baggage members recorded, 0 for no limit")
+	baggageValue   = flag.Int("baggage-max-value", baggageattr.DefaultMaxValueLength, "longest baggage value recorded, 0 for no limit")
+	putWorker      = flag.Bool("put-worker", false, "apply the puts that service1 queues with -async-put")
+	putStream      = flag.String("put-stream", putqueue.DefaultStream, "Redis Stream of the queued puts")
+	putMinIdle     = flag.Duration("put-min-idle", putqueue.DefaultMinIdle, "how long a queued put that failed waits before it is delivered again")
+	putDeliveries  = flag.Int64("put-max-deliveries", putqueue.DefaultMaxDeliveries, "deliveries of a queued put before it moves to the dead letter stream")
 )
 
 type server struct {
-	service2.UnimplementedStorageServer
+	storagev1.UnimplementedStorageServer
 }
 
-func (s *server) Put(ctx context.Context, req *service2.PutRequest) (*service2.PutResponse, error) {
+func (s *server) Put(ctx context.Context, req *storagev1.PutRequest) (*storagev1.PutResponse, error) {
+	// the keys of a tenant live under its prefix
+	key := tenant.Prefix(ctx) + req.Key
 	tracer := otel.Tracer("service2")
-	ctx, span := tracer.Start(ctx, "redis.set")
+	setCtx, span := tracer.Start(ctx, "redis.set", trace.WithAttributes(putAttributes(key, req)...))
 	defer span.End()
 
-	err := redisClient.Set(ctx, req.Key, req.Value, time.Minute).Err()
+	version, err := kvstore.Put(setCtx, redisClient, key, req.Value, putOptions(ctx, req)).Result()
 	if err != nil {
-		return nil, fmt.Errorf("redis set error: %v", err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, storeError("set", err)
+	}
+	span.SetAttributes(attribute.Int64("kv.version", version))
+
+	// the value is stored whether watchers hear of it or not; failing the Put
+	// would have the client, or the worker of a queued put, write it again
+	if err := publish(ctx, key, req.Value); err != nil {
+		log.Printf("redis publish error: %v", err)
 	}
 
-	return &service2.PutResponse{}, nil
+	return &storagev1.PutResponse{Version: version}, nil
 }
 
-func (s *server) Get(ctx context.Context, req *service2.GetRequest) (*service2.GetResponse, error) {
+func (s *server) Get(ctx context.Context, req *storagev1.GetRequest) (*storagev1.GetResponse, error) {
+	key := tenant.Prefix(ctx) + req.Key
 	tracer := otel.Tracer("service2")
-	ctx, span := tracer.Start(ctx, "redis.get")
+	ctx, span := tracer.Start(ctx, "redis.get", trace.WithAttributes(attribute.String("redis.key", key)))
 	defer span.End()
 
-	value, err := redisClient.Get(ctx, req.Key).Result()
+	value, version, err := kvstore.Get(ctx, redisClient, key)
 	if err != nil {
-		return nil, fmt.Errorf("redis get error: %v", err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, storeError("get", err)
 	}
+	span.SetAttributes(attribute.Int64("kv.version", version))
 
-	return &service2.GetResponse{
-		Value: value,
+	return &storagev1.GetResponse{
+		Value:   value,
+		Version: version,
 	}, nil
 }
 
+func (s *server) Delete(ctx context.Context, req *storagev1.DeleteRequest) (*storagev1.DeleteResponse, error) {
+	key := tenant.Prefix(ctx) + req.Key
+	tracer := otel.Tracer("service2")
+	ctx, span := tracer.Start(ctx, "redis.del", trace.WithAttributes(attribute.String("redis.key", key)))
+	defer span.End()
+
+	deleted, err := kvstore.Delete(ctx, redisClient, key, quotas.For(ctx))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, storeError("del", err)
+	}
+	span.SetAttributes(attribute.Bool("kv.deleted", deleted))
+
+	return &storagev1.DeleteResponse{Deleted: deleted}, nil
+}
+
+func (s *server) Exists(ctx context.Context, req *storagev1.ExistsRequest) (*storagev1.ExistsResponse, error) {
+	key := tenant.Prefix(ctx) + req.Key
+	tracer := otel.Tracer("service2")
+	ctx, span := tracer.Start(ctx, "redis.exists", trace.WithAttributes(attribute.String("redis.key", key)))
+	defer span.End()
+
+	exists, err := kvstore.Exists(ctx, redisClient, key)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, storeError("exists", err)
+	}
+	span.SetAttributes(attribute.Bool("kv.exists", exists))
+
+	return &storagev1.ExistsResponse{Exists: exists}, nil
+}
+
+func (s *server) List(ctx context.Context, req *storagev1.ListRequest) (*storagev1.ListResponse, error) {
+	namespace := tenant.Prefix(ctx)
+	prefix := namespace + req.Prefix
+	tracer := otel.Tracer("service2")
+	ctx, span := tracer.Start(ctx, "redis.scan", trace.WithAttributes(
+		attribute.String("kv.prefix", prefix),
+		attribute.Int64("kv.cursor", int64(req.Cursor)),
+		attribute.Int64("kv.limit", req.Limit),
+	))
+	defer span.End()
+
+	keys, next, err := kvstore.List(ctx, redisClient, prefix, req.Cursor, req.Limit)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, storeError("scan", err)
+	}
+	span.SetAttributes(
+		attribute.Int("kv.keys", len(keys)),
+		attribute.Int64("kv.next_cursor", int64(next)),
+	)
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, namespace)
+	}
+
+	return &storagev1.ListResponse{Keys: keys, NextCursor: next}, nil
+}
+
+// Watch relays Put events published on Redis. Every event gets its own span,
+// linked to the Put that produced it.
+func (s *server) Watch(req *storagev1.WatchRequest, stream storagev1.Storage_WatchServer) error {
+	ctx := stream.Context()
+	// a tenant only hears about its own keys
+	namespace := tenant.Prefix(ctx)
+	var pubsub *redis.PubSub
+	if req.Key == "" {
+		pubsub = redisClient.PSubscribe(ctx, watchChannel+namespace+"*")
+	} else {
+		pubsub = redisClient.Subscribe(ctx, watchChannel+namespace+req.Key)
+	}
+	defer pubsub.Close()
+
+	tracer := otel.Tracer("service2")
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-pubsub.Channel():
+			if !ok {
+				return nil
+			}
+			event := &storagev1.WatchEvent{}
+			if err := protojson.Unmarshal([]byte(msg.Payload), event); err != nil {
+				log.Printf("invalid watch event on %s: %v", msg.Channel, err)
+				continue
+			}
+
+			putCtx := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(event.TraceContext))
+			_, span := tracer.Start(ctx, "service2.Watch.event",
+				trace.WithLinks(trace.LinkFromContext(putCtx)),
+				trace.WithAttributes(attribute.String("redis.key", event.Key)),
+			)
+			event.Key = strings.TrimPrefix(event.Key, namespace)
+			err := stream.Send(event)
+			span.End()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// BatchPut writes the requests of the stream with Redis pipelines. Requests
+// that are already waiting when a pipeline is sent go into it, so a client
+// streaming quickly gets large pipelines and one sending a request at a time
+// still gets its results back immediately.
+func (s *server) BatchPut(stream storagev1.Storage_BatchPutServer) error {
+	tracer := otel.Tracer("service2")
+	ctx, span := tracer.Start(stream.Context(), "service2.BatchPut")
+	defer span.End()
+
+	var counts batchCounts
+	defer func() {
+		span.SetAttributes(counts.attributes()...)
+	}()
+
+	requests := make(chan *storagev1.PutRequest, maxPipeline)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer close(requests)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF {
+					recvErr <- err
+				}
+				return
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var index int64
+	for req := range requests {
+		batch := []*storagev1.PutRequest{req}
+	drain:
+		for len(batch) < maxPipeline {
+			select {
+			case req, ok := <-requests:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, req)
+			default:
+				break drain
+			}
+		}
+		for _, req := range batch {
+			counts.received++
+			counts.receivedBytes += int64(proto.Size(req))
+		}
+
+		for _, result := range putPipeline(ctx, index, batch) {
+			if result.Error != "" {
+				counts.errors++
+			}
+			if err := stream.Send(result); err != nil {
+				span.SetStatus(codes.Error, err.Error())
+				return err
+			}
+			counts.sent++
+			counts.sentBytes += int64(proto.Size(result))
+		}
+		index += int64(len(batch))
+	}
+
+	select {
+	case err := <-recvErr:
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	default:
+		return nil
+	}
+}
+
+// putPipeline writes batch, whose first request is at index in the stream, in
+// one Redis pipeline and returns a result per request.
+func putPipeline(ctx context.Context, index int64, batch []*storagev1.PutRequest) []*storagev1.PutResult {
+	tracer := otel.Tracer("service2")
+	pipeCtx, pipeSpan := tracer.Start(ctx, "redis.pipeline",
+		trace.WithAttributes(attribute.Int("redis.pipeline.size", len(batch))),
+	)
+	defer pipeSpan.End()
+
+	results := make([]*storagev1.PutResult, len(batch))
+	spans := make([]trace.Span, len(batch))
+	puts := make([]*kvstore.PutCmd, len(batch))
+	publishes := make([]*redis.IntCmd, len(batch))
+	namespace := tenant.Prefix(ctx)
+	pipe := redisClient.Pipeline()
+	for i, req := range batch {
+		results[i] = &storagev1.PutResult{Index: index + int64(i), Key: req.Key}
+		key := namespace + req.Key
+
+		eventCtx := ctx
+		if *batchSpans == "message" {
+			eventCtx, spans[i] = tracer.Start(ctx, "service2.BatchPut.message",
+				trace.WithLinks(trace.LinkFromContext(pipeCtx)),
+				trace.WithAttributes(
+					attribute.Int64("rpc.message.id", results[i].Index),
+					attribute.Int("rpc.message.uncompressed_size", proto.Size(req)),
+					attribute.String("redis.key", key),
+				),
+			)
+		}
+
+		if req.Key == "" {
+			results[i].Error = "key is required"
+			continue
+		}
+		payload, err := watchEvent(eventCtx, key, req.Value)
+		if err != nil {
+			results[i].Error = fmt.Sprintf("watch event error: %v", err)
+			continue
+		}
+		puts[i] = kvstore.Put(pipeCtx, pipe, key, req.Value, putOptions(ctx, req))
+		publishes[i] = pipe.Publish(pipeCtx, watchChannel+key, payload)
+	}
+
+	// Exec only reports the first failure, every command carries its own
+	if _, err := pipe.Exec(pipeCtx); err != nil {
+		pipeSpan.SetStatus(codes.Error, err.Error())
+	}
+
+	for i, result := range results {
+		if puts[i] != nil {
+			version, err := puts[i].Result()
+			if err != nil {
+				result.Error = fmt.Sprintf("redis set error: %v", err)
+			} else if err := publishes[i].Err(); err != nil {
+				// stored all the same, as with Put
+				pipeSpan.RecordError(fmt.Errorf("redis publish error: %v", err),
+					trace.WithAttributes(attribute.Int64("rpc.message.id", result.Index)))
+			}
+			result.Version = version
+		}
+		if spans[i] != nil {
+			if result.Error != "" {
+				spans[i].SetStatus(codes.Error, result.Error)
+			}
+			spans[i].End()
+		}
+	}
+	return results
+}
+
+// putOptions reads the options of req; a tenant's puts count against its
+// quota.
+func putOptions(ctx context.Context, req *storagev1.PutRequest) kvstore.PutOptions {
+	return kvstore.PutOptions{
+		TTL:             kvstore.TTLFromSeconds(req.TtlSeconds),
+		ExpectedVersion: req.ExpectedVersion,
+		Quota:           quotas.For(ctx),
+	}
+}
+
+func putAttributes(key string, req *storagev1.PutRequest) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("redis.key", key),
+		attribute.Int64("kv.ttl_seconds", req.TtlSeconds),
+	}
+	if req.ExpectedVersion != nil {
+		attrs = append(attrs, attribute.Int64("kv.expected_version", *req.ExpectedVersion))
+	}
+	return attrs
+}
+
+// storeError keeps the cases a client can act on apart from other failures.
+func storeError(op string, err error) error {
+	switch {
+	case deadline.IsExceeded(err):
+		return status.Errorf(grpccodes.DeadlineExceeded, "redis %s error: %v", op, err)
+	case errors.Is(err, kvstore.ErrNotFound):
+		return status.Errorf(grpccodes.NotFound, "redis %s error: %v", op, err)
+	case errors.Is(err, kvstore.ErrVersionMismatch):
+		return status.Errorf(grpccodes.Aborted, "redis %s error: %v", op, err)
+	case errors.Is(err, kvstore.ErrQuotaExceeded):
+		return tenant.QuotaError(fmt.Errorf("redis %s error: %v", op, err))
+	default:
+		return fmt.Errorf("redis %s error: %v", op, err)
+	}
+}
+
+// batchCounts is recorded on the span of a BatchPut stream.
+type batchCounts struct {
+	received, receivedBytes int64
+	sent, sentBytes         int64
+	errors                  int64
+}
+
+func (c batchCounts) attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int64("rpc.batch.messages_received", c.received),
+		attribute.Int64("rpc.batch.bytes_received", c.receivedBytes),
+		attribute.Int64("rpc.batch.messages_sent", c.sent),
+		attribute.Int64("rpc.batch.bytes_sent", c.sentBytes),
+		attribute.Int64("rpc.batch.errors", c.errors),
+	}
+}
+
+// publish notifies watchers of key.
+func publish(ctx context.Context, key, value string) error {
+	payload, err := watchEvent(ctx, key, value)
+
+	ctx, span := otel.Tracer("service2").Start(ctx, "redis.publish")
+	defer span.End()
+
+	if err == nil {
+		err = redisClient.Publish(ctx, watchChannel+key, payload).Err()
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// watchEvent encodes the event of a Put. The event carries the trace context
+// of ctx so that watchers can link their spans to the Put.
+func watchEvent(ctx context.Context, key, value string) ([]byte, error) {
+	event := &storagev1.WatchEvent{
+		Key:          key,
+		Value:        value,
+		TraceContext: map[string]string{},
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(event.TraceContext))
+	return protojson.Marshal(event)
+}
+
 func main() {
+	flag.Parse()
+	loadCerts()
+	baggagePolicy = baggageattr.Policy{
+		Allow:          baggageattr.ParseAllow(*baggageAttrs),
+		MaxMembers:     *baggageMax,
+		MaxValueLength: *baggageValue,
+	}
+	if *batchSpans != "stream" && *batchSpans != "message" {
+		log.Fatalf("unknown -batch-spans %q", *batchSpans)
+	}
+	rpcTimeouts, err := deadline.ParseTimeouts(*timeouts)
+	if err != nil {
+		log.Fatalf("invalid -timeouts: %v", err)
+	}
+	quotas, err = tenant.ParseQuotas(*tenantQuotas)
+	if err != nil {
+		log.Fatalf("invalid -tenant-quotas: %v", err)
+	}
+
 	shutdown := initTracer()
 	defer shutdown()
 
+	traceOpts := grpctrace.Options{
+		Filter:         grpctrace.ExcludeHealthChecks,
+		MessageEvents:  *messageEvents,
+		PublicEndpoint: *publicEndpoint,
+	}
+	shutdownMeter := initMeter()
+	defer shutdownMeter()
+	if *metrics {
+		traceOpts.MeterProvider = meterProvider
+	}
+
 	createRedisClient()
+	if *putWorker {
+		startPutWorker()
+	}
 
 	lis, err := net.Listen("tcp", ":8081")
 	if err != nil {
@@ -70,10 +521,28 @@ func main() {
 	}
 
 	s := grpc.NewServer(
-		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
-		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
+		grpc.Creds(tlsconfig.ServerCredentials(tlsCerts)),
+		grpc.StatsHandler(grpctrace.NewServerHandler(traceOpts)),
+		grpc.ChainUnaryInterceptor(
+			auth.IdentityUnaryServerInterceptor(auth.OTel),
+			tenant.BaggageUnaryServerInterceptor(tenant.OTel),
+			deadline.UnaryServerInterceptor(rpcTimeouts, deadline.OTel),
+		),
+		grpc.ChainStreamInterceptor(
+			auth.IdentityStreamServerInterceptor(auth.OTel),
+			tenant.BaggageStreamServerInterceptor(tenant.OTel),
+		),
 	)
-	service2.RegisterStorageServer(s, &server{})
+	storagev1.RegisterStorageServer(s, &server{})
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	reflection.Register(s)
+
+	checker := readiness.NewChecker()
+	checker.Add("redis", readiness.Redis(redisClient))
+	checker.Add("collector", readiness.GRPCConn(collectorConn))
+	checker.Serve(healthServer, "", storagev1.Storage_ServiceDesc.ServiceName)
+	checker.Start(context.Background())
 
 	log.Printf("server listening at %v", lis.Addr())
 	if err := s.Serve(lis); err != nil {
@@ -81,15 +550,86 @@ func main() {
 	}
 }
 
+func loadCerts() {
+	var err error
+	tlsCerts, err = tlsconfig.Load(tlsconfig.Files{
+		Cert:       *tlsCert,
+		Key:        *tlsKey,
+		CA:         *tlsCA,
+		ClientAuth: *tlsClientAuth,
+		Use:        tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %v", err)
+	}
+}
+
+// startPutWorker applies the puts that service1 queues in the background, as
+// a consumer of the group of service2. Each put gets a trace of its own, which
+// starts with the consumer span.
+func startPutWorker() {
+	opts := putqueue.DefaultOptions()
+	opts.Stream = *putStream
+	opts.MinIdle = *putMinIdle
+	opts.MaxDeliveries = *putDeliveries
+	// a read waits for new puts within the bound of -redis-timeout
+	if *redisTimeout > 0 && opts.Block >= *redisTimeout {
+		opts.Block = *redisTimeout / 2
+	}
+	consumer := putqueue.NewConsumer(redisClient, opts, applyPut, messaging.OTel(otel.Tracer("service2")), meterProvider)
+	go consumer.Run(context.Background())
+}
+
+// applyPut applies a put that service1 queued like a Put of a client.
+func applyPut(ctx context.Context, p putqueue.Put) error {
+	_, err := (&server{}).Put(ctx, &storagev1.PutRequest{
+		Key:             p.Key,
+		Value:           p.Value,
+		TtlSeconds:      p.TTLSeconds,
+		ExpectedVersion: p.ExpectedVersion,
+	})
+	switch status.Code(err) {
+	case grpccodes.Aborted, grpccodes.ResourceExhausted:
+		return putqueue.Permanent(err)
+	}
+	return err
+}
+
 func createRedisClient() {
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
+	addrs := redisclient.ParseAddrs(*redisAddrs)
+	if len(addrs) == 0 {
+		log.Fatalf("invalid -redis-addrs: %q\n", *redisAddrs)
+	}
+	// with TLS, every node is verified against the host of the first address
+	var err error
+	redisClient, err = redisclient.New(redisclient.Config{
+		Mode:                  *redisMode,
+		Addrs:                 addrs,
+		MasterName:            *redisMaster,
+		PoolSize:              *redisPool,
+		MinIdleConns:          *redisMinIdle,
+		PoolTimeout:           *redisPoolWait,
+		DialTimeout:           *redisDial,
+		ReadTimeout:           *redisRead,
+		WriteTimeout:          *redisWrite,
+		ContextTimeoutEnabled: true,
+		TLSConfig:             tlsCerts.ClientConfig("redis", addrs[0]),
+		Report:                redisclient.OTel,
+		MeterProvider:         meterProvider,
 	})
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	err := redisClient.Ping(ctx).Err()
 	if err != nil {
-		log.Fatalf("redis ping error: %+v\n", err)
+		log.Fatalf("invalid Redis configuration: %+v\n", err)
+	}
+	// serve without Redis rather than exit, /readyz failing until it answers
+	if err := redisclient.Wait(context.Background(), redisClient, *redisWait); err != nil {
+		log.Printf("redis ping error: %+v\n", err)
+	}
+
+	// every command gets what is left of the request's budget, up to
+	// -redis-timeout, and records it on its span
+	redisClient.AddHook(deadline.RedisHook(*redisTimeout, deadline.OTel))
+	if *redisDelay > 0 {
+		redisClient.AddHook(deadline.SlowRedis(*redisDelay))
 	}
 }
 
@@ -106,20 +646,31 @@ func initTracer() func() {
 	}
 
 	conn, err := grpc.Dial("localhost:4317",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "collector", "localhost:4317")),
 		grpc.WithBlock(),
 	)
 	if err != nil {
 		panic(err)
 	}
+	collectorConn = conn
 
 	traceExporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithGRPCConn(conn))
 	if err != nil {
 		panic(err)
 	}
 
+	redactor, err := redact.Load(*redactPolicy)
+	if err != nil {
+		log.Fatalf("invalid -redact-policy: %v", err)
+	}
 	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(traceExporter),
+		// every span of a tenant's request records the tenant
+		sdktrace.WithSpanProcessor(tenant.SpanProcessor()),
+		// and the baggage members that -baggage-attributes allows
+		sdktrace.WithSpanProcessor(baggageattr.SpanProcessor(baggagePolicy)),
+		// redact before the batch holds on to the spans, by default KV values
+		// never leave the process
+		sdktrace.WithSpanProcessor(redact.SpanProcessor(redactor, sdktrace.NewBatchSpanProcessor(traceExporter))),
 		sdktrace.WithResource(res),
 	)
 	otel.SetTracerProvider(tracerProvider)
@@ -134,3 +685,36 @@ func initTracer() func() {
 		}
 	}
 }
+
+// initMeter exports the metrics of the service on the connection to the
+// collector that initTracer opened.
+func initMeter() func() {
+	ctx := context.Background()
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName("service2"),
+			semconv.ServiceVersion("1.0.0"),
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	metricExporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithGRPCConn(collectorConn))
+	if err != nil {
+		panic(err)
+	}
+
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(provider)
+	meterProvider = provider
+
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/deadline"
+	"go-service-tracing/internal/grpctrace"
+	"go-service-tracing/internal/kvstore"
+	"go-service-tracing/internal/tenant"
+	"go-service-tracing/internal/tlsconfig"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startServer serves Storage over bufconn like main does, on Redis in memory,
+// and records the spans of the service in memory.
+func startServer(t *testing.T) (storagev1.StorageClient, *miniredis.Miniredis, *tracetest.InMemoryExporter) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	mr := miniredis.RunT(t)
+	redisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	// service2 trusts the tenant in the baggage of callers with a client
+	// certificate only, as service1 presents over mTLS
+	ca, err := tlsconfig.NewCA("test CA", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := ca.WriteFiles(dir, "service", []string{"localhost"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := tlsconfig.Load(tlsconfig.Files{
+		Cert:       filepath.Join(dir, "cert.pem"),
+		Key:        filepath.Join(dir, "key.pem"),
+		CA:         filepath.Join(dir, "ca.pem"),
+		ClientAuth: true,
+		Use:        []string{"server", "service2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.Creds(tlsconfig.ServerCredentials(certs)),
+		grpc.StatsHandler(grpctrace.NewServerHandler(grpctrace.Options{})),
+		grpc.ChainUnaryInterceptor(tenant.BaggageUnaryServerInterceptor(tenant.OTel)),
+		grpc.ChainStreamInterceptor(tenant.BaggageStreamServerInterceptor(tenant.OTel)),
+	)
+	storagev1.RegisterStorageServer(s, &server{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(certs, "service2", "localhost:8081")),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return storagev1.NewStorageClient(conn), mr, exporter
+}
+
+// spansNamed returns the spans of name.
+func spansNamed(spans tracetest.SpanStubs, name string) []tracetest.SpanStub {
+	var named []tracetest.SpanStub
+	for _, s := range spans {
+		if s.Name == name {
+			named = append(named, s)
+		}
+	}
+	return named
+}
+
+func hasAttribute(s tracetest.SpanStub, kv attribute.KeyValue) bool {
+	for _, a := range s.Attributes {
+		if a == kv {
+			return true
+		}
+	}
+	return false
+}
+
+// receiveEvent puts a value under key in ctx until events receives it, as
+// the subscription is only set up once the stream is open.
+func receiveEvent(t *testing.T, ctx context.Context, client storagev1.StorageClient, events storagev1.Storage_WatchClient, key string) *storagev1.WatchEvent {
+	t.Helper()
+	received := make(chan *storagev1.WatchEvent, 1)
+	go func() {
+		if event, err := events.Recv(); err == nil {
+			received <- event
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if _, err := client.Put(ctx, &storagev1.PutRequest{Key: key, Value: "watched"}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case event := <-received:
+			return event
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	t.Fatal("no watch event")
+	return nil
+}
+
+// The span of every event of a Watch is a child of the span of the stream and
+// links to the span of the Put that caused it.
+func TestWatchTracing(t *testing.T) {
+	client, _, exporter := startServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := client.Watch(ctx, &storagev1.WatchRequest{Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := receiveEvent(t, context.Background(), client, events, "k")
+	if event.Key != "k" || event.Value != "watched" {
+		t.Fatalf("event = %v, want k=watched", event)
+	}
+	if event.TraceContext["traceparent"] == "" {
+		t.Fatalf("event carries no trace context: %v", event.TraceContext)
+	}
+	cancel()
+
+	// the span of the stream ends once the server has seen the cancellation
+	var watch []tracetest.SpanStub
+	for i := 0; i < 50 && len(watch) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		watch = spansNamed(exporter.GetSpans(), "storage.v1.Storage/Watch")
+	}
+	if len(watch) != 1 {
+		t.Fatalf("got %d Watch spans, want 1", len(watch))
+	}
+
+	spans := exporter.GetSpans()
+	puts := map[trace.SpanID]bool{}
+	for _, s := range spansNamed(spans, "storage.v1.Storage/Put") {
+		puts[s.SpanContext.SpanID()] = true
+	}
+	eventSpans := spansNamed(spans, "service2.Watch.event")
+	if len(eventSpans) != 1 {
+		t.Fatalf("got %d event spans, want 1", len(eventSpans))
+	}
+	s := eventSpans[0]
+	if s.Parent.SpanID() != watch[0].SpanContext.SpanID() {
+		t.Errorf("event span is not a child of the Watch span")
+	}
+	if len(s.Links) != 1 || !puts[s.Links[0].SpanContext.SpanID()] {
+		t.Errorf("event span links = %v, want the span of a Put", s.Links)
+	}
+	if !hasAttribute(s, attribute.String("redis.key", "{_}:k")) {
+		t.Errorf("event span attributes = %v, want redis.key _:k", s.Attributes)
+	}
+}
+
+// A Put whose value is stored succeeds even if its watchers cannot be told.
+func TestPutPublishError(t *testing.T) {
+	client, mr, exporter := startServer(t)
+	redisClient.AddHook(failPublish{})
+
+	resp, err := client.Put(context.Background(), &storagev1.PutRequest{Key: "k", Value: "v"})
+	if err != nil {
+		t.Fatalf("Put error = %v, want none", err)
+	}
+	if resp.Version != 1 {
+		t.Fatalf("Put version = %d, want 1", resp.Version)
+	}
+	if got := mr.HGet("{_}:k", "value"); got != "v" {
+		t.Fatalf("stored value = %q, want v", got)
+	}
+	publish := spansNamed(exporter.GetSpans(), "redis.publish")
+	if len(publish) != 1 || publish[0].Status.Description != "publish failed" {
+		t.Fatalf("redis.publish spans = %v, want one with the error", publish)
+	}
+}
+
+// failPublish fails PUBLISH commands.
+type failPublish struct{}
+
+func (failPublish) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (failPublish) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == "publish" {
+			cmd.SetErr(errPublish)
+			return errPublish
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (failPublish) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+var errPublish = errors.New("publish failed")
+
+// batchPut sends reqs on one BatchPut stream and returns the results.
+func batchPut(t *testing.T, client storagev1.StorageClient, reqs []*storagev1.PutRequest) []*storagev1.PutResult {
+	t.Helper()
+	stream, err := client.BatchPut(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range reqs {
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	var results []*storagev1.PutResult
+	for {
+		result, err := stream.Recv()
+		if err == io.EOF {
+			return results
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+}
+
+// Results come back in the order of the requests, each with its own error,
+// and the failure of one request does not fail the others.
+func TestBatchPut(t *testing.T) {
+	for _, mode := range []string{"stream", "message"} {
+		t.Run(mode, func(t *testing.T) {
+			defer func(prev string) { *batchSpans = prev }(*batchSpans)
+			*batchSpans = mode
+			client, mr, exporter := startServer(t)
+
+			stale := int64(7)
+			reqs := make([]*storagev1.PutRequest, 0, 2*maxPipeline)
+			for i := 0; i < 2*maxPipeline; i++ {
+				reqs = append(reqs, &storagev1.PutRequest{Key: fmt.Sprintf("k%03d", i), Value: fmt.Sprint(i)})
+			}
+			reqs[3].Key = ""
+			reqs[150].ExpectedVersion = &stale
+
+			results := batchPut(t, client, reqs)
+			if len(results) != len(reqs) {
+				t.Fatalf("got %d results, want %d", len(results), len(reqs))
+			}
+			for i, result := range results {
+				if result.Index != int64(i) || result.Key != reqs[i].Key {
+					t.Fatalf("result %d = %v, want index %d of key %q", i, result, i, reqs[i].Key)
+				}
+				switch i {
+				case 3:
+					if result.Error != "key is required" {
+						t.Errorf("result of an empty key = %v", result)
+					}
+				case 150:
+					if !strings.Contains(result.Error, kvstore.ErrVersionMismatch.Error()) {
+						t.Errorf("result of a stale version = %v", result)
+					}
+					if mr.Exists("{_}:" + reqs[i].Key) {
+						t.Errorf("key %s of a stale version was stored", reqs[i].Key)
+					}
+				default:
+					if result.Error != "" || result.Version != 1 {
+						t.Errorf("result %d = %v, want version 1", i, result)
+					}
+					if got := mr.HGet("{_}:"+reqs[i].Key, "value"); got != reqs[i].Value {
+						t.Errorf("stored value of %s = %q, want %q", reqs[i].Key, got, reqs[i].Value)
+					}
+				}
+			}
+
+			spans := exporter.GetSpans()
+			stream := spansNamed(spans, "service2.BatchPut")
+			if len(stream) != 1 {
+				t.Fatalf("got %d BatchPut spans, want 1", len(stream))
+			}
+			for _, kv := range []attribute.KeyValue{
+				attribute.Int64("rpc.batch.messages_received", int64(len(reqs))),
+				attribute.Int64("rpc.batch.messages_sent", int64(len(reqs))),
+				attribute.Int64("rpc.batch.errors", 2),
+			} {
+				if !hasAttribute(stream[0], kv) {
+					t.Errorf("BatchPut span attributes = %v, want %s=%s", stream[0].Attributes, kv.Key, kv.Value.Emit())
+				}
+			}
+
+			messages := spansNamed(spans, "service2.BatchPut.message")
+			if mode == "stream" {
+				if len(messages) != 0 {
+					t.Errorf("got %d message spans, want none", len(messages))
+				}
+				return
+			}
+			if len(messages) != len(reqs) {
+				t.Fatalf("got %d message spans, want %d", len(messages), len(reqs))
+			}
+			failed := 0
+			for _, s := range messages {
+				if s.Status.Code == codes.Error {
+					failed++
+				}
+			}
+			if failed != 2 {
+				t.Errorf("got %d failed message spans, want 2", failed)
+			}
+		})
+	}
+}
+
+// A Redis slower than the bound of its commands fails a Put with
+// DeadlineExceeded, which the span of the command records.
+func TestPutSlowRedis(t *testing.T) {
+	client, _, exporter := startServer(t)
+	redisClient.AddHook(deadline.RedisHook(50*time.Millisecond, deadline.OTel))
+	redisClient.AddHook(deadline.SlowRedis(time.Second))
+
+	_, err := client.Put(context.Background(), &storagev1.PutRequest{Key: "k", Value: "v"})
+	if status.Code(err) != grpccodes.DeadlineExceeded {
+		t.Fatalf("Put error = %v, want DeadlineExceeded", err)
+	}
+	set := spansNamed(exporter.GetSpans(), "redis.set")
+	if len(set) != 1 || set[0].Status.Code != codes.Error || !hasAttribute(set[0], attribute.Bool(deadline.AttrExceeded, true)) {
+		t.Fatalf("redis.set spans = %v, want one exceeded", set)
+	}
+}
+
+// withTenant returns a context whose calls carry the tenant id in their
+// baggage, as service1 passes it on.
+func withTenant(id string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "baggage", tenant.BaggageKey+"="+id)
+}
+
+// Tenants, and the callers without one, neither read, list nor watch each
+// other's keys.
+func TestTenantIsolation(t *testing.T) {
+	client, mr, _ := startServer(t)
+	defer func(prev tenant.Quotas) { quotas = prev }(quotas)
+	quotas = tenant.Quotas{Default: 10}
+	acme, globex, none := withTenant("acme"), withTenant("globex"), context.Background()
+
+	if _, err := client.Put(acme, &storagev1.PutRequest{Key: "secret", Value: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Put(none, &storagev1.PutRequest{Key: "plain", Value: "n"}); err != nil {
+		t.Fatal(err)
+	}
+	if mr.HGet("{acme}:secret", "value") != "a" || mr.HGet("{_}:plain", "value") != "n" {
+		t.Fatalf("keys = %v, want {acme}:secret and {_}:plain", mr.Keys())
+	}
+
+	if resp, err := client.Get(acme, &storagev1.GetRequest{Key: "secret"}); err != nil || resp.Value != "a" {
+		t.Fatalf("acme Get = %v, %v", resp, err)
+	}
+	for name, get := range map[string]struct {
+		ctx context.Context
+		key string
+	}{
+		"globex":            {globex, "secret"},
+		"no tenant":         {none, "secret"},
+		"no tenant, prefix": {none, "{acme}:secret"},
+		"acme, untenanted":  {acme, "plain"},
+		"acme, prefix":      {acme, "{_}:plain"},
+		"acme, quota index": {acme, "#quota:{acme}"},
+	} {
+		if _, err := client.Get(get.ctx, &storagev1.GetRequest{Key: get.key}); status.Code(err) != grpccodes.NotFound {
+			t.Errorf("%s: Get %s = %v, want NotFound", name, get.key, err)
+		}
+	}
+	if resp, err := client.Delete(globex, &storagev1.DeleteRequest{Key: "secret"}); err != nil || resp.Deleted {
+		t.Errorf("globex Delete = %v, %v, want nothing deleted", resp, err)
+	}
+
+	for name, list := range map[string]struct {
+		ctx  context.Context
+		want string
+	}{
+		"acme":      {acme, "secret"},
+		"globex":    {globex, ""},
+		"no tenant": {none, "plain"},
+	} {
+		resp, err := client.List(list.ctx, &storagev1.ListRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(resp.Keys, ","); got != list.want {
+			t.Errorf("%s: List = %q, want %q", name, got, list.want)
+		}
+	}
+
+	// every watcher of all keys first hears of a key of its own, so that
+	// it is subscribed, then of nothing but its own keys
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watchers := map[string]context.Context{"acme": acme, "globex": globex, "none": none}
+	events := map[string]storagev1.Storage_WatchClient{}
+	for name, tenantCtx := range watchers {
+		md, _ := metadata.FromOutgoingContext(tenantCtx)
+		stream, err := client.Watch(metadata.NewOutgoingContext(ctx, md), &storagev1.WatchRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		receiveEvent(t, tenantCtx, client, stream, "mine")
+		events[name] = stream
+	}
+	if _, err := client.Put(acme, &storagev1.PutRequest{Key: "secret", Value: "a2"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, stream := range events {
+		if _, err := client.Put(watchers[name], &storagev1.PutRequest{Key: "last", Value: name}); err != nil {
+			t.Fatal(err)
+		}
+		want := "last"
+		if name == "acme" {
+			want = "secret"
+		}
+		// skip the puts of receiveEvent that came after the first
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.Key == "mine" {
+				continue
+			}
+			if event.Key != want {
+				t.Errorf("%s watcher heard of %s=%s, want %s", name, event.Key, event.Value, want)
+			}
+			break
+		}
+	}
+}
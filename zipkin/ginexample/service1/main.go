@@ -1,14 +1,39 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"encoding/json"
+	"flag"
 	"github.com/gin-gonic/gin"
 	"github.com/openzipkin/zipkin-go"
 	zipkinhttp "github.com/openzipkin/zipkin-go/middleware/http"
 	"github.com/openzipkin/zipkin-go/propagation/b3"
 	httpreporter "github.com/openzipkin/zipkin-go/reporter/http"
 	"github.com/redis/go-redis/v9"
+	"go-service-tracing/internal/auth"
+	"go-service-tracing/internal/baggageattr"
+	"go-service-tracing/internal/breaker"
+	"go-service-tracing/internal/cache"
+	"go-service-tracing/internal/deadline"
+	"go-service-tracing/internal/kvhttp"
+	"go-service-tracing/internal/messaging"
+	"go-service-tracing/internal/putqueue"
+	"go-service-tracing/internal/ratelimit"
+	"go-service-tracing/internal/readiness"
+	"go-service-tracing/internal/redact"
+	"go-service-tracing/internal/redisclient"
+	"go-service-tracing/internal/retry"
+	"go-service-tracing/internal/tenant"
+	"go-service-tracing/internal/tlsconfig"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"google.golang.org/grpc"
 	"io"
 	"log"
 	"net/http"
@@ -19,18 +44,232 @@ import (
 )
 
 var (
-	tracer      *zipkin.Tracer
-	redisClient *redis.Client
-	httpClient  *zipkinhttp.Client
+	service2URL   string
+	tlsCerts      *tlsconfig.Reloader
+	tracer        *zipkin.Tracer
+	meterProvider metric.MeterProvider
+	redisClient   redis.UniversalClient
+	httpClient    *zipkinhttp.Client
+	retryPolicy   retry.Policy
+	baggagePolicy baggageattr.Policy
+	baggageHook   *baggageattr.ZipkinHook
+	valueCache    *cache.Cache
+	putQueue      *putqueue.Producer
+
+	timeouts      = flag.String("timeouts", "default=2s", "timeouts per route, e.g. default=2s,GET /v1/kv=5s")
+	retries       = flag.Int("retries", 3, "attempts per request to service2, 1 to disable retries")
+	retryBackoff  = flag.Duration("retry-backoff", 50*time.Millisecond, "pause before the first retry, doubled for each one after")
+	retryPut      = flag.Bool("retry-put", false, "retry PUT and POST as well, which may write a value twice")
+	failureRate   = flag.Float64("breaker-failure-rate", 0.5, "share of failed requests to service2 that opens the circuit breaker, 0 to disable it")
+	openTimeout   = flag.Duration("breaker-open-timeout", 5*time.Second, "how long the circuit breaker stays open")
+	bulkhead      = flag.Int("bulkhead", 32, "requests to service2 in flight at once, 0 for no limit")
+	redactPolicy  = flag.String("redact-policy", "", "YAML policy that redacts span tags, the built-in one if empty")
+	tlsUse        = flag.String("tls", "", "connections that use TLS, any of server,service2,collector,redis")
+	tlsCert       = flag.String("tls-cert", "", "certificate file of this service, served and presented to peers that ask for one")
+	tlsKey        = flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA         = flag.String("tls-ca", "", "CA file to verify peers against, the system roots if empty")
+	tlsClientAuth = flag.Bool("tls-client-auth", false, "require clients to present a certificate signed by -tls-ca")
+	metricsAddr   = flag.String("metrics-endpoint", "", "OTLP gRPC address of a collector that receives the metrics, e.g. localhost:4317, none if empty")
+	authConfig    = flag.String("auth", "", "authentication config file of API keys, HMAC keys and a JWKS, empty to leave the API open")
+	requireTenant = flag.Bool("require-tenant", false, "reject requests without a tenant")
+	baggageAttrs  = flag.String("baggage-attributes", "", "baggage members recorded on every span and the only ones kept from clients, e.g. user.tier,experiment.*")
+	baggageMax    = flag.Int("baggage-max-members", baggageattr.DefaultMaxMembers, "most baggage members kept, 0 for no limit")
+	baggageValue  = flag.Int("baggage-max-value", baggageattr.DefaultMaxValueLength, "longest baggage value kept, 0 for no limit")
+	rateLimit     = flag.Float64("rate-limit", 0, "requests per second per client, 0 for no limit")
+	rateBurst     = flag.Int("rate-burst", 20, "requests a client may make at once before -rate-limit applies")
+	rateLimitKey  = flag.String("rate-limit-key", "ip", "what a client is: ip, principal or tenant, the last two falling back to ip")
+	rateRedis     = flag.Bool("rate-limit-redis", false, "keep the buckets of the clients in Redis, shared by every replica of service1")
+	authRateLimit = flag.Float64("auth-rate-limit", 50, "requests per second per client IP before authentication, which bounds the attempts at guessing credentials, 0 for no limit")
+	authRateBurst = flag.Int("auth-rate-burst", 100, "requests a client IP may make at once before -auth-rate-limit applies")
+	redisMode     = flag.String("redis-mode", redisclient.ModeSingle, "Redis deployment: single, sentinel or cluster")
+	redisAddrs    = flag.String("redis-addrs", redisclient.DefaultAddr, "comma separated addresses of the Redis server, of the Sentinels or of some Cluster nodes")
+	redisMaster   = flag.String("redis-master", "", "master that the Sentinels watch, with -redis-mode sentinel")
+	redisPool     = flag.Int("redis-pool-size", 0, "most connections to each Redis node, 0 for 10 per CPU")
+	redisMinIdle  = flag.Int("redis-min-idle", 0, "idle connections to each Redis node kept open")
+	redisPoolWait = flag.Duration("redis-pool-timeout", 0, "how long a Redis command waits for a connection when the pool is exhausted, 0 for the read timeout plus 1s")
+	redisDial     = flag.Duration("redis-dial-timeout", 0, "bound of connecting to Redis, 0 for 5s")
+	redisRead     = flag.Duration("redis-read-timeout", 0, "bound of reading a Redis reply, 0 for 3s")
+	redisWrite    = flag.Duration("redis-write-timeout", 0, "bound of writing a Redis command, 0 for the read timeout")
+	redisWait     = flag.Duration("redis-wait", redisclient.DefaultWait, "how long startup waits for Redis to answer before serving without it")
+	cacheSize     = flag.Int("cache-size", 0, "keys in the read-through cache of gets, 0 to disable it")
+	cacheTTL      = flag.Duration("cache-ttl", 5*time.Second, "how long the cache serves a value")
+	asyncPut      = flag.Bool("async-put", false, "queue PUT /v1/kv/:key on a Redis Stream for the workers of service2 rather than forward it")
+	putStream     = flag.String("put-stream", putqueue.DefaultStream, "Redis Stream of the queued puts")
 )
 
 func main() {
+	flag.Parse()
+	loadCerts()
+	baggagePolicy = baggageattr.Policy{
+		Allow:          baggageattr.ParseAllow(*baggageAttrs),
+		MaxMembers:     *baggageMax,
+		MaxValueLength: *baggageValue,
+	}
+	authenticator, err := auth.Load(*authConfig)
+	if err != nil {
+		log.Fatalf("invalid -auth: %+v\n", err)
+	}
+	routeTimeouts, err := deadline.ParseTimeouts(*timeouts)
+	if err != nil {
+		log.Fatalf("invalid -timeouts: %+v\n", err)
+	}
 	createTracer()
+	shutdownMeter := createMeter()
+	defer shutdownMeter()
 	createRedisClient()
+	if *asyncPut {
+		putQueue = putqueue.NewProducer(redisClient, *putStream, messaging.Zipkin(tracer))
+	}
 	createHttpClient()
+	authThrottle := createAuthThrottle(authenticator != nil)
+	throttle := createThrottle()
+	valueCache = cache.New(*cacheSize, *cacheTTL, cache.Zipkin(tracer), meterProvider)
+	// a queued put leaves the cache once a worker of service2 applied it
+	if putQueue != nil && valueCache != nil {
+		go putqueue.Applied(context.Background(), redisClient, *putStream, valueCache.Invalidate)
+	}
+	// service1只有在service2就绪时才就绪，检查使用不带追踪的http客户端
+	checker := readiness.NewChecker()
+	checker.Add("redis", readiness.Redis(redisClient))
+	checker.Add("service2", readiness.HTTP(&http.Client{
+		Transport: tlsconfig.Transport(tlsCerts, "service2", "localhost:8081"),
+	}, service2URL+"/readyz"))
+	checker.Add("zipkin", readiness.Dial("localhost:9411"))
+	checker.Start(context.Background())
 	r := gin.Default()
-	r.Use(zipkinMiddleware())
-	r.POST("/kv/put", func(c *gin.Context) {
+	// clients keep only the baggage that -baggage-attributes allows; the
+	// budget is taken after the span is started, so that it is recorded there
+	r.Use(baggageattr.Strip(baggagePolicy), zipkinMiddleware(), deadline.Gin(routeTimeouts, deadline.Zipkin),
+		authThrottle.Gin(), auth.Gin(authenticator, auth.Zipkin), tenant.Gin(*requireTenant, tenant.Zipkin),
+		baggageHook.Gin(), throttle.Gin())
+	r.GET("/healthz", gin.WrapF(readiness.Healthz))
+	r.GET("/readyz", gin.WrapF(checker.Readyz))
+	v1 := r.Group("/v1/kv", kvhttp.Negotiate())
+	v1.PUT("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+		body, ok := kvhttp.BindPut(c)
+		if !ok {
+			return
+		}
+
+		span, ctx := tracer.StartSpanFromContext(c.Request.Context(), "kv.set")
+		defer span.Finish()
+		span.Tag("key", key)
+		span.Tag("value", body.Value)
+
+		if putQueue != nil {
+			// the cache forgets the key once the put is applied, see main
+			enqueue(ctx, c, key, body)
+			return
+		}
+		defer valueCache.Invalidate(tenant.Prefix(ctx) + key)
+
+		data, err := json.Marshal(body)
+		if err != nil {
+			kvhttp.RespondError(c, 500, err.Error())
+			return
+		}
+		req, err := http.NewRequest("PUT", service2KeyURL(key), bytes.NewReader(data))
+		if err != nil {
+			kvhttp.RespondError(c, 500, err.Error())
+			return
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		forward(c, req.WithContext(ctx))
+	})
+	v1.GET("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+
+		span, ctx := tracer.StartSpanFromContext(c.Request.Context(), "kv.get")
+		defer span.Finish()
+		span.Tag("key", key)
+
+		v, err := valueCache.Get(ctx, tenant.Prefix(ctx)+key, func(ctx context.Context) (cache.Value, error) {
+			req, err := http.NewRequest("GET", service2KeyURL(key), nil)
+			if err != nil {
+				return cache.Value{}, err
+			}
+			return fetch(req.WithContext(ctx))
+		})
+		if err != nil {
+			kvhttp.RespondError(c, kvhttp.FailureCode(err), err.Error())
+			return
+		}
+
+		kvhttp.Respond(c, 200, kvhttp.KV{Key: key, Value: v.Value, Version: v.Version})
+	})
+	v1.DELETE("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+
+		span, ctx := tracer.StartSpanFromContext(c.Request.Context(), "kv.delete")
+		defer span.Finish()
+		span.Tag("key", key)
+
+		req, err := http.NewRequest("DELETE", service2KeyURL(key), nil)
+		if err != nil {
+			kvhttp.RespondError(c, 500, err.Error())
+			return
+		}
+
+		defer valueCache.Invalidate(tenant.Prefix(ctx) + key)
+		forward(c, req.WithContext(ctx))
+	})
+	v1.HEAD("/:key", func(c *gin.Context) {
+		key, ok := kvhttp.BindKey(c)
+		if !ok {
+			return
+		}
+
+		span, ctx := tracer.StartSpanFromContext(c.Request.Context(), "kv.exists")
+		defer span.Finish()
+		span.Tag("key", key)
+
+		req, err := http.NewRequest("HEAD", service2KeyURL(key), nil)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+
+		forward(c, req.WithContext(ctx))
+	})
+	v1.GET("", func(c *gin.Context) {
+		query, ok := kvhttp.BindList(c)
+		if !ok {
+			return
+		}
+
+		span, ctx := tracer.StartSpanFromContext(c.Request.Context(), "kv.list")
+		defer span.Finish()
+		span.Tag("prefix", query.Prefix)
+
+		params := url.Values{
+			"prefix": {query.Prefix},
+			"cursor": {strconv.FormatUint(query.Cursor, 10)},
+		}
+		if query.Limit > 0 {
+			params.Set("limit", strconv.FormatInt(query.Limit, 10))
+		}
+		req, err := http.NewRequest("GET", service2URL+"/v1/kv?"+params.Encode(), nil)
+		if err != nil {
+			kvhttp.RespondError(c, 500, err.Error())
+			return
+		}
+
+		forward(c, req.WithContext(ctx))
+	})
+
+	// deprecated routes, kept until every client has moved to /v1/kv
+	r.POST("/kv/put", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
 		key := c.PostForm("key")
 		value := c.PostForm("value")
 		if key == "" || value == "" {
@@ -49,17 +288,13 @@ func main() {
 			"key":   {key},
 			"value": {value},
 		}
-		req, err := http.NewRequest("POST", "http://localhost:8081/kv/put", strings.NewReader(params.Encode()))
-		if err != nil {
-			c.JSON(500, gin.H{
-				"message": err.Error(),
-			})
-			return
+		// ttl and version are validated by service2
+		for _, name := range []string{"ttl", "version"} {
+			if v, ok := c.GetPostForm(name); ok {
+				params.Set(name, v)
+			}
 		}
-
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		req = req.WithContext(ctx)
-		resp, err := httpClient.DoWithAppSpan(req, "service2")
+		req, err := http.NewRequest("POST", service2URL+"/kv/put", strings.NewReader(params.Encode()))
 		if err != nil {
 			c.JSON(500, gin.H{
 				"message": err.Error(),
@@ -67,11 +302,11 @@ func main() {
 			return
 		}
 
-		defer resp.Body.Close()
-		c.Status(resp.StatusCode)
-		io.Copy(c.Writer, resp.Body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		defer valueCache.Invalidate(tenant.Prefix(ctx) + key)
+		forward(c, req.WithContext(ctx))
 	})
-	r.GET("/kv/get", func(c *gin.Context) {
+	r.GET("/kv/get", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
 		key := c.Query("key")
 		if key == "" {
 			c.JSON(400, gin.H{
@@ -88,7 +323,32 @@ func main() {
 			"key": {key},
 		}
 
-		req, err := http.NewRequest("GET", "http://localhost:8081/kv/get?"+params.Encode(), nil)
+		v, err := valueCache.Get(ctx, tenant.Prefix(ctx)+key, func(ctx context.Context) (cache.Value, error) {
+			req, err := http.NewRequest("GET", service2URL+"/kv/get?"+params.Encode(), nil)
+			if err != nil {
+				return cache.Value{}, err
+			}
+			return fetch(req.WithContext(ctx))
+		})
+		if err != nil {
+			c.JSON(kvhttp.FailureCode(err), gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"value":   v.Value,
+			"version": v.Version,
+		})
+	})
+	r.DELETE("/kv/:key", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
+		key := c.Param("key")
+		span, ctx := tracer.StartSpanFromContext(c.Request.Context(), "kv.delete")
+		defer span.Finish()
+		span.Tag("key", key)
+
+		req, err := http.NewRequest("DELETE", service2URL+"/kv/"+url.PathEscape(key), nil)
 		if err != nil {
 			c.JSON(500, gin.H{
 				"message": err.Error(),
@@ -96,9 +356,30 @@ func main() {
 			return
 		}
 
-		req = req.WithContext(ctx)
+		defer valueCache.Invalidate(tenant.Prefix(ctx) + key)
+		forward(c, req.WithContext(ctx))
+	})
+	r.HEAD("/kv/:key", kvhttp.Deprecated("/v1/kv/{key}"), func(c *gin.Context) {
+		key := c.Param("key")
+		span, ctx := tracer.StartSpanFromContext(c.Request.Context(), "kv.exists")
+		defer span.Finish()
+		span.Tag("key", key)
+
+		req, err := http.NewRequest("HEAD", service2URL+"/kv/"+url.PathEscape(key), nil)
+		if err != nil {
+			c.Status(500)
+			return
+		}
+
+		forward(c, req.WithContext(ctx))
+	})
+	r.GET("/kv/list", kvhttp.Deprecated("/v1/kv"), func(c *gin.Context) {
+		span, ctx := tracer.StartSpanFromContext(c.Request.Context(), "kv.list")
+		defer span.Finish()
+		span.Tag("prefix", c.Query("prefix"))
 
-		resp, err := httpClient.DoWithAppSpan(req, "service2")
+		// prefix, cursor and limit are validated by service2
+		req, err := http.NewRequest("GET", service2URL+"/kv/list?"+c.Request.URL.RawQuery, nil)
 		if err != nil {
 			c.JSON(500, gin.H{
 				"message": err.Error(),
@@ -106,27 +387,124 @@ func main() {
 			return
 		}
 
-		defer resp.Body.Close()
-		c.Status(resp.StatusCode)
-		io.Copy(c.Writer, resp.Body)
+		forward(c, req.WithContext(ctx))
 	})
-	log.Fatal(r.Run(":8082"))
+	log.Fatal(tlsconfig.ListenAndServe(tlsCerts, ":8082", r))
+}
+
+func service2KeyURL(key string) string {
+	return service2URL + "/v1/kv/" + url.PathEscape(key)
+}
+
+// forward sends req to service2 and relays its response. The Accept header
+// is passed on, so service2 answers in the format the caller negotiated.
+func forward(c *gin.Context, req *http.Request) {
+	if accept := c.GetHeader("Accept"); accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	// service2 gets the remaining budget less what service1 needs to answer,
+	// refreshed for every attempt
+	req, cancel := deadline.Outgoing(req)
+	defer cancel()
+	resp, err := retry.Do(retryPolicy, retry.Zipkin(tracer), req, func(req *http.Request) (*http.Response, error) {
+		deadline.SetHeader(req)
+		auth.SetHeader(req)
+		return httpClient.DoWithAppSpan(req, "service2")
+	})
+	if err != nil {
+		c.JSON(kvhttp.FailureCode(err), gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	c.Status(resp.StatusCode)
+	io.Copy(c.Writer, resp.Body)
+}
+
+// enqueue adds a put to the stream of the workers of service2 and answers
+// 202 with the ID of its entry in X-Put-Id. Whether the put succeeds only
+// shows in the trace of the worker that applies it.
+func enqueue(ctx context.Context, c *gin.Context, key string, body kvhttp.PutBody) {
+	tenantID, _ := tenant.FromContext(ctx)
+	id, err := putQueue.Add(ctx, putqueue.Put{
+		Tenant:          tenantID,
+		Key:             key,
+		Value:           body.Value,
+		TTLSeconds:      body.TTLSeconds,
+		ExpectedVersion: body.Version,
+	})
+	if err != nil {
+		kvhttp.RespondError(c, kvhttp.FailureCode(err), err.Error())
+		return
+	}
+
+	c.Header("X-Put-Id", id)
+	kvhttp.Respond(c, 202, kvhttp.KV{Key: key})
+}
+
+// fetch gets the value of a key from service2 through req, for valueCache,
+// which keeps values rather than responses: service1 answers every get in
+// the format it asks for.
+func fetch(req *http.Request) (cache.Value, error) {
+	req.Header.Set("Accept", "application/json")
+	req, cancel := deadline.Outgoing(req)
+	defer cancel()
+	resp, err := retry.Do(retryPolicy, retry.Zipkin(tracer), req, func(req *http.Request) (*http.Response, error) {
+		deadline.SetHeader(req)
+		auth.SetHeader(req)
+		return httpClient.DoWithAppSpan(req, "service2")
+	})
+	if err != nil {
+		return cache.Value{}, err
+	}
+
+	defer resp.Body.Close()
+	kv, err := kvhttp.DecodeKV(resp)
+	if err != nil {
+		return cache.Value{}, err
+	}
+	return cache.Value{Value: kv.Value, Version: kv.Version}, nil
 }
 
 func createHttpClient() {
 	var err error
-	httpClient, err = zipkinhttp.NewClient(tracer, zipkinhttp.ClientTrace(true))
+	service2URL = tlsconfig.URL(tlsCerts, "service2", "localhost:8081")
+	settings := breaker.DefaultSettings()
+	settings.FailureRate = *failureRate
+	settings.OpenTimeout = *openTimeout
+	guard := breaker.NewGuard("service2", settings, breaker.NewBulkhead(*bulkhead, 0), breaker.Zipkin(tracer), meterProvider)
+	// the client span of zipkinhttp wraps the guarded transport, so a rejected
+	// request shows up as a failed client span with the rejection beneath it
+	httpClient, err = zipkinhttp.NewClient(tracer,
+		zipkinhttp.ClientTrace(true),
+		zipkinhttp.WithClient(&http.Client{Transport: breaker.Transport(guard, tlsconfig.Transport(tlsCerts, "service2", "localhost:8081"))}),
+	)
 	if err != nil {
 		log.Fatalf("unable to create http client: %+v\n", err)
 	}
+	retryPolicy = retry.DefaultPolicy()
+	retryPolicy.MaxAttempts = *retries
+	retryPolicy.InitialBackoff = *retryBackoff
+	retryPolicy.NonIdempotent = *retryPut
 }
 
 func zipkinMiddleware() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		// probes are not traced
+		if readiness.IsProbe(c.Request) {
+			c.Next()
+			return
+		}
 		// get parent context using b3
 		spanContext := tracer.Extract(b3.ExtractHTTP(c.Request))
-		// start a new span
-		request := fmt.Sprintf("%s %s", c.Request.Method, c.Request.URL.Path)
+		// start a new span, named after the route template so that every key
+		// shares one span name
+		request := kvhttp.SpanName(c.Request.Method, c.FullPath())
 		span, ctx := tracer.StartSpanFromContext(c.Request.Context(), request, zipkin.Parent(spanContext))
 		defer span.Finish()
 		// set the new context
@@ -134,26 +512,106 @@ func zipkinMiddleware() func(c *gin.Context) {
 
 		span.Tag("http.method", c.Request.Method)
 		span.Tag("http.url", c.Request.URL.Path)
+		span.Tag("http.route", c.FullPath())
 		c.Next()
 		span.Tag("http.status_code", strconv.Itoa(c.Writer.Status()))
 		span.Tag("http.response_size", strconv.Itoa(c.Writer.Size()))
 	}
 }
 
+func loadCerts() {
+	var err error
+	tlsCerts, err = tlsconfig.Load(tlsconfig.Files{
+		Cert:       *tlsCert,
+		Key:        *tlsKey,
+		CA:         *tlsCA,
+		ClientAuth: *tlsClientAuth,
+		Use:        tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %+v\n", err)
+	}
+}
+
 func createRedisClient() {
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
+	addrs := redisclient.ParseAddrs(*redisAddrs)
+	if len(addrs) == 0 {
+		log.Fatalf("invalid -redis-addrs: %q\n", *redisAddrs)
+	}
+	// with TLS, every node is verified against the host of the first address
+	var err error
+	redisClient, err = redisclient.New(redisclient.Config{
+		Mode:          *redisMode,
+		Addrs:         addrs,
+		MasterName:    *redisMaster,
+		PoolSize:      *redisPool,
+		MinIdleConns:  *redisMinIdle,
+		PoolTimeout:   *redisPoolWait,
+		DialTimeout:   *redisDial,
+		ReadTimeout:   *redisRead,
+		WriteTimeout:  *redisWrite,
+		TLSConfig:     tlsCerts.ClientConfig("redis", addrs[0]),
+		Report:        redisclient.Zipkin,
+		MeterProvider: meterProvider,
 	})
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	err := redisClient.Ping(ctx).Err()
 	if err != nil {
-		log.Fatalf("redis ping error: %+v\n", err)
+		log.Fatalf("invalid Redis configuration: %+v\n", err)
+	}
+	// serve without Redis rather than exit, /readyz failing until it answers
+	if err := redisclient.Wait(context.Background(), redisClient, *redisWait); err != nil {
+		log.Printf("redis ping error: %+v\n", err)
+	}
+}
+
+// createThrottle returns the rate limiter of the API, nil if -rate-limit is 0.
+func createThrottle() *ratelimit.Throttle {
+	key, err := ratelimit.ParseKey(*rateLimitKey)
+	if err != nil {
+		log.Fatalf("invalid -rate-limit-key: %v", err)
+	}
+	if *rateLimit <= 0 {
+		return nil
+	}
+	if *rateBurst < 1 {
+		log.Fatalf("invalid -rate-burst: %d", *rateBurst)
+	}
+	return newThrottle(ratelimit.Limit{Rate: *rateLimit, Burst: *rateBurst}, key)
+}
+
+// createAuthThrottle returns the rate limiter of the requests by client IP
+// before they are authenticated, nil if they are not or -auth-rate-limit is 0.
+func createAuthThrottle(authenticated bool) *ratelimit.Throttle {
+	if !authenticated || *authRateLimit <= 0 {
+		return nil
+	}
+	if *authRateBurst < 1 {
+		log.Fatalf("invalid -auth-rate-burst: %d", *authRateBurst)
+	}
+	return newThrottle(ratelimit.Limit{Rate: *authRateLimit, Burst: *authRateBurst}, ratelimit.KeyUnauthenticated)
+}
+
+func newThrottle(limit ratelimit.Limit, key ratelimit.Key) *ratelimit.Throttle {
+	var limiter ratelimit.Limiter = ratelimit.NewLocal(limit)
+	if *rateRedis {
+		limiter = ratelimit.Redis(redisClient, limit)
 	}
+	return ratelimit.New(limiter, key, ratelimit.Zipkin, meterProvider)
 }
 
 func createTracer() {
-	reporter := httpreporter.NewReporter("http://localhost:9411/api/v2/spans", httpreporter.Timeout(time.Second*5))
+	redactor, err := redact.Load(*redactPolicy)
+	if err != nil {
+		log.Fatalf("invalid -redact-policy: %+v\n", err)
+	}
+	// redact tags before they are reported, by default KV values never are
+	reporter := redact.Reporter(redactor, httpreporter.NewReporter(tlsconfig.URL(tlsCerts, "collector", "localhost:9411")+"/api/v2/spans",
+		httpreporter.Timeout(time.Second*5),
+		httpreporter.Client(&http.Client{Transport: tlsconfig.Transport(tlsCerts, "collector", "localhost:9411")}),
+	))
+	// tag the spans of a request with the baggage that -baggage-attributes
+	// allows, before they are redacted
+	baggageHook = baggageattr.NewZipkinHook(baggagePolicy)
+	reporter = baggageHook.Reporter(reporter)
 	// 初始化endpoint
 	endpoint, err := zipkin.NewEndpoint("service1", "localhost:8082")
 	if err != nil {
@@ -172,3 +630,42 @@ func createTracer() {
 		log.Fatalf("unable to create tracer: %+v\n", err)
 	}
 }
+
+// createMeter 把指标以 OTLP 发往 -metrics-endpoint 的 collector，Zipkin 本身不接收指标；
+// 没有指定时丢弃指标
+func createMeter() func() {
+	if *metricsAddr == "" {
+		meterProvider = noop.NewMeterProvider()
+		return func() {}
+	}
+	conn, err := grpc.NewClient(*metricsAddr,
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "collector", *metricsAddr)))
+	if err != nil {
+		log.Fatalf("invalid -metrics-endpoint: %+v\n", err)
+	}
+	exporter, err := otlpmetricgrpc.New(context.Background(), otlpmetricgrpc.WithGRPCConn(conn))
+	if err != nil {
+		log.Fatalf("failed to create the metric exporter: %+v\n", err)
+	}
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(
+			semconv.ServiceName("service1"),
+			semconv.ServiceVersion("1.0.0"),
+		),
+	)
+	if err != nil {
+		log.Fatalf("failed to create the metric resource: %+v\n", err)
+	}
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(provider)
+	meterProvider = provider
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			log.Printf("failed to shut down the meter provider: %+v\n", err)
+		}
+		conn.Close()
+	}
+}
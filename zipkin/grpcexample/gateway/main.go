@@ -0,0 +1,183 @@
+// Command gateway serves the Storage service of service1 as HTTP/JSON through
+// grpc-gateway, e.g. PUT /v1/kv/{key} with a body of {"value": "..."}.
+//
+// Callers may send their trace context as B3 or W3C traceparent headers; the
+// gateway continues that trace and passes it on to service1.
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/openzipkin/zipkin-go"
+	zikpingrpc "github.com/openzipkin/zipkin-go/middleware/grpc"
+	zipkinhttp "github.com/openzipkin/zipkin-go/middleware/http"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+	httpreporter "github.com/openzipkin/zipkin-go/reporter/http"
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/tenant"
+	"go-service-tracing/internal/tlsconfig"
+	"google.golang.org/grpc"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	tlsCerts *tlsconfig.Reloader
+	tracer   *zipkin.Tracer
+
+	addr          = flag.String("addr", ":8080", "HTTP listen address")
+	service1Addr  = flag.String("service1", "localhost:8081", "address of the service1 gRPC server")
+	tlsUse        = flag.String("tls", "", "connections that use TLS, any of server,service1,collector")
+	tlsCert       = flag.String("tls-cert", "", "certificate file of the gateway, served and presented to peers that ask for one")
+	tlsKey        = flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA         = flag.String("tls-ca", "", "CA file to verify peers against, the system roots if empty")
+	tlsClientAuth = flag.Bool("tls-client-auth", false, "require clients to present a certificate signed by -tls-ca")
+)
+
+func main() {
+	flag.Parse()
+	loadCerts()
+	createTracer()
+
+	sh := zikpingrpc.NewClientHandler(tracer, zikpingrpc.WithRemoteServiceName("service1"))
+	conn, err := grpc.NewClient(*service1Addr,
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "service1", *service1Addr)),
+		grpc.WithStatsHandler(sh),
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	mux := runtime.NewServeMux(
+		runtime.WithMiddlewares(routeSpanName),
+		runtime.WithIncomingHeaderMatcher(incomingHeader),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeader),
+	)
+	if err := storagev1.RegisterStorageHandler(context.Background(), mux, conn); err != nil {
+		log.Fatalf("register gateway error: %+v\n", err)
+	}
+
+	// 服务端span从B3头中提取上游的trace，网关调用service1时处于该span之下，
+	// gRPC客户端span因此延续同一个trace
+	handler := traceparentToB3(zipkinhttp.NewServerMiddleware(tracer)(mux))
+
+	log.Printf("gateway listening at %v", *addr)
+	if err := tlsconfig.ListenAndServe(tlsCerts, *addr, handler); err != nil {
+		panic(err)
+	}
+}
+
+// routeSpanName names the server span after the path template of the matched
+// HTTP rule, e.g. "GET /v1/kv/{key}", so that every key shares one span name.
+func routeSpanName(next runtime.HandlerFunc) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		if pattern, ok := runtime.HTTPPattern(r.Context()); ok {
+			route := httpRoute(pattern)
+			if span := zipkin.SpanFromContext(r.Context()); span != nil {
+				span.SetName(r.Method + " " + route)
+				span.Tag("http.route", route)
+			}
+		}
+		next(w, r, pathParams)
+	}
+}
+
+// httpRoute returns the path template as written in the HTTP rule. Pattern
+// spells single-segment variables out as {key=*}.
+func httpRoute(pattern runtime.Pattern) string {
+	return strings.ReplaceAll(pattern.String(), "=*}", "}")
+}
+
+// traceparentToB3 lets callers that only speak W3C Trace Context join the
+// trace: zipkin-go only extracts B3, so a valid traceparent is rewritten into
+// the single b3 header. Requests that already carry B3 are left alone.
+func traceparentToB3(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(b3.Context) == "" && r.Header.Get(b3.TraceID) == "" {
+			if header, ok := b3FromTraceparent(r.Header.Get("traceparent")); ok {
+				r.Header.Set(b3.Context, header)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// b3FromTraceparent converts a traceparent of the form
+// version-traceid-parentid-flags into traceid-spanid-sampled.
+func b3FromTraceparent(traceparent string) (string, bool) {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", false
+	}
+	// version 00 has exactly four fields, later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", false
+	}
+	sampled := "0"
+	if strings.ContainsRune("13579bdf", rune(parts[3][1])) {
+		sampled = "1"
+	}
+	return parts[1] + "-" + parts[2] + "-" + sampled, true
+}
+
+// incomingHeader passes the X-Tenant-Id header on to service1 as metadata,
+// next to the headers grpc-gateway forwards by default.
+func incomingHeader(key string) (string, bool) {
+	if http.CanonicalHeaderKey(key) == tenant.Header {
+		return key, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// outgoingHeader answers the retry-after of a rate limited call as the
+// Retry-After header, and the rest of the metadata as grpc-gateway does by
+// default.
+func outgoingHeader(key string) (string, bool) {
+	if key == "retry-after" {
+		return "Retry-After", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+func loadCerts() {
+	var err error
+	tlsCerts, err = tlsconfig.Load(tlsconfig.Files{
+		Cert:       *tlsCert,
+		Key:        *tlsKey,
+		CA:         *tlsCA,
+		ClientAuth: *tlsClientAuth,
+		Use:        tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %+v\n", err)
+	}
+}
+
+func createTracer() {
+	reporter := httpreporter.NewReporter(tlsconfig.URL(tlsCerts, "collector", "localhost:9411")+"/api/v2/spans",
+		httpreporter.Timeout(time.Second*5),
+		httpreporter.Client(&http.Client{Transport: tlsconfig.Transport(tlsCerts, "collector", "localhost:9411")}),
+	)
+	// 初始化endpoint
+	endpoint, err := zipkin.NewEndpoint("gateway", "localhost:8080")
+	if err != nil {
+		log.Fatalf("unable to create local endpoint: %+v\n", err)
+	}
+	sampler, err := zipkin.NewCountingSampler(1)
+	if err != nil {
+		log.Fatalf("unable to create sampler: %+v\n", err)
+	}
+	// 初始化tracer
+	tracer, err = zipkin.NewTracer(reporter,
+		zipkin.WithLocalEndpoint(endpoint),
+		zipkin.WithSampler(sampler),
+	)
+	if err != nil {
+		log.Fatalf("unable to create tracer: %+v\n", err)
+	}
+}
@@ -2,53 +2,398 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"flag"
 	"github.com/openzipkin/zipkin-go"
 	zikpingrpc "github.com/openzipkin/zipkin-go/middleware/grpc"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
 	httpreporter "github.com/openzipkin/zipkin-go/reporter/http"
 	"github.com/redis/go-redis/v9"
-	"go-service-tracing/zipkin/grpcexample/service1/service1"
-	"go-service-tracing/zipkin/grpcexample/service2/service2"
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/auth"
+	"go-service-tracing/internal/baggageattr"
+	"go-service-tracing/internal/breaker"
+	"go-service-tracing/internal/cache"
+	"go-service-tracing/internal/deadline"
+	"go-service-tracing/internal/messaging"
+	"go-service-tracing/internal/putqueue"
+	"go-service-tracing/internal/ratelimit"
+	"go-service-tracing/internal/readiness"
+	"go-service-tracing/internal/redact"
+	"go-service-tracing/internal/redisclient"
+	"go-service-tracing/internal/retry"
+	"go-service-tracing/internal/tenant"
+	"go-service-tracing/internal/tlsconfig"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"strconv"
+	"sync"
 	"time"
 )
 
 var (
-	tracer      *zipkin.Tracer
-	redisClient *redis.Client
-	svc2Client  service2.StorageClient
+	tlsCerts      *tlsconfig.Reloader
+	tracer        *zipkin.Tracer
+	meterProvider metric.MeterProvider
+	redisClient   redis.UniversalClient
+	svc2Conn      *grpc.ClientConn
+	svc2Client    storagev1.StorageClient
+	baggagePolicy baggageattr.Policy
+	baggageHook   *baggageattr.ZipkinHook
+	valueCache    *cache.Cache
+	putQueue      *putqueue.Producer
+
+	batchSpans    = flag.String("batch-spans", "stream", "BatchPut spans: stream for one span per stream, message for a child span per message")
+	timeouts      = flag.String("timeouts", "default=2s", "timeouts per RPC, e.g. default=2s,/storage.v1.Storage/List=5s")
+	retries       = flag.Int("retries", 3, "attempts per call to service2, 1 to disable retries")
+	retryBackoff  = flag.Duration("retry-backoff", 50*time.Millisecond, "pause before the first retry, doubled for each one after")
+	retryPut      = flag.Bool("retry-put", false, "retry Put as well, which may write a value twice")
+	retryConfig   = flag.Bool("retry-service-config", false, "leave retries to grpc-go through a service config instead of an interceptor")
+	failureRate   = flag.Float64("breaker-failure-rate", 0.5, "share of failed calls to service2 that opens the circuit breaker, 0 to disable it")
+	openTimeout   = flag.Duration("breaker-open-timeout", 5*time.Second, "how long the circuit breaker stays open")
+	bulkhead      = flag.Int("bulkhead", 32, "calls to service2 in flight at once, 0 for no limit")
+	redactPolicy  = flag.String("redact-policy", "", "YAML policy that redacts span tags, the built-in one if empty")
+	tlsUse        = flag.String("tls", "", "connections that use TLS, any of server,service2,collector,redis")
+	tlsCert       = flag.String("tls-cert", "", "certificate file of this service, served and presented to peers that ask for one")
+	tlsKey        = flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA         = flag.String("tls-ca", "", "CA file to verify peers against, the system roots if empty")
+	tlsClientAuth = flag.Bool("tls-client-auth", false, "require clients to present a certificate signed by -tls-ca")
+	metricsAddr   = flag.String("metrics-endpoint", "", "OTLP gRPC address of a collector that receives the metrics, e.g. localhost:4317, none if empty")
+	authConfig    = flag.String("auth", "", "authentication config file of API keys, HMAC keys and a JWKS, empty to leave the API open")
+	requireTenant = flag.Bool("require-tenant", false, "reject requests without a tenant")
+	baggageAttrs  = flag.String("baggage-attributes", "", "baggage members recorded on every span and the only ones kept from clients, e.g. user.tier,experiment.*")
+	baggageMax    = flag.Int("baggage-max-members", baggageattr.DefaultMaxMembers, "most baggage members kept, 0 for no limit")
+	baggageValue  = flag.Int("baggage-max-value", baggageattr.DefaultMaxValueLength, "longest baggage value kept, 0 for no limit")
+	rateLimit     = flag.Float64("rate-limit", 0, "requests per second per client, 0 for no limit")
+	rateBurst     = flag.Int("rate-burst", 20, "requests a client may make at once before -rate-limit applies")
+	rateLimitKey  = flag.String("rate-limit-key", "ip", "what a client is: ip, principal or tenant, the last two falling back to ip")
+	rateRedis     = flag.Bool("rate-limit-redis", false, "keep the buckets of the clients in Redis, shared by every replica of service1")
+	authRateLimit = flag.Float64("auth-rate-limit", 50, "requests per second per client IP before authentication, which bounds the attempts at guessing credentials, 0 for no limit")
+	authRateBurst = flag.Int("auth-rate-burst", 100, "requests a client IP may make at once before -auth-rate-limit applies")
+	redisMode     = flag.String("redis-mode", redisclient.ModeSingle, "Redis deployment: single, sentinel or cluster")
+	redisAddrs    = flag.String("redis-addrs", redisclient.DefaultAddr, "comma separated addresses of the Redis server, of the Sentinels or of some Cluster nodes")
+	redisMaster   = flag.String("redis-master", "", "master that the Sentinels watch, with -redis-mode sentinel")
+	redisPool     = flag.Int("redis-pool-size", 0, "most connections to each Redis node, 0 for 10 per CPU")
+	redisMinIdle  = flag.Int("redis-min-idle", 0, "idle connections to each Redis node kept open")
+	redisPoolWait = flag.Duration("redis-pool-timeout", 0, "how long a Redis command waits for a connection when the pool is exhausted, 0 for the read timeout plus 1s")
+	redisDial     = flag.Duration("redis-dial-timeout", 0, "bound of connecting to Redis, 0 for 5s")
+	redisRead     = flag.Duration("redis-read-timeout", 0, "bound of reading a Redis reply, 0 for 3s")
+	redisWrite    = flag.Duration("redis-write-timeout", 0, "bound of writing a Redis command, 0 for the read timeout")
+	redisWait     = flag.Duration("redis-wait", redisclient.DefaultWait, "how long startup waits for Redis to answer before serving without it")
+	cacheSize     = flag.Int("cache-size", 0, "keys in the read-through cache of gets, 0 to disable it")
+	cacheTTL      = flag.Duration("cache-ttl", 5*time.Second, "how long the cache serves a value")
+	asyncPut      = flag.Bool("async-put", false, "queue Put on a Redis Stream for the workers of service2 rather than forward it")
+	putStream     = flag.String("put-stream", putqueue.DefaultStream, "Redis Stream of the queued puts")
 )
 
 type server struct {
-	service1.UnimplementedStorageServer
+	storagev1.UnimplementedStorageServer
+}
+
+func (s server) Put(ctx context.Context, req *storagev1.PutRequest) (*storagev1.PutResponse, error) {
+	if putQueue != nil {
+		// 写入应用之后缓存才去掉这个键，见 main
+		return enqueue(ctx, req)
+	}
+	defer valueCache.Invalidate(tenant.Prefix(ctx) + req.Key)
+	resp, err := svc2Client.Put(ctx, req)
+	if err != nil {
+		return nil, relayError("put", err)
+	}
+	return resp, nil
+}
+
+func (s server) Get(ctx context.Context, req *storagev1.GetRequest) (*storagev1.GetResponse, error) {
+	v, err := valueCache.Get(ctx, tenant.Prefix(ctx)+req.Key, func(ctx context.Context) (cache.Value, error) {
+		resp, err := svc2Client.Get(ctx, req)
+		if err != nil {
+			return cache.Value{}, err
+		}
+		return cache.Value{Value: resp.Value, Version: resp.Version}, nil
+	})
+	if err != nil {
+		return nil, relayError("get", err)
+	}
+	return &storagev1.GetResponse{Value: v.Value, Version: v.Version}, nil
+}
+
+func (s server) Delete(ctx context.Context, req *storagev1.DeleteRequest) (*storagev1.DeleteResponse, error) {
+	defer valueCache.Invalidate(tenant.Prefix(ctx) + req.Key)
+	resp, err := svc2Client.Delete(ctx, req)
+	if err != nil {
+		return nil, relayError("delete", err)
+	}
+	return resp, nil
 }
 
-func (s server) Put(ctx context.Context, req *service1.PutRequest) (*service1.PutResponse, error) {
-	_, err := svc2Client.Put(ctx, &service2.PutRequest{
-		Key:   req.Key,
-		Value: req.Value,
+func (s server) Exists(ctx context.Context, req *storagev1.ExistsRequest) (*storagev1.ExistsResponse, error) {
+	resp, err := svc2Client.Exists(ctx, req)
+	if err != nil {
+		return nil, relayError("exists", err)
+	}
+	return resp, nil
+}
+
+func (s server) List(ctx context.Context, req *storagev1.ListRequest) (*storagev1.ListResponse, error) {
+	resp, err := svc2Client.List(ctx, req)
+	if err != nil {
+		return nil, relayError("list", err)
+	}
+	return resp, nil
+}
+
+// enqueue adds a put to the stream of the workers of service2 and answers
+// with the ID of its entry in the put-id header. The version of the
+// response is 0, as the put is applied later.
+func enqueue(ctx context.Context, req *storagev1.PutRequest) (*storagev1.PutResponse, error) {
+	tenantID, _ := tenant.FromContext(ctx)
+	id, err := putQueue.Add(ctx, putqueue.Put{
+		Tenant:          tenantID,
+		Key:             req.Key,
+		Value:           req.Value,
+		TTLSeconds:      req.TtlSeconds,
+		ExpectedVersion: req.ExpectedVersion,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("service2 put error: %+v", err)
+		return nil, status.Errorf(grpccodes.Unavailable, "queue put error: %+v", err)
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("put-id", id))
+	return &storagev1.PutResponse{}, nil
+}
+
+// relayError keeps the status code and details of a service2 error, so that
+// callers can still tell a missing key, a version conflict or an exhausted
+// quota from a failure.
+func relayError(op string, err error) error {
+	st := status.Convert(err).Proto()
+	st.Message = "service2 " + op + " error: " + st.Message
+	return status.FromProto(st).Err()
+}
+
+// Watch relays service2's event stream, reporting a span per event in the
+// trace of the Put that produced it.
+func (s server) Watch(req *storagev1.WatchRequest, stream storagev1.Storage_WatchServer) error {
+	ctx := stream.Context()
+	events, err := svc2Client.Watch(ctx, req)
+	if err != nil {
+		return relayError("watch", err)
+	}
+
+	for {
+		event, err := events.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return relayError("watch", err)
+		}
+
+		carrier := b3.Map(event.TraceContext)
+		putContext := tracer.Extract(carrier.Extract)
+		span := tracer.StartSpan("service1.watch.event", zipkin.Parent(putContext))
+		span.Tag("key", event.Key)
+		err = stream.Send(event)
+		span.Finish()
+		if err != nil {
+			return err
+		}
 	}
-	return &service1.PutResponse{}, nil
 }
 
-func (s server) Get(ctx context.Context, req *service1.GetRequest) (*service1.GetResponse, error) {
-	resp, err := svc2Client.Get(ctx, &service2.GetRequest{Key: req.Key})
+// BatchPut relays the stream to service2 and its results back. Results come
+// back in request order, so with -batch-spans message the span of a request
+// finishes when the next result arrives.
+func (s server) BatchPut(stream storagev1.Storage_BatchPutServer) error {
+	span, ctx := tracer.StartSpanFromContext(stream.Context(), "service1.batchput")
+	defer span.Finish()
+
+	upstreamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	upstream, err := svc2Client.BatchPut(upstreamCtx)
 	if err != nil {
-		return nil, fmt.Errorf("service2 get error: %+v", err)
+		zipkin.TagError.Set(span, err.Error())
+		return relayError("batch put", err)
+	}
+
+	var (
+		counts batchCounts
+		mu     sync.Mutex
+		spans  []zipkin.Span
+		// keys of the requests still waiting for their result
+		keys []string
+		// stopped is set once the stream ends; the forwarding stops then, as
+		// it may still be waiting for a request of the client
+		stopped bool
+	)
+	defer func() {
+		cancel()
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		for _, msgSpan := range spans {
+			msgSpan.Finish()
+		}
+		// the writes may have happened without their result coming back
+		for _, key := range keys {
+			valueCache.Invalidate(tenant.Prefix(ctx) + key)
+		}
+		counts.tag(span)
+	}()
+
+	forwardErr := make(chan error, 1)
+	go func() {
+		var index int64
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				forwardErr <- upstream.CloseSend()
+				return
+			}
+			if err != nil {
+				forwardErr <- err
+				return
+			}
+
+			mu.Lock()
+			if stopped {
+				mu.Unlock()
+				forwardErr <- nil
+				return
+			}
+			counts.received++
+			counts.receivedBytes += int64(proto.Size(req))
+			keys = append(keys, req.Key)
+			if *batchSpans == "message" {
+				msgSpan, _ := tracer.StartSpanFromContext(ctx, "service1.batchput.message")
+				msgSpan.Tag("rpc.message.id", strconv.FormatInt(index, 10))
+				msgSpan.Tag("rpc.message.uncompressed_size", strconv.Itoa(proto.Size(req)))
+				msgSpan.Tag("key", req.Key)
+				spans = append(spans, msgSpan)
+			}
+			mu.Unlock()
+			index++
+
+			err = upstream.Send(req)
+			if err != nil {
+				// the reason is returned by upstream.Recv
+				forwardErr <- nil
+				return
+			}
+		}
+	}()
+
+	for {
+		result, err := upstream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			zipkin.TagError.Set(span, err.Error())
+			return relayError("batch put", err)
+		}
+
+		mu.Lock()
+		if len(spans) > 0 {
+			if result.Error != "" {
+				zipkin.TagError.Set(spans[0], result.Error)
+			}
+			spans[0].Finish()
+			spans = spans[1:]
+		}
+		if result.Error != "" {
+			counts.errors++
+		}
+		if len(keys) > 0 {
+			valueCache.Invalidate(tenant.Prefix(ctx) + keys[0])
+			keys = keys[1:]
+		}
+		mu.Unlock()
+
+		err = stream.Send(result)
+		if err != nil {
+			zipkin.TagError.Set(span, err.Error())
+			return err
+		}
+
+		mu.Lock()
+		counts.sent++
+		counts.sentBytes += int64(proto.Size(result))
+		mu.Unlock()
+	}
+
+	if err := <-forwardErr; err != nil {
+		zipkin.TagError.Set(span, err.Error())
+		return err
 	}
-	return &service1.GetResponse{Value: resp.Value}, nil
+	return nil
+}
+
+// batchCounts is tagged on the span of a BatchPut stream.
+type batchCounts struct {
+	received, receivedBytes int64
+	sent, sentBytes         int64
+	errors                  int64
+}
+
+func (c *batchCounts) tag(span zipkin.Span) {
+	span.Tag("rpc.batch.messages_received", strconv.FormatInt(c.received, 10))
+	span.Tag("rpc.batch.bytes_received", strconv.FormatInt(c.receivedBytes, 10))
+	span.Tag("rpc.batch.messages_sent", strconv.FormatInt(c.sent, 10))
+	span.Tag("rpc.batch.bytes_sent", strconv.FormatInt(c.sentBytes, 10))
+	span.Tag("rpc.batch.errors", strconv.FormatInt(c.errors, 10))
 }
 
 func main() {
+	flag.Parse()
+	loadCerts()
+	baggagePolicy = baggageattr.Policy{
+		Allow:          baggageattr.ParseAllow(*baggageAttrs),
+		MaxMembers:     *baggageMax,
+		MaxValueLength: *baggageValue,
+	}
+	authenticator, err := auth.Load(*authConfig)
+	if err != nil {
+		log.Fatalf("invalid -auth: %+v\n", err)
+	}
+	if *batchSpans != "stream" && *batchSpans != "message" {
+		log.Fatalf("unknown -batch-spans %q", *batchSpans)
+	}
+	rpcTimeouts, err := deadline.ParseTimeouts(*timeouts)
+	if err != nil {
+		log.Fatalf("invalid -timeouts: %+v\n", err)
+	}
+
 	createTracer()
+	shutdownMeter := createMeter()
+	defer shutdownMeter()
 	createRedisClient()
+	if *asyncPut {
+		putQueue = putqueue.NewProducer(redisClient, *putStream, messaging.Zipkin(tracer))
+	}
 	createService2Client()
+	authThrottle := createAuthThrottle(authenticator != nil)
+	throttle := createThrottle()
+	valueCache = cache.New(*cacheSize, *cacheTTL, cache.Zipkin(tracer), meterProvider)
+	// 排队的写入由 service2 的 worker 应用之后才从缓存中去掉
+	if putQueue != nil && valueCache != nil {
+		go putqueue.Applied(context.Background(), redisClient, *putStream, valueCache.Invalidate)
+	}
 
 	listener, err := net.Listen("tcp", ":8081")
 	if err != nil {
@@ -56,9 +401,43 @@ func main() {
 	}
 	defer listener.Close()
 
-	sh := zikpingrpc.NewServerHandler(tracer)
-	s := grpc.NewServer(grpc.StatsHandler(sh))
-	service1.RegisterStorageServer(s, &server{})
+	// 健康检查不产生span
+	sh := readiness.SkipHealthChecks(zikpingrpc.NewServerHandler(tracer))
+	s := grpc.NewServer(
+		grpc.Creds(tlsconfig.ServerCredentials(tlsCerts)),
+		grpc.StatsHandler(sh),
+		// 按方法限制超时，并在服务端span上记录剩余时间
+		// 客户端的baggage只保留-baggage-attributes允许的成员
+		grpc.ChainUnaryInterceptor(
+			baggageattr.StripUnaryServerInterceptor(baggagePolicy),
+			authThrottle.UnaryServerInterceptor(),
+			auth.UnaryServerInterceptor(authenticator, auth.Zipkin),
+			tenant.UnaryServerInterceptor(*requireTenant, tenant.Zipkin),
+			baggageHook.UnaryServerInterceptor(),
+			throttle.UnaryServerInterceptor(),
+			deadline.UnaryServerInterceptor(rpcTimeouts, deadline.Zipkin),
+		),
+		grpc.ChainStreamInterceptor(
+			baggageattr.StripStreamServerInterceptor(baggagePolicy),
+			authThrottle.StreamServerInterceptor(),
+			auth.StreamServerInterceptor(authenticator, auth.Zipkin),
+			tenant.StreamServerInterceptor(*requireTenant, tenant.Zipkin),
+			baggageHook.StreamServerInterceptor(),
+			throttle.StreamServerInterceptor(),
+		),
+	)
+	storagev1.RegisterStorageServer(s, &server{})
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	reflection.Register(s)
+
+	// service1只有在service2就绪时才就绪
+	checker := readiness.NewChecker()
+	checker.Add("redis", readiness.Redis(redisClient))
+	checker.Add("service2", readiness.GRPCHealth(svc2Conn, storagev1.Storage_ServiceDesc.ServiceName))
+	checker.Add("zipkin", readiness.Dial("localhost:9411"))
+	checker.Serve(healthServer, "", storagev1.Storage_ServiceDesc.ServiceName)
+	checker.Start(context.Background())
 
 	log.Printf("server listening at %v", listener.Addr())
 	if err := s.Serve(listener); err != nil {
@@ -67,31 +446,150 @@ func main() {
 }
 
 func createService2Client() {
-	sh := zikpingrpc.NewClientHandler(tracer)
-	conn, err := grpc.NewClient("localhost:8082",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	sh := readiness.SkipHealthChecks(zikpingrpc.NewClientHandler(tracer))
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "service2", "localhost:8082")),
 		grpc.WithStatsHandler(sh),
-	)
+		// zipkin-go只传递B3，调用方身份和租户通过baggage元数据传递
+		grpc.WithChainUnaryInterceptor(auth.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(auth.StreamClientInterceptor()),
+	}
+	conn, err := grpc.NewClient("localhost:8082", append(opts, service2Options()...)...)
 	if err != nil {
 		panic(err)
 	}
-	svc2Client = service2.NewStorageClient(conn)
+	svc2Conn = conn
+	svc2Client = storagev1.NewStorageClient(conn)
+}
+
+func loadCerts() {
+	var err error
+	tlsCerts, err = tlsconfig.Load(tlsconfig.Files{
+		Cert:       *tlsCert,
+		Key:        *tlsKey,
+		CA:         *tlsCA,
+		ClientAuth: *tlsClientAuth,
+		Use:        tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %+v\n", err)
+	}
 }
 
 func createRedisClient() {
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
+	addrs := redisclient.ParseAddrs(*redisAddrs)
+	if len(addrs) == 0 {
+		log.Fatalf("invalid -redis-addrs: %q\n", *redisAddrs)
+	}
+	// with TLS, every node is verified against the host of the first address
+	var err error
+	redisClient, err = redisclient.New(redisclient.Config{
+		Mode:          *redisMode,
+		Addrs:         addrs,
+		MasterName:    *redisMaster,
+		PoolSize:      *redisPool,
+		MinIdleConns:  *redisMinIdle,
+		PoolTimeout:   *redisPoolWait,
+		DialTimeout:   *redisDial,
+		ReadTimeout:   *redisRead,
+		WriteTimeout:  *redisWrite,
+		TLSConfig:     tlsCerts.ClientConfig("redis", addrs[0]),
+		Report:        redisclient.Zipkin,
+		MeterProvider: meterProvider,
 	})
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	err := redisClient.Ping(ctx).Err()
 	if err != nil {
-		log.Fatalf("redis ping error: %+v\n", err)
+		log.Fatalf("invalid Redis configuration: %+v\n", err)
+	}
+	// serve without Redis rather than exit, /readyz failing until it answers
+	if err := redisclient.Wait(context.Background(), redisClient, *redisWait); err != nil {
+		log.Printf("redis ping error: %+v\n", err)
+	}
+}
+
+// service2Options 在截止时间内重试调用service2失败的请求：默认由拦截器重试并为每次尝试
+// 记录span，-retry-service-config时交给grpc-go按服务配置重试。
+// 每次尝试都要经过熔断器和舱壁，并预留出service1自己应答的时间
+func service2Options() []grpc.DialOption {
+	policy := retry.DefaultPolicy()
+	policy.MaxAttempts = *retries
+	policy.InitialBackoff = *retryBackoff
+	policy.NonIdempotent = *retryPut
+
+	settings := breaker.DefaultSettings()
+	settings.FailureRate = *failureRate
+	settings.OpenTimeout = *openTimeout
+	guard := breaker.NewGuard("service2", settings, breaker.NewBulkhead(*bulkhead, 0), breaker.Zipkin(tracer), meterProvider)
+
+	if *retryConfig {
+		service := storagev1.File_storage_v1_storage_proto.Services().ByName("Storage")
+		config, err := retry.ServiceConfig(policy, service)
+		if err != nil {
+			log.Fatalf("invalid -retry-backoff: %v", err)
+		}
+		return []grpc.DialOption{
+			grpc.WithDefaultServiceConfig(config),
+			grpc.WithChainUnaryInterceptor(
+				breaker.UnaryClientInterceptor(guard),
+				deadline.UnaryClientInterceptor(),
+			),
+		}
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(
+			retry.UnaryClientInterceptor(policy, retry.Zipkin(tracer)),
+			breaker.UnaryClientInterceptor(guard),
+			deadline.UnaryClientInterceptor(),
+		),
+	}
+}
+
+// createThrottle 创建接口的限流器，-rate-limit 为 0 时返回 nil
+func createThrottle() *ratelimit.Throttle {
+	key, err := ratelimit.ParseKey(*rateLimitKey)
+	if err != nil {
+		log.Fatalf("invalid -rate-limit-key: %+v\n", err)
+	}
+	if *rateLimit <= 0 {
+		return nil
 	}
+	if *rateBurst < 1 {
+		log.Fatalf("invalid -rate-burst: %d\n", *rateBurst)
+	}
+	return newThrottle(ratelimit.Limit{Rate: *rateLimit, Burst: *rateBurst}, key)
+}
+
+// createAuthThrottle 创建认证之前按客户端 IP 的限流器，不认证或 -auth-rate-limit 为 0 时返回 nil
+func createAuthThrottle(authenticated bool) *ratelimit.Throttle {
+	if !authenticated || *authRateLimit <= 0 {
+		return nil
+	}
+	if *authRateBurst < 1 {
+		log.Fatalf("invalid -auth-rate-burst: %d", *authRateBurst)
+	}
+	return newThrottle(ratelimit.Limit{Rate: *authRateLimit, Burst: *authRateBurst}, ratelimit.KeyUnauthenticated)
+}
+
+func newThrottle(limit ratelimit.Limit, key ratelimit.Key) *ratelimit.Throttle {
+	var limiter ratelimit.Limiter = ratelimit.NewLocal(limit)
+	if *rateRedis {
+		limiter = ratelimit.Redis(redisClient, limit)
+	}
+	return ratelimit.New(limiter, key, ratelimit.Zipkin, meterProvider)
 }
 
 func createTracer() {
-	reporter := httpreporter.NewReporter("http://localhost:9411/api/v2/spans", httpreporter.Timeout(time.Second*5))
+	redactor, err := redact.Load(*redactPolicy)
+	if err != nil {
+		log.Fatalf("invalid -redact-policy: %+v\n", err)
+	}
+	// 上报前脱敏，默认不上报KV的值
+	reporter := redact.Reporter(redactor, httpreporter.NewReporter(tlsconfig.URL(tlsCerts, "collector", "localhost:9411")+"/api/v2/spans",
+		httpreporter.Timeout(time.Second*5),
+		httpreporter.Client(&http.Client{Transport: tlsconfig.Transport(tlsCerts, "collector", "localhost:9411")}),
+	))
+	// 同一请求的span都打上-baggage-attributes允许的baggage标签，再脱敏
+	baggageHook = baggageattr.NewZipkinHook(baggagePolicy)
+	reporter = baggageHook.Reporter(reporter)
 	// 初始化endpoint
 	endpoint, err := zipkin.NewEndpoint("service1", "localhost:8081")
 	if err != nil {
@@ -110,3 +608,42 @@ func createTracer() {
 		log.Fatalf("unable to create tracer: %+v\n", err)
 	}
 }
+
+// createMeter 把指标以 OTLP 发往 -metrics-endpoint 的 collector，Zipkin 本身不接收指标；
+// 没有指定时丢弃指标
+func createMeter() func() {
+	if *metricsAddr == "" {
+		meterProvider = noop.NewMeterProvider()
+		return func() {}
+	}
+	conn, err := grpc.NewClient(*metricsAddr,
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "collector", *metricsAddr)))
+	if err != nil {
+		log.Fatalf("invalid -metrics-endpoint: %+v\n", err)
+	}
+	exporter, err := otlpmetricgrpc.New(context.Background(), otlpmetricgrpc.WithGRPCConn(conn))
+	if err != nil {
+		log.Fatalf("failed to create the metric exporter: %+v\n", err)
+	}
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(
+			semconv.ServiceName("service1"),
+			semconv.ServiceVersion("1.0.0"),
+		),
+	)
+	if err != nil {
+		log.Fatalf("failed to create the metric resource: %+v\n", err)
+	}
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(provider)
+	meterProvider = provider
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			log.Printf("failed to shut down the meter provider: %+v\n", err)
+		}
+		conn.Close()
+	}
+}
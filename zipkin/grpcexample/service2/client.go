@@ -1,32 +1,121 @@
+//go:build ignore
+
+// Run with: go run client.go, and against a service started with -tls server
+// e.g. go run client.go -tls service2 -tls-ca ../../../certs/ca.pem
 package main
 
 import (
 	"context"
-	"go-service-tracing/zipkin/grpcexample/service2/service2"
+	"flag"
+	"github.com/openzipkin/zipkin-go"
+	zikpingrpc "github.com/openzipkin/zipkin-go/middleware/grpc"
+	httpreporter "github.com/openzipkin/zipkin-go/reporter/http"
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/tlsconfig"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	tracer   *zipkin.Tracer
+	tlsCerts *tlsconfig.Reloader
+
+	tlsUse  = flag.String("tls", "", "connections that use TLS, any of service2,collector")
+	tlsCert = flag.String("tls-cert", "", "certificate file presented to servers that ask for one")
+	tlsKey  = flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA   = flag.String("tls-ca", "", "CA file to verify servers against, the system roots if empty")
 )
 
 func main() {
-	conn, err := grpc.NewClient("localhost:8082", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	flag.Parse()
+	loadCerts()
+	closeReporter := createTracer()
+	defer closeReporter()
+
+	sh := zikpingrpc.NewClientHandler(tracer, zikpingrpc.WithRemoteServiceName("service2"))
+	conn, err := grpc.NewClient("localhost:8082",
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "service2", "localhost:8082")),
+		grpc.WithStatsHandler(sh),
+	)
 	if err != nil {
 		panic(err)
 	}
 	defer conn.Close()
 
-	client := service2.NewStorageClient(conn)
-	_, err = client.Put(context.Background(), &service2.PutRequest{
+	client := storagev1.NewStorageClient(conn)
+
+	// 截止时间随每次调用传递，并在每一跳递减
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	span, putCtx := tracer.StartSpanFromContext(ctx, "client.put")
+	log.Printf("put trace id: %s", span.Context().TraceID)
+	_, err = client.Put(putCtx, &storagev1.PutRequest{
 		Key:   "test",
 		Value: "test2",
 	})
 	if err != nil {
+		zipkin.TagError.Set(span, err.Error())
+		span.Finish()
 		panic(err)
 	}
+	span.Finish()
 	log.Printf("put success")
-	resp, err := client.Get(context.Background(), &service2.GetRequest{Key: "test"})
+
+	span, getCtx := tracer.StartSpanFromContext(ctx, "client.get")
+	log.Printf("get trace id: %s", span.Context().TraceID)
+	resp, err := client.Get(getCtx, &storagev1.GetRequest{Key: "test"})
 	if err != nil {
+		zipkin.TagError.Set(span, err.Error())
+		span.Finish()
 		panic(err)
 	}
+	span.Finish()
 	log.Printf("get success, value: %s", resp.Value)
 }
+
+func loadCerts() {
+	var err error
+	tlsCerts, err = tlsconfig.Load(tlsconfig.Files{
+		Cert: *tlsCert,
+		Key:  *tlsKey,
+		CA:   *tlsCA,
+		Use:  tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %+v\n", err)
+	}
+}
+
+// createTracer returns a function that flushes and closes the reporter; call
+// it before exiting or the last spans are lost.
+func createTracer() func() {
+	reporter := httpreporter.NewReporter(tlsconfig.URL(tlsCerts, "collector", "localhost:9411")+"/api/v2/spans",
+		httpreporter.Timeout(time.Second*5),
+		httpreporter.Client(&http.Client{Transport: tlsconfig.Transport(tlsCerts, "collector", "localhost:9411")}),
+	)
+	// 初始化endpoint
+	endpoint, err := zipkin.NewEndpoint("client", "")
+	if err != nil {
+		log.Fatalf("unable to create local endpoint: %+v\n", err)
+	}
+	sampler, err := zipkin.NewCountingSampler(1)
+	if err != nil {
+		log.Fatalf("unable to create sampler: %+v\n", err)
+	}
+	// 初始化tracer
+	tracer, err = zipkin.NewTracer(reporter,
+		zipkin.WithLocalEndpoint(endpoint),
+		zipkin.WithSampler(sampler),
+	)
+	if err != nil {
+		log.Fatalf("unable to create tracer: %+v\n", err)
+	}
+	return func() {
+		if err := reporter.Close(); err != nil {
+			log.Printf("close reporter error: %+v\n", err)
+		}
+	}
+}
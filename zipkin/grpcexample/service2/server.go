@@ -2,58 +2,490 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/openzipkin/zipkin-go"
 	zikpingrpc "github.com/openzipkin/zipkin-go/middleware/grpc"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
 	httpreporter "github.com/openzipkin/zipkin-go/reporter/http"
 	"github.com/redis/go-redis/v9"
-	"go-service-tracing/zipkin/grpcexample/service2/service2"
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/auth"
+	"go-service-tracing/internal/baggageattr"
+	"go-service-tracing/internal/deadline"
+	"go-service-tracing/internal/kvstore"
+	"go-service-tracing/internal/messaging"
+	"go-service-tracing/internal/putqueue"
+	"go-service-tracing/internal/readiness"
+	"go-service-tracing/internal/redact"
+	"go-service-tracing/internal/redisclient"
+	"go-service-tracing/internal/tenant"
+	"go-service-tracing/internal/tlsconfig"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	watchChannel = "kv:watch:"
+
+	// maxPipeline caps how many BatchPut requests go into one Redis pipeline.
+	maxPipeline = 100
+)
+
 var (
-	tracer      *zipkin.Tracer
-	redisClient *redis.Client
+	tlsCerts      *tlsconfig.Reloader
+	tracer        *zipkin.Tracer
+	meterProvider metric.MeterProvider
+	redisClient   redis.UniversalClient
+	quotas        tenant.Quotas
+	baggagePolicy baggageattr.Policy
+	baggageHook   *baggageattr.ZipkinHook
+
+	batchSpans    = flag.String("batch-spans", "stream", "BatchPut spans: stream for one span per stream, message for a child span per message")
+	timeouts      = flag.String("timeouts", "default=1s", "timeouts per RPC, e.g. default=1s,/storage.v1.Storage/List=3s")
+	redisTimeout  = flag.Duration("redis-timeout", 500*time.Millisecond, "upper bound of a Redis command")
+	redisDelay    = flag.Duration("redis-delay", 0, "hold every Redis command back this long, to try out timeouts")
+	redisMode     = flag.String("redis-mode", redisclient.ModeSingle, "Redis deployment: single, sentinel or cluster")
+	redisAddrs    = flag.String("redis-addrs", redisclient.DefaultAddr, "comma separated addresses of the Redis server, of the Sentinels or of some Cluster nodes")
+	redisMaster   = flag.String("redis-master", "", "master that the Sentinels watch, with -redis-mode sentinel")
+	redisPool     = flag.Int("redis-pool-size", 0, "most connections to each Redis node, 0 for 10 per CPU")
+	redisMinIdle  = flag.Int("redis-min-idle", 0, "idle connections to each Redis node kept open")
+	redisPoolWait = flag.Duration("redis-pool-timeout", 0, "how long a Redis command waits for a connection when the pool is exhausted, 0 for the read timeout plus 1s")
+	redisDial     = flag.Duration("redis-dial-timeout", 0, "bound of connecting to Redis, 0 for 5s")
+	redisRead     = flag.Duration("redis-read-timeout", 0, "bound of reading a Redis reply, 0 for 3s")
+	redisWrite    = flag.Duration("redis-write-timeout", 0, "bound of writing a Redis command, 0 for the read timeout")
+	redisWait     = flag.Duration("redis-wait", redisclient.DefaultWait, "how long startup waits for Redis to answer before serving without it")
+	redactPolicy  = flag.String("redact-policy", "", "YAML policy that redacts span tags, the built-in one if empty")
+	tlsUse        = flag.String("tls", "", "connections that use TLS, any of server,collector,redis")
+	tlsCert       = flag.String("tls-cert", "", "certificate file of this service, served and presented to peers that ask for one")
+	tlsKey        = flag.String("tls-key", "", "key file of -tls-cert")
+	tlsCA         = flag.String("tls-ca", "", "CA file to verify peers against, the system roots if empty")
+	tlsClientAuth = flag.Bool("tls-client-auth", false, "require clients to present a certificate signed by -tls-ca")
+	metricsAddr   = flag.String("metrics-endpoint", "", "OTLP gRPC address of a collector that receives the metrics, e.g. localhost:4317, none if empty")
+	tenantQuotas  = flag.String("tenant-quotas", "", "maximum number of keys per tenant, e.g. default=1000,acme=50")
+	baggageAttrs  = flag.String("baggage-attributes", "", "baggage members recorded on every span, e.g. user.tier,experiment.*")
+	baggageMax    = flag.Int("baggage-max-members", baggageattr.DefaultMaxMembers, "most baggage members recorded, 0 for no limit")
+	baggageValue  = flag.Int("baggage-max-value", baggageattr.DefaultMaxValueLength, "longest baggage value recorded, 0 for no limit")
+	putWorker     = flag.Bool("put-worker", false, "apply the puts that service1 queues with -async-put")
+	putStream     = flag.String("put-stream", putqueue.DefaultStream, "Redis Stream of the queued puts")
+	putMinIdle    = flag.Duration("put-min-idle", putqueue.DefaultMinIdle, "how long a queued put that failed waits before it is delivered again")
+	putDeliveries = flag.Int64("put-max-deliveries", putqueue.DefaultMaxDeliveries, "deliveries of a queued put before it moves to the dead letter stream")
 )
 
 type server struct {
-	service2.UnimplementedStorageServer
+	storagev1.UnimplementedStorageServer
 }
 
-func (s server) Put(ctx context.Context, req *service2.PutRequest) (*service2.PutResponse, error) {
-	span, ctx := tracer.StartSpanFromContext(ctx, "redis.set")
+func (s server) Put(ctx context.Context, req *storagev1.PutRequest) (*storagev1.PutResponse, error) {
+	// the keys of a tenant live under its prefix
+	key := tenant.Prefix(ctx) + req.Key
+	span, setCtx := tracer.StartSpanFromContext(ctx, "redis.set")
 	defer span.Finish()
 
-	span.Tag("redis.key", req.Key)
+	span.Tag("redis.key", key)
 	span.Tag("redis.value", req.Value)
+	tagPut(span, req)
 
-	if err := redisClient.Set(ctx, req.Key, req.Value, time.Minute).Err(); err != nil {
-		return nil, fmt.Errorf("redis set error: %+v", err)
+	version, err := kvstore.Put(setCtx, redisClient, key, req.Value, putOptions(ctx, req)).Result()
+	if err != nil {
+		zipkin.TagError.Set(span, err.Error())
+		return nil, storeError("set", err)
+	}
+	span.Tag("kv.version", strconv.FormatInt(version, 10))
+
+	// the value is stored whether watchers hear of it or not; failing the Put
+	// would have the client, or the worker of a queued put, write it again
+	if err := publish(ctx, key, req.Value); err != nil {
+		log.Printf("redis publish error: %+v", err)
 	}
 
-	return &service2.PutResponse{}, nil
+	return &storagev1.PutResponse{Version: version}, nil
 }
 
-func (s server) Get(ctx context.Context, req *service2.GetRequest) (*service2.GetResponse, error) {
+func (s server) Get(ctx context.Context, req *storagev1.GetRequest) (*storagev1.GetResponse, error) {
+	key := tenant.Prefix(ctx) + req.Key
 	span, ctx := tracer.StartSpanFromContext(ctx, "redis.get")
 	defer span.Finish()
 
-	span.Tag("redis.key", req.Key)
+	span.Tag("redis.key", key)
+
+	value, version, err := kvstore.Get(ctx, redisClient, key)
+	if err != nil {
+		zipkin.TagError.Set(span, err.Error())
+		return nil, storeError("get", err)
+	}
+	span.Tag("kv.version", strconv.FormatInt(version, 10))
+
+	return &storagev1.GetResponse{Value: value, Version: version}, nil
+}
+
+func (s server) Delete(ctx context.Context, req *storagev1.DeleteRequest) (*storagev1.DeleteResponse, error) {
+	key := tenant.Prefix(ctx) + req.Key
+	span, ctx := tracer.StartSpanFromContext(ctx, "redis.del")
+	defer span.Finish()
+
+	span.Tag("redis.key", key)
+
+	deleted, err := kvstore.Delete(ctx, redisClient, key, quotas.For(ctx))
+	if err != nil {
+		zipkin.TagError.Set(span, err.Error())
+		return nil, storeError("del", err)
+	}
+	span.Tag("kv.deleted", strconv.FormatBool(deleted))
+
+	return &storagev1.DeleteResponse{Deleted: deleted}, nil
+}
+
+func (s server) Exists(ctx context.Context, req *storagev1.ExistsRequest) (*storagev1.ExistsResponse, error) {
+	key := tenant.Prefix(ctx) + req.Key
+	span, ctx := tracer.StartSpanFromContext(ctx, "redis.exists")
+	defer span.Finish()
+
+	span.Tag("redis.key", key)
+
+	exists, err := kvstore.Exists(ctx, redisClient, key)
+	if err != nil {
+		zipkin.TagError.Set(span, err.Error())
+		return nil, storeError("exists", err)
+	}
+	span.Tag("kv.exists", strconv.FormatBool(exists))
+
+	return &storagev1.ExistsResponse{Exists: exists}, nil
+}
+
+func (s server) List(ctx context.Context, req *storagev1.ListRequest) (*storagev1.ListResponse, error) {
+	namespace := tenant.Prefix(ctx)
+	prefix := namespace + req.Prefix
+	span, ctx := tracer.StartSpanFromContext(ctx, "redis.scan")
+	defer span.Finish()
+
+	span.Tag("kv.prefix", prefix)
+	span.Tag("kv.cursor", strconv.FormatUint(req.Cursor, 10))
+	span.Tag("kv.limit", strconv.FormatInt(req.Limit, 10))
+
+	keys, next, err := kvstore.List(ctx, redisClient, prefix, req.Cursor, req.Limit)
+	if err != nil {
+		zipkin.TagError.Set(span, err.Error())
+		return nil, storeError("scan", err)
+	}
+	span.Tag("kv.keys", strconv.Itoa(len(keys)))
+	span.Tag("kv.next_cursor", strconv.FormatUint(next, 10))
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, namespace)
+	}
+
+	return &storagev1.ListResponse{Keys: keys, NextCursor: next}, nil
+}
+
+// Watch relays Put events published on Redis. Zipkin has no span links, so
+// every event gets a span in the trace of the Put that produced it.
+func (s server) Watch(req *storagev1.WatchRequest, stream storagev1.Storage_WatchServer) error {
+	ctx := stream.Context()
+	// a tenant only hears about its own keys
+	namespace := tenant.Prefix(ctx)
+	var pubsub *redis.PubSub
+	if req.Key == "" {
+		pubsub = redisClient.PSubscribe(ctx, watchChannel+namespace+"*")
+	} else {
+		pubsub = redisClient.Subscribe(ctx, watchChannel+namespace+req.Key)
+	}
+	defer pubsub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-pubsub.Channel():
+			if !ok {
+				return nil
+			}
+			event := &storagev1.WatchEvent{}
+			if err := protojson.Unmarshal([]byte(msg.Payload), event); err != nil {
+				log.Printf("invalid watch event on %s: %+v", msg.Channel, err)
+				continue
+			}
+
+			carrier := b3.Map(event.TraceContext)
+			putContext := tracer.Extract(carrier.Extract)
+			span := tracer.StartSpan("service2.watch.event", zipkin.Parent(putContext))
+			span.Tag("redis.key", event.Key)
+			event.Key = strings.TrimPrefix(event.Key, namespace)
+			err := stream.Send(event)
+			span.Finish()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// BatchPut writes the requests of the stream with Redis pipelines. Requests
+// that are already waiting when a pipeline is sent go into it, so a client
+// streaming quickly gets large pipelines and one sending a request at a time
+// still gets its results back immediately.
+func (s server) BatchPut(stream storagev1.Storage_BatchPutServer) error {
+	span, ctx := tracer.StartSpanFromContext(stream.Context(), "service2.batchput")
+	defer span.Finish()
+
+	var counts batchCounts
+	defer counts.tag(span)
+
+	requests := make(chan *storagev1.PutRequest, maxPipeline)
+	recvErr := make(chan error, 1)
+	go func() {
+		defer close(requests)
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF {
+					recvErr <- err
+				}
+				return
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var index int64
+	for req := range requests {
+		batch := []*storagev1.PutRequest{req}
+	drain:
+		for len(batch) < maxPipeline {
+			select {
+			case req, ok := <-requests:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, req)
+			default:
+				break drain
+			}
+		}
+		for _, req := range batch {
+			counts.received++
+			counts.receivedBytes += int64(proto.Size(req))
+		}
+
+		for _, result := range putPipeline(ctx, index, batch) {
+			if result.Error != "" {
+				counts.errors++
+			}
+			if err := stream.Send(result); err != nil {
+				zipkin.TagError.Set(span, err.Error())
+				return err
+			}
+			counts.sent++
+			counts.sentBytes += int64(proto.Size(result))
+		}
+		index += int64(len(batch))
+	}
+
+	select {
+	case err := <-recvErr:
+		zipkin.TagError.Set(span, err.Error())
+		return err
+	default:
+		return nil
+	}
+}
+
+// putPipeline writes batch, whose first request is at index in the stream, in
+// one Redis pipeline and returns a result per request.
+func putPipeline(ctx context.Context, index int64, batch []*storagev1.PutRequest) []*storagev1.PutResult {
+	pipeSpan, pipeCtx := tracer.StartSpanFromContext(ctx, "redis.pipeline")
+	defer pipeSpan.Finish()
+	pipeSpan.Tag("redis.pipeline.size", strconv.Itoa(len(batch)))
+
+	results := make([]*storagev1.PutResult, len(batch))
+	spans := make([]zipkin.Span, len(batch))
+	puts := make([]*kvstore.PutCmd, len(batch))
+	publishes := make([]*redis.IntCmd, len(batch))
+	namespace := tenant.Prefix(ctx)
+	pipe := redisClient.Pipeline()
+	for i, req := range batch {
+		results[i] = &storagev1.PutResult{Index: index + int64(i), Key: req.Key}
+		key := namespace + req.Key
+
+		// without message spans, watchers report in the trace of the stream
+		eventContext := zipkin.SpanFromContext(ctx).Context()
+		if *batchSpans == "message" {
+			spans[i], _ = tracer.StartSpanFromContext(ctx, "service2.batchput.message")
+			spans[i].Tag("rpc.message.id", strconv.FormatInt(results[i].Index, 10))
+			spans[i].Tag("rpc.message.uncompressed_size", strconv.Itoa(proto.Size(req)))
+			spans[i].Tag("redis.key", key)
+			eventContext = spans[i].Context()
+		}
+
+		if req.Key == "" {
+			results[i].Error = "key is required"
+			continue
+		}
+		payload, err := watchEvent(eventContext, key, req.Value)
+		if err != nil {
+			results[i].Error = fmt.Sprintf("watch event error: %+v", err)
+			continue
+		}
+		puts[i] = kvstore.Put(pipeCtx, pipe, key, req.Value, putOptions(ctx, req))
+		publishes[i] = pipe.Publish(pipeCtx, watchChannel+key, payload)
+	}
+
+	// Exec only reports the first failure, every command carries its own
+	if _, err := pipe.Exec(pipeCtx); err != nil {
+		zipkin.TagError.Set(pipeSpan, err.Error())
+	}
+
+	for i, result := range results {
+		if puts[i] != nil {
+			version, err := puts[i].Result()
+			if err != nil {
+				result.Error = fmt.Sprintf("redis set error: %+v", err)
+			} else if err := publishes[i].Err(); err != nil {
+				// stored all the same, as with Put
+				pipeSpan.Annotate(time.Now(), fmt.Sprintf("redis publish error of message %d: %+v", result.Index, err))
+			}
+			result.Version = version
+		}
+		if spans[i] != nil {
+			if result.Error != "" {
+				zipkin.TagError.Set(spans[i], result.Error)
+			}
+			spans[i].Finish()
+		}
+	}
+	return results
+}
+
+// putOptions reads the options of req; a tenant's puts count against its
+// quota.
+func putOptions(ctx context.Context, req *storagev1.PutRequest) kvstore.PutOptions {
+	return kvstore.PutOptions{
+		TTL:             kvstore.TTLFromSeconds(req.TtlSeconds),
+		ExpectedVersion: req.ExpectedVersion,
+		Quota:           quotas.For(ctx),
+	}
+}
+
+func tagPut(span zipkin.Span, req *storagev1.PutRequest) {
+	span.Tag("kv.ttl_seconds", strconv.FormatInt(req.TtlSeconds, 10))
+	if req.ExpectedVersion != nil {
+		span.Tag("kv.expected_version", strconv.FormatInt(*req.ExpectedVersion, 10))
+	}
+}
 
-	value, err := redisClient.Get(ctx, req.Key).Result()
+// storeError keeps the cases a client can act on apart from other failures.
+func storeError(op string, err error) error {
+	switch {
+	case deadline.IsExceeded(err):
+		return status.Errorf(codes.DeadlineExceeded, "redis %s error: %+v", op, err)
+	case errors.Is(err, kvstore.ErrNotFound):
+		return status.Errorf(codes.NotFound, "redis %s error: %+v", op, err)
+	case errors.Is(err, kvstore.ErrVersionMismatch):
+		return status.Errorf(codes.Aborted, "redis %s error: %+v", op, err)
+	case errors.Is(err, kvstore.ErrQuotaExceeded):
+		return tenant.QuotaError(fmt.Errorf("redis %s error: %+v", op, err))
+	default:
+		return fmt.Errorf("redis %s error: %+v", op, err)
+	}
+}
+
+// batchCounts is tagged on the span of a BatchPut stream.
+type batchCounts struct {
+	received, receivedBytes int64
+	sent, sentBytes         int64
+	errors                  int64
+}
+
+func (c *batchCounts) tag(span zipkin.Span) {
+	span.Tag("rpc.batch.messages_received", strconv.FormatInt(c.received, 10))
+	span.Tag("rpc.batch.bytes_received", strconv.FormatInt(c.receivedBytes, 10))
+	span.Tag("rpc.batch.messages_sent", strconv.FormatInt(c.sent, 10))
+	span.Tag("rpc.batch.bytes_sent", strconv.FormatInt(c.sentBytes, 10))
+	span.Tag("rpc.batch.errors", strconv.FormatInt(c.errors, 10))
+}
+
+// publish notifies watchers of key.
+func publish(ctx context.Context, key, value string) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "redis.publish")
+	defer span.Finish()
+	span.Tag("redis.key", key)
+
+	payload, err := watchEvent(span.Context(), key, value)
+	if err == nil {
+		err = redisClient.Publish(ctx, watchChannel+key, payload).Err()
+	}
 	if err != nil {
-		return nil, fmt.Errorf("redis get error: %+v", err)
+		zipkin.TagError.Set(span, err.Error())
 	}
+	return err
+}
 
-	return &service2.GetResponse{Value: value}, nil
+// watchEvent encodes the event of a Put. The event carries the b3 context of
+// the Put so that watchers can report their spans in its trace.
+func watchEvent(sc model.SpanContext, key, value string) ([]byte, error) {
+	carrier := b3.Map{}
+	if err := carrier.Inject()(sc); err != nil {
+		return nil, err
+	}
+	return protojson.Marshal(&storagev1.WatchEvent{
+		Key:          key,
+		Value:        value,
+		TraceContext: carrier,
+	})
 }
 
 func main() {
+	flag.Parse()
+	loadCerts()
+	baggagePolicy = baggageattr.Policy{
+		Allow:          baggageattr.ParseAllow(*baggageAttrs),
+		MaxMembers:     *baggageMax,
+		MaxValueLength: *baggageValue,
+	}
+	if *batchSpans != "stream" && *batchSpans != "message" {
+		log.Fatalf("unknown -batch-spans %q", *batchSpans)
+	}
+	rpcTimeouts, err := deadline.ParseTimeouts(*timeouts)
+	if err != nil {
+		log.Fatalf("invalid -timeouts: %+v\n", err)
+	}
+	quotas, err = tenant.ParseQuotas(*tenantQuotas)
+	if err != nil {
+		log.Fatalf("invalid -tenant-quotas: %+v\n", err)
+	}
+
 	createTracer()
+	shutdownMeter := createMeter()
+	defer shutdownMeter()
 	createRedisClient()
+	if *putWorker {
+		startPutWorker()
+	}
 
 	listener, err := net.Listen("tcp", ":8082")
 	if err != nil {
@@ -61,9 +493,34 @@ func main() {
 	}
 	defer listener.Close()
 
-	sh := zikpingrpc.NewServerHandler(tracer)
-	s := grpc.NewServer(grpc.StatsHandler(sh))
-	service2.RegisterStorageServer(s, &server{})
+	// 健康检查不产生span
+	sh := readiness.SkipHealthChecks(zikpingrpc.NewServerHandler(tracer))
+	s := grpc.NewServer(
+		grpc.Creds(tlsconfig.ServerCredentials(tlsCerts)),
+		grpc.StatsHandler(sh),
+		// 按方法限制超时，并在服务端span上记录剩余时间
+		grpc.ChainUnaryInterceptor(
+			auth.IdentityUnaryServerInterceptor(auth.Zipkin),
+			tenant.BaggageUnaryServerInterceptor(tenant.Zipkin),
+			baggageHook.UnaryServerInterceptor(),
+			deadline.UnaryServerInterceptor(rpcTimeouts, deadline.Zipkin),
+		),
+		grpc.ChainStreamInterceptor(
+			auth.IdentityStreamServerInterceptor(auth.Zipkin),
+			tenant.BaggageStreamServerInterceptor(tenant.Zipkin),
+			baggageHook.StreamServerInterceptor(),
+		),
+	)
+	storagev1.RegisterStorageServer(s, &server{})
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	reflection.Register(s)
+
+	checker := readiness.NewChecker()
+	checker.Add("redis", readiness.Redis(redisClient))
+	checker.Add("zipkin", readiness.Dial("localhost:9411"))
+	checker.Serve(healthServer, "", storagev1.Storage_ServiceDesc.ServiceName)
+	checker.Start(context.Background())
 
 	log.Printf("server listening at %v", listener.Addr())
 	if err := s.Serve(listener); err != nil {
@@ -71,20 +528,101 @@ func main() {
 	}
 }
 
+func loadCerts() {
+	var err error
+	tlsCerts, err = tlsconfig.Load(tlsconfig.Files{
+		Cert:       *tlsCert,
+		Key:        *tlsKey,
+		CA:         *tlsCA,
+		ClientAuth: *tlsClientAuth,
+		Use:        tlsconfig.ParseUse(*tlsUse),
+	})
+	if err != nil {
+		log.Fatalf("invalid TLS configuration: %+v\n", err)
+	}
+}
+
+// startPutWorker applies the puts that service1 queues in the background, as
+// a consumer of the group of service2. Each put gets a trace of its own, which
+// starts with the consumer span.
+func startPutWorker() {
+	opts := putqueue.DefaultOptions()
+	opts.Stream = *putStream
+	opts.MinIdle = *putMinIdle
+	opts.MaxDeliveries = *putDeliveries
+	// a read waits for new puts within the bound of -redis-timeout
+	if *redisTimeout > 0 && opts.Block >= *redisTimeout {
+		opts.Block = *redisTimeout / 2
+	}
+	consumer := putqueue.NewConsumer(redisClient, opts, applyPut, messaging.Zipkin(tracer), meterProvider)
+	go consumer.Run(context.Background())
+}
+
+// applyPut applies a put that service1 queued like a Put of a client.
+func applyPut(ctx context.Context, p putqueue.Put) error {
+	_, err := server{}.Put(ctx, &storagev1.PutRequest{
+		Key:             p.Key,
+		Value:           p.Value,
+		TtlSeconds:      p.TTLSeconds,
+		ExpectedVersion: p.ExpectedVersion,
+	})
+	switch status.Code(err) {
+	case codes.Aborted, codes.ResourceExhausted:
+		return putqueue.Permanent(err)
+	}
+	return err
+}
+
 func createRedisClient() {
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
+	addrs := redisclient.ParseAddrs(*redisAddrs)
+	if len(addrs) == 0 {
+		log.Fatalf("invalid -redis-addrs: %q\n", *redisAddrs)
+	}
+	// with TLS, every node is verified against the host of the first address
+	var err error
+	redisClient, err = redisclient.New(redisclient.Config{
+		Mode:                  *redisMode,
+		Addrs:                 addrs,
+		MasterName:            *redisMaster,
+		PoolSize:              *redisPool,
+		MinIdleConns:          *redisMinIdle,
+		PoolTimeout:           *redisPoolWait,
+		DialTimeout:           *redisDial,
+		ReadTimeout:           *redisRead,
+		WriteTimeout:          *redisWrite,
+		ContextTimeoutEnabled: true,
+		TLSConfig:             tlsCerts.ClientConfig("redis", addrs[0]),
+		Report:                redisclient.Zipkin,
+		MeterProvider:         meterProvider,
 	})
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-	err := redisClient.Ping(ctx).Err()
 	if err != nil {
-		log.Fatalf("redis ping error: %+v\n", err)
+		log.Fatalf("invalid Redis configuration: %+v\n", err)
+	}
+	// serve without Redis rather than exit, /readyz failing until it answers
+	if err := redisclient.Wait(context.Background(), redisClient, *redisWait); err != nil {
+		log.Printf("redis ping error: %+v\n", err)
+	}
+
+	// 每条命令使用请求剩余的时间，最多-redis-timeout
+	redisClient.AddHook(deadline.RedisHook(*redisTimeout, deadline.Zipkin))
+	if *redisDelay > 0 {
+		redisClient.AddHook(deadline.SlowRedis(*redisDelay))
 	}
 }
 
 func createTracer() {
-	reporter := httpreporter.NewReporter("http://localhost:9411/api/v2/spans", httpreporter.Timeout(time.Second*5))
+	redactor, err := redact.Load(*redactPolicy)
+	if err != nil {
+		log.Fatalf("invalid -redact-policy: %+v\n", err)
+	}
+	// 上报前脱敏，默认不上报KV的值
+	reporter := redact.Reporter(redactor, httpreporter.NewReporter(tlsconfig.URL(tlsCerts, "collector", "localhost:9411")+"/api/v2/spans",
+		httpreporter.Timeout(time.Second*5),
+		httpreporter.Client(&http.Client{Transport: tlsconfig.Transport(tlsCerts, "collector", "localhost:9411")}),
+	))
+	// 同一请求的span都打上-baggage-attributes允许的baggage标签，再脱敏
+	baggageHook = baggageattr.NewZipkinHook(baggagePolicy)
+	reporter = baggageHook.Reporter(reporter)
 	// 初始化endpoint
 	endpoint, err := zipkin.NewEndpoint("service2", "localhost:8082")
 	if err != nil {
@@ -103,3 +641,42 @@ func createTracer() {
 		log.Fatalf("unable to create tracer: %+v\n", err)
 	}
 }
+
+// createMeter 把指标以 OTLP 发往 -metrics-endpoint 的 collector，Zipkin 本身不接收指标；
+// 没有指定时丢弃指标
+func createMeter() func() {
+	if *metricsAddr == "" {
+		meterProvider = noop.NewMeterProvider()
+		return func() {}
+	}
+	conn, err := grpc.NewClient(*metricsAddr,
+		grpc.WithTransportCredentials(tlsconfig.ClientCredentials(tlsCerts, "collector", *metricsAddr)))
+	if err != nil {
+		log.Fatalf("invalid -metrics-endpoint: %+v\n", err)
+	}
+	exporter, err := otlpmetricgrpc.New(context.Background(), otlpmetricgrpc.WithGRPCConn(conn))
+	if err != nil {
+		log.Fatalf("failed to create the metric exporter: %+v\n", err)
+	}
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(
+			semconv.ServiceName("service2"),
+			semconv.ServiceVersion("1.0.0"),
+		),
+	)
+	if err != nil {
+		log.Fatalf("failed to create the metric resource: %+v\n", err)
+	}
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(provider)
+	meterProvider = provider
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			log.Printf("failed to shut down the meter provider: %+v\n", err)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	storagev1 "go-service-tracing/api/storage/v1"
+	"go-service-tracing/internal/deadline"
+	"go-service-tracing/internal/kvstore"
+	"go-service-tracing/internal/redact"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/openzipkin/zipkin-go"
+	zikpingrpc "github.com/openzipkin/zipkin-go/middleware/grpc"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startServer serves Storage over bufconn like main does, on Redis in memory,
+// and records the spans of the service in memory.
+func startServer(t *testing.T) (storagev1.StorageClient, *miniredis.Miniredis, *recorder.ReporterRecorder) {
+	t.Helper()
+	reporter := recorder.NewReporter()
+	var err error
+	tracer, err = zipkin.NewTracer(reporter, zipkin.WithSampler(zipkin.AlwaysSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mr := miniredis.RunT(t)
+	redisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.StatsHandler(zikpingrpc.NewServerHandler(tracer)))
+	storagev1.RegisterStorageServer(s, &server{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return storagev1.NewStorageClient(conn), mr, reporter
+}
+
+// spansNamed returns the recorded spans of name.
+func spansNamed(spans []model.SpanModel, name string) []model.SpanModel {
+	var named []model.SpanModel
+	for _, s := range spans {
+		if strings.EqualFold(s.Name, name) {
+			named = append(named, s)
+		}
+	}
+	return named
+}
+
+// receiveEvent puts a value under key until events receives it, as the
+// subscription is only set up once the stream is open.
+func receiveEvent(t *testing.T, client storagev1.StorageClient, events storagev1.Storage_WatchClient, key string) *storagev1.WatchEvent {
+	t.Helper()
+	received := make(chan *storagev1.WatchEvent, 1)
+	go func() {
+		if event, err := events.Recv(); err == nil {
+			received <- event
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if _, err := client.Put(context.Background(), &storagev1.PutRequest{Key: key, Value: "watched"}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case event := <-received:
+			return event
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	t.Fatal("no watch event")
+	return nil
+}
+
+// The span of every event of a Watch is a child of the redis.publish span of
+// the Put that caused it, in the trace of the Put, as Zipkin has no links.
+func TestWatchTracing(t *testing.T) {
+	client, _, reporter := startServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := client.Watch(ctx, &storagev1.WatchRequest{Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := receiveEvent(t, client, events, "k")
+	if event.Key != "k" || event.Value != "watched" {
+		t.Fatalf("event = %v, want k=watched", event)
+	}
+	if event.TraceContext["x-b3-traceid"] == "" {
+		t.Fatalf("event carries no b3 context: %v", event.TraceContext)
+	}
+	cancel()
+
+	// the span of the stream ends once the server has seen the cancellation
+	var spans []model.SpanModel
+	for i := 0; i < 50 && len(spansNamed(spans, "storage.v1.Storage.Watch")) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		spans = append(spans, reporter.Flush()...)
+	}
+	if watch := spansNamed(spans, "storage.v1.Storage.Watch"); len(watch) != 1 {
+		t.Fatalf("got %d Watch spans, want 1", len(watch))
+	}
+	publishes := map[model.ID]model.TraceID{}
+	for _, s := range spansNamed(spans, "redis.publish") {
+		publishes[s.ID] = s.TraceID
+	}
+	eventSpans := spansNamed(spans, "service2.watch.event")
+	if len(eventSpans) != 1 {
+		t.Fatalf("got %d event spans, want 1", len(eventSpans))
+	}
+	s := eventSpans[0]
+	if s.ParentID == nil || publishes[*s.ParentID] != s.TraceID {
+		t.Errorf("event span is not a child of a redis.publish span")
+	}
+	if s.Tags["redis.key"] != "{_}:k" {
+		t.Errorf("event span tags = %v, want redis.key _:k", s.Tags)
+	}
+}
+
+// A Put whose value is stored succeeds even if its watchers cannot be told.
+func TestPutPublishError(t *testing.T) {
+	client, mr, reporter := startServer(t)
+	redisClient.AddHook(failPublish{})
+
+	resp, err := client.Put(context.Background(), &storagev1.PutRequest{Key: "k", Value: "v"})
+	if err != nil {
+		t.Fatalf("Put error = %v, want none", err)
+	}
+	if resp.Version != 1 {
+		t.Fatalf("Put version = %d, want 1", resp.Version)
+	}
+	if got := mr.HGet("{_}:k", "value"); got != "v" {
+		t.Fatalf("stored value = %q, want v", got)
+	}
+	publish := spansNamed(reporter.Flush(), "redis.publish")
+	if len(publish) != 1 || publish[0].Tags["error"] != "publish failed" {
+		t.Fatalf("redis.publish spans = %v, want one with the error", publish)
+	}
+}
+
+// The value of a Put reaches the reporter of the service masked, the key as
+// it is.
+func TestPutRedacted(t *testing.T) {
+	client, _, _ := startServer(t)
+	redactor, err := redact.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter := recorder.NewReporter()
+	tracer, err = zipkin.NewTracer(redact.Reporter(redactor, reporter), zipkin.WithSampler(zipkin.AlwaysSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const secret = "s3cr3t-value"
+	if _, err := client.Put(context.Background(), &storagev1.PutRequest{Key: "k", Value: secret}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(context.Background(), &storagev1.GetRequest{Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	spans := reporter.Flush()
+	for _, s := range spans {
+		if strings.Contains(fmt.Sprintf("%+v", s), secret) {
+			t.Errorf("span %s holds the raw value: %+v", s.Name, s)
+		}
+	}
+	set := spansNamed(spans, "redis.set")
+	if len(set) != 1 || set[0].Tags["redis.value"] != redact.Masked || set[0].Tags["redis.key"] != "{_}:k" {
+		t.Fatalf("redis.set spans = %v, want one with the value masked", set)
+	}
+}
+
+// failPublish fails PUBLISH commands.
+type failPublish struct{}
+
+func (failPublish) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (failPublish) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == "publish" {
+			cmd.SetErr(errPublish)
+			return errPublish
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (failPublish) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+var errPublish = errors.New("publish failed")
+
+// batchPut sends reqs on one BatchPut stream and returns the results.
+func batchPut(t *testing.T, client storagev1.StorageClient, reqs []*storagev1.PutRequest) []*storagev1.PutResult {
+	t.Helper()
+	stream, err := client.BatchPut(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range reqs {
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	var results []*storagev1.PutResult
+	for {
+		result, err := stream.Recv()
+		if err == io.EOF {
+			return results
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+}
+
+// Results come back in the order of the requests, each with its own error,
+// and the failure of one request does not fail the others.
+func TestBatchPut(t *testing.T) {
+	for _, mode := range []string{"stream", "message"} {
+		t.Run(mode, func(t *testing.T) {
+			defer func(prev string) { *batchSpans = prev }(*batchSpans)
+			*batchSpans = mode
+			client, mr, reporter := startServer(t)
+
+			stale := int64(7)
+			reqs := make([]*storagev1.PutRequest, 0, 2*maxPipeline)
+			for i := 0; i < 2*maxPipeline; i++ {
+				reqs = append(reqs, &storagev1.PutRequest{Key: fmt.Sprintf("k%03d", i), Value: fmt.Sprint(i)})
+			}
+			reqs[3].Key = ""
+			reqs[150].ExpectedVersion = &stale
+
+			results := batchPut(t, client, reqs)
+			if len(results) != len(reqs) {
+				t.Fatalf("got %d results, want %d", len(results), len(reqs))
+			}
+			for i, result := range results {
+				if result.Index != int64(i) || result.Key != reqs[i].Key {
+					t.Fatalf("result %d = %v, want index %d of key %q", i, result, i, reqs[i].Key)
+				}
+				switch i {
+				case 3:
+					if result.Error != "key is required" {
+						t.Errorf("result of an empty key = %v", result)
+					}
+				case 150:
+					if !strings.Contains(result.Error, kvstore.ErrVersionMismatch.Error()) {
+						t.Errorf("result of a stale version = %v", result)
+					}
+					if mr.Exists("{_}:" + reqs[i].Key) {
+						t.Errorf("key %s of a stale version was stored", reqs[i].Key)
+					}
+				default:
+					if result.Error != "" || result.Version != 1 {
+						t.Errorf("result %d = %v, want version 1", i, result)
+					}
+					if got := mr.HGet("{_}:"+reqs[i].Key, "value"); got != reqs[i].Value {
+						t.Errorf("stored value of %s = %q, want %q", reqs[i].Key, got, reqs[i].Value)
+					}
+				}
+			}
+
+			spans := reporter.Flush()
+			stream := spansNamed(spans, "service2.batchput")
+			if len(stream) != 1 {
+				t.Fatalf("got %d BatchPut spans, want 1", len(stream))
+			}
+			for k, v := range map[string]string{
+				"rpc.batch.messages_received": fmt.Sprint(len(reqs)),
+				"rpc.batch.messages_sent":     fmt.Sprint(len(reqs)),
+				"rpc.batch.errors":            "2",
+			} {
+				if stream[0].Tags[k] != v {
+					t.Errorf("BatchPut span tags = %v, want %s=%s", stream[0].Tags, k, v)
+				}
+			}
+
+			messages := spansNamed(spans, "service2.batchput.message")
+			if mode == "stream" {
+				if len(messages) != 0 {
+					t.Errorf("got %d message spans, want none", len(messages))
+				}
+				return
+			}
+			if len(messages) != len(reqs) {
+				t.Fatalf("got %d message spans, want %d", len(messages), len(reqs))
+			}
+			failed := 0
+			for _, s := range messages {
+				if s.Tags["error"] != "" {
+					failed++
+				}
+			}
+			if failed != 2 {
+				t.Errorf("got %d failed message spans, want 2", failed)
+			}
+		})
+	}
+}
+
+// A Redis slower than the bound of its commands fails a Put with
+// DeadlineExceeded, which the span of the command records.
+func TestPutSlowRedis(t *testing.T) {
+	client, _, reporter := startServer(t)
+	redisClient.AddHook(deadline.RedisHook(50*time.Millisecond, deadline.Zipkin))
+	redisClient.AddHook(deadline.SlowRedis(time.Second))
+
+	_, err := client.Put(context.Background(), &storagev1.PutRequest{Key: "k", Value: "v"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("Put error = %v, want DeadlineExceeded", err)
+	}
+	set := spansNamed(reporter.Flush(), "redis.set")
+	if len(set) != 1 || set[0].Tags["error"] == "" || set[0].Tags[deadline.AttrExceeded] != "true" {
+		t.Fatalf("redis.set spans = %v, want one exceeded", set)
+	}
+}